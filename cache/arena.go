@@ -0,0 +1,607 @@
+package gocache
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrArenaFull is returned when the arena of a bucket of an ArenaStore would grow past 4 GiB
+var ErrArenaFull = errors.New("arena of the bucket is full")
+
+const (
+	// noIndex ends a list of slots and stands for an empty subtree of an indexTree
+	noIndex = math.MaxUint32
+	// maxArenaSize is the size of the arena of a bucket, offsets in the arena are 32 bits
+	maxArenaSize = math.MaxUint32
+)
+
+// ArenaStore is a minimal store with the cost based eviction of Cache whose entries are stored without pointers, so
+// the garbage collector does not scan them: keys and values are copied into one large byte arena per bucket, entries
+// are slots of a slice referenced by index from a map[uint64]uint32, and the cost lists and the cost tree are
+// linked by indexes. With millions of entries, this keeps the garbage collection pauses short.
+//
+// Removed and replaced values leave garbage in the arena, which is compacted when the arena is full and at least
+// half of it is garbage, otherwise the arena grows. Get returns copies of the key and the value. Cost functions
+// are called with a Data whose key and value point into the arena and must not be retained.
+//
+// ArenaStore is the storage of a Cache initialized with Cache.InitArena, it can also be used on its own. It only
+// implements Store, Clear and a few counters.
+type ArenaStore struct {
+	buckets []arenaBucket
+}
+
+var _ Store = (*ArenaStore)(nil)
+
+// arenaSlot is an entry of an ArenaStore, the key and the value are stored one after the other at offset in the
+// arena of the bucket
+type arenaSlot struct {
+	hash         uint64
+	offset       uint32
+	keyLen       uint32
+	valueLen     uint32
+	costFunction uint32 // index in the cost functions of the bucket
+	cost         int    // cost of the entry when it was linked into its cost list
+	reads        int
+	updates      int
+	next         uint32 // next slot of the cost list, noIndex at the tail
+	prev         uint32 // previous slot of the cost list, noIndex at the head
+}
+
+// arenaList is the list of the slots with the same cost, oldest first
+type arenaList struct {
+	head uint32
+	tail uint32
+}
+
+type arenaBucket struct {
+	mutex         sync.Mutex
+	index         map[uint64]uint32 // key of this map = hash(key), value = index of the slot
+	slots         []arenaSlot
+	freeSlots     []uint32 // indexes of the unused slots
+	arena         []byte
+	garbage       int               // bytes of the arena used by removed entries and replaced values
+	costLists     map[int]arenaList // key of this map is cost, value is the list of slots with the same cost
+	costTree      indexTree         // costs of the cost lists
+	costFunctions []*func(data Data) int
+	costIndexes   map[*func(data Data) int]uint32 // index of every cost function in costFunctions
+	maxEntries    int
+	collisions    uint64
+	evictions     uint64
+}
+
+// Init initializes the store like Cache.Init, arenaSize is the number of bytes preallocated for the keys and values
+// over all the buckets, arenas grow as needed
+func (s *ArenaStore) Init(capacity int, buckets int, arenaSize int) {
+	numberOfBuckets, bucketCapacity := bucketsFor(capacity, buckets)
+	s.buckets = make([]arenaBucket, numberOfBuckets)
+	for i := range s.buckets {
+		s.buckets[i].init(bucketCapacity, arenaSize/numberOfBuckets)
+	}
+}
+
+// InitArena initializes the cache like Init with its entries stored in an ArenaStore, see ArenaStore.Init for
+// arenaSize. Add, Get, Update, Evict, Clear, the entries, capacity, collisions and capacity evictions counters and
+// Stats use the arena. The features built on the entry nodes of Init are not available and behave as on a cache
+// which is not initialized: tags, dependencies, mutation listeners and watchers, transactions, namespaces,
+// compression, copy modes and GetFunc, GetOrLoad, iteration, snapshots, the other counters, tracing and
+// instrumentation, and so the packages built on them such as replication and invalidation.
+func (c *Cache) InitArena(capacity int, buckets int, arenaSize int) {
+	arena := &ArenaStore{}
+	arena.Init(capacity, buckets, arenaSize)
+	c.buckets = nil
+	c.arena = arena
+}
+
+func (b *arenaBucket) init(maxEntries int, arenaSize int) {
+	b.mutex.Lock()
+	b.index = map[uint64]uint32{}
+	b.slots = nil
+	b.freeSlots = nil
+	if cap(b.arena) < arenaSize {
+		b.arena = make([]byte, 0, arenaSize)
+	}
+	b.arena = b.arena[:0]
+	b.garbage = 0
+	b.costLists = map[int]arenaList{}
+	b.costTree = indexTree{root: noIndex}
+	b.costFunctions = nil
+	b.costIndexes = map[*func(data Data) int]uint32{}
+	b.maxEntries = maxEntries
+	b.mutex.Unlock()
+}
+
+// Clear removes all the entries of the store, the arenas are kept for the next entries
+func (s *ArenaStore) Clear() {
+	for i := range s.buckets {
+		s.buckets[i].init(s.buckets[i].maxEntries, 0)
+	}
+}
+
+// bucket returns the bucket of the key with hash h
+func (s *ArenaStore) bucket(h uint64) *arenaBucket {
+	return &s.buckets[h%uint64(len(s.buckets))]
+}
+
+// Add adds (k, v) to the store like Cache.Add
+func (s *ArenaStore) Add(k, v []byte, costFun *func(data Data) int) error {
+	if s == nil || len(s.buckets) == 0 {
+		return ErrNotInitialized
+	}
+	h := getHash64(k)
+	return s.bucket(h).add(k, v, h, costFun)
+}
+
+// Get returns a copy of the entry of k like Cache.Get
+func (s *ArenaStore) Get(k []byte) (Data, error) {
+	if s == nil || len(s.buckets) == 0 {
+		return Data{}, ErrNotInitialized
+	}
+	h := getHash64(k)
+	return s.bucket(h).get(k, h)
+}
+
+// Update replaces the value of k like Cache.Update
+func (s *ArenaStore) Update(k, v []byte) error {
+	if s == nil || len(s.buckets) == 0 {
+		return ErrNotInitialized
+	}
+	h := getHash64(k)
+	return s.bucket(h).update(k, v, h)
+}
+
+// Evict removes k from the store like Cache.Evict
+func (s *ArenaStore) Evict(k []byte) error {
+	if s == nil || len(s.buckets) == 0 {
+		return ErrNotInitialized
+	}
+	h := getHash64(k)
+	return s.bucket(h).evict(k, h)
+}
+
+// GetEntriesCount returns the number of entries in the store
+func (s *ArenaStore) GetEntriesCount() uint64 {
+	var count uint64
+	for i := range s.buckets {
+		b := &s.buckets[i]
+		b.mutex.Lock()
+		count += uint64(len(b.index))
+		b.mutex.Unlock()
+	}
+	return count
+}
+
+// GetCapacity returns the maximum number of entries of the store
+func (s *ArenaStore) GetCapacity() uint64 {
+	var capacity uint64
+	for i := range s.buckets {
+		capacity += uint64(s.buckets[i].maxEntries)
+	}
+	return capacity
+}
+
+// GetArenaBytes returns the number of bytes allocated for the arenas and the number of them used by live entries
+func (s *ArenaStore) GetArenaBytes() (allocated uint64, used uint64) {
+	for i := range s.buckets {
+		b := &s.buckets[i]
+		b.mutex.Lock()
+		allocated += uint64(cap(b.arena))
+		used += uint64(len(b.arena) - b.garbage)
+		b.mutex.Unlock()
+	}
+	return allocated, used
+}
+
+// GetCollisionsCount returns the number of operations which hit a different key with the same hash
+func (s *ArenaStore) GetCollisionsCount() uint64 {
+	var count uint64
+	for i := range s.buckets {
+		count += atomic.LoadUint64(&s.buckets[i].collisions)
+	}
+	return count
+}
+
+// GetEvictionsCount returns the number of entries evicted to make room
+func (s *ArenaStore) GetEvictionsCount() uint64 {
+	var count uint64
+	for i := range s.buckets {
+		count += atomic.LoadUint64(&s.buckets[i].evictions)
+	}
+	return count
+}
+
+func (b *arenaBucket) add(k, v []byte, h uint64, costFun *func(data Data) int) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.index == nil {
+		return ErrNotInitialized
+	}
+	// the bytes are stored first, compacting the arena moves the entries which could be removed below
+	offset, err := b.store(k, v)
+	if err != nil {
+		return err
+	}
+
+	if i, exist := b.index[h]; exist {
+		if !bytes.Equal(b.key(i), k) {
+			atomic.AddUint64(&b.collisions, 1)
+		}
+		b.removeSlot(i)
+	}
+	if len(b.index) >= b.maxEntries {
+		if cost, found := b.costTree.minimum(); found {
+			b.removeSlot(b.costLists[cost].head)
+			atomic.AddUint64(&b.evictions, 1)
+		}
+	}
+
+	i := b.newSlot(arenaSlot{
+		hash:         h,
+		offset:       offset,
+		keyLen:       uint32(len(k)),
+		valueLen:     uint32(len(v)),
+		costFunction: b.costFunctionIndex(costFun),
+	})
+	b.index[h] = i
+	b.link(i, b.cost(i))
+	return nil
+}
+
+func (b *arenaBucket) get(k []byte, h uint64) (Data, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.index == nil {
+		return Data{}, ErrNotInitialized
+	}
+	i, exist := b.index[h]
+	if !exist {
+		return Data{}, ErrNotFound
+	}
+	if !bytes.Equal(b.key(i), k) {
+		atomic.AddUint64(&b.collisions, 1)
+		return Data{}, ErrNotFound
+	}
+	b.slots[i].reads++
+	b.move(i)
+	data := b.data(i)
+	data.key = append([]byte(nil), data.key...)
+	data.value = append([]byte(nil), data.value...)
+	return data, nil
+}
+
+func (b *arenaBucket) update(k, v []byte, h uint64) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.index == nil {
+		return ErrNotInitialized
+	}
+	i, exist := b.index[h]
+	if !exist {
+		return ErrKeyNotExist
+	}
+	if !bytes.Equal(b.key(i), k) {
+		atomic.AddUint64(&b.collisions, 1)
+		return ErrKeyNotExist
+	}
+	slot := &b.slots[i]
+	if len(v) <= int(slot.valueLen) {
+		// the new value fits in the place of the old one
+		copy(b.arena[slot.offset+slot.keyLen:], v)
+		b.garbage += int(slot.valueLen) - len(v)
+	} else {
+		size := int(slot.keyLen + slot.valueLen)
+		offset, err := b.store(k, v)
+		if err != nil {
+			return err
+		}
+		// storing may have compacted the arena, which reallocates the slots of live entries in place
+		slot = &b.slots[i]
+		slot.offset = offset
+		b.garbage += size
+	}
+	slot.valueLen = uint32(len(v))
+	slot.updates++
+	b.move(i)
+	return nil
+}
+
+func (b *arenaBucket) evict(k []byte, h uint64) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.index == nil {
+		return ErrNotInitialized
+	}
+	i, exist := b.index[h]
+	if !exist {
+		return ErrKeyNotExist
+	}
+	if !bytes.Equal(b.key(i), k) {
+		atomic.AddUint64(&b.collisions, 1)
+		return ErrKeyNotExist
+	}
+	b.removeSlot(i)
+	return nil
+}
+
+// store appends k and v to the arena and returns their offset. When the arena is full and at least half of it is
+// garbage, it is compacted first.
+func (b *arenaBucket) store(k, v []byte) (uint32, error) {
+	size := len(k) + len(v)
+	full := len(b.arena)+size > cap(b.arena) || uint64(len(b.arena)+size) > maxArenaSize
+	if full && b.garbage > 0 && b.garbage >= len(b.arena)/2 {
+		b.compact()
+	}
+	if uint64(len(b.arena)+size) > maxArenaSize {
+		b.compact()
+		if uint64(len(b.arena)+size) > maxArenaSize {
+			return 0, ErrArenaFull
+		}
+	}
+	offset := uint32(len(b.arena))
+	b.arena = append(append(b.arena, k...), v...)
+	return offset, nil
+}
+
+// compact copies the keys and values of the live entries into a new arena without garbage
+func (b *arenaBucket) compact() {
+	arena := make([]byte, 0, cap(b.arena))
+	for _, i := range b.index {
+		slot := &b.slots[i]
+		offset := len(arena)
+		arena = append(arena, b.arena[slot.offset:slot.offset+slot.keyLen+slot.valueLen]...)
+		slot.offset = uint32(offset)
+	}
+	b.arena = arena
+	b.garbage = 0
+}
+
+// key returns the key of slot i, stored in the arena
+func (b *arenaBucket) key(i uint32) []byte {
+	slot := &b.slots[i]
+	return b.arena[slot.offset : slot.offset+slot.keyLen : slot.offset+slot.keyLen]
+}
+
+// data returns the entry of slot i for the cost function, its key and value point into the arena
+func (b *arenaBucket) data(i uint32) Data {
+	slot := &b.slots[i]
+	end := slot.offset + slot.keyLen + slot.valueLen
+	return Data{
+		key:          b.key(i),
+		value:        b.arena[slot.offset+slot.keyLen : end : end],
+		reads:        slot.reads,
+		updates:      slot.updates,
+		costFunction: b.costFunctions[slot.costFunction],
+	}
+}
+
+// cost returns the cost of slot i with its cost function
+func (b *arenaBucket) cost(i uint32) int {
+	return (*b.costFunctions[b.slots[i].costFunction])(b.data(i))
+}
+
+// costFunctionIndex returns the index of costFun in the cost functions of the bucket, adding it when it is new
+func (b *arenaBucket) costFunctionIndex(costFun *func(data Data) int) uint32 {
+	if index, found := b.costIndexes[costFun]; found {
+		return index
+	}
+	index := uint32(len(b.costFunctions))
+	b.costFunctions = append(b.costFunctions, costFun)
+	b.costIndexes[costFun] = index
+	return index
+}
+
+// newSlot stores slot in an unused slot or a new one and returns its index
+func (b *arenaBucket) newSlot(slot arenaSlot) uint32 {
+	if n := len(b.freeSlots); n > 0 {
+		i := b.freeSlots[n-1]
+		b.freeSlots = b.freeSlots[:n-1]
+		b.slots[i] = slot
+		return i
+	}
+	b.slots = append(b.slots, slot)
+	return uint32(len(b.slots) - 1)
+}
+
+// removeSlot removes the entry of slot i from the index and its cost list, its bytes become garbage
+func (b *arenaBucket) removeSlot(i uint32) {
+	slot := &b.slots[i]
+	b.unlink(i, slot.cost)
+	delete(b.index, slot.hash)
+	b.garbage += int(slot.keyLen + slot.valueLen)
+	b.freeSlots = append(b.freeSlots, i)
+}
+
+// link appends slot i to the cost list of cost, creating the list and its tree node when it is the first slot with
+// this cost
+func (b *arenaBucket) link(i uint32, cost int) {
+	slot := &b.slots[i]
+	slot.cost = cost
+	slot.next = noIndex
+	list, found := b.costLists[cost]
+	if !found {
+		slot.prev = noIndex
+		b.costLists[cost] = arenaList{i, i}
+		b.costTree.insert(cost)
+		return
+	}
+	slot.prev = list.tail
+	b.slots[list.tail].next = i
+	list.tail = i
+	b.costLists[cost] = list
+}
+
+// unlink removes slot i from the cost list of cost, removing the list and its tree node when it becomes empty
+func (b *arenaBucket) unlink(i uint32, cost int) {
+	slot := &b.slots[i]
+	list := b.costLists[cost]
+	if slot.prev != noIndex {
+		b.slots[slot.prev].next = slot.next
+	} else {
+		list.head = slot.next
+	}
+	if slot.next != noIndex {
+		b.slots[slot.next].prev = slot.prev
+	} else {
+		list.tail = slot.prev
+	}
+	slot.next, slot.prev = noIndex, noIndex
+	if list.head == noIndex {
+		delete(b.costLists, cost)
+		b.costTree.remove(cost)
+		return
+	}
+	b.costLists[cost] = list
+}
+
+// move moves slot i to the cost list of its new cost when its cost has changed
+func (b *arenaBucket) move(i uint32) {
+	if cost := b.cost(i); cost != b.slots[i].cost {
+		b.unlink(i, b.slots[i].cost)
+		b.link(i, cost)
+	}
+}
+
+// indexTree is an AVL tree of costs like the tree of costNode, with the nodes stored in a slice and linked by index
+type indexTree struct {
+	nodes []indexTreeNode
+	free  []uint32 // indexes of the unused nodes
+	root  uint32
+}
+
+type indexTreeNode struct {
+	cost   int
+	height int
+	left   uint32
+	right  uint32
+}
+
+func (t *indexTree) height(i uint32) int {
+	if i == noIndex {
+		return 0
+	}
+	return t.nodes[i].height
+}
+
+func (t *indexTree) heightDiff(i uint32) int {
+	if i == noIndex {
+		return 0
+	}
+	return t.height(t.nodes[i].left) - t.height(t.nodes[i].right)
+}
+
+func (t *indexTree) updateHeight(i uint32) {
+	t.nodes[i].height = 1 + max(t.height(t.nodes[i].left), t.height(t.nodes[i].right))
+}
+
+func (t *indexTree) rightRotate(i uint32) uint32 {
+	left := t.nodes[i].left
+	t.nodes[i].left = t.nodes[left].right
+	t.nodes[left].right = i
+	t.updateHeight(i)
+	t.updateHeight(left)
+	return left
+}
+
+func (t *indexTree) leftRotate(i uint32) uint32 {
+	right := t.nodes[i].right
+	t.nodes[i].right = t.nodes[right].left
+	t.nodes[right].left = i
+	t.updateHeight(i)
+	t.updateHeight(right)
+	return right
+}
+
+// balance rotates the subtree of i when its children differ in height by more than one and returns its new root
+func (t *indexTree) balance(i uint32) uint32 {
+	t.updateHeight(i)
+	heightDiff := t.heightDiff(i)
+	if heightDiff > 1 {
+		if t.heightDiff(t.nodes[i].left) < 0 {
+			t.nodes[i].left = t.leftRotate(t.nodes[i].left)
+		}
+		return t.rightRotate(i)
+	}
+	if heightDiff < -1 {
+		if t.heightDiff(t.nodes[i].right) > 0 {
+			t.nodes[i].right = t.rightRotate(t.nodes[i].right)
+		}
+		return t.leftRotate(i)
+	}
+	return i
+}
+
+// insert adds cost to the tree, nothing changes when it is already in the tree
+func (t *indexTree) insert(cost int) {
+	t.root = t.insertAt(t.root, cost)
+}
+
+func (t *indexTree) insertAt(i uint32, cost int) uint32 {
+	if i == noIndex {
+		node := indexTreeNode{cost, 1, noIndex, noIndex}
+		if n := len(t.free); n > 0 {
+			i = t.free[n-1]
+			t.free = t.free[:n-1]
+			t.nodes[i] = node
+			return i
+		}
+		t.nodes = append(t.nodes, node)
+		return uint32(len(t.nodes) - 1)
+	}
+	// the nodes may be reallocated by the insertion, so they are only accessed by index
+	if cost < t.nodes[i].cost {
+		left := t.insertAt(t.nodes[i].left, cost)
+		t.nodes[i].left = left
+	} else if cost > t.nodes[i].cost {
+		right := t.insertAt(t.nodes[i].right, cost)
+		t.nodes[i].right = right
+	} else {
+		return i
+	}
+	return t.balance(i)
+}
+
+// remove removes cost from the tree
+func (t *indexTree) remove(cost int) {
+	t.root = t.removeAt(t.root, cost)
+}
+
+func (t *indexTree) removeAt(i uint32, cost int) uint32 {
+	if i == noIndex {
+		return i
+	}
+	if cost < t.nodes[i].cost {
+		t.nodes[i].left = t.removeAt(t.nodes[i].left, cost)
+	} else if cost > t.nodes[i].cost {
+		t.nodes[i].right = t.removeAt(t.nodes[i].right, cost)
+	} else {
+		left, right := t.nodes[i].left, t.nodes[i].right
+		if left == noIndex || right == noIndex {
+			t.free = append(t.free, i)
+			if left == noIndex {
+				return right
+			}
+			return left
+		}
+		successor := right
+		for t.nodes[successor].left != noIndex {
+			successor = t.nodes[successor].left
+		}
+		t.nodes[i].cost = t.nodes[successor].cost
+		t.nodes[i].right = t.removeAt(right, t.nodes[i].cost)
+	}
+	return t.balance(i)
+}
+
+// minimum returns the minimum cost of the tree, false when the tree is empty
+func (t *indexTree) minimum() (int, bool) {
+	i := t.root
+	if i == noIndex {
+		return 0, false
+	}
+	for t.nodes[i].left != noIndex {
+		i = t.nodes[i].left
+	}
+	return t.nodes[i].cost, true
+}
@@ -0,0 +1,384 @@
+package gocache
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func newTestArenaStore(t testing.TB, capacity, buckets, arenaSize int) *ArenaStore {
+	t.Helper()
+	c := &ArenaStore{}
+	c.Init(capacity, buckets, arenaSize)
+	return c
+}
+
+func TestArenaAddGet(t *testing.T) {
+	tests := []struct {
+		name    string
+		adds    [][2]string
+		get     string
+		want    string
+		wantErr error
+	}{
+		{"single", [][2]string{{"k", "v"}}, "k", "v", nil},
+		{"missing", [][2]string{{"k", "v"}}, "other", "", ErrNotFound},
+		{"overwrite", [][2]string{{"k", "v1"}, {"k", "v2"}}, "k", "v2", nil},
+		{"empty value", [][2]string{{"k", ""}}, "k", "", nil},
+		{"empty key", [][2]string{{"", "v"}}, "", "v", nil},
+		{"empty cache", nil, "k", "", ErrNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestArenaStore(t, 100, 4, 0)
+			for _, kv := range test.adds {
+				if err := c.Add([]byte(kv[0]), []byte(kv[1]), &BalancedCost); err != nil {
+					t.Fatalf("Add(%q): %v", kv[0], err)
+				}
+			}
+			data, err := c.Get([]byte(test.get))
+			if err != test.wantErr {
+				t.Fatalf("Get(%q) error = %v, want %v", test.get, err, test.wantErr)
+			}
+			if err == nil && string(data.GetValue()) != test.want {
+				t.Errorf("Get(%q) = %q, want %q", test.get, data.GetValue(), test.want)
+			}
+			checkArenaStore(t, c)
+		})
+	}
+}
+
+func TestArenaNotInitialized(t *testing.T) {
+	var c ArenaStore
+	if err := c.Add([]byte("k"), []byte("v"), &BalancedCost); err != ErrNotInitialized {
+		t.Errorf("Add error = %v, want %v", err, ErrNotInitialized)
+	}
+	if _, err := c.Get([]byte("k")); err != ErrNotInitialized {
+		t.Errorf("Get error = %v, want %v", err, ErrNotInitialized)
+	}
+	if err := c.Update([]byte("k"), []byte("v")); err != ErrNotInitialized {
+		t.Errorf("Update error = %v, want %v", err, ErrNotInitialized)
+	}
+	if err := c.Evict([]byte("k")); err != ErrNotInitialized {
+		t.Errorf("Evict error = %v, want %v", err, ErrNotInitialized)
+	}
+}
+
+func TestArenaGetReturnsCopies(t *testing.T) {
+	c := newTestArenaStore(t, 10, 1, 0)
+	c.Add([]byte("k"), []byte("value"), &ConstantCost)
+	data, _ := c.Get([]byte("k"))
+	data.GetValue()[0] = 'X'
+	c.Update([]byte("k"), []byte("other"))
+	if string(data.GetValue()) != "Xalue" {
+		t.Errorf("value read before Update = %q, want %q", data.GetValue(), "Xalue")
+	}
+	if data, _ := c.Get([]byte("k")); string(data.GetValue()) != "other" {
+		t.Errorf("Get = %q, want %q", data.GetValue(), "other")
+	}
+}
+
+// TestArenaMatchesCache runs the same random operations on a Cache and an ArenaStore with one bucket, which must
+// keep the same entries, evicted in the same order, with the same reads and updates
+func TestArenaMatchesCache(t *testing.T) {
+	for _, costName := range []string{"constant", "size", "frequency", "balanced"} {
+		t.Run(costName, func(t *testing.T) {
+			costFun, _ := CostFunction(costName)
+			c := newTestCache(t, 50, 1)
+			a := newTestArenaStore(t, 50, 1, 256)
+			r := rand.New(rand.NewSource(1))
+			for i := 0; i < 20000; i++ {
+				k := key(r.Intn(100))
+				v := make([]byte, r.Intn(40))
+				r.Read(v)
+				var err, arenaErr error
+				switch op := r.Intn(10); {
+				case op < 3:
+					err, arenaErr = c.Add(k, v, costFun), a.Add(k, v, costFun)
+				case op < 5:
+					err, arenaErr = c.Update(k, v), a.Update(k, v)
+				case op < 6:
+					err, arenaErr = c.Evict(k), a.Evict(k)
+				default:
+					var data, arenaData Data
+					data, err = c.Get(k)
+					arenaData, arenaErr = a.Get(k)
+					if err == nil && arenaErr == nil && (string(data.GetValue()) != string(arenaData.GetValue()) ||
+						data.GetReads() != arenaData.GetReads() || data.GetUpdates() != arenaData.GetUpdates()) {
+						t.Fatalf("op %v: Get(%s) = %+v, Cache has %+v", i, k, arenaData, data)
+					}
+				}
+				if err != arenaErr {
+					t.Fatalf("op %v on %s: error = %v, Cache returned %v", i, k, arenaErr, err)
+				}
+				if i%500 == 0 {
+					checkArenaStore(t, a)
+				}
+			}
+			if c.GetEntriesCount() != a.GetEntriesCount() {
+				t.Errorf("%v entries, Cache has %v", a.GetEntriesCount(), c.GetEntriesCount())
+			}
+			checkArenaStore(t, a)
+		})
+	}
+}
+
+func TestCacheInitArena(t *testing.T) {
+	c := &Cache{}
+	c.Init(100, 4)
+	c.Add([]byte("node"), []byte("v"), &BalancedCost)
+	c.InitArena(8, 2, 64)
+	if _, err := c.Get([]byte("node")); err != ErrNotFound {
+		t.Errorf("Get of an entry added before InitArena = %v, want %v", err, ErrNotFound)
+	}
+	for i := 0; i < 20; i++ {
+		if err := c.Add(key(i), []byte("v"), &ConstantCost); err != nil {
+			t.Fatalf("Add(%s): %v", key(i), err)
+		}
+	}
+	if err := c.Update(key(19), []byte("updated")); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if data, err := c.Get(key(19)); err != nil || string(data.GetValue()) != "updated" || data.GetUpdates() != 1 {
+		t.Errorf("Get = %+v, %v, want the updated value", data, err)
+	}
+	if err := c.Evict(key(19)); err != nil {
+		t.Errorf("Evict: %v", err)
+	}
+	stats := c.Stats()
+	if c.GetEntriesCount() != 7 || stats.Entries != 7 || stats.MaxEntries != 8 || c.GetCapacity() != 8 {
+		t.Errorf("%v entries, stats %+v, want 7 entries of 8", c.GetEntriesCount(), stats)
+	}
+	if evictions := c.GetEvictionsCount(EvictedByCapacity); evictions != 12 || stats.Evictions["capacity"] != 12 {
+		t.Errorf("%v capacity evictions, stats %v, want 12", evictions, stats.Evictions)
+	}
+	if c.GetEvictionsCount(EvictedExplicitly) != 0 || stats.Skew != 0 {
+		t.Errorf("stats %+v, want only the counters of the arena", stats)
+	}
+
+	// the features built on entry nodes see a cache which is not initialized
+	if err := c.AddWithTags([]byte("k"), []byte("v"), &ConstantCost, "tag"); err != ErrNotInitialized {
+		t.Errorf("AddWithTags = %v, want %v", err, ErrNotInitialized)
+	}
+	if _, err := c.GetOrLoad([]byte("k"), func(k []byte) ([]byte, error) { return k, nil }, &ConstantCost); err != ErrNotInitialized {
+		t.Errorf("GetOrLoad = %v, want %v", err, ErrNotInitialized)
+	}
+	if len(c.Keys()) != 0 || c.InvalidateTag("tag") != 0 {
+		t.Errorf("Keys and InvalidateTag see entries of the arena")
+	}
+
+	c.Clear()
+	if c.GetEntriesCount() != 0 {
+		t.Errorf("%v entries after Clear, want 0", c.GetEntriesCount())
+	}
+	c.Init(100, 4)
+	if _, err := c.Get(key(0)); err != ErrNotFound {
+		t.Errorf("Get after Init of an entry of the arena = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestArenaCompaction(t *testing.T) {
+	c := newTestArenaStore(t, 10, 1, 64)
+	for i := 0; i < 1000; i++ {
+		if err := c.Add(key(i%10), []byte(fmt.Sprintf("value%v", i)), &ConstantCost); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	allocated, used := c.GetArenaBytes()
+	if allocated > 1024 {
+		t.Errorf("%v bytes allocated for %v bytes of entries, the arena was not compacted", allocated, used)
+	}
+	for i := 990; i < 1000; i++ {
+		want := fmt.Sprintf("value%v", i)
+		if data, err := c.Get(key(i % 10)); err != nil || string(data.GetValue()) != want {
+			t.Errorf("Get(%s) = %q, %v, want %q", key(i%10), data.GetValue(), err, want)
+		}
+	}
+	checkArenaStore(t, c)
+}
+
+func TestArenaClear(t *testing.T) {
+	c := newTestArenaStore(t, 100, 4, 0)
+	for i := 0; i < 50; i++ {
+		c.Add(key(i), key(i), &BalancedCost)
+	}
+	c.Clear()
+	if count := c.GetEntriesCount(); count != 0 {
+		t.Errorf("%v entries after Clear, want 0", count)
+	}
+	if _, err := c.Get(key(1)); err != ErrNotFound {
+		t.Errorf("Get after Clear error = %v, want %v", err, ErrNotFound)
+	}
+	c.Add(key(1), key(1), &BalancedCost)
+	if data, err := c.Get(key(1)); err != nil || string(data.GetValue()) != string(key(1)) {
+		t.Errorf("Get after Clear and Add = %q, %v", data.GetValue(), err)
+	}
+	checkArenaStore(t, c)
+}
+
+// checkArenaStore checks every bucket of c like checkCache, the cost tree of a bucket must hold the costs of its
+// non empty cost lists, which must hold every entry once, and the live entries must not overlap in the arena
+func checkArenaStore(t testing.TB, c *ArenaStore) {
+	t.Helper()
+	for i := range c.buckets {
+		b := &c.buckets[i]
+		b.mutex.Lock()
+		err := checkArenaBucket(b)
+		b.mutex.Unlock()
+		if err != nil {
+			t.Fatalf("bucket %v: %v", i, err)
+		}
+	}
+}
+
+func checkArenaBucket(b *arenaBucket) error {
+	costs, err := checkIndexTree(&b.costTree, b.costTree.root)
+	if err != nil {
+		return err
+	}
+	if len(costs) != len(b.costLists) {
+		return fmt.Errorf("tree has %v costs, there are %v cost lists", len(costs), len(b.costLists))
+	}
+	listed := map[uint32]bool{}
+	for _, cost := range costs {
+		list, found := b.costLists[cost]
+		if !found {
+			return fmt.Errorf("cost %v is in the tree but has no list", cost)
+		}
+		prev := uint32(noIndex)
+		for i := list.head; i != noIndex; i = b.slots[i].next {
+			if b.slots[i].prev != prev {
+				return fmt.Errorf("cost list %v has a broken prev link", cost)
+			}
+			if listed[i] {
+				return fmt.Errorf("slot %v is listed twice", i)
+			}
+			listed[i] = true
+			if b.slots[i].cost != cost || b.cost(i) != cost {
+				return fmt.Errorf("slot %v with cost %v is in the list of cost %v", i, b.cost(i), cost)
+			}
+			prev = i
+		}
+		if list.tail != prev {
+			return fmt.Errorf("cost list %v has a wrong tail", cost)
+		}
+	}
+	if len(listed) != len(b.index) {
+		return fmt.Errorf("%v slots are listed, the index has %v entries", len(listed), len(b.index))
+	}
+	if len(b.index) > b.maxEntries {
+		return fmt.Errorf("%v entries, capacity is %v", len(b.index), b.maxEntries)
+	}
+	used := 0
+	owners := make([]int, len(b.arena))
+	for h, i := range b.index {
+		if !listed[i] {
+			return fmt.Errorf("slot %v is not in a cost list", i)
+		}
+		slot := b.slots[i]
+		if slot.hash != h || getHash64(b.key(i)) != h {
+			return fmt.Errorf("slot %v is indexed under a wrong hash", i)
+		}
+		for offset := slot.offset; offset < slot.offset+slot.keyLen+slot.valueLen; offset++ {
+			if owners[offset] != 0 {
+				return fmt.Errorf("slot %v overlaps another entry in the arena", i)
+			}
+			owners[offset] = int(i) + 1
+		}
+		used += int(slot.keyLen + slot.valueLen)
+	}
+	if used+b.garbage != len(b.arena) {
+		return fmt.Errorf("%v bytes used and %v of garbage, the arena has %v", used, b.garbage, len(b.arena))
+	}
+	return nil
+}
+
+// checkIndexTree returns the costs of the subtree of i in order, or an error when it is not a valid AVL tree
+func checkIndexTree(tree *indexTree, i uint32) ([]int, error) {
+	if i == noIndex {
+		return nil, nil
+	}
+	node := tree.nodes[i]
+	left, err := checkIndexTree(tree, node.left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := checkIndexTree(tree, node.right)
+	if err != nil {
+		return nil, err
+	}
+	if len(left) > 0 && left[len(left)-1] >= node.cost || len(right) > 0 && right[0] <= node.cost {
+		return nil, fmt.Errorf("cost %v is out of order", node.cost)
+	}
+	if node.height != 1+max(tree.height(node.left), tree.height(node.right)) {
+		return nil, fmt.Errorf("cost %v has a wrong height", node.cost)
+	}
+	if diff := tree.heightDiff(i); diff > 1 || diff < -1 {
+		return nil, fmt.Errorf("cost %v is unbalanced", node.cost)
+	}
+	return append(append(left, node.cost), right...), nil
+}
+
+func TestArenaConcurrent(t *testing.T) {
+	c := newTestArenaStore(t, 1000, 8, 0)
+	done := make(chan struct{})
+	for g := 0; g < 8; g++ {
+		go func(seed int64) {
+			defer func() { done <- struct{}{} }()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < 5000; i++ {
+				k := key(r.Intn(2000))
+				switch r.Intn(4) {
+				case 0:
+					c.Add(k, k, &BalancedCost)
+				case 1:
+					c.Update(k, append(k, k...))
+				case 2:
+					c.Evict(k)
+				default:
+					c.Get(k)
+				}
+			}
+		}(int64(g))
+	}
+	for g := 0; g < 8; g++ {
+		<-done
+	}
+	checkArenaStore(t, c)
+}
+
+// BenchmarkGCPause measures the time of a full garbage collection with millions of entries in a Cache and in an
+// ArenaStore, whose entries the garbage collector does not scan
+func BenchmarkGCPause(b *testing.B) {
+	const entries = 2000000
+	keys := benchmarkKeys(entries)
+	stores := []struct {
+		name  string
+		store func() Store
+	}{
+		{"cache", func() Store { return newTestCache(b, entries, 1024) }},
+		{"arena", func() Store {
+			c := &Cache{}
+			c.InitArena(entries, 1024, entries*32)
+			return c
+		}},
+	}
+	for _, s := range stores {
+		b.Run(s.name, func(b *testing.B) {
+			store := s.store()
+			for _, k := range keys {
+				store.Add(k, k, &BalancedCost)
+			}
+			runtime.GC()
+			b.ResetTimer()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+			}
+			b.ReportMetric(float64(time.Since(start).Microseconds())/float64(b.N), "µs/gc")
+			runtime.KeepAlive(store)
+		})
+	}
+}
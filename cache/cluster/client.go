@@ -0,0 +1,157 @@
+package cluster
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"gocache"
+	"gocache/httpcache"
+)
+
+// Node is a gocache server of the cluster, Addr is the base URL its httpcache.Handler is served at
+type Node struct {
+	Addr   string
+	Weight int
+}
+
+// Client is a gocache.Store routing every key to the server owning it on a consistent hash ring
+type Client struct {
+	ring       *Ring
+	httpClient *http.Client
+	mutex      sync.RWMutex
+	clients    map[string]*httpcache.Client
+}
+
+var _ gocache.Store = (*Client)(nil)
+
+// NewClient returns a client of nodes, virtualNodes is the number of ring points of a node of weight 1 (0 for
+// DefaultVirtualNodes) and httpClient may be nil to use http.DefaultClient
+func NewClient(virtualNodes int, httpClient *http.Client, nodes ...Node) *Client {
+	c := &Client{ring: NewRing(virtualNodes), httpClient: httpClient, clients: map[string]*httpcache.Client{}}
+	for _, node := range nodes {
+		c.AddNode(node)
+	}
+	return c
+}
+
+// AddNode adds a server to the cluster, keys it now owns are not moved to it and miss until they are added again
+func (c *Client) AddNode(node Node) {
+	c.mutex.Lock()
+	c.clients[node.Addr] = httpcache.NewClient(node.Addr, c.httpClient)
+	c.mutex.Unlock()
+	c.ring.AddNode(node.Addr, node.Weight)
+}
+
+// RemoveNode removes the server at addr from the cluster, its keys are routed to the other servers
+func (c *Client) RemoveNode(addr string) {
+	c.ring.RemoveNode(addr)
+	c.mutex.Lock()
+	delete(c.clients, addr)
+	c.mutex.Unlock()
+}
+
+// Owner returns the address of the server owning k
+func (c *Client) Owner(k []byte) (string, error) {
+	return c.ring.Node(k)
+}
+
+// Ring returns the ring the client routes keys with
+func (c *Client) Ring() *Ring {
+	return c.ring
+}
+
+func (c *Client) clientFor(k []byte) (*httpcache.Client, error) {
+	addr, err := c.ring.Node(k)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.RLock()
+	client, found := c.clients[addr]
+	c.mutex.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("node %s was removed from the cluster", addr)
+	}
+	return client, nil
+}
+
+// Add adds (k, v) on the server owning k, see httpcache.Client.Add for how costFun is sent
+func (c *Client) Add(k, v []byte, costFun *func(data gocache.Data) int) error {
+	client, err := c.clientFor(k)
+	if err != nil {
+		return err
+	}
+	return client.Add(k, v, costFun)
+}
+
+// Get reads k from the server owning it
+func (c *Client) Get(k []byte) (gocache.Data, error) {
+	client, err := c.clientFor(k)
+	if err != nil {
+		return gocache.Data{}, err
+	}
+	return client.Get(k)
+}
+
+// Update updates k on the server owning it
+func (c *Client) Update(k, v []byte) error {
+	client, err := c.clientFor(k)
+	if err != nil {
+		return err
+	}
+	return client.Update(k, v)
+}
+
+// Evict evicts k from the server owning it
+func (c *Client) Evict(k []byte) error {
+	client, err := c.clientFor(k)
+	if err != nil {
+		return err
+	}
+	return client.Evict(k)
+}
+
+// AddWithTags adds (k, v) with tags on the server owning k
+func (c *Client) AddWithTags(k, v []byte, costFun *func(data gocache.Data) int, tags ...string) error {
+	client, err := c.clientFor(k)
+	if err != nil {
+		return err
+	}
+	return client.AddWithTags(k, v, costFun, tags...)
+}
+
+// InvalidateTag evicts the entries tagged with tag on every server of the cluster, as the keys carrying a tag can
+// be owned by any server. It returns the number of evicted entries and the first error but tries all the servers.
+func (c *Client) InvalidateTag(tag string) (int, error) {
+	evicted := 0
+	var firstErr error
+	for _, client := range c.allClients() {
+		n, err := client.InvalidateTag(tag)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("invalidating tag on %s: %v", client.BaseURL(), err)
+		}
+		evicted += n
+	}
+	return evicted, firstErr
+}
+
+func (c *Client) allClients() []*httpcache.Client {
+	c.mutex.RLock()
+	clients := make([]*httpcache.Client, 0, len(c.clients))
+	for _, client := range c.clients {
+		clients = append(clients, client)
+	}
+	c.mutex.RUnlock()
+	return clients
+}
+
+// Flush clears every server of the cluster, it returns the first error but tries all the servers
+func (c *Client) Flush() error {
+	var firstErr error
+	for _, client := range c.allClients() {
+		if err := client.Flush(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("flushing %s: %v", client.BaseURL(), err)
+		}
+	}
+	return firstErr
+}
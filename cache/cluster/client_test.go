@@ -0,0 +1,132 @@
+package cluster
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"gocache"
+	"gocache/httpcache"
+)
+
+// testNode is a gocache server of a test cluster
+type testNode struct {
+	cache  *gocache.Cache
+	server *httptest.Server
+}
+
+func newTestNodes(t *testing.T, n int) []testNode {
+	t.Helper()
+	nodes := make([]testNode, n)
+	for i := range nodes {
+		nodes[i].cache = &gocache.Cache{}
+		nodes[i].cache.Init(1000, 4)
+		nodes[i].server = httptest.NewServer(httpcache.NewHandler(nodes[i].cache, &gocache.SizeCost))
+		t.Cleanup(nodes[i].server.Close)
+	}
+	return nodes
+}
+
+func TestClientRoutesKeysToTheirOwner(t *testing.T) {
+	nodes := newTestNodes(t, 3)
+	client := NewClient(0, nil)
+	for _, node := range nodes {
+		client.AddNode(Node{Addr: node.server.URL, Weight: 1})
+	}
+
+	keys := [][]byte{[]byte("a"), []byte("a//b"), []byte("\x00\xff\x01"), []byte(""), []byte("with space?&#%")}
+	for i := 0; i < 50; i++ {
+		keys = append(keys, ringKey(i))
+	}
+	for i, k := range keys {
+		if err := client.Add(k, []byte{byte(i)}, &gocache.SizeCost); err != nil {
+			t.Fatalf("Add(%q): %v", k, err)
+		}
+	}
+	for i, k := range keys {
+		data, err := client.Get(k)
+		if err != nil || !bytes.Equal(data.GetValue(), []byte{byte(i)}) {
+			t.Errorf("Get(%q) = %q, %v, want %q", k, data.GetValue(), err, []byte{byte(i)})
+		}
+		owner, _ := client.Owner(k)
+		for _, node := range nodes {
+			_, err := node.cache.Get(k)
+			if owned := node.server.URL == owner; owned != (err == nil) {
+				t.Errorf("key %q owned by %s is in the cache of %s: %v", k, owner, node.server.URL, err == nil)
+			}
+		}
+	}
+
+	if err := client.Update(keys[1], []byte("updated")); err != nil {
+		t.Errorf("Update: %v", err)
+	}
+	if data, _ := client.Get(keys[1]); string(data.GetValue()) != "updated" {
+		t.Errorf("Get after Update = %q, want %q", data.GetValue(), "updated")
+	}
+	if err := client.Evict(keys[1]); err != nil {
+		t.Errorf("Evict: %v", err)
+	}
+	if _, err := client.Get(keys[1]); err != gocache.ErrNotFound {
+		t.Errorf("Get after Evict = %v, want %v", err, gocache.ErrNotFound)
+	}
+}
+
+func TestClientTagsAndFlushReachEveryNode(t *testing.T) {
+	nodes := newTestNodes(t, 3)
+	client := NewClient(0, nil, Node{Addr: nodes[0].server.URL}, Node{Addr: nodes[1].server.URL},
+		Node{Addr: nodes[2].server.URL})
+	for i := 0; i < 30; i++ {
+		if err := client.AddWithTags(ringKey(i), []byte("v"), &gocache.SizeCost, "tag"); err != nil {
+			t.Fatalf("AddWithTags: %v", err)
+		}
+	}
+	for i := 30; i < 60; i++ {
+		client.Add(ringKey(i), []byte("v"), &gocache.SizeCost)
+	}
+	if evicted, err := client.InvalidateTag("tag"); evicted != 30 || err != nil {
+		t.Errorf("InvalidateTag = %v, %v, want 30", evicted, err)
+	}
+	if err := client.Flush(); err != nil {
+		t.Errorf("Flush: %v", err)
+	}
+	for _, node := range nodes {
+		if entries := node.cache.GetEntriesCount(); entries != 0 {
+			t.Errorf("%s has %v entries after Flush", node.server.URL, entries)
+		}
+	}
+}
+
+func TestClientAddRemoveNode(t *testing.T) {
+	nodes := newTestNodes(t, 2)
+	client := NewClient(0, nil, Node{Addr: nodes[0].server.URL})
+	if _, err := NewClient(0, nil).Get([]byte("k")); err != ErrNoNodes {
+		t.Errorf("Get without nodes = %v, want %v", err, ErrNoNodes)
+	}
+
+	for i := 0; i < 100; i++ {
+		client.Add(ringKey(i), []byte("v"), &gocache.SizeCost)
+	}
+	client.AddNode(Node{Addr: nodes[1].server.URL, Weight: 1})
+	missing := 0
+	for i := 0; i < 100; i++ {
+		owner, _ := client.Owner(ringKey(i))
+		_, err := client.Get(ringKey(i))
+		// keys moved to the new node miss until they are added again
+		if (owner == nodes[1].server.URL) != (err == gocache.ErrNotFound) {
+			t.Errorf("Get(%q) owned by %s = %v", ringKey(i), owner, err)
+		}
+		if err != nil {
+			missing++
+		}
+	}
+	if missing == 0 || missing == 100 {
+		t.Errorf("%v keys moved to the added node, want some", missing)
+	}
+
+	client.RemoveNode(nodes[1].server.URL)
+	for i := 0; i < 100; i++ {
+		if _, err := client.Get(ringKey(i)); err != nil {
+			t.Errorf("Get(%q) after removing the added node = %v", ringKey(i), err)
+		}
+	}
+}
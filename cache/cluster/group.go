@@ -0,0 +1,213 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"gocache"
+)
+
+// PeerPath is the path a Group serves the loads of its peers under, the key is the "key" query parameter so the mux
+// does not clean it like a path
+const PeerPath = "/_gocache/peer/"
+
+// defaultHotCacheRatio is the default of GroupConfig.HotCacheRatio
+const defaultHotCacheRatio = 10
+
+// maxPeerValueSize limits the size of a value fetched from a peer
+const maxPeerValueSize = 64 << 20
+
+// GroupConfig configures a Group
+type GroupConfig struct {
+	// Self is the base URL of this node as the peers know it, it must be one of Peers
+	Self string
+	// Peers are all the nodes of the group, this node included
+	Peers []Node
+	// VirtualNodes is the number of ring points of a node of weight 1, 0 for DefaultVirtualNodes
+	VirtualNodes int
+	// Cache holds the keys this node owns
+	Cache *gocache.Cache
+	// Loader loads the keys this node owns
+	Loader gocache.Loader
+	// CostFunction is the cost function of loaded entries
+	CostFunction *func(data gocache.Data) int
+	// HotCache optionally holds copies of keys owned by other nodes, nil disables mirroring
+	HotCache *gocache.Cache
+	// HotCacheRatio mirrors one in HotCacheRatio keys fetched from peers into HotCache, 0 for 10
+	HotCacheRatio int
+	// HTTPClient is used to fetch keys from peers, nil for http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// Group loads every key once across a cluster of nodes, like groupcache. On a miss, a node asks the owner of the
+// key on the consistent hash ring, and only the owner runs the loader and keeps the key in its cache. Keys fetched
+// from peers can be mirrored in a small hot cache so the hottest keys do not cross the network on every Get.
+//
+// The peers reach a Group through its ServeHTTP, which must be mounted at PeerPath on the mux serving Self.
+type Group struct {
+	self          string
+	ring          *Ring
+	cache         *gocache.Cache
+	loader        gocache.Loader
+	costFunction  *func(data gocache.Data) int
+	hotCache      *gocache.Cache
+	hotCacheRatio int
+	httpClient    *http.Client
+	randMutex     sync.Mutex
+	rand          *rand.Rand
+	stats         GroupStats
+}
+
+// GroupStats holds the counters of a group
+type GroupStats struct {
+	Gets        uint64 `json:"gets"`        // calls of Get
+	HotHits     uint64 `json:"hotHits"`     // Get calls answered by the hot cache
+	LocalLoads  uint64 `json:"localLoads"`  // Get calls for keys owned by this node, hits included
+	PeerLoads   uint64 `json:"peerLoads"`   // Get calls answered by the owner peer
+	PeerErrors  uint64 `json:"peerErrors"`  // Get calls whose owner peer failed, they were loaded locally
+	ServedPeers uint64 `json:"servedPeers"` // requests served to peers
+}
+
+// NewGroup returns a group for config
+func NewGroup(config GroupConfig) *Group {
+	g := &Group{
+		self:          strings.TrimRight(config.Self, "/"),
+		ring:          NewRing(config.VirtualNodes),
+		cache:         config.Cache,
+		loader:        config.Loader,
+		costFunction:  config.CostFunction,
+		hotCache:      config.HotCache,
+		hotCacheRatio: config.HotCacheRatio,
+		httpClient:    config.HTTPClient,
+		rand:          rand.New(rand.NewSource(rand.Int63())),
+	}
+	if g.hotCacheRatio <= 0 {
+		g.hotCacheRatio = defaultHotCacheRatio
+	}
+	if g.httpClient == nil {
+		g.httpClient = http.DefaultClient
+	}
+	for _, peer := range config.Peers {
+		g.ring.AddNode(strings.TrimRight(peer.Addr, "/"), peer.Weight)
+	}
+	return g
+}
+
+// Ring returns the ring the group routes keys with, nodes can be added and removed on it
+func (g *Group) Ring() *Ring {
+	return g.ring
+}
+
+// Stats returns the counters of the group
+func (g *Group) Stats() GroupStats {
+	return GroupStats{
+		Gets:        atomic.LoadUint64(&g.stats.Gets),
+		HotHits:     atomic.LoadUint64(&g.stats.HotHits),
+		LocalLoads:  atomic.LoadUint64(&g.stats.LocalLoads),
+		PeerLoads:   atomic.LoadUint64(&g.stats.PeerLoads),
+		PeerErrors:  atomic.LoadUint64(&g.stats.PeerErrors),
+		ServedPeers: atomic.LoadUint64(&g.stats.ServedPeers),
+	}
+}
+
+// Get returns the value of k, from the local cache and loader when this node owns k and from the owner otherwise.
+// When the owner can not be reached, the key is loaded locally so a failed peer does not fail the Get.
+func (g *Group) Get(k []byte) (gocache.Data, error) {
+	atomic.AddUint64(&g.stats.Gets, 1)
+	if g.hotCache != nil {
+		if data, err := g.hotCache.Get(k); err == nil {
+			atomic.AddUint64(&g.stats.HotHits, 1)
+			return data, nil
+		}
+	}
+
+	owner, err := g.ring.Node(k)
+	if err != nil || owner == g.self {
+		return g.getLocally(k)
+	}
+
+	value, err := g.fetch(owner, k)
+	if errors.Is(err, errLoaderFailed) {
+		return gocache.Data{}, err
+	}
+	if err != nil {
+		atomic.AddUint64(&g.stats.PeerErrors, 1)
+		return g.getLocally(k)
+	}
+	atomic.AddUint64(&g.stats.PeerLoads, 1)
+	if g.hotCache != nil && g.mirror() {
+		_ = g.hotCache.Add(k, value, g.costFunction)
+	}
+	return gocache.NewData(k, value, 0, 0), nil
+}
+
+func (g *Group) getLocally(k []byte) (gocache.Data, error) {
+	atomic.AddUint64(&g.stats.LocalLoads, 1)
+	return g.cache.GetOrLoad(k, g.loader, g.costFunction)
+}
+
+// mirror decides whether a key fetched from a peer is copied into the hot cache
+func (g *Group) mirror() bool {
+	g.randMutex.Lock()
+	mirror := g.rand.Intn(g.hotCacheRatio) == 0
+	g.randMutex.Unlock()
+	return mirror
+}
+
+// errLoaderFailed is returned when the owner could be reached but its loader failed, the key is not loaded again locally
+var errLoaderFailed = errors.New("loader of the owner peer failed")
+
+// fetch asks owner for the value of k
+func (g *Group) fetch(owner string, k []byte) ([]byte, error) {
+	response, err := g.httpClient.Get(owner + PeerPath + "?" + url.Values{"key": {string(k)}}.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxPeerValueSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxPeerValueSize {
+		return nil, fmt.Errorf("peer %s responded a value larger than %d bytes", owner, maxPeerValueSize)
+	}
+	switch response.StatusCode {
+	case http.StatusOK:
+		return body, nil
+	case http.StatusBadGateway:
+		return nil, fmt.Errorf("%w: %s", errLoaderFailed, strings.TrimSpace(string(body)))
+	}
+	return nil, fmt.Errorf("peer %s responded %s", owner, response.Status)
+}
+
+// ServeHTTP serves the loads of the peers, it loads the key locally even when this node does not own it by its
+// own ring, so peers with a different view of the ring never bounce a request between them
+func (g *Group) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query, err := url.ParseQuery(r.URL.RawQuery)
+	keys, found := query["key"]
+	if err != nil || !found {
+		http.Error(w, "missing or malformed key", http.StatusBadRequest)
+		return
+	}
+	key := keys[0]
+	atomic.AddUint64(&g.stats.ServedPeers, 1)
+	data, err := g.getLocally([]byte(key))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(data.GetValue())
+}
@@ -0,0 +1,209 @@
+package cluster
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"gocache"
+)
+
+// countingLoader counts the loads of every key over all the nodes of a test group
+type countingLoader struct {
+	mutex sync.Mutex
+	loads map[string]int
+	err   error
+}
+
+func (l *countingLoader) load(k []byte) ([]byte, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.loads == nil {
+		l.loads = map[string]int{}
+	}
+	l.loads[string(k)]++
+	if l.err != nil {
+		return nil, l.err
+	}
+	return append([]byte("value of "), k...), nil
+}
+
+func (l *countingLoader) count(k []byte) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.loads[string(k)]
+}
+
+// groupNode is a node of a test group, served by an httptest server
+type groupNode struct {
+	group  *Group
+	cache  *gocache.Cache
+	server *httptest.Server
+}
+
+// newTestGroup starts n nodes loading with loader, configure can change the config of every node
+func newTestGroup(t *testing.T, n int, loader gocache.Loader, configure func(config *GroupConfig)) []groupNode {
+	t.Helper()
+	nodes := make([]groupNode, n)
+	muxes := make([]*http.ServeMux, n)
+	var peers []Node
+	for i := range nodes {
+		muxes[i] = http.NewServeMux()
+		nodes[i].server = httptest.NewServer(muxes[i])
+		t.Cleanup(nodes[i].server.Close)
+		peers = append(peers, Node{Addr: nodes[i].server.URL})
+	}
+	for i := range nodes {
+		nodes[i].cache = &gocache.Cache{}
+		nodes[i].cache.Init(1000, 4)
+		config := GroupConfig{
+			Self:         nodes[i].server.URL,
+			Peers:        peers,
+			Cache:        nodes[i].cache,
+			Loader:       loader,
+			CostFunction: &gocache.SizeCost,
+		}
+		if configure != nil {
+			configure(&config)
+		}
+		nodes[i].group = NewGroup(config)
+		muxes[i].Handle(PeerPath, nodes[i].group)
+	}
+	return nodes
+}
+
+func groupKeys() [][]byte {
+	keys := [][]byte{[]byte("a//b"), []byte("../x"), []byte(""), []byte("\x00\xff")}
+	for i := 0; i < 30; i++ {
+		keys = append(keys, ringKey(i))
+	}
+	return keys
+}
+
+func TestGroupLoadsEveryKeyOnce(t *testing.T) {
+	loader := &countingLoader{}
+	nodes := newTestGroup(t, 3, loader.load, nil)
+	keys := groupKeys()
+
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		for _, k := range keys {
+			for i := 0; i < 3; i++ {
+				wg.Add(1)
+				go func(group *Group, k []byte) {
+					defer wg.Done()
+					data, err := group.Get(k)
+					if want := "value of " + string(k); err != nil || string(data.GetValue()) != want {
+						t.Errorf("Get(%q) = %q, %v, want %q", k, data.GetValue(), err, want)
+					}
+				}(node.group, k)
+			}
+		}
+	}
+	wg.Wait()
+
+	for _, k := range keys {
+		if loads := loader.count(k); loads != 1 {
+			t.Errorf("%q loaded %v times over the cluster, want 1", k, loads)
+		}
+		owner, _ := nodes[0].group.Ring().Node(k)
+		for _, node := range nodes {
+			_, err := node.cache.Get(k)
+			if owned := node.server.URL == owner; owned != (err == nil) {
+				t.Errorf("%q owned by %s is in the cache of %s: %v", k, owner, node.server.URL, err == nil)
+			}
+		}
+	}
+
+	var stats GroupStats
+	for _, node := range nodes {
+		s := node.group.Stats()
+		stats.Gets += s.Gets
+		stats.LocalLoads += s.LocalLoads
+		stats.PeerLoads += s.PeerLoads
+		stats.ServedPeers += s.ServedPeers
+		stats.PeerErrors += s.PeerErrors
+	}
+	if gets := uint64(len(nodes) * len(keys) * 3); stats.Gets != gets || stats.PeerErrors != 0 {
+		t.Errorf("stats = %+v, want %v gets without peer errors", stats, gets)
+	}
+	// every Get is answered locally by the owner, or by a peer which served it with a local load
+	if stats.PeerLoads != stats.ServedPeers || stats.LocalLoads != stats.Gets {
+		t.Errorf("stats = %+v, want as many peer loads as served peers and a local load per Get", stats)
+	}
+}
+
+func TestGroupHotCache(t *testing.T) {
+	loader := &countingLoader{}
+	nodes := newTestGroup(t, 2, loader.load, func(config *GroupConfig) {
+		config.HotCache = &gocache.Cache{}
+		config.HotCache.Init(100, 1)
+		config.HotCacheRatio = 1
+	})
+	// a key owned by the second node, read from the first one
+	var k []byte
+	for _, candidate := range groupKeys() {
+		if owner, _ := nodes[0].group.Ring().Node(candidate); owner == nodes[1].server.URL {
+			k = candidate
+			break
+		}
+	}
+	if k == nil {
+		t.Fatal("no key is owned by the second node")
+	}
+
+	for i := 0; i < 3; i++ {
+		if data, err := nodes[0].group.Get(k); err != nil || string(data.GetValue()) != "value of "+string(k) {
+			t.Fatalf("Get(%q) = %q, %v", k, data.GetValue(), err)
+		}
+	}
+	if stats := nodes[0].group.Stats(); stats.PeerLoads != 1 || stats.HotHits != 2 {
+		t.Errorf("stats = %+v, want 1 peer load and 2 hot hits", stats)
+	}
+	if stats := nodes[1].group.Stats(); stats.ServedPeers != 1 {
+		t.Errorf("owner stats = %+v, want 1 served peer", stats)
+	}
+	if _, err := nodes[0].cache.Get(k); err != gocache.ErrNotFound {
+		t.Errorf("a mirrored key is in the main cache of a node not owning it: %v", err)
+	}
+	if loads := loader.count(k); loads != 1 {
+		t.Errorf("%q loaded %v times, want 1", k, loads)
+	}
+}
+
+func TestGroupPeerFailures(t *testing.T) {
+	loader := &countingLoader{}
+	nodes := newTestGroup(t, 2, loader.load, nil)
+	var k []byte
+	for _, candidate := range groupKeys() {
+		if owner, _ := nodes[0].group.Ring().Node(candidate); owner == nodes[1].server.URL {
+			k = candidate
+			break
+		}
+	}
+
+	// a failing loader of the owner fails the Get without loading the key again locally
+	loader.mutex.Lock()
+	loader.err = errors.New("database down")
+	loader.mutex.Unlock()
+	if _, err := nodes[0].group.Get(k); !errors.Is(err, errLoaderFailed) {
+		t.Errorf("Get with a failing loader on the owner = %v, want %v", err, errLoaderFailed)
+	}
+	if loads := loader.count(k); loads != 1 {
+		t.Errorf("%q loaded %v times, want 1", k, loads)
+	}
+
+	// an unreachable owner makes the node load the key itself
+	loader.mutex.Lock()
+	loader.err = nil
+	loader.mutex.Unlock()
+	nodes[1].server.Close()
+	if data, err := nodes[0].group.Get(k); err != nil || string(data.GetValue()) != "value of "+string(k) {
+		t.Errorf("Get with the owner down = %q, %v", data.GetValue(), err)
+	}
+	if stats := nodes[0].group.Stats(); stats.PeerErrors != 1 || stats.LocalLoads != 1 {
+		t.Errorf("stats = %+v, want 1 peer error and 1 local load", stats)
+	}
+}
@@ -0,0 +1,121 @@
+// Package cluster shards keys over several gocache servers with consistent hashing.
+package cluster
+
+import (
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultVirtualNodes is the number of points a node of weight 1 gets on the ring
+const DefaultVirtualNodes = 160
+
+// ErrNoNodes is returned when a key is routed on a ring without nodes
+var ErrNoNodes = errors.New("cluster has no nodes")
+
+// Ring is a consistent hash ring. Every node is placed on the ring at weight times virtualNodes points and a key
+// belongs to the node of the first point at or after the hash of the key, so adding or removing a node only moves
+// the keys of its own points. Ring is safe for concurrent use.
+type Ring struct {
+	mutex        sync.RWMutex
+	virtualNodes int
+	weights      map[string]int
+	points       []uint64          // sorted hashes of the virtual nodes
+	owners       map[uint64]string // node of every point
+}
+
+// NewRing returns an empty ring, virtualNodes is the number of points of a node of weight 1,
+// 0 means DefaultVirtualNodes
+func NewRing(virtualNodes int) *Ring {
+	if virtualNodes <= 0 {
+		virtualNodes = DefaultVirtualNodes
+	}
+	return &Ring{virtualNodes: virtualNodes, weights: map[string]int{}, owners: map[uint64]string{}}
+}
+
+// AddNode places node on the ring with weight, a node of weight 2 owns about twice the keys of a node of weight 1.
+// Adding a node which is already on the ring changes its weight.
+func (r *Ring) AddNode(node string, weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	r.mutex.Lock()
+	r.weights[node] = weight
+	r.rebuild()
+	r.mutex.Unlock()
+}
+
+// RemoveNode removes node from the ring
+func (r *Ring) RemoveNode(node string) {
+	r.mutex.Lock()
+	delete(r.weights, node)
+	r.rebuild()
+	r.mutex.Unlock()
+}
+
+// Nodes returns the nodes on the ring, sorted
+func (r *Ring) Nodes() []string {
+	r.mutex.RLock()
+	nodes := make([]string, 0, len(r.weights))
+	for node := range r.weights {
+		nodes = append(nodes, node)
+	}
+	r.mutex.RUnlock()
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Node returns the node owning key
+func (r *Ring) Node(key []byte) (string, error) {
+	h := hash(key)
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if len(r.points) == 0 {
+		return "", ErrNoNodes
+	}
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]], nil
+}
+
+// rebuild recomputes the points of the ring, ring must be locked by the caller
+func (r *Ring) rebuild() {
+	r.points = r.points[:0]
+	r.owners = map[uint64]string{}
+	// nodes are placed in sorted order so two nodes hashing a point to the same value always resolve the same way
+	nodes := make([]string, 0, len(r.weights))
+	for node := range r.weights {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		for i := 0; i < r.weights[node]*r.virtualNodes; i++ {
+			point := hash([]byte(node + "#" + strconv.Itoa(i)))
+			if _, taken := r.owners[point]; taken {
+				continue
+			}
+			r.owners[point] = node
+			r.points = append(r.points, point)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+}
+
+// hash returns the position of key on the ring. FNV-1a spreads keys differing only in their last bytes, like
+// "host:8001#1" and "host:8002#1", over the low bits only, so its result goes through the finalizer of MurmurHash3
+// to spread them over the whole ring.
+func hash(key []byte) uint64 {
+	hasher := fnv.New64a()
+	_, _ = hasher.Write(key)
+	h := hasher.Sum64()
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,116 @@
+package cluster
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func ringKey(i int) []byte {
+	return []byte(fmt.Sprintf("key%v", i))
+}
+
+// ownership returns the number of keys out of n owned by every node of r
+func ownership(t *testing.T, r *Ring, n int) map[string]int {
+	t.Helper()
+	owned := map[string]int{}
+	for i := 0; i < n; i++ {
+		node, err := r.Node(ringKey(i))
+		if err != nil {
+			t.Fatalf("Node(%q): %v", ringKey(i), err)
+		}
+		owned[node]++
+	}
+	return owned
+}
+
+func TestRingEmpty(t *testing.T) {
+	r := NewRing(0)
+	if _, err := r.Node([]byte("k")); err != ErrNoNodes {
+		t.Errorf("Node on an empty ring = %v, want %v", err, ErrNoNodes)
+	}
+	r.AddNode("a", 1)
+	r.RemoveNode("a")
+	if _, err := r.Node([]byte("k")); err != ErrNoNodes {
+		t.Errorf("Node after removing the last node = %v, want %v", err, ErrNoNodes)
+	}
+}
+
+func TestRingDistribution(t *testing.T) {
+	r := NewRing(0)
+	nodes := []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080", "http://10.0.0.3:8080", "http://10.0.0.4:8080"}
+	for _, node := range nodes {
+		r.AddNode(node, 1)
+	}
+	if got := r.Nodes(); fmt.Sprint(got) != fmt.Sprint(nodes) {
+		t.Errorf("Nodes = %v, want %v", got, nodes)
+	}
+	const keys = 100000
+	owned := ownership(t, r, keys)
+	mean := float64(keys) / float64(len(nodes))
+	for _, node := range nodes {
+		if deviation := math.Abs(float64(owned[node])-mean) / mean; deviation > 0.2 {
+			t.Errorf("%s owns %v keys, %.0f%% away from the mean %v", node, owned[node], deviation*100, mean)
+		}
+	}
+}
+
+func TestRingWeights(t *testing.T) {
+	r := NewRing(0)
+	r.AddNode("light", 1)
+	r.AddNode("heavy", 3)
+	owned := ownership(t, r, 100000)
+	if ratio := float64(owned["heavy"]) / float64(owned["light"]); ratio < 2.4 || ratio > 3.6 {
+		t.Errorf("heavy owns %v keys and light %v, ratio %.2f, want about 3", owned["heavy"], owned["light"], ratio)
+	}
+
+	// adding a node again changes its weight
+	r.AddNode("heavy", 1)
+	owned = ownership(t, r, 100000)
+	if ratio := float64(owned["heavy"]) / float64(owned["light"]); ratio < 0.8 || ratio > 1.25 {
+		t.Errorf("after reweighting heavy owns %v keys and light %v, want about as many", owned["heavy"], owned["light"])
+	}
+}
+
+func TestRingAddRemoveMovesOnlyTheKeysOfTheNode(t *testing.T) {
+	r := NewRing(0)
+	for _, node := range []string{"a", "b", "c"} {
+		r.AddNode(node, 1)
+	}
+	const keys = 10000
+	before := make([]string, keys)
+	for i := range before {
+		before[i], _ = r.Node(ringKey(i))
+	}
+
+	r.AddNode("d", 1)
+	moved := 0
+	for i := range before {
+		node, _ := r.Node(ringKey(i))
+		if node != before[i] {
+			if node != "d" {
+				t.Fatalf("adding d moved %q from %s to %s", ringKey(i), before[i], node)
+			}
+			moved++
+		}
+	}
+	// d should take about a quarter of the keys
+	if moved < keys/8 || moved > keys/2 {
+		t.Errorf("adding a fourth node moved %v keys out of %v", moved, keys)
+	}
+
+	r.RemoveNode("d")
+	for i := range before {
+		if node, _ := r.Node(ringKey(i)); node != before[i] {
+			t.Fatalf("after removing d, %q is owned by %s, want %s", ringKey(i), node, before[i])
+		}
+	}
+
+	r.RemoveNode("b")
+	for i := range before {
+		node, _ := r.Node(ringKey(i))
+		if node == "b" || (before[i] != "b" && node != before[i]) {
+			t.Fatalf("removing b moved %q from %s to %s", ringKey(i), before[i], node)
+		}
+	}
+}
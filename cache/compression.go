@@ -0,0 +1,225 @@
+package gocache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// DefaultCompressionThreshold is the length from which values are compressed when SetCompression is given a
+// threshold of 0, smaller values rarely shrink enough to be worth the time
+const DefaultCompressionThreshold = 256
+
+// Codec compresses and decompresses values for SetCompression. The methods must be safe for concurrent use.
+type Codec interface {
+	// Name identifies the codec, for example "flate"
+	Name() string
+	// Compress appends src compressed to dst and returns the extended slice
+	Compress(dst, src []byte) ([]byte, error)
+	// Decompress appends src, returned by Compress, decompressed to dst and returns the extended slice
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+// compression is the codec of the cache and the length from which values are compressed
+type compression struct {
+	codec     Codec
+	threshold int
+}
+
+// SetCompression makes the cache compress with codec the values of at least threshold bytes given to Add,
+// AddWithTags, Update, GetOrLoad, Restore and transactions, or DefaultCompressionThreshold bytes when threshold is
+// 0. A value is kept as is when it does not shrink. Values are decompressed by every method returning them, and for
+// the mutation listeners and watchers, so compression is invisible to the callers except in the counters and the
+// cost functions: the byte count is the compressed length and GetRawBytesCount the length before compression. The
+// preset cost functions use the length before compression, so compressing does not change the eviction order. Cost
+// functions run on the stored entry, for a compressed entry GetValue returns the compressed bytes and GetSize the
+// length before compression, decompressing for every cost computation would cost more than compression saves. A nil codec disables compression, which is the default. Entries keep the codec they were stored
+// with, so the codec can be changed at any time.
+func (c *Cache) SetCompression(codec Codec, threshold int) {
+	if codec == nil {
+		c.compression.Store((*compression)(nil))
+		return
+	}
+	if threshold <= 0 {
+		threshold = DefaultCompressionThreshold
+	}
+	c.compression.Store(&compression{codec, threshold})
+}
+
+// compress returns the value to store for v with the codec it was compressed with, nil when it is stored as is
+func (c *Cache) compress(v []byte) ([]byte, Codec, error) {
+	settings, _ := c.compression.Load().(*compression)
+	if settings == nil || len(v) < settings.threshold {
+		return v, nil, nil
+	}
+	compressed, err := settings.codec.Compress(nil, v)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(compressed) >= len(v) {
+		return v, nil, nil
+	}
+	return compressed, settings.codec, nil
+}
+
+// prepare returns the key and value to store for k and v: the value compressed when it is long enough, and both
+// copied unless the cache is in zero-copy mode, a compressed value being a copy already. size is the length of v.
+func (c *Cache) prepare(k, v []byte) (key []byte, value []byte, codec Codec, size int, err error) {
+	value, codec, err = c.compress(v)
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	if codec == nil {
+		key, value = c.copyIn(k, v)
+		return key, value, nil, len(v), nil
+	}
+	return c.copyBytes(k), value, codec, len(v), nil
+}
+
+// prepareValue returns the value to store for v like prepare does
+func (c *Cache) prepareValue(v []byte) ([]byte, Codec, int, error) {
+	value, codec, err := c.compress(v)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if codec == nil {
+		return c.copyBytes(v), nil, len(v), nil
+	}
+	return value, codec, len(v), nil
+}
+
+// output returns data as returned to the callers: with its value decompressed, or with its key and value copied
+// unless the cache is in zero-copy mode
+func (c *Cache) output(data Data) (Data, error) {
+	if data.codec == nil {
+		return c.copyOut(data), nil
+	}
+	value, err := data.codec.Decompress(make([]byte, 0, data.size), data.value)
+	if err != nil {
+		return Data{}, err
+	}
+	data.key, data.value, data.codec = c.copyBytes(data.key), value, nil
+	return data, nil
+}
+
+// rawSize returns the length of the key and value of an entry before compression
+func rawSize(node *Data) uint64 {
+	if node.codec == nil {
+		return entrySize(node)
+	}
+	return uint64(len(node.key) + node.size)
+}
+
+// decompressBuffers holds the buffers GetFunc decompresses values into
+var decompressBuffers = sync.Pool{New: func() interface{} { return new([]byte) }}
+
+// NewCodec returns the built-in codec named name, "flate" or "gzip", at the default compression level, for flags
+// and configuration files
+func NewCodec(name string) (Codec, error) {
+	switch name {
+	case "flate":
+		return NewFlateCodec(flate.DefaultCompression)
+	case "gzip":
+		return NewGzipCodec(gzip.DefaultCompression)
+	}
+	return nil, fmt.Errorf("unknown codec %q", name)
+}
+
+// NewFlateCodec returns a codec compressing with DEFLATE at level, one of the levels of compress/flate
+func NewFlateCodec(level int) (Codec, error) {
+	if _, err := flate.NewWriter(nil, level); err != nil {
+		return nil, err
+	}
+	codec := &streamCodec{name: "flate"}
+	codec.writers.New = func() interface{} {
+		w, _ := flate.NewWriter(nil, level)
+		return w
+	}
+	codec.newReader = func(r io.Reader) (io.ReadCloser, error) {
+		return flate.NewReader(r), nil
+	}
+	return codec, nil
+}
+
+// NewGzipCodec returns a codec compressing with gzip at level, one of the levels of compress/gzip. It writes a
+// header of about 20 bytes in every value, which NewFlateCodec does not.
+func NewGzipCodec(level int) (Codec, error) {
+	if _, err := gzip.NewWriterLevel(nil, level); err != nil {
+		return nil, err
+	}
+	codec := &streamCodec{name: "gzip"}
+	codec.writers.New = func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, level)
+		return w
+	}
+	codec.newReader = func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	}
+	return codec, nil
+}
+
+// resetWriter is the writer of a compress package, which can be reused with Reset
+type resetWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// streamCodec is a codec using the stream compressors of the standard library, reusing their writers and readers,
+// whose allocations cost more than compressing a small value
+type streamCodec struct {
+	name      string
+	writers   sync.Pool // resetWriter
+	readers   sync.Pool // io.ReadCloser, implementing flate.Resetter or with a Reset method like gzip.Reader
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+func (codec *streamCodec) Name() string {
+	return codec.name
+}
+
+func (codec *streamCodec) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w := codec.writers.Get().(resetWriter)
+	defer codec.writers.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (codec *streamCodec) Decompress(dst, src []byte) ([]byte, error) {
+	r, err := codec.reader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer codec.readers.Put(r)
+	buf := bytes.NewBuffer(dst)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// reader returns a reader of src, reused when one is available
+func (codec *streamCodec) reader(src io.Reader) (io.ReadCloser, error) {
+	switch r := codec.readers.Get().(type) {
+	case flate.Resetter:
+		if err := r.Reset(src, nil); err != nil {
+			return nil, err
+		}
+		return r.(io.ReadCloser), nil
+	case *gzip.Reader:
+		if err := r.Reset(src); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	return codec.newReader(src)
+}
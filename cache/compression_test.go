@@ -0,0 +1,419 @@
+package gocache
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+)
+
+// jsonValue returns a JSON blob of about n bytes which compresses well, like the values of a typical cache
+func jsonValue(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i := 0; buf.Len() < n; i++ {
+		fmt.Fprintf(&buf, `{"id":%v,"name":"user %v","active":true,"roles":["reader","writer"]},`, i, i)
+	}
+	buf.WriteString("{}]")
+	return buf.Bytes()
+}
+
+func randomValue(n int) []byte {
+	value := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(value)
+	return value
+}
+
+// countingCodec is a Codec of another package, counting its calls
+type countingCodec struct {
+	Codec
+	compressions   int64
+	decompressions int64
+}
+
+func (codec *countingCodec) Compress(dst, src []byte) ([]byte, error) {
+	atomic.AddInt64(&codec.compressions, 1)
+	return codec.Codec.Compress(dst, src)
+}
+
+func (codec *countingCodec) Decompress(dst, src []byte) ([]byte, error) {
+	atomic.AddInt64(&codec.decompressions, 1)
+	return codec.Codec.Decompress(dst, src)
+}
+
+func newTestCodec(t testing.TB, name string) Codec {
+	t.Helper()
+	var codec Codec
+	var err error
+	switch name {
+	case "flate":
+		codec, err = NewFlateCodec(flate.DefaultCompression)
+	case "gzip":
+		codec, err = NewGzipCodec(flate.BestSpeed)
+	}
+	if err != nil {
+		t.Fatalf("new %v codec: %v", name, err)
+	}
+	return codec
+}
+
+func TestCodecs(t *testing.T) {
+	for _, name := range []string{"flate", "gzip"} {
+		t.Run(name, func(t *testing.T) {
+			codec := newTestCodec(t, name)
+			if codec.Name() != name {
+				t.Errorf("Name = %q, want %q", codec.Name(), name)
+			}
+			for _, value := range [][]byte{{}, []byte("v"), jsonValue(10000), randomValue(1000)} {
+				compressed, err := codec.Compress([]byte("prefix"), value)
+				if err != nil || !bytes.HasPrefix(compressed, []byte("prefix")) {
+					t.Fatalf("Compress = %q, %v, want the compressed value appended to the prefix", compressed, err)
+				}
+				// twice, the second time with a reused reader
+				for i := 0; i < 2; i++ {
+					decompressed, err := codec.Decompress([]byte("prefix"), compressed[len("prefix"):])
+					if err != nil || !bytes.Equal(decompressed, append([]byte("prefix"), value...)) {
+						t.Fatalf("Decompress of %v bytes = %v bytes, %v", len(value), len(decompressed)-len("prefix"), err)
+					}
+				}
+			}
+			if _, err := codec.Decompress(nil, []byte("not compressed")); err == nil {
+				t.Errorf("Decompress of garbage succeeded")
+			}
+		})
+	}
+	for _, name := range []string{"flate", "gzip"} {
+		if codec, err := NewCodec(name); err != nil || codec.Name() != name {
+			t.Errorf("NewCodec(%q) = %v, %v", name, codec, err)
+		}
+	}
+	if _, err := NewCodec("zstd"); err == nil {
+		t.Errorf("NewCodec(zstd) succeeded")
+	}
+	if _, err := NewFlateCodec(42); err == nil {
+		t.Errorf("NewFlateCodec(42) succeeded")
+	}
+	if _, err := NewGzipCodec(42); err == nil {
+		t.Errorf("NewGzipCodec(42) succeeded")
+	}
+}
+
+func TestCompression(t *testing.T) {
+	large, small, random := jsonValue(10000), jsonValue(100), randomValue(1000)
+	for _, name := range []string{"flate", "gzip"} {
+		t.Run(name, func(t *testing.T) {
+			c := newTestCache(t, 100, 4)
+			c.SetCompression(newTestCodec(t, name), 0)
+			c.Add([]byte("large"), large, &SizeCost)
+			c.Add([]byte("small"), small, &SizeCost)
+			c.Add([]byte("random"), random, &SizeCost)
+
+			compressed := map[string]bool{"large": true, "small": false, "random": false}
+			for k, want := range compressed {
+				if node := entry(c, k); (node.codec != nil) != want {
+					t.Errorf("%v is compressed: %v, want %v", k, node.codec != nil, want)
+				}
+			}
+			if node := entry(c, "large"); len(node.value) >= len(large)/5 || (*node.costFunction)(*node) != len("large")+len(large) {
+				t.Errorf("large value stored in %v bytes with cost %v", len(node.value), (*node.costFunction)(*node))
+			}
+			for k, want := range map[string][]byte{"large": large, "small": small, "random": random} {
+				if data, err := c.Get([]byte(k)); err != nil || !bytes.Equal(data.GetValue(), want) {
+					t.Errorf("Get(%v) = %v bytes, %v, want %v bytes", k, len(data.GetValue()), err, len(want))
+				}
+			}
+
+			stats := c.Stats()
+			raw := uint64(len("large") + len(large) + len("small") + len(small) + len("random") + len(random))
+			if stats.RawBytes != raw || c.GetRawBytesCount() != raw || stats.Bytes >= raw {
+				t.Errorf("bytes = %v, raw bytes = %v, want raw bytes %v and less bytes", stats.Bytes, stats.RawBytes, raw)
+			}
+			if ratio := stats.CompressionRatio; ratio < 2 {
+				t.Errorf("CompressionRatio = %v, want at least 2", ratio)
+			}
+
+			// updates change the compression of the entry with its value
+			c.Update([]byte("large"), small)
+			c.Update([]byte("small"), large)
+			if entry(c, "large").codec != nil || entry(c, "small").codec == nil {
+				t.Errorf("compression of the updated entries did not follow their values")
+			}
+			if data, _ := c.Get([]byte("small")); !bytes.Equal(data.GetValue(), large) {
+				t.Errorf("Get(small) after Update = %v bytes, want %v", len(data.GetValue()), len(large))
+			}
+			checkCache(t, c)
+
+			c.Clear()
+			if stats := c.Stats(); stats.Bytes != 0 || stats.RawBytes != 0 || stats.CompressionRatio != 1 {
+				t.Errorf("after Clear bytes = %v, raw bytes = %v, ratio = %v", stats.Bytes, stats.RawBytes, stats.CompressionRatio)
+			}
+		})
+	}
+}
+
+// entry returns the node of k, as stored
+func entry(c *Cache, k string) *Data {
+	h := getHash64([]byte(k))
+	b := &c.buckets[h%uint64(len(c.buckets))]
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.entries[h]
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	c.SetCompression(newTestCodec(t, "flate"), 1000)
+	c.Add([]byte("under"), jsonValue(900), &ConstantCost)
+	c.Add([]byte("over"), jsonValue(1100), &ConstantCost)
+	if entry(c, "under").codec != nil || entry(c, "over").codec == nil {
+		t.Errorf("values were not compressed from the threshold on")
+	}
+
+	// entries keep their codec when compression is disabled
+	c.SetCompression(nil, 0)
+	c.Add([]byte("later"), jsonValue(1100), &ConstantCost)
+	if entry(c, "later").codec != nil {
+		t.Errorf("value compressed after compression was disabled")
+	}
+	if data, err := c.Get([]byte("over")); err != nil || !bytes.Equal(data.GetValue(), jsonValue(1100)) {
+		t.Errorf("Get(over) after disabling compression = %v bytes, %v", len(data.GetValue()), err)
+	}
+	checkCache(t, c)
+}
+
+func TestCompressionPluggableCodec(t *testing.T) {
+	codec := &countingCodec{Codec: newTestCodec(t, "flate")}
+	c := newTestCache(t, 100, 4)
+	c.SetCompression(codec, 0)
+	value := jsonValue(1000)
+	c.Add([]byte("k"), value, &ConstantCost)
+	c.Get([]byte("k"))
+	c.GetFunc([]byte("k"), func(got []byte) {
+		if !bytes.Equal(got, value) {
+			t.Errorf("GetFunc = %v bytes, want %v", len(got), len(value))
+		}
+	})
+	if codec.compressions != 1 || codec.decompressions != 2 {
+		t.Errorf("%v compressions and %v decompressions, want 1 and 2", codec.compressions, codec.decompressions)
+	}
+}
+
+func TestCompressionReturnsDecompressedValues(t *testing.T) {
+	value := jsonValue(1000)
+	tests := []struct {
+		name  string
+		value func(c *Cache) []byte
+	}{
+		{"GetOrLoad", func(c *Cache) []byte {
+			data, _ := c.GetOrLoad([]byte("key"), nil, &ConstantCost)
+			return data.GetValue()
+		}},
+		{"GetOrLoad loaded", func(c *Cache) []byte {
+			data, _ := c.GetOrLoad([]byte("loaded"), func([]byte) ([]byte, error) { return value, nil }, &ConstantCost)
+			if entry(c, "loaded").codec == nil {
+				return nil
+			}
+			data, _ = c.GetOrLoad([]byte("loaded"), nil, &ConstantCost)
+			return data.GetValue()
+		}},
+		{"Range", func(c *Cache) []byte {
+			var got []byte
+			c.Range(func(data Data) bool {
+				got = data.GetValue()
+				return false
+			})
+			return got
+		}},
+		{"ScanPrefix", func(c *Cache) []byte {
+			return c.ScanPrefix([]byte("key"))[0].GetValue()
+		}},
+		{"Txn.Get", func(c *Cache) []byte {
+			var got []byte
+			c.Transaction(func(tx *Txn) error {
+				data, _ := tx.Get([]byte("key"))
+				got = data.GetValue()
+				return nil
+			})
+			return got
+		}},
+		{"Txn.Add", func(c *Cache) []byte {
+			c.Transaction(func(tx *Txn) error {
+				return tx.Add([]byte("added"), value, &ConstantCost)
+			})
+			if entry(c, "added").codec == nil {
+				return nil
+			}
+			data, _ := c.Get([]byte("added"))
+			return data.GetValue()
+		}},
+		{"Txn.Update", func(c *Cache) []byte {
+			c.Transaction(func(tx *Txn) error {
+				return tx.Update([]byte("key"), value[:len(value)-1])
+			})
+			data, _ := c.Get([]byte("key"))
+			return append(data.GetValue(), value[len(value)-1])
+		}},
+		{"Watch", func(c *Cache) []byte {
+			w := c.Watch(nil)
+			defer w.Close()
+			c.Update([]byte("key"), value)
+			return (<-w.Events).GetValue()
+		}},
+		{"Snapshot", func(c *Cache) []byte {
+			var snapshot bytes.Buffer
+			c.Snapshot(&snapshot)
+			restored := newTestCache(t, 100, 4)
+			restored.Restore(&snapshot, &ConstantCost)
+			data, _ := restored.Get([]byte("key"))
+			return data.GetValue()
+		}},
+		{"Restore", func(c *Cache) []byte {
+			var snapshot bytes.Buffer
+			c.Snapshot(&snapshot)
+			c.Clear()
+			c.Restore(&snapshot, &ConstantCost)
+			if entry(c, "key").codec == nil {
+				return nil
+			}
+			data, _ := c.Get([]byte("key"))
+			return data.GetValue()
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestCache(t, 100, 4)
+			c.SetCompression(newTestCodec(t, "flate"), 0)
+			c.Add([]byte("key"), value, &ConstantCost)
+			if got := test.value(c); !bytes.Equal(got, value) {
+				t.Errorf("value = %v bytes, want %v", len(got), len(value))
+			}
+			checkCache(t, c)
+		})
+	}
+}
+
+func TestCompressionMutationsDecompressLater(t *testing.T) {
+	codec := &countingCodec{Codec: newTestCodec(t, "flate")}
+	c := newTestCache(t, 100, 4)
+	c.SetCompression(codec, 0)
+	var mutations []Mutation
+	cancel := c.OnMutation(func(mutation Mutation) {
+		mutations = append(mutations, mutation)
+	})
+	defer cancel()
+	w := c.Watch(nil)
+	defer w.Close()
+
+	value := jsonValue(1000)
+	c.Add([]byte("k"), value, &ConstantCost)
+	c.Update([]byte("k"), value)
+	c.Evict([]byte("k"))
+	c.Add([]byte("small"), []byte("v"), &ConstantCost)
+	if decompressions := atomic.LoadInt64(&codec.decompressions); decompressions != 0 {
+		t.Errorf("%v decompressions while notifying, want none", decompressions)
+	}
+	if len(mutations) != 4 {
+		t.Fatalf("%v mutations, want 4", len(mutations))
+	}
+	for _, mutation := range mutations[:3] {
+		if mutation.Value != nil || !bytes.Equal(mutation.GetValue(), value) {
+			t.Errorf("%v mutation Value = %v bytes, GetValue = %v bytes, want nil and %v bytes",
+				mutation.Kind, len(mutation.Value), len(mutation.GetValue()), len(value))
+		}
+		if event := <-w.Events; event.Value != nil || !bytes.Equal(event.GetValue(), value) {
+			t.Errorf("%v event GetValue = %v bytes, want %v", event.Kind, len(event.GetValue()), len(value))
+		}
+	}
+	// values which compression does not shrink are stored as is
+	if mutation := mutations[3]; string(mutation.Value) != "v" || string(mutation.GetValue()) != "v" {
+		t.Errorf("mutation of an uncompressed entry Value = %q, GetValue = %q", mutation.Value, mutation.GetValue())
+	}
+}
+
+func TestCompressionKeysDoNotDecompress(t *testing.T) {
+	codec := &countingCodec{Codec: newTestCodec(t, "flate")}
+	c := newTestCache(t, 100, 4)
+	c.SetCompression(codec, 0)
+	for i := 0; i < 10; i++ {
+		c.Add(key(i), jsonValue(1000), &ConstantCost)
+	}
+	if keys := c.Keys(); len(keys) != 10 {
+		t.Errorf("Keys returned %v keys, want 10", len(keys))
+	}
+	if _, keys := c.Scan(0, 100); len(keys) != 10 {
+		t.Errorf("Scan returned %v keys, want 10", len(keys))
+	}
+	if decompressions := atomic.LoadInt64(&codec.decompressions); decompressions != 0 {
+		t.Errorf("Keys and Scan decompressed %v values", decompressions)
+	}
+}
+
+func TestCompressionCostFunctions(t *testing.T) {
+	c := newTestCache(t, 100, 1)
+	c.SetCompression(newTestCodec(t, "flate"), 16)
+	var seenValue, seenSize int
+	custom := func(data Data) int {
+		seenValue, seenSize = len(data.GetValue()), data.GetSize()
+		return 0
+	}
+	value := bytes.Repeat([]byte("a"), 700)
+	c.Add([]byte("custom"), value, &custom)
+	if seenSize != 700 || seenValue >= 700 {
+		t.Errorf("custom cost function saw a value of %v bytes and a size of %v, want the compressed value and 700",
+			seenValue, seenSize)
+	}
+
+	c.Add([]byte("k"), value, &SizeCost)
+	c.Add([]byte("b"), value, &BalancedCost)
+	c.Add([]byte("d"), value, &DependentsCost)
+	for _, k := range []string{"k", "b", "d"} {
+		if node := entry(c, k); node.codec == nil || (*node.costFunction)(*node) != 1+700 {
+			t.Errorf("cost of %v = %v, want the length before compression %v", k, (*node.costFunction)(*node), 1+700)
+		}
+	}
+	// a compressed value is not cheaper to keep than a shorter value stored as is
+	c.SetCompression(nil, 0)
+	c.Add([]byte("short"), bytes.Repeat([]byte("a"), 100), &SizeCost)
+	if node := entry(c, "short"); (*node.costFunction)(*node) >= (*entry(c, "k").costFunction)(*entry(c, "k")) {
+		t.Errorf("short uncompressed value costs more than a long compressed value")
+	}
+	if data, err := c.Get([]byte("k")); err != nil || data.GetSize() != 700 {
+		t.Errorf("GetSize of Get = %v, %v, want 700", data.GetSize(), err)
+	}
+}
+
+func TestCompressionNamespace(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	c.SetCompression(newTestCodec(t, "flate"), 0)
+	ns := c.Namespace("ns")
+	ns.Add([]byte("k"), jsonValue(1000), &ConstantCost)
+	if ns.cache.GetRawBytesCount() <= ns.cache.GetBytesCount() {
+		t.Errorf("namespace did not compress like its cache")
+	}
+	ns.SetCompression(nil, 0)
+	ns.Add([]byte("k"), jsonValue(1000), &ConstantCost)
+	if ns.cache.GetRawBytesCount() != ns.cache.GetBytesCount() {
+		t.Errorf("namespace compressed after its compression was disabled")
+	}
+}
+
+func BenchmarkGetCompressed(b *testing.B) {
+	value := jsonValue(4096)
+	for _, name := range []string{"none", "flate", "gzip"} {
+		b.Run(name, func(b *testing.B) {
+			c := newTestCache(b, 1000, 0)
+			if name != "none" {
+				c.SetCompression(newTestCodec(b, name), 0)
+			}
+			c.Add([]byte("key"), value, &ConstantCost)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.Get([]byte("key"))
+			}
+			b.ReportMetric(c.Stats().CompressionRatio, "ratio")
+		})
+	}
+}
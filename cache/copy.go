@@ -0,0 +1,106 @@
+package gocache
+
+import "sync/atomic"
+
+// CopyMode tells whether the cache copies the keys and values given to it and returned by it
+type CopyMode int32
+
+const (
+	// CopyValues, the default, stores copies of the keys and values given to Add, AddWithTags, Update and
+	// transactions, and returns copies from Get, GetOrLoad, Range, ScanPrefix, Keys, Scan and Txn.Get, so callers can
+	// reuse or modify their buffers and the returned ones freely
+	CopyValues CopyMode = iota
+	// ZeroCopy stores the slices given to the cache and returns the stored slices without copying them. Callers must
+	// not modify a slice after giving it to the cache nor a slice returned by it.
+	ZeroCopy
+)
+
+var copyModeNames = []string{
+	CopyValues: "copy",
+	ZeroCopy:   "zero-copy",
+}
+
+func (mode CopyMode) String() string {
+	if int(mode) < len(copyModeNames) {
+		return copyModeNames[mode]
+	}
+	return "unknown"
+}
+
+// SetCopyMode sets the copy mode of the cache, see CopyMode. Entries added before keep the keys and values they were
+// stored with.
+func (c *Cache) SetCopyMode(mode CopyMode) {
+	atomic.StoreInt32(&c.copyMode, int32(mode))
+}
+
+// GetCopyMode returns the copy mode of the cache
+func (c *Cache) GetCopyMode() CopyMode {
+	return CopyMode(atomic.LoadInt32(&c.copyMode))
+}
+
+// GetFunc calls fn with the value of k without copying it, whatever the copy mode, and counts the read like Get. It
+// returns ErrNotFound without calling fn when k is not in the cache. fn is called after the bucket lock is released,
+// so it may call the cache, and the value is only valid while fn runs: fn must not modify it nor keep it after it
+// returns. A compressed value is decompressed into a buffer reused once fn returns.
+func (c *Cache) GetFunc(k []byte, fn func(value []byte)) error {
+	if c == nil || len(c.buckets) == 0 {
+		return ErrNotInitialized
+	}
+	h := getHash64(k)
+	index := h % uint64(len(c.buckets))
+	span := c.startSpan(OpGetFunc, h, index)
+	data, err := c.buckets[index].getFromBucket(k, h, OpGetFunc)
+	if span != nil {
+		span.SetBool(AttributeHit, err == nil)
+		span.End(err)
+	}
+	if err != nil {
+		return err
+	}
+	if data.codec == nil {
+		fn(data.value)
+		return nil
+	}
+	buf := decompressBuffers.Get().(*[]byte)
+	defer decompressBuffers.Put(buf)
+	if *buf, err = data.codec.Decompress((*buf)[:0], data.value); err != nil {
+		return err
+	}
+	fn(*buf)
+	return nil
+}
+
+// copyIn returns the key and value to store for k and v, copied into a single allocation unless the cache is in
+// zero-copy mode
+func (c *Cache) copyIn(k, v []byte) ([]byte, []byte) {
+	if c.GetCopyMode() == ZeroCopy {
+		return k, v
+	}
+	buf := make([]byte, len(k)+len(v))
+	copy(buf, k)
+	copy(buf[len(k):], v)
+	return buf[:len(k):len(k)], buf[len(k):]
+}
+
+// copyBytes returns b, copied unless the cache is in zero-copy mode, for a value stored by Update or a key returned
+// without its value
+func (c *Cache) copyBytes(b []byte) []byte {
+	if c.GetCopyMode() == ZeroCopy {
+		return b
+	}
+	return cloneBytes(b)
+}
+
+// copyOut returns data with its key and value copied into a single allocation unless the cache is in zero-copy mode
+func (c *Cache) copyOut(data Data) Data {
+	data.key, data.value = c.copyIn(data.key, data.value)
+	return data
+}
+
+// cloneBytes returns a copy of b, nil stays nil so a missing value is not turned into an empty one
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append(make([]byte, 0, len(b)), b...)
+}
@@ -0,0 +1,167 @@
+package gocache
+
+import "testing"
+
+func TestCopyValuesProtectsBuffers(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	k, v := []byte("key"), []byte("value")
+	c.Add(k, v, &ConstantCost)
+	copy(k, "xxx")
+	copy(v, "xxxxx")
+	data, err := c.Get([]byte("key"))
+	if err != nil || string(data.GetValue()) != "value" {
+		t.Fatalf("Get after reusing the buffers of Add = %q, %v, want %q", data.GetValue(), err, "value")
+	}
+
+	data.GetValue()[0] = 'X'
+	data.GetKey()[0] = 'X'
+	if data, _ := c.Get([]byte("key")); string(data.GetValue()) != "value" || string(data.GetKey()) != "key" {
+		t.Errorf("Get after modifying a returned entry = %q, %q", data.GetKey(), data.GetValue())
+	}
+
+	v = []byte("other")
+	c.Update([]byte("key"), v)
+	copy(v, "xxxxx")
+	if data, _ := c.Get([]byte("key")); string(data.GetValue()) != "other" {
+		t.Errorf("Get after reusing the buffer of Update = %q, want %q", data.GetValue(), "other")
+	}
+	checkCache(t, c)
+}
+
+func TestCopyValuesReturnsCopies(t *testing.T) {
+	tests := []struct {
+		name  string
+		value func(c *Cache) []byte
+	}{
+		{"GetOrLoad", func(c *Cache) []byte {
+			data, _ := c.GetOrLoad([]byte("key"), nil, &ConstantCost)
+			return data.GetValue()
+		}},
+		{"Range", func(c *Cache) []byte {
+			var value []byte
+			c.Range(func(data Data) bool {
+				value = data.GetValue()
+				return false
+			})
+			return value
+		}},
+		{"ScanPrefix", func(c *Cache) []byte {
+			return c.ScanPrefix([]byte("k"))[0].GetValue()
+		}},
+		{"Txn.Get", func(c *Cache) []byte {
+			var value []byte
+			c.Transaction(func(tx *Txn) error {
+				data, _ := tx.Get([]byte("key"))
+				value = data.GetValue()
+				return nil
+			})
+			return value
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestCache(t, 100, 4)
+			c.Add([]byte("key"), []byte("value"), &ConstantCost)
+			test.value(c)[0] = 'X'
+			if data, _ := c.Get([]byte("key")); string(data.GetValue()) != "value" {
+				t.Errorf("Get after modifying the returned value = %q, want %q", data.GetValue(), "value")
+			}
+		})
+	}
+}
+
+func TestCopyValuesInTransaction(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	c.Add([]byte("updated"), []byte("value"), &ConstantCost)
+	added, updated := []byte("value"), []byte("other")
+	err := c.Transaction(func(tx *Txn) error {
+		tx.Add([]byte("added"), added, &ConstantCost)
+		return tx.Update([]byte("updated"), updated)
+	})
+	if err != nil {
+		t.Fatalf("Transaction: %v", err)
+	}
+	copy(added, "xxxxx")
+	copy(updated, "xxxxx")
+	if data, _ := c.Get([]byte("added")); string(data.GetValue()) != "value" {
+		t.Errorf("Get(added) = %q, want %q", data.GetValue(), "value")
+	}
+	if data, _ := c.Get([]byte("updated")); string(data.GetValue()) != "other" {
+		t.Errorf("Get(updated) = %q, want %q", data.GetValue(), "other")
+	}
+}
+
+func TestZeroCopySharesBuffers(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	c.SetCopyMode(ZeroCopy)
+	if mode := c.GetCopyMode(); mode != ZeroCopy {
+		t.Fatalf("GetCopyMode = %v, want %v", mode, ZeroCopy)
+	}
+	v := []byte("value")
+	c.Add([]byte("key"), v, &ConstantCost)
+	data, _ := c.Get([]byte("key"))
+	if &data.GetValue()[0] != &v[0] {
+		t.Errorf("Get returned a copy of the value in zero-copy mode")
+	}
+	if ns := c.Namespace("ns"); ns.cache.GetCopyMode() != ZeroCopy {
+		t.Errorf("namespace copy mode = %v, want %v", ns.cache.GetCopyMode(), ZeroCopy)
+	}
+}
+
+func TestGetFunc(t *testing.T) {
+	for _, mode := range []CopyMode{CopyValues, ZeroCopy} {
+		t.Run(mode.String(), func(t *testing.T) {
+			c := newTestCache(t, 100, 4)
+			c.SetCopyMode(mode)
+			c.Add([]byte("key"), []byte("value"), &FrequencyCost)
+
+			var got string
+			err := c.GetFunc([]byte("key"), func(value []byte) {
+				got = string(value)
+				// the bucket is not locked while fn runs
+				c.Update([]byte("key"), []byte("other"))
+			})
+			if err != nil || got != "value" {
+				t.Errorf("GetFunc = %q, %v, want %q", got, err, "value")
+			}
+			if data, _ := c.Get([]byte("key")); data.GetReads() != 2 || string(data.GetValue()) != "other" {
+				t.Errorf("Get after GetFunc = %q with %v reads, want %q with 2", data.GetValue(), data.GetReads(), "other")
+			}
+
+			called := false
+			if err := c.GetFunc([]byte("missing"), func([]byte) { called = true }); err != ErrNotFound || called {
+				t.Errorf("GetFunc(missing) = %v, called %v, want %v without calling fn", err, called, ErrNotFound)
+			}
+			checkCache(t, c)
+		})
+	}
+	var c *Cache
+	if err := c.GetFunc([]byte("key"), func([]byte) {}); err != ErrNotInitialized {
+		t.Errorf("GetFunc on a nil cache = %v, want %v", err, ErrNotInitialized)
+	}
+}
+
+func BenchmarkGetCopyMode(b *testing.B) {
+	value := make([]byte, 1024)
+	for _, mode := range []CopyMode{CopyValues, ZeroCopy} {
+		b.Run(mode.String(), func(b *testing.B) {
+			c := newTestCache(b, 1000, 0)
+			c.SetCopyMode(mode)
+			c.Add([]byte("key"), value, &ConstantCost)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.Get([]byte("key"))
+			}
+		})
+	}
+	b.Run("GetFunc", func(b *testing.B) {
+		c := newTestCache(b, 1000, 0)
+		c.Add([]byte("key"), value, &ConstantCost)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			c.GetFunc([]byte("key"), func([]byte) {})
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package gocache
+
+import "fmt"
+
+// SizeCost is a cost function where cost = length of key + length of value, before compression
+var SizeCost = func(data Data) int {
+	return len(data.key) + data.GetSize()
+}
+
+// FrequencyCost is a cost function where cost = number of reads, so the least read entry is evicted first
+var FrequencyCost = func(data Data) int {
+	return data.reads
+}
+
+// BalancedCost is a cost function where cost = length of key + length of value before compression + number of reads -
+// number of updates
+var BalancedCost = func(data Data) int {
+	return len(data.key) + data.GetSize() + data.reads - data.updates
+}
+
+// ConstantCost is a cost function which gives the same cost to every entry. As entries with the same cost are
+// evicted in insertion order, it makes the cache behave like a FIFO cache
+var ConstantCost = func(data Data) int {
+	return 0
+}
+
+// costFunctionNames maps the preset cost functions to the names they are known by in flags and requests
+var costFunctionNames = map[string]*func(data Data) int{
+	"size":       &SizeCost,
+	"frequency":  &FrequencyCost,
+	"balanced":   &BalancedCost,
+	"constant":   &ConstantCost,
+	"dependents": &DependentsCost,
+}
+
+// CostFunction returns the pointer to the preset cost function with the given name.
+// Known names are "size", "frequency", "balanced", "constant" and "dependents".
+func CostFunction(name string) (*func(data Data) int, error) {
+	costFun, found := costFunctionNames[name]
+	if !found {
+		return nil, fmt.Errorf("unknown cost function %q", name)
+	}
+	return costFun, nil
+}
+
+// CostFunctionName returns the name of a preset cost function pointer, and false if it is not one of the presets
+func CostFunctionName(costFun *func(data Data) int) (string, bool) {
+	for name, preset := range costFunctionNames {
+		if preset == costFun {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,251 @@
+package gocache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrDependencyCycle is returned by DependsOn when an edge would make an entry depend on itself
+var ErrDependencyCycle = errors.New("dependency cycle")
+
+// dependentsCostWeight is the cost DependentsCost adds for every dependent of an entry
+const dependentsCostWeight = 64
+
+// DependentsCost is a cost function where cost = BalancedCost + 64 for every entry depending on the entry, so
+// entries whose eviction would cascade to many others are evicted last. Only the direct dependents are counted, not
+// the whole cascade: a parent of one entry with many dependents of its own costs the same as a parent of one leaf.
+// Counting the cascade would take a walk of the graph up to every ancestor for every edge added or removed, and the
+// ancestors sharing descendants through several paths would need the set of their descendants, not a counter.
+var DependentsCost = func(data Data) int {
+	return BalancedCost(data) + dependentsCostWeight*data.dependents
+}
+
+// dependencyWork is a change the graph asks for after a bucket lock is released: the eviction of a dependent, or
+// the decrement of the dependents of a parent whose dependent is gone
+type dependencyWork struct {
+	key   string
+	evict bool
+}
+
+// dependencyGraph holds the edges declared by DependsOn between the keys of a cache. Edges change while buckets
+// are locked, so the graph never locks a bucket itself: evictions and updates of the dependents counters are queued
+// and applied by applyDependencyWork once the operation released its bucket. Lock order is bucket, then graph.
+type dependencyGraph struct {
+	mutex    sync.Mutex
+	parents  map[string]map[string]struct{} // key is a dependent, value is the set of keys it depends on
+	children map[string]map[string]struct{} // key is a parent, value is the set of keys depending on it
+	edges    int64                          // number of edges, read without the lock to skip the graph when unused
+	pending  []dependencyWork
+	queued   int64 // length of pending, read without the lock
+}
+
+// DependsOn declares that the entry of child is derived from the entries of parents: when a parent is evicted for
+// any reason, updated or overwritten by Add, child is evicted too, with the reason EvictedByDependency, and in turn
+// its own dependents. Keys must be in the cache, otherwise ErrKeyNotExist is returned, and an edge which would
+// close a cycle returns ErrDependencyCycle. Edges declared before an error are kept. Edges are removed with their
+// entries and by Clear, they are not part of snapshots nor replicated.
+func (c *Cache) DependsOn(child []byte, parents ...[]byte) error {
+	if c == nil || len(c.buckets) == 0 {
+		return ErrNotInitialized
+	}
+	if !c.contains(child) {
+		return ErrKeyNotExist
+	}
+	for _, parent := range parents {
+		if err := c.addDependency(child, parent); err != nil {
+			return err
+		}
+	}
+	// child may have been removed meanwhile, checking again removes the edges it would leave behind
+	if !c.contains(child) {
+		c.dependencies.removed(child)
+		c.applyDependencyWork()
+		return ErrKeyNotExist
+	}
+	return nil
+}
+
+// addDependency adds the edge from parent to child under the lock of the bucket of parent, so the edge and the
+// dependents counter of parent change together and parent can not be removed in between
+func (c *Cache) addDependency(child, parent []byte) error {
+	h := getHash64(parent)
+	b := &c.buckets[h%uint64(len(c.buckets))]
+	b.lockUntimed()
+	defer b.mutex.Unlock()
+	node, exist := b.entries[h]
+	if !exist || string(node.key) != string(parent) {
+		return ErrKeyNotExist
+	}
+	added, err := c.dependencies.add(string(child), string(parent))
+	if err != nil || !added {
+		return err
+	}
+	oldCost := (*node.costFunction)(*node)
+	node.dependents++
+	b.moveNode(node, oldCost, (*node.costFunction)(*node))
+	return nil
+}
+
+// contains returns whether k is in the cache without counting a read
+func (c *Cache) contains(k []byte) bool {
+	h := getHash64(k)
+	b := &c.buckets[h%uint64(len(c.buckets))]
+	b.mutex.RLock()
+	node, exist := b.entries[h]
+	found := exist && string(node.key) == string(k)
+	b.mutex.RUnlock()
+	return found
+}
+
+// Dependents returns the keys declared as depending on k
+func (c *Cache) Dependents(k []byte) [][]byte {
+	g := &c.dependencies
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	keys := make([][]byte, 0, len(g.children[string(k)]))
+	for child := range g.children[string(k)] {
+		keys = append(keys, []byte(child))
+	}
+	return keys
+}
+
+// applyDependencyWork evicts the dependents and updates the counters queued by the graph, until nothing is queued.
+// It is called by every operation which can remove or update entries after it released its bucket.
+func (c *Cache) applyDependencyWork() {
+	g := &c.dependencies
+	for atomic.LoadInt64(&g.queued) > 0 {
+		g.mutex.Lock()
+		work := g.pending
+		g.pending = nil
+		atomic.StoreInt64(&g.queued, 0)
+		g.mutex.Unlock()
+		for _, w := range work {
+			k := []byte(w.key)
+			h := getHash64(k)
+			b := &c.buckets[h%uint64(len(c.buckets))]
+			if w.evict {
+				if b.deleteFromBucket(k, h, EvictedByDependency) == ErrKeyNotExist {
+					// the dependent is already gone, its edges still have to go
+					g.removed(k)
+				}
+				continue
+			}
+			b.lockUntimed()
+			if node, exist := b.entries[h]; exist && string(node.key) == w.key && node.dependents > 0 {
+				oldCost := (*node.costFunction)(*node)
+				node.dependents--
+				b.moveNode(node, oldCost, (*node.costFunction)(*node))
+			}
+			b.mutex.Unlock()
+		}
+	}
+}
+
+// add adds the edge from parent to child, it returns false when the edge already exists
+func (g *dependencyGraph) add(child, parent string) (bool, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if child == parent || g.reaches(child, parent) {
+		return false, ErrDependencyCycle
+	}
+	if _, found := g.children[parent][child]; found {
+		return false, nil
+	}
+	if g.parents == nil {
+		g.parents = map[string]map[string]struct{}{}
+		g.children = map[string]map[string]struct{}{}
+	}
+	addToSet(g.parents, child, parent)
+	addToSet(g.children, parent, child)
+	atomic.AddInt64(&g.edges, 1)
+	return true, nil
+}
+
+// reaches returns whether to is a dependent of from, directly or through other dependents. Graph must be locked.
+func (g *dependencyGraph) reaches(from, to string) bool {
+	visited := map[string]bool{from: true}
+	stack := []string{from}
+	for len(stack) > 0 {
+		key := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for child := range g.children[key] {
+			if child == to {
+				return true
+			}
+			if !visited[child] {
+				visited[child] = true
+				stack = append(stack, child)
+			}
+		}
+	}
+	return false
+}
+
+// removed drops the edges of a removed entry: its dependents are queued for eviction and its parents for a
+// decrement of their dependents. The lock of the bucket of k may be held by the caller.
+func (g *dependencyGraph) removed(k []byte) {
+	if atomic.LoadInt64(&g.edges) == 0 {
+		return
+	}
+	g.mutex.Lock()
+	key := string(k)
+	for child := range g.children[key] {
+		removeFromSet(g.parents, child, key)
+		g.queue(dependencyWork{key: child, evict: true})
+		atomic.AddInt64(&g.edges, -1)
+	}
+	delete(g.children, key)
+	for parent := range g.parents[key] {
+		removeFromSet(g.children, parent, key)
+		g.queue(dependencyWork{key: parent})
+		atomic.AddInt64(&g.edges, -1)
+	}
+	delete(g.parents, key)
+	g.mutex.Unlock()
+}
+
+// updated queues the eviction of the dependents of an updated entry, whose edges go once they are evicted.
+// The lock of the bucket of k may be held by the caller.
+func (g *dependencyGraph) updated(k []byte) {
+	if atomic.LoadInt64(&g.edges) == 0 {
+		return
+	}
+	g.mutex.Lock()
+	for child := range g.children[string(k)] {
+		g.queue(dependencyWork{key: child, evict: true})
+	}
+	g.mutex.Unlock()
+}
+
+// reset drops all the edges, it is used by Clear
+func (g *dependencyGraph) reset() {
+	g.mutex.Lock()
+	g.parents, g.children, g.pending = nil, nil, nil
+	atomic.StoreInt64(&g.edges, 0)
+	atomic.StoreInt64(&g.queued, 0)
+	g.mutex.Unlock()
+}
+
+// queue adds work to the pending work, graph must be locked
+func (g *dependencyGraph) queue(work dependencyWork) {
+	g.pending = append(g.pending, work)
+	atomic.StoreInt64(&g.queued, int64(len(g.pending)))
+}
+
+func addToSet(sets map[string]map[string]struct{}, key, member string) {
+	set, found := sets[key]
+	if !found {
+		set = map[string]struct{}{}
+		sets[key] = set
+	}
+	set[member] = struct{}{}
+}
+
+func removeFromSet(sets map[string]map[string]struct{}, key, member string) {
+	set := sets[key]
+	delete(set, member)
+	if len(set) == 0 {
+		delete(sets, key)
+	}
+}
@@ -0,0 +1,225 @@
+package gocache
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// addChain adds keys a, b and c to c, where b depends on a and c depends on b
+func addChain(t *testing.T, c *Cache) {
+	t.Helper()
+	for _, k := range []string{"a", "b", "c"} {
+		c.Add([]byte(k), []byte("v"), &SizeCost)
+	}
+	if err := c.DependsOn([]byte("b"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DependsOn([]byte("c"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDependencyCascade(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Cache)
+		gone   []string
+		kept   []string
+	}{
+		{"evict root", func(c *Cache) { c.Evict([]byte("a")) }, []string{"a", "b", "c"}, nil},
+		{"evict middle", func(c *Cache) { c.Evict([]byte("b")) }, []string{"b", "c"}, []string{"a"}},
+		{"evict leaf", func(c *Cache) { c.Evict([]byte("c")) }, []string{"c"}, []string{"a", "b"}},
+		{"update root", func(c *Cache) { c.Update([]byte("a"), []byte("new")) }, []string{"b", "c"}, []string{"a"}},
+		{"overwrite root", func(c *Cache) { c.Add([]byte("a"), []byte("new"), &SizeCost) }, []string{"b", "c"}, []string{"a"}},
+		{"invalidate tag", func(c *Cache) {
+			c.Clear()
+			c.AddWithTags([]byte("a"), []byte("v"), &SizeCost, "t")
+			c.Add([]byte("b"), []byte("v"), &SizeCost)
+			c.DependsOn([]byte("b"), []byte("a"))
+			c.InvalidateTag("t")
+		}, []string{"a", "b", "c"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestCache(t, 100, 4)
+			addChain(t, c)
+			test.change(c)
+			for _, k := range test.gone {
+				if _, err := c.Get([]byte(k)); err != ErrNotFound {
+					t.Errorf("%v was not evicted", k)
+				}
+			}
+			for _, k := range test.kept {
+				data, err := c.Get([]byte(k))
+				if err != nil {
+					t.Errorf("%v was evicted", k)
+					continue
+				}
+				// a kept entry still has its dependent when the next key of the chain is kept
+				want := 0
+				if next := string(k[0] + 1); c.contains([]byte(next)) && k != "c" {
+					want = 1
+				}
+				if data.GetDependents() != want {
+					t.Errorf("%v has %v dependents, want %v", k, data.GetDependents(), want)
+				}
+			}
+			checkCache(t, c)
+			checkDependencies(t, c)
+		})
+	}
+}
+
+func TestDependencyCascadeOnCapacityEviction(t *testing.T) {
+	c := newTestCache(t, 3, 1)
+	c.Add([]byte("parent"), []byte("v"), &SizeCost)
+	c.Add([]byte("child"), []byte("long value"), &SizeCost)
+	c.DependsOn([]byte("child"), []byte("parent"))
+	c.Add([]byte("other"), []byte("long value"), &SizeCost)
+	// parent has the lowest cost, making room evicts it and its dependent
+	c.Add([]byte("new"), []byte("long value"), &SizeCost)
+	if _, err := c.Get([]byte("child")); err != ErrNotFound {
+		t.Errorf("child of the evicted parent was not evicted")
+	}
+	if n := c.GetEvictionsCount(EvictedByDependency); n != 1 {
+		t.Errorf("dependency evictions = %v, want 1", n)
+	}
+	checkCache(t, c)
+	checkDependencies(t, c)
+}
+
+func TestDependentsCost(t *testing.T) {
+	c := newTestCache(t, 3, 1)
+	c.Add([]byte("parent"), []byte("v"), &DependentsCost)
+	c.Add([]byte("child"), []byte("long value"), &DependentsCost)
+	c.Add([]byte("other"), []byte("long value"), &DependentsCost)
+	c.DependsOn([]byte("child"), []byte("parent"))
+	// with a dependent, parent is no longer the lowest cost entry
+	c.Add([]byte("new"), []byte("long value"), &DependentsCost)
+	if _, err := c.Get([]byte("parent")); err != nil {
+		t.Errorf("parent with a dependent was evicted")
+	}
+	checkCache(t, c)
+	checkDependencies(t, c)
+}
+
+func TestDependentsCountsDirectDependents(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	addChain(t, c)
+	// a has the whole chain depending on it, but only b directly
+	for k, want := range map[string]int{"a": 1, "b": 1, "c": 0} {
+		if data, err := c.Get([]byte(k)); err != nil || data.GetDependents() != want {
+			t.Errorf("GetDependents of %v = %v, %v, want %v", k, data.GetDependents(), err, want)
+		}
+	}
+}
+
+func TestDependenciesAreNotTimed(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	for _, k := range []string{"a", "b", "c"} {
+		c.Add([]byte(k), []byte("v"), &SizeCost)
+	}
+	c.EnableInstrumentation()
+	c.DependsOn([]byte("b"), []byte("a"))
+	c.DependsOn([]byte("c"), []byte("a"))
+	// evicting c decrements the dependents of a after the bucket of c is released
+	c.Evict([]byte("c"))
+	operations := c.Stats().Operations
+	if update := operations[OpUpdate]; update.Wait.Count != 0 || update.Latency.Count != 0 {
+		t.Errorf("%v updates recorded, want none as nothing was updated", update.Latency.Count)
+	}
+	if evict := operations[OpEvict]; evict.Latency.Count != 1 {
+		t.Errorf("%v evictions recorded, want 1", evict.Latency.Count)
+	}
+	if data, _ := c.Get([]byte("a")); data.GetDependents() != 1 {
+		t.Errorf("a has %v dependents after the eviction of c, want 1", data.GetDependents())
+	}
+	checkDependencies(t, c)
+}
+
+func TestDependsOnErrors(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	addChain(t, c)
+	if err := c.DependsOn([]byte("a"), []byte("c")); err != ErrDependencyCycle {
+		t.Errorf("DependsOn closing a cycle error = %v, want %v", err, ErrDependencyCycle)
+	}
+	if err := c.DependsOn([]byte("a"), []byte("a")); err != ErrDependencyCycle {
+		t.Errorf("DependsOn on itself error = %v, want %v", err, ErrDependencyCycle)
+	}
+	if err := c.DependsOn([]byte("missing"), []byte("a")); err != ErrKeyNotExist {
+		t.Errorf("DependsOn of a missing child error = %v, want %v", err, ErrKeyNotExist)
+	}
+	if err := c.DependsOn([]byte("c"), []byte("missing")); err != ErrKeyNotExist {
+		t.Errorf("DependsOn on a missing parent error = %v, want %v", err, ErrKeyNotExist)
+	}
+	// declaring an edge twice counts it once
+	if err := c.DependsOn([]byte("c"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := c.Get([]byte("b")); data.GetDependents() != 1 {
+		t.Errorf("b has %v dependents, want 1", data.GetDependents())
+	}
+	c.Clear()
+	if n := len(c.Dependents([]byte("a"))); n != 0 {
+		t.Errorf("a has %v dependents after Clear", n)
+	}
+	checkDependencies(t, c)
+}
+
+func TestDependenciesConcurrent(t *testing.T) {
+	c := newTestCache(t, 200, 8)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < 2000; i++ {
+				k := key(r.Intn(300))
+				switch r.Intn(5) {
+				case 0, 1:
+					c.Add(k, []byte(fmt.Sprint(i)), &DependentsCost)
+				case 2:
+					c.DependsOn(k, key(r.Intn(300)), key(r.Intn(300)))
+				case 3:
+					c.Update(k, []byte("updated"))
+				case 4:
+					c.Evict(k)
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+	checkCache(t, c)
+	checkDependencies(t, c)
+}
+
+// checkDependencies checks that the edges of the graph join entries of the cache and that the dependents counter
+// of every entry is its number of dependents, no operation must be running
+func checkDependencies(t *testing.T, c *Cache) {
+	t.Helper()
+	g := &c.dependencies
+	edges := 0
+	for parent, children := range g.children {
+		for child := range children {
+			if _, found := g.parents[child][parent]; !found {
+				t.Fatalf("edge %v -> %v is missing from the parents", parent, child)
+			}
+			if !c.contains([]byte(child)) || !c.contains([]byte(parent)) {
+				t.Fatalf("edge %v -> %v joins a removed entry", parent, child)
+			}
+			edges++
+		}
+	}
+	if int64(edges) != g.edges || len(g.pending) != 0 {
+		t.Fatalf("graph has %v edges and counts %v, %v pending", edges, g.edges, len(g.pending))
+	}
+	c.Range(func(data Data) bool {
+		if want := len(g.children[string(data.GetKey())]); data.GetDependents() != want {
+			t.Fatalf("%q has %v dependents, the graph has %v", data.GetKey(), data.GetDependents(), want)
+		}
+		return true
+	})
+}
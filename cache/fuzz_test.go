@@ -0,0 +1,118 @@
+package gocache
+
+import (
+	"math"
+	"testing"
+)
+
+// fuzzCostFunctions are cost functions chosen to stress the cost tree: negative and extreme costs, costs which
+// change on every read or update in both directions and costs with many ties
+var fuzzCostFunctions = []func(data Data) int{
+	ConstantCost,
+	SizeCost,
+	BalancedCost,
+	func(data Data) int { return -data.reads },
+	func(data Data) int { return data.updates*1000 - data.reads*7 },
+	func(data Data) int { return (data.reads%3 - 1) * (len(data.value) + 1) },
+	func(data Data) int {
+		if data.reads%2 == 0 {
+			return math.MaxInt32
+		}
+		return math.MinInt32
+	},
+	func(data Data) int {
+		cost := 0
+		for _, b := range data.value {
+			cost = cost*31 + int(b)
+		}
+		return cost%17 - 8
+	},
+}
+
+// fuzzTags are the tags of the added entries, an entry gets up to two consecutive tags of the list
+var fuzzTags = []string{"red", "green", "blue", "red", "green"}
+
+// FuzzCacheOperations reads data as a sequence of operations of 3 bytes: the operation, the key and the length of
+// the value. The first byte picks the number of buckets and the second the capacity, so small caches with many
+// evictions are covered. The invariants of every bucket are checked after every operation.
+func FuzzCacheOperations(f *testing.F) {
+	f.Add([]byte{1, 3, 0, 1, 4, 0, 2, 5, 0, 3, 6, 1, 1, 0, 2, 2, 0, 3, 3, 0})
+	f.Add([]byte{0, 2, 0, 0, 1, 8, 1, 2, 16, 2, 3, 1, 0, 4, 5, 4, 0, 0, 5, 1, 0, 6, 0, 0})
+	f.Add([]byte{3, 10, 0, 0, 0, 0, 1, 0, 0, 1, 0, 0, 2, 0, 9, 3, 0, 0, 7, 0, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) < 2 {
+			return
+		}
+		buckets := int(data[0]%4) + 1
+		capacity := int(data[1]%32) + 1
+		c := newTestCache(t, capacity, buckets)
+		data = data[2:]
+		for len(data) >= 3 {
+			op, k, size := data[0], []byte{'k', data[1] % 64}, int(data[2])
+			data = data[3:]
+			v := make([]byte, size%40)
+			for i := range v {
+				v[i] = byte(size + i)
+			}
+			switch op % 8 {
+			case 0, 1:
+				costFun := fuzzCostFunctions[int(op/8)%len(fuzzCostFunctions)]
+				// entries get zero to two of three tags, so tags are shared by many entries
+				tags := fuzzTags[size%3 : size%3+size/3%3]
+				if err := c.AddWithTags(k, v, &costFun, tags...); err != nil {
+					t.Fatalf("Add: %v", err)
+				}
+			case 2, 3:
+				c.Get(k)
+			case 4:
+				c.Update(k, v)
+			case 5:
+				c.Evict(k)
+			case 6:
+				// Clear empties the cache, it is kept rare so the sequences reach full buckets
+				if op == 6 {
+					c.Clear()
+				} else {
+					c.InvalidateTag(fuzzTags[size%3])
+				}
+			case 7:
+				c.GetOrLoad(k, func(k []byte) ([]byte, error) { return v, nil }, &SizeCost)
+			}
+			checkCache(t, c)
+		}
+	})
+}
+
+// FuzzCostTree reads data as a sequence of costs, a cost is inserted into the tree when its first byte is even and
+// removed otherwise. The tree must stay a valid AVL tree holding the costs of a reference set.
+func FuzzCostTree(f *testing.F) {
+	f.Add([]byte{0, 1, 0, 2, 0, 3, 0, 4, 0, 5, 1, 3, 1, 1})
+	f.Add([]byte{0, 9, 0, 8, 0, 7, 0, 6, 0, 5, 0, 4, 1, 8, 1, 6})
+	f.Add([]byte{0, 1, 0, 3, 0, 2, 1, 1, 0, 1, 1, 2, 1, 3})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var root *costNode
+		model := map[int]bool{}
+		for ; len(data) >= 2; data = data[2:] {
+			cost := int(int8(data[1]))
+			if data[0]%2 == 0 {
+				root = insert(root, cost)
+				model[cost] = true
+			} else {
+				root = remove(root, cost)
+				delete(model, cost)
+			}
+			if err := checkCostTree(root); err != nil {
+				t.Fatal(err)
+			}
+			costs := treeCosts(root, nil)
+			if len(costs) != len(model) {
+				t.Fatalf("tree holds %v costs, want %v", len(costs), len(model))
+			}
+			for _, cost := range costs {
+				if !model[cost] {
+					t.Fatalf("tree holds removed cost %v", cost)
+				}
+			}
+		}
+	})
+}
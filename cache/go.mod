@@ -1,3 +1,3 @@
 module gocache
 
-go 1.15
\ No newline at end of file
+go 1.19
\ No newline at end of file
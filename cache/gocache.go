@@ -13,24 +13,55 @@ const defaultBucketsNumber = 512
 
 const maxEntriesPerBucket = 2000
 
+var (
+	// ErrNotInitialized is returned when a method is called on a cache which has not been initialized
+	ErrNotInitialized = errors.New("Cache has not been initialized. Use Init() method for initialization.")
+	// ErrNotFound is returned by Get when there is no entry for the key
+	ErrNotFound = errors.New("key-value pair not found")
+	// ErrKeyNotExist is returned by Update and Evict when there is no entry for the key
+	ErrKeyNotExist = errors.New("key not exist")
+)
+
 type Data struct {
 	key          []byte
 	value        []byte
 	reads        int
+	pendingReads uint32					// reads counted by Get under the shared lock and not yet applied to reads, see applyReads
 	updates      int
+	dependents   int					// number of entries declared as depending on this entry by DependsOn
+	version      uint64					// version given by the last add or update of the entry, see Txn
 	costFunction *func(data Data) int	//pointer to cost function associated with this entry
+	tags         []string				// tags given to AddWithTags, indexed by the tags map of the bucket
+	codec        Codec					// codec value is compressed with, nil when it is stored as is
+	size         int					// length of value before compression
 	next         *Data
 	prev         *Data
 }
 
+// NewData returns a Data with the given fields, it is meant for implementations of Store which fetch
+// entries from elsewhere, entries of a Cache are only created by Add
+func NewData(key, value []byte, reads, updates int) Data {
+	return Data{key: key, value: value, reads: reads, updates: updates}
+}
+
 func (data Data) GetKey() []byte {
 	return data.key
 }
 
+// GetValue returns the value of the entry. Cost functions run under the bucket lock on the stored entry, so for an
+// entry compressed by SetCompression they get the compressed bytes, use GetSize for the length of the value.
 func (data Data) GetValue() []byte {
 	return data.value
 }
 
+// GetSize returns the length of the value before compression, the length of the value when it is not compressed
+func (data Data) GetSize() int {
+	if data.codec == nil {
+		return len(data.value)
+	}
+	return data.size
+}
+
 func (data Data) GetReads() int {
 	return data.reads
 }
@@ -38,18 +69,71 @@ func (data Data) GetUpdates() int {
 	return data.updates
 }
 
+// GetVersion returns the version of the entry, which changes with every add and update of its key. Transactions
+// use it to check that a key did not change since it was read.
+func (data Data) GetVersion() uint64 {
+	return data.version
+}
+
+// GetDependents returns the number of entries depending directly on the entry, cost functions can use it to make
+// entries with many dependents more expensive to evict. The dependents of the dependents are not counted.
+func (data Data) GetDependents() int {
+	return data.dependents
+}
+
+// GetTags returns the tags the entry was added with, they must not be modified
+func (data Data) GetTags() []string {
+	return data.tags
+}
+
 type bucket struct {
 	mutex        sync.RWMutex
 	entries      map[uint64]*Data		// key of this map = hash(key) and value of this map is pointer to Data
 	costListsMap map[int]*dataNodesList	// key of this map is cost, value of this map is doubly linked list of Data nodes with the same cost
 	costTree     *costNode				// root node of AVL Tree, tree stores costNodes
+	tags         map[string]map[uint64]struct{}	// key of this map is a tag, value is the set of hashes of the entries carrying it
 	maxEntries   uint64					// maximum number of entries in the bucket
 	entriesCount uint64					// current number of entries in the bucket
 	collisions   uint64					// count of collisions due to same hash of different keys in the bucket
-}
+	bytes        uint64					// sum of the key and value lengths of the entries in the bucket, as stored
+	rawBytes     uint64					// sum of the key and value lengths of the entries in the bucket, before compression
+	hits         uint64					// count of Get calls which found the key
+	misses       uint64					// count of Get calls which did not find the key
+	adds         uint64					// count of entries added
+	updates      uint64					// count of entries updated
+	evictions    [numEvictionReasons]uint64	// count of entries removed, by reason
+	contentions  uint64					// count of operations which had to wait for the bucket lock
+	instrumentation atomic.Value		// *bucketInstrumentation, holds a nil pointer while instrumentation is disabled
+	readsMutex   sync.Mutex				// serializes the readers appending to pendingReads under the shared lock
+	pendingReads []*Data				// entries read since the reads were last applied to the cost structures
+	cache        *Cache					// cache the bucket belongs to
+}
+
+// Store is the interface of the key-value operations of Cache. It is implemented by Cache and by the clients of
+// remote caches, so code can use a local cache or a remote one without changes.
+type Store interface {
+	Add(k, v []byte, costFun *func(data Data) int) error
+	Get(k []byte) (Data, error)
+	Update(k, v []byte) error
+	Evict(k []byte) error
+}
+
+var _ Store = (*Cache)(nil)
 
 type Cache struct {
-	buckets []bucket
+	buckets    []bucket
+	tracer     atomic.Value			// *tracerHolder, holds a nil pointer while tracing is disabled
+	loadsMutex sync.Mutex
+	loads      map[string]*loadCall	// loads in flight by GetOrLoad, keyed by key
+	listenersMutex sync.Mutex
+	listeners  atomic.Value			// []*mutationListener, replaced on every change
+	namespacesMutex sync.Mutex
+	namespaces map[string]*Namespace	// namespaces created by Namespace and NewNamespace, keyed by name
+	dependencies dependencyGraph		// edges declared by DependsOn
+	versions     uint64					// last version given to an entry
+	copyMode     int32					// CopyMode of the cache
+	compression  atomic.Value			// *compression, holds a nil pointer while compression is disabled
+	arena        *ArenaStore			// entries of a cache initialized by InitArena, nil otherwise
 }
 
 //Doubly linked list
@@ -102,6 +186,9 @@ func (list *dataNodesList) removeNode(node *Data)  {
 
 // Retruns sum of collisions count over all the buckets in cache
 func (c *Cache) GetCollisionsCount() uint64 {
+	if c.arena != nil {
+		return c.arena.GetCollisionsCount()
+	}
 	var sum uint64 = 0
 	for i:=0 ; i<len(c.buckets) ; i++ {
 		sum += atomic.LoadUint64(&c.buckets[i].collisions)
@@ -111,6 +198,20 @@ func (c *Cache) GetCollisionsCount() uint64 {
 
 // Init method for cache
 func (c *Cache) Init(capacity int, buckets int) {
+	numberOfBuckets, bucketCapacity := bucketsFor(capacity, buckets)
+
+	c.arena = nil
+	c.buckets = make([]bucket, numberOfBuckets)
+
+	for i:=0 ; i<numberOfBuckets ; i++ {
+		c.buckets[i].cache = c
+		c.buckets[i].initBucket(bucketCapacity)
+	}
+	c.initNamespaces()
+}
+
+// bucketsFor checks the arguments of Init and returns the number of buckets and the capacity of every bucket
+func bucketsFor(capacity int, buckets int) (int, int) {
 	if buckets < 0 {
 		panic("Number of buckets can not be negative. You can use 0 for default number of buckets = 512")
 	}
@@ -131,43 +232,70 @@ func (c *Cache) Init(capacity int, buckets int) {
 	if capacity > numberOfBuckets*maxEntriesPerBucket {
 		panic("Capacity should be less than number of buckets times 2000")
 	}
-
-	c.buckets = make([]bucket, numberOfBuckets)
-
-	for i:=0 ; i<numberOfBuckets ; i++ {
-		c.buckets[i].initBucket(min(maxEntriesPerBucket, int(math.Ceil(float64(capacity)/float64(numberOfBuckets)))))
-	}
+	return numberOfBuckets, min(maxEntriesPerBucket, int(math.Ceil(float64(capacity)/float64(numberOfBuckets))))
 }
 
-// Clear method for cache
+// Clear method for cache. All the buckets stay locked until the clear is reported to the mutation listeners, so a
+// concurrent mutation is reported before the clear when the clear removed it and after the clear otherwise.
 func (c *Cache) Clear() {
+	if c.arena != nil {
+		c.arena.Clear()
+		return
+	}
 	for i:=0 ; i<len(c.buckets) ; i++ {
+		c.buckets[i].mutex.Lock()
 		c.buckets[i].clearBucket()
 	}
+	c.dependencies.reset()
+	c.notify(Mutation{Kind: MutationClear})
+	for i:=len(c.buckets)-1 ; i>=0 ; i-- {
+		c.buckets[i].mutex.Unlock()
+	}
 }
 
 func (b *bucket) initBucket(bucketCapacity int) {
 	b.mutex.Lock()
 	b.entries = map[uint64]*Data{}
 	b.costListsMap = map[int]*dataNodesList{}
+	b.tags = map[string]map[uint64]struct{}{}
+	b.pendingReads = nil
 	atomic.StoreUint64(&b.maxEntries, uint64(bucketCapacity))
 	atomic.StoreUint64(&b.entriesCount, 0)
 	atomic.StoreUint64(&b.collisions, 0)
+	atomic.StoreUint64(&b.bytes, 0)
+	atomic.StoreUint64(&b.rawBytes, 0)
+	atomic.StoreUint64(&b.hits, 0)
+	atomic.StoreUint64(&b.misses, 0)
+	atomic.StoreUint64(&b.adds, 0)
+	atomic.StoreUint64(&b.updates, 0)
+	for i := range b.evictions {
+		atomic.StoreUint64(&b.evictions[i], 0)
+	}
+	atomic.StoreUint64(&b.contentions, 0)
 	b.mutex.Unlock()
 }
 
+// clearBucket removes all the entries of the bucket. Bucket must be locked by the caller. The map of entries is
+// emptied rather than replaced, operations check that it is not nil before taking the lock.
 func (b *bucket) clearBucket()  {
-	b.mutex.Lock()
-	b.entries = map[uint64]*Data{}
+	for h := range b.entries {
+		delete(b.entries, h)
+	}
 	b.costTree = nil
 	b.costListsMap = map[int]*dataNodesList{}
+	b.tags = map[string]map[uint64]struct{}{}
+	b.pendingReads = nil
 	atomic.StoreUint64(&b.entriesCount, 0)
 	atomic.StoreUint64(&b.collisions, 0)
-	b.mutex.Unlock()
+	atomic.StoreUint64(&b.bytes, 0)
+	atomic.StoreUint64(&b.rawBytes, 0)
 }
 
 // Returns sum of total entries count in the cache
 func (c *Cache) GetEntriesCount() uint64 {
+	if c.arena != nil {
+		return c.arena.GetEntriesCount()
+	}
 	var count uint64
 	for i:=0 ; i<len(c.buckets) ; i++ {
 		c.buckets[i].mutex.RLock()
@@ -179,207 +307,288 @@ func (c *Cache) GetEntriesCount() uint64 {
 
 // Add method will add (k, v) to the cache
 func (c *Cache) Add(k, v []byte, costFun *func(data Data) int) error {
+	if c!=nil && c.arena!=nil {
+		return c.arena.Add(k, v, costFun)
+	}
+	if c==nil || len(c.buckets)==0 {
+		return ErrNotInitialized
+	}
 	h := getHash64(k)
-	return c.buckets[h%uint64(len(c.buckets))].addToBucket(k, v, h, costFun)
+	index := h%uint64(len(c.buckets))
+	span := c.startSpan(OpAdd, h, index)
+	evictions, err := c.buckets[index].addToBucket(k, v, h, costFun, nil)
+	c.applyDependencyWork()
+	if span != nil {
+		span.SetInt(AttributeEvictions, int64(evictions))
+		span.End(err)
+	}
+	return err
 }
 
 // Get method will return the (k, v) for matched k
 func (c *Cache) Get(k []byte) (Data, error) {
-	if c==nil {
-		return Data{}, errors.New("Cache has not been initialized. Use Init() method for initialization.")
+	if c!=nil && c.arena!=nil {
+		return c.arena.Get(k)
+	}
+	if c==nil || len(c.buckets)==0 {
+		return Data{}, ErrNotInitialized
 	}
 	h := getHash64(k)
-	return c.buckets[h%uint64(len(c.buckets))].getFromBucket(k, h)
+	index := h%uint64(len(c.buckets))
+	span := c.startSpan(OpGet, h, index)
+	data, err := c.buckets[index].getFromBucket(k, h, OpGet)
+	if span != nil {
+		span.SetBool(AttributeHit, err == nil)
+		span.End(err)
+	}
+	if err != nil {
+		return data, err
+	}
+	return c.output(data)
 }
 
 // Update method will update the v for given k
 func (c *Cache) Update(k, v []byte) error {
-	if c==nil {
-		return errors.New("Cache has not been initialized. Use Init() method for initialization.")
+	if c!=nil && c.arena!=nil {
+		return c.arena.Update(k, v)
+	}
+	if c==nil || len(c.buckets)==0 {
+		return ErrNotInitialized
 	}
 	h := getHash64(k)
-	return c.buckets[h%uint64(len(c.buckets))].updateInBucket(k, v, h)
+	index := h%uint64(len(c.buckets))
+	span := c.startSpan(OpUpdate, h, index)
+	err := c.buckets[index].updateInBucket(k, v, h)
+	c.applyDependencyWork()
+	if span != nil {
+		span.End(err)
+	}
+	return err
 }
 
 // Evict method will evict the (k, v) from the cache on the basis of k
 func (c *Cache) Evict(k []byte) error {
-	if c==nil {
-		return errors.New("Cache has not been initialized. Use Init() method for initialization.")
+	if c!=nil && c.arena!=nil {
+		return c.arena.Evict(k)
+	}
+	if c==nil || len(c.buckets)==0 {
+		return ErrNotInitialized
 	}
 	h := getHash64(k)
-	return c.buckets[h%uint64(len(c.buckets))].deleteFromBucket(k, h)
+	index := h%uint64(len(c.buckets))
+	span := c.startSpan(OpEvict, h, index)
+	err := c.buckets[index].deleteFromBucket(k, h, EvictedExplicitly)
+	c.applyDependencyWork()
+	if span != nil {
+		span.End(err)
+	}
+	return err
 }
 
-func (b *bucket) addToBucket(k, v []byte, h uint64, costFun *func(data Data) int) error {
+func (b *bucket) addToBucket(k, v []byte, h uint64, costFun *func(data Data) int, tags []string) (int, error) {
+	k, v, codec, size, err := b.cache.prepare(k, v)
+	if err != nil {
+		return 0, err
+	}
+	return b.addNodeToBucket(&Data{key: k, value: v, costFunction: costFun, tags: tags, codec: codec, size: size}, h)
+}
+
+// addNodeToBucket adds a prepared node to the bucket, it is used by addToBucket and by Restore which
+// has to keep the reads and updates of the restored entries. It returns the number of other entries evicted to make room.
+func (b *bucket) addNodeToBucket(node *Data, h uint64) (int, error) {
 	if b.entries == nil {
-		return errors.New("Cache has not been initialized. Use Init() method for initialization.")
+		return 0, ErrNotInitialized
 	}
-	b.mutex.Lock()
-	node := &Data{k, v, 0, 0, costFun, nil, nil}
+	timer := b.lock(OpAdd)
+	evictions := b.addNode(node, h)
+	b.unlock(timer)
+	return evictions, nil
+}
+
+// addNode adds node under hash h, evicting the entry with the same hash or the entry with the minimum cost when
+// the bucket is full. It returns the number of other entries evicted. Bucket must be locked by the caller.
+func (b *bucket) addNode(node *Data, h uint64) int {
+	return b.addNodeKeeping(node, h, nil)
+}
 
+// addNodeKeeping adds node like addNode, but the entry evicted when the bucket is full is the one with the minimum
+// cost whose hash is not in keep. Bucket must be locked by the caller.
+func (b *bucket) addNodeKeeping(node *Data, h uint64, keep map[uint64]bool) int {
+	evictions := 0
+	k := node.key
 	value, exist := b.entries[h]
 
 	if exist {
 		if !bytes.Equal(value.key, k) {
 			atomic.AddUint64(&b.collisions, 1)
+			b.removeEntry(h, value, EvictedByCollision)
+			evictions++
+		} else {
+			b.removeEntry(h, value, replaced)
 		}
-		delete(b.entries, h)
-		cost := (*value.costFunction)(*value)
-		b.costListsMap[cost].removeNode(value)
-		if b.costListsMap[cost].size == 0 {
-			delete(b.costListsMap, cost)
-			b.costTree = remove(b.costTree, cost)
-		}
-		b.entriesCount = uint64(len(b.entries))
 	}
 
 	if b.entriesCount == b.maxEntries {
-		minCostNode := findMinimum(b.costTree)
-		if minCostNode != nil {
-			minCost := minCostNode.cost
-
-			headNode := b.costListsMap[minCost].head
-			delete(b.entries, getHash64(headNode.key))
-
-			b.costListsMap[minCost].removeNode(b.costListsMap[minCost].head)
-			if b.costListsMap[minCost].size == 0 {
-				delete(b.costListsMap, minCost)
-				b.costTree = remove(b.costTree, minCost)
+		var minimum *Data
+		if keep == nil {
+			if minCostNode := findMinimum(b.costTree); minCostNode != nil {
+				minimum = b.costListsMap[minCostNode.cost].head
 			}
-			b.entriesCount = uint64(len(b.entries))
+		} else {
+			minimum = b.minimumExcept(keep)
+		}
+		if minimum != nil {
+			b.removeEntry(getHash64(minimum.key), minimum, EvictedByCapacity)
+			evictions++
 		}
 	}
+	node.version = atomic.AddUint64(&b.cache.versions, 1)
 	b.entries[h] = node
-	nodeCost := (*node.costFunction)(*node)
-	b.costTree = insert(b.costTree, nodeCost)
-	_, found := b.costListsMap[nodeCost]
-	if found {
-		b.costListsMap[nodeCost].addNode(node)
-	}else{
-		b.costListsMap[nodeCost] = createDataNodesList()
-		b.costListsMap[nodeCost].addNode(node)
-	}
+	b.linkNode(node, (*node.costFunction)(*node))
+	b.tagNode(h, node)
+	b.cache.notify(Mutation{Kind: MutationAdd, Key: node.key, CostFunction: node.costFunction, Tags: node.tags}.withValue(node.value, node.codec))
 	b.entriesCount = uint64(len(b.entries))
-
-	b.mutex.Unlock()
-	return nil
+	atomic.AddUint64(&b.bytes, entrySize(node))
+	atomic.AddUint64(&b.rawBytes, rawSize(node))
+	atomic.AddUint64(&b.adds, 1)
+	return evictions
 }
 
-func (b *bucket) getFromBucket(k []byte, h uint64) (Data, error) {
+// getFromBucket reads under the shared lock, so readers of a bucket never wait for each other, only for writers. The
+// read is recorded and applied to the cost of the entry by the next writer of the bucket, see recordRead. op is the
+// operation reading, OpGet or OpGetFunc.
+func (b *bucket) getFromBucket(k []byte, h uint64, op Op) (Data, error) {
 	if b.entries == nil {
-		return Data{}, errors.New("Cache has not been initialized. Use Init() method for initialization.")
+		return Data{}, ErrNotInitialized
 	}
-	b.mutex.Lock()
+	timer := b.rlock(op)
 	value, exist := b.entries[h]
 
 	if !exist {
-		b.mutex.Unlock()
-		return Data{}, errors.New("key-value pair not found")
+		b.runlock(timer)
+		atomic.AddUint64(&b.misses, 1)
+		return Data{}, ErrNotFound
 	} else {
 		if bytes.Compare(k, value.key) != 0 {
 			atomic.AddUint64(&b.collisions, 1)
-			b.mutex.Unlock()
-			return Data{}, errors.New("key-value pair not found")
+			b.runlock(timer)
+			atomic.AddUint64(&b.misses, 1)
+			return Data{}, ErrNotFound
 		}
 	}
 
-	oldCost := (*value.costFunction)(*value)
-	value.reads++
-	newCost := (*value.costFunction)(*value)
-
-	if oldCost != newCost{
-
-		nodesList := b.costListsMap[oldCost]
-		if nodesList.size == 1 {
-			b.costTree = remove(b.costTree, oldCost)
-			delete(b.costListsMap, oldCost)
-		}else {
-			nodesList.removeNode(value)
-		}
-
-		_, found := b.costListsMap[newCost]
-
-		if found{
-			b.costListsMap[newCost].addNode(value)
-		}else {
-			b.costListsMap[newCost] = createDataNodesList()
-			b.costListsMap[newCost].addNode(value)
-
-			b.costTree = insert(b.costTree, newCost)
-		}
-	}
-
-	b.mutex.Unlock()
-
-	return *value, nil
+	return b.getShared(value, timer)
 }
 
 func (b *bucket) updateInBucket(k, v []byte, h uint64) error {
 	if b.entries == nil {
-		return errors.New("Cache has not been initialized. Use Init() method for initialization.")
+		return ErrNotInitialized
 	}
-	b.mutex.Lock()
+	v, codec, size, err := b.cache.prepareValue(v)
+	if err != nil {
+		return err
+	}
+	timer := b.lock(OpUpdate)
+	err = b.updateEntry(k, &Data{value: v, codec: codec, size: size}, h)
+	b.unlock(timer)
+	return err
+}
+
+// updateEntry replaces the value of k, stored under hash h, with the value of update, compressed with the codec of
+// update. Bucket must be locked by the caller.
+func (b *bucket) updateEntry(k []byte, update *Data, h uint64) error {
 	value, exist := b.entries[h]
 	if exist {
 		if bytes.Compare(k, value.key) == 0 {
 			oldCost := (*value.costFunction)(*value)
-			value.value = v
+			oldSize, oldRawSize := entrySize(value), rawSize(value)
+			value.value, value.codec, value.size = update.value, update.codec, update.size
+			atomic.AddUint64(&b.bytes, entrySize(value)-oldSize)
+			atomic.AddUint64(&b.rawBytes, rawSize(value)-oldRawSize)
 			value.updates++
-			newCost := (*value.costFunction)(*value)
-			if oldCost!=newCost{
-				nodesList := b.costListsMap[oldCost]
-				if nodesList.size==1 {
-					b.costTree = remove(b.costTree, oldCost)
-					delete(b.costListsMap, oldCost)
-				}else {
-					nodesList.removeNode(value)
-				}
-
-				_, found := b.costListsMap[newCost]
-
-				if found{
-					b.costListsMap[newCost].addNode(value)
-				}else {
-					b.costListsMap[newCost] = createDataNodesList()
-					b.costListsMap[newCost].addNode(value)
-
-					b.costTree = insert(b.costTree, newCost)
-				}
-			}
-			b.mutex.Unlock()
+			value.version = atomic.AddUint64(&b.cache.versions, 1)
+			b.moveNode(value, oldCost, (*value.costFunction)(*value))
+			b.cache.dependencies.updated(k)
+			b.cache.notify(Mutation{Kind: MutationUpdate, Key: k, CostFunction: value.costFunction}.withValue(value.value, value.codec))
+			atomic.AddUint64(&b.updates, 1)
 			return nil
 		}
 		atomic.AddUint64(&b.collisions, 1)
 	}
-	b.mutex.Unlock()
-	return errors.New("key not exist")
+	return ErrKeyNotExist
 }
 
-func (b *bucket) deleteFromBucket(k []byte, h uint64) error {
+func (b *bucket) deleteFromBucket(k []byte, h uint64, reason EvictionReason) error {
 	if b.entries == nil {
-		return errors.New("Cache has not been initialized. Use Init() method for initialization.")
+		return ErrNotInitialized
 	}
-	b.mutex.Lock()
+	timer := b.lock(OpEvict)
+	err := b.deleteEntry(k, h, reason)
+	b.unlock(timer)
+	return err
+}
+
+// deleteEntry removes k, stored under hash h, under reason. Bucket must be locked by the caller.
+func (b *bucket) deleteEntry(k []byte, h uint64, reason EvictionReason) error {
 	value, exist := b.entries[h]
 	if exist {
 		if bytes.Compare(k, value.key) == 0 {
-			cost := (*value.costFunction)(*value)
-			nodesList := b.costListsMap[cost]
-			if nodesList.size==1{
-				b.costTree = remove(b.costTree, cost)
-				delete(b.costListsMap, cost)
-			}else{
-				nodesList.removeNode(value)
-			}
-			delete(b.entries, h)
-			b.entriesCount = uint64(len(b.entries))
-			b.mutex.Unlock()
+			b.removeEntry(h, value, reason)
 			return nil
 		}
 		atomic.AddUint64(&b.collisions, 1)
 	}
-	b.mutex.Unlock()
-	return errors.New("key not exist")
+	return ErrKeyNotExist
+}
+
+// removeEntry removes node, stored under hash h, from the entries and the cost structures of the bucket and counts
+// the eviction under reason. Bucket must be locked by the caller.
+func (b *bucket) removeEntry(h uint64, node *Data, reason EvictionReason) {
+	b.unlinkNode(node, (*node.costFunction)(*node))
+	b.untagNode(h, node)
+	b.cache.dependencies.removed(node.key)
+	delete(b.entries, h)
+	b.entriesCount = uint64(len(b.entries))
+	atomic.AddUint64(&b.bytes, -entrySize(node))
+	atomic.AddUint64(&b.rawBytes, -rawSize(node))
+	if reason != replaced {
+		atomic.AddUint64(&b.evictions[reason], 1)
+		b.cache.notify(Mutation{Kind: MutationEvict, Key: node.key, CostFunction: node.costFunction, Reason: reason}.withValue(node.value, node.codec))
+	}
+}
+
+// linkNode adds node to the cost list of cost, creating the list and its tree node when it is the first node with this cost
+func (b *bucket) linkNode(node *Data, cost int) {
+	nodesList, found := b.costListsMap[cost]
+	if !found {
+		nodesList = createDataNodesList()
+		b.costListsMap[cost] = nodesList
+		b.costTree = insert(b.costTree, cost)
+	}
+	nodesList.addNode(node)
+}
+
+// unlinkNode removes node from the cost list of cost, removing the list and its tree node when it becomes empty
+func (b *bucket) unlinkNode(node *Data, cost int) {
+	nodesList := b.costListsMap[cost]
+	nodesList.removeNode(node)
+	if nodesList.size == 0 {
+		delete(b.costListsMap, cost)
+		b.costTree = remove(b.costTree, cost)
+	}
+}
+
+// moveNode moves node to the cost list of newCost when its cost has changed
+func (b *bucket) moveNode(node *Data, oldCost, newCost int) {
+	if oldCost != newCost {
+		b.unlinkNode(node, oldCost)
+		b.linkNode(node, newCost)
+	}
+}
+
+// entrySize returns the number of bytes of key and value of an entry
+func entrySize(node *Data) uint64 {
+	return uint64(len(node.key) + len(node.value))
 }
 
 func getHash64(k []byte) uint64 {
@@ -444,7 +653,7 @@ func leftRotate(node *costNode) *costNode {
 	rightLeftSubTree := rightNode.left
 
 	rightNode.left = node
-	node.left = rightLeftSubTree
+	node.right = rightLeftSubTree
 
 	node.height = max(height(node.left), height(node.right))+1
 	rightNode.height = max(height(rightNode.left), height(rightNode.right))+1
@@ -567,6 +776,15 @@ func remove(rootNode *costNode, cost int) *costNode {
 	return rootNode
 }
 
+// Returns maximum cost node of the cost tree
+func findMaximum(rootNode *costNode) *costNode {
+	node := rootNode
+	for node!=nil && node.right != nil {
+		node = node.right
+	}
+	return node
+}
+
 // Returns minimum cost node of the cost tree
 func findMinimum(rootNode *costNode) *costNode {
 	node := rootNode
@@ -0,0 +1,803 @@
+package gocache
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestCache(t testing.TB, capacity, buckets int) *Cache {
+	t.Helper()
+	c := &Cache{}
+	c.Init(capacity, buckets)
+	return c
+}
+
+func key(i int) []byte {
+	return []byte(fmt.Sprintf("key%v", i))
+}
+
+func TestAddGet(t *testing.T) {
+	tests := []struct {
+		name    string
+		adds    [][2]string
+		get     string
+		want    string
+		wantErr error
+	}{
+		{"single", [][2]string{{"k", "v"}}, "k", "v", nil},
+		{"missing", [][2]string{{"k", "v"}}, "other", "", ErrNotFound},
+		{"overwrite", [][2]string{{"k", "v1"}, {"k", "v2"}}, "k", "v2", nil},
+		{"empty value", [][2]string{{"k", ""}}, "k", "", nil},
+		{"empty key", [][2]string{{"", "v"}}, "", "v", nil},
+		{"empty cache", nil, "k", "", ErrNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestCache(t, 100, 4)
+			for _, kv := range test.adds {
+				if err := c.Add([]byte(kv[0]), []byte(kv[1]), &BalancedCost); err != nil {
+					t.Fatalf("Add(%q): %v", kv[0], err)
+				}
+			}
+			data, err := c.Get([]byte(test.get))
+			if err != test.wantErr {
+				t.Fatalf("Get(%q) error = %v, want %v", test.get, err, test.wantErr)
+			}
+			if err == nil && string(data.GetValue()) != test.want {
+				t.Errorf("Get(%q) = %q, want %q", test.get, data.GetValue(), test.want)
+			}
+			checkCache(t, c)
+		})
+	}
+}
+
+func TestReadsAndUpdates(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	c.Add([]byte("k"), []byte("v"), &BalancedCost)
+	c.Get([]byte("k"))
+	c.Update([]byte("k"), []byte("v2"))
+	data, err := c.Get([]byte("k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.GetReads() != 2 || data.GetUpdates() != 1 {
+		t.Errorf("reads, updates = %v, %v, want 2, 1", data.GetReads(), data.GetUpdates())
+	}
+	// Add of an existing key replaces the entry and its counters
+	c.Add([]byte("k"), []byte("v3"), &BalancedCost)
+	data, _ = c.Get([]byte("k"))
+	if data.GetReads() != 1 || data.GetUpdates() != 0 || string(data.GetValue()) != "v3" {
+		t.Errorf("after overwrite got %q reads %v updates %v, want \"v3\" 1 0", data.GetValue(), data.GetReads(), data.GetUpdates())
+	}
+	checkCache(t, c)
+}
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		value   string
+		wantErr error
+	}{
+		{"existing", "k", "new", nil},
+		{"empty value", "k", "", nil},
+		{"missing", "other", "new", ErrKeyNotExist},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestCache(t, 100, 4)
+			c.Add([]byte("k"), []byte("old"), &SizeCost)
+			if err := c.Update([]byte(test.key), []byte(test.value)); err != test.wantErr {
+				t.Fatalf("Update(%q) error = %v, want %v", test.key, err, test.wantErr)
+			}
+			want := "old"
+			if test.wantErr == nil {
+				want = test.value
+			}
+			if data, _ := c.Get([]byte("k")); string(data.GetValue()) != want {
+				t.Errorf("value = %q, want %q", data.GetValue(), want)
+			}
+			if test.wantErr != nil {
+				if _, err := c.Get([]byte(test.key)); err != ErrNotFound {
+					t.Errorf("Update of a missing key added it")
+				}
+			}
+			checkCache(t, c)
+		})
+	}
+}
+
+func TestEvict(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr error
+	}{
+		{"existing", "k", nil},
+		{"missing", "other", ErrKeyNotExist},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestCache(t, 100, 4)
+			c.Add([]byte("k"), []byte("v"), &SizeCost)
+			if err := c.Evict([]byte(test.key)); err != test.wantErr {
+				t.Fatalf("Evict(%q) error = %v, want %v", test.key, err, test.wantErr)
+			}
+			_, err := c.Get([]byte("k"))
+			if test.wantErr == nil && err != ErrNotFound {
+				t.Errorf("Get after Evict error = %v, want %v", err, ErrNotFound)
+			}
+			if test.wantErr != nil && err != nil {
+				t.Errorf("Evict of a missing key removed another key: %v", err)
+			}
+			if got, want := c.GetEvictionsCount(EvictedExplicitly), uint64(1); test.wantErr == nil && got != want {
+				t.Errorf("explicit evictions = %v, want %v", got, want)
+			}
+			checkCache(t, c)
+		})
+	}
+}
+
+func TestClear(t *testing.T) {
+	c := newTestCache(t, 1000, 8)
+	for i := 0; i < 500; i++ {
+		c.Add(key(i), []byte("v"), &BalancedCost)
+	}
+	c.Clear()
+	if n := c.GetEntriesCount(); n != 0 {
+		t.Errorf("entries after Clear = %v, want 0", n)
+	}
+	if n := c.GetBytesCount(); n != 0 {
+		t.Errorf("bytes after Clear = %v, want 0", n)
+	}
+	for i := 0; i < 500; i++ {
+		if _, err := c.Get(key(i)); err != ErrNotFound {
+			t.Fatalf("Get(%s) after Clear error = %v, want %v", key(i), err, ErrNotFound)
+		}
+	}
+	checkCache(t, c)
+	// the cache is usable after Clear
+	c.Add([]byte("k"), []byte("v"), &BalancedCost)
+	if _, err := c.Get([]byte("k")); err != nil {
+		t.Errorf("Get after Clear and Add: %v", err)
+	}
+	checkCache(t, c)
+}
+
+func TestNotInitialized(t *testing.T) {
+	for _, c := range []*Cache{nil, {}} {
+		if err := c.Add([]byte("k"), []byte("v"), &SizeCost); err != ErrNotInitialized {
+			t.Errorf("Add error = %v, want %v", err, ErrNotInitialized)
+		}
+		if _, err := c.Get([]byte("k")); err != ErrNotInitialized {
+			t.Errorf("Get error = %v, want %v", err, ErrNotInitialized)
+		}
+		if err := c.Update([]byte("k"), []byte("v")); err != ErrNotInitialized {
+			t.Errorf("Update error = %v, want %v", err, ErrNotInitialized)
+		}
+		if err := c.Evict([]byte("k")); err != ErrNotInitialized {
+			t.Errorf("Evict error = %v, want %v", err, ErrNotInitialized)
+		}
+	}
+}
+
+func TestInitPanics(t *testing.T) {
+	tests := []struct {
+		name              string
+		capacity, buckets int
+	}{
+		{"negative buckets", 10, -1},
+		{"too many buckets", 10, 1025},
+		{"negative capacity", -1, 1},
+		{"capacity over buckets", 2001, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Init(%v, %v) did not panic", test.capacity, test.buckets)
+				}
+			}()
+			c := &Cache{}
+			c.Init(test.capacity, test.buckets)
+		})
+	}
+}
+
+func TestCostBasedEviction(t *testing.T) {
+	tests := []struct {
+		name    string
+		costFun *func(data Data) int
+		setup   func(c *Cache)
+		evicted string
+	}{
+		{"smallest size", &SizeCost, func(c *Cache) {
+			c.Add([]byte("a"), []byte("long value"), &SizeCost)
+			c.Add([]byte("b"), []byte("v"), &SizeCost)
+			c.Add([]byte("c"), []byte("medium"), &SizeCost)
+		}, "b"},
+		{"least read", &FrequencyCost, func(c *Cache) {
+			c.Add([]byte("a"), []byte("v"), &FrequencyCost)
+			c.Add([]byte("b"), []byte("v"), &FrequencyCost)
+			c.Add([]byte("c"), []byte("v"), &FrequencyCost)
+			c.Get([]byte("a"))
+			c.Get([]byte("c"))
+		}, "b"},
+		{"oldest of equal costs", &ConstantCost, func(c *Cache) {
+			c.Add([]byte("a"), []byte("v"), &ConstantCost)
+			c.Add([]byte("b"), []byte("v"), &ConstantCost)
+			c.Add([]byte("c"), []byte("v"), &ConstantCost)
+		}, "a"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestCache(t, 3, 1)
+			test.setup(c)
+			checkCache(t, c)
+			c.Add([]byte("d"), []byte("vvvvvvvvvvvvvvvvvvvv"), test.costFun)
+			if _, err := c.Get([]byte(test.evicted)); err != ErrNotFound {
+				t.Errorf("%q was not evicted", test.evicted)
+			}
+			if n := c.GetEntriesCount(); n != 3 {
+				t.Errorf("entries = %v, want 3", n)
+			}
+			if n := c.GetEvictionsCount(EvictedByCapacity); n != 1 {
+				t.Errorf("capacity evictions = %v, want 1", n)
+			}
+			checkCache(t, c)
+		})
+	}
+}
+
+func TestCollision(t *testing.T) {
+	c := newTestCache(t, 10, 1)
+	b := &c.buckets[0]
+	// two keys stored under the same hash, as if their hashes collided
+	h := getHash64([]byte("a"))
+	b.addToBucket([]byte("a"), []byte("1"), h, &SizeCost, nil)
+	b.addToBucket([]byte("b"), []byte("2"), h, &SizeCost, nil)
+	if _, err := b.getFromBucket([]byte("a"), h, OpGet); err != ErrNotFound {
+		t.Errorf("colliding key was not replaced, error = %v", err)
+	}
+	if data, err := b.getFromBucket([]byte("b"), h, OpGet); err != nil || string(data.GetValue()) != "2" {
+		t.Errorf("Get of the last key = %q, %v", data.GetValue(), err)
+	}
+	if err := b.updateInBucket([]byte("a"), []byte("x"), h); err != ErrKeyNotExist {
+		t.Errorf("Update of the replaced key error = %v, want %v", err, ErrKeyNotExist)
+	}
+	if err := b.deleteFromBucket([]byte("a"), h, EvictedExplicitly); err != ErrKeyNotExist {
+		t.Errorf("Evict of the replaced key error = %v, want %v", err, ErrKeyNotExist)
+	}
+	if n := c.GetEvictionsCount(EvictedByCollision); n != 1 {
+		t.Errorf("collision evictions = %v, want 1", n)
+	}
+	if n := c.GetCollisionsCount(); n != 4 {
+		t.Errorf("collisions = %v, want 4", n)
+	}
+	checkCache(t, c)
+}
+
+func TestBytesCount(t *testing.T) {
+	c := newTestCache(t, 100, 2)
+	c.Add([]byte("ab"), []byte("cde"), &SizeCost)
+	c.Add([]byte("f"), []byte("g"), &SizeCost)
+	c.Update([]byte("ab"), []byte("c"))
+	c.Evict([]byte("f"))
+	if n := c.GetBytesCount(); n != 3 {
+		t.Errorf("bytes = %v, want 3", n)
+	}
+	checkCache(t, c)
+}
+
+func TestCostTreeRandomized(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for round := 0; round < 50; round++ {
+		var root *costNode
+		model := map[int]bool{}
+		for i := 0; i < 500; i++ {
+			cost := r.Intn(200) - 100
+			if r.Intn(3) == 0 {
+				root = remove(root, cost)
+				delete(model, cost)
+			} else {
+				root = insert(root, cost)
+				model[cost] = true
+			}
+			if err := checkCostTree(root); err != nil {
+				t.Fatalf("round %v op %v: %v", round, i, err)
+			}
+		}
+		got := treeCosts(root, nil)
+		want := make([]int, 0, len(model))
+		for cost := range model {
+			want = append(want, cost)
+		}
+		sort.Ints(want)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("round %v: tree holds %v, want %v", round, got, want)
+		}
+		if min, max := findMinimum(root), findMaximum(root); len(want) > 0 && (min.cost != want[0] || max.cost != want[len(want)-1]) {
+			t.Fatalf("round %v: min, max = %v, %v, want %v, %v", round, min.cost, max.cost, want[0], want[len(want)-1])
+		}
+	}
+}
+
+// TestModel runs random operations against the cache and a map, with a capacity large enough for no eviction the
+// cache must behave exactly like the map
+func TestModel(t *testing.T) {
+	for _, costName := range []string{"size", "frequency", "balanced", "constant"} {
+		t.Run(costName, func(t *testing.T) {
+			costFun, _ := CostFunction(costName)
+			r := rand.New(rand.NewSource(2))
+			c := newTestCache(t, 1000, 8)
+			model := map[string]string{}
+			for i := 0; i < 20000; i++ {
+				k := key(r.Intn(300))
+				v := bytes.Repeat([]byte("v"), r.Intn(20))
+				switch r.Intn(4) {
+				case 0:
+					if err := c.Add(k, v, costFun); err != nil {
+						t.Fatalf("Add: %v", err)
+					}
+					model[string(k)] = string(v)
+				case 1:
+					data, err := c.Get(k)
+					want, found := model[string(k)]
+					if found != (err == nil) || string(data.GetValue()) != want {
+						t.Fatalf("op %v: Get(%s) = %q, %v, model has %q, %v", i, k, data.GetValue(), err, want, found)
+					}
+				case 2:
+					err := c.Update(k, v)
+					if _, found := model[string(k)]; found != (err == nil) {
+						t.Fatalf("op %v: Update(%s) error = %v, model has the key: %v", i, k, err, found)
+					}
+					if err == nil {
+						model[string(k)] = string(v)
+					}
+				case 3:
+					err := c.Evict(k)
+					if _, found := model[string(k)]; found != (err == nil) {
+						t.Fatalf("op %v: Evict(%s) error = %v, model has the key: %v", i, k, err, found)
+					}
+					delete(model, string(k))
+				}
+				if i%500 == 0 {
+					checkCache(t, c)
+				}
+			}
+			if n := c.GetEntriesCount(); n != uint64(len(model)) {
+				t.Errorf("entries = %v, model has %v", n, len(model))
+			}
+			checkCache(t, c)
+		})
+	}
+}
+
+// TestModelWithEvictions runs random operations on a small cache, entries may be evicted but a key must never
+// return a value other than the last one written
+func TestModelWithEvictions(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	c := newTestCache(t, 40, 4)
+	model := map[string]string{}
+	for i := 0; i < 20000; i++ {
+		k := key(r.Intn(200))
+		v := []byte(fmt.Sprint(i))
+		switch r.Intn(3) {
+		case 0:
+			c.Add(k, v, &BalancedCost)
+			model[string(k)] = string(v)
+		case 1:
+			if data, err := c.Get(k); err == nil && string(data.GetValue()) != model[string(k)] {
+				t.Fatalf("op %v: Get(%s) = %q, last written %q", i, k, data.GetValue(), model[string(k)])
+			}
+		case 2:
+			if c.Update(k, v) == nil {
+				model[string(k)] = string(v)
+			}
+		}
+		if n := c.GetEntriesCount(); n > 40 {
+			t.Fatalf("op %v: entries = %v over capacity 40", i, n)
+		}
+		if i%500 == 0 {
+			checkCache(t, c)
+		}
+	}
+	checkCache(t, c)
+}
+
+func TestConcurrentStress(t *testing.T) {
+	c := newTestCache(t, 2000, 16)
+	goroutines, ops := 8, 5000
+	if testing.Short() {
+		ops = 500
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < ops; i++ {
+				k := key(r.Intn(3000))
+				switch r.Intn(6) {
+				case 0, 1:
+					c.Add(k, []byte(fmt.Sprint(i)), &BalancedCost)
+				case 2, 3:
+					c.Get(k)
+				case 4:
+					c.Update(k, []byte("updated"))
+				case 5:
+					c.Evict(k)
+				}
+			}
+		}(int64(g))
+	}
+	// readers of the whole cache run alongside the operations
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			c.Stats()
+			c.GetEntriesCount()
+			c.Snapshot(&bytes.Buffer{})
+		}
+	}()
+	wg.Wait()
+	checkCache(t, c)
+	stats := c.Stats()
+	if stats.Entries > stats.MaxEntries {
+		t.Errorf("entries = %v over capacity %v", stats.Entries, stats.MaxEntries)
+	}
+}
+
+func TestConcurrentGetOrLoad(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	var mutex sync.Mutex
+	loads := 0
+	release := make(chan struct{})
+	loader := func(k []byte) ([]byte, error) {
+		mutex.Lock()
+		loads++
+		mutex.Unlock()
+		<-release
+		return []byte("loaded"), nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if data, err := c.GetOrLoad([]byte("k"), loader, &SizeCost); err != nil || string(data.GetValue()) != "loaded" {
+				t.Errorf("GetOrLoad = %q, %v", data.GetValue(), err)
+			}
+		}()
+	}
+	close(release)
+	wg.Wait()
+	// callers arriving after the load find the key in the cache, so there is never more than one load
+	if loads != 1 {
+		t.Errorf("loader called %v times, want 1", loads)
+	}
+}
+
+func TestGetOrLoadReturnsTheStoredEntry(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	c.SetCompression(newTestCodec(t, "flate"), 0)
+	value := jsonValue(1000)
+	release := make(chan struct{})
+	loader := func(k []byte) ([]byte, error) {
+		<-release
+		return value, nil
+	}
+	results := make(chan Data, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := c.GetOrLoad([]byte("k"), loader, &SizeCost)
+			if err != nil {
+				t.Errorf("GetOrLoad: %v", err)
+			}
+			results <- data
+		}()
+	}
+	// give the callers time to wait for the load
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	stored, err := c.Get([]byte("k"))
+	if err != nil || stored.GetVersion() == 0 {
+		t.Fatalf("Get = version %v, %v, want the loaded entry", stored.GetVersion(), err)
+	}
+	for data := range results {
+		if data.GetVersion() != stored.GetVersion() || !bytes.Equal(data.GetValue(), value) ||
+			string(data.GetKey()) != "k" || data.costFunction != &SizeCost {
+			t.Errorf("GetOrLoad = version %v, %v bytes, want version %v and the loaded value", data.GetVersion(),
+				len(data.GetValue()), stored.GetVersion())
+		}
+	}
+}
+
+func TestGetOrLoadPanic(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	started, release := make(chan struct{}), make(chan struct{})
+	panicking := func(k []byte) ([]byte, error) {
+		close(started)
+		<-release
+		panic("loader failed")
+	}
+	panicked := make(chan interface{})
+	go func() {
+		defer func() { panicked <- recover() }()
+		c.GetOrLoad([]byte("k"), panicking, &SizeCost)
+	}()
+	<-started
+	waiter := make(chan error)
+	go func() {
+		_, err := c.GetOrLoad([]byte("k"), func(k []byte) ([]byte, error) { return []byte("v"), nil }, &SizeCost)
+		waiter <- err
+	}()
+	close(release)
+	if recovered := <-panicked; recovered != "loader failed" {
+		t.Errorf("GetOrLoad recovered %v, want the panic of the loader", recovered)
+	}
+	// the waiter either joined the panicked load or started after it, it must not hang
+	if err := <-waiter; err != nil && err != ErrLoaderPanicked {
+		t.Errorf("GetOrLoad waiting for a panicked load = %v, want nil or %v", err, ErrLoaderPanicked)
+	}
+	data, err := c.GetOrLoad([]byte("k"), func(k []byte) ([]byte, error) { return []byte("v"), nil }, &SizeCost)
+	if err != nil || string(data.GetValue()) != "v" {
+		t.Errorf("GetOrLoad after a panicked load = %q, %v, want %q", data.GetValue(), err, "v")
+	}
+}
+
+// checkCache checks the invariants of every bucket of c
+func checkCache(t testing.TB, c *Cache) {
+	t.Helper()
+	for i := range c.buckets {
+		b := &c.buckets[i]
+		b.mutex.RLock()
+		err := checkBucket(b)
+		b.mutex.RUnlock()
+		if err != nil {
+			t.Fatalf("bucket %v: %v", i, err)
+		}
+	}
+}
+
+// checkBucket returns an error when the entries, the cost lists and the cost tree of b are out of sync: every entry
+// must be in exactly one cost list, the one of its current cost, every cost list must be non empty and have a
+// node in the tree, the tree must be a valid AVL tree and the tags index must hold exactly the tags of the entries.
+// Bucket must be locked by the caller.
+func checkBucket(b *bucket) error {
+	if err := checkCostTree(b.costTree); err != nil {
+		return err
+	}
+	costs := treeCosts(b.costTree, nil)
+	if len(costs) != len(b.costListsMap) {
+		return fmt.Errorf("tree has %v costs, there are %v cost lists", len(costs), len(b.costListsMap))
+	}
+	listed := map[*Data]int{}
+	for _, cost := range costs {
+		list, found := b.costListsMap[cost]
+		if !found {
+			return fmt.Errorf("cost %v is in the tree but has no list", cost)
+		}
+		if list.size == 0 || list.head == nil || list.tail == nil {
+			return fmt.Errorf("cost list %v is empty", cost)
+		}
+		size := 0
+		var prev *Data
+		for node := list.head; node != nil; node = node.next {
+			if node.prev != prev {
+				return fmt.Errorf("cost list %v has a broken prev link", cost)
+			}
+			if _, found := listed[node]; found {
+				return fmt.Errorf("entry %q is in cost lists %v and %v", node.key, listed[node], cost)
+			}
+			listed[node] = cost
+			prev = node
+			size++
+			if size > len(b.entries) {
+				return fmt.Errorf("cost list %v has a cycle", cost)
+			}
+		}
+		if prev != list.tail || size != list.size {
+			return fmt.Errorf("cost list %v has size %v and %v nodes", cost, list.size, size)
+		}
+	}
+	var bytes, rawBytes uint64
+	for _, node := range b.entries {
+		cost, found := listed[node]
+		if !found {
+			return fmt.Errorf("entry %q is in no cost list", node.key)
+		}
+		if want := (*node.costFunction)(*node); cost != want {
+			return fmt.Errorf("entry %q is in cost list %v, its cost is %v", node.key, cost, want)
+		}
+		bytes += entrySize(node)
+		rawBytes += rawSize(node)
+	}
+	if len(listed) != len(b.entries) {
+		return fmt.Errorf("cost lists hold %v nodes, there are %v entries", len(listed), len(b.entries))
+	}
+	tagged := 0
+	for h, node := range b.entries {
+		for _, tag := range node.tags {
+			if _, found := b.tags[tag][h]; !found {
+				return fmt.Errorf("entry %q is not in the index of its tag %q", node.key, tag)
+			}
+			tagged++
+		}
+	}
+	indexed := 0
+	for tag, hashes := range b.tags {
+		if len(hashes) == 0 {
+			return fmt.Errorf("tag %q has an empty set", tag)
+		}
+		for h := range hashes {
+			if _, found := b.entries[h]; !found {
+				return fmt.Errorf("tag %q indexes a removed entry", tag)
+			}
+		}
+		indexed += len(hashes)
+	}
+	if tagged != indexed {
+		return fmt.Errorf("entries carry %v tags, the index holds %v", tagged, indexed)
+	}
+	if b.entriesCount != uint64(len(b.entries)) {
+		return fmt.Errorf("entries count is %v, there are %v entries", b.entriesCount, len(b.entries))
+	}
+	if b.entriesCount > b.maxEntries {
+		return fmt.Errorf("%v entries over the maximum of %v", b.entriesCount, b.maxEntries)
+	}
+	if b.bytes != bytes {
+		return fmt.Errorf("bytes count is %v, entries hold %v bytes", b.bytes, bytes)
+	}
+	if b.rawBytes != rawBytes {
+		return fmt.Errorf("raw bytes count is %v, entries hold %v bytes before compression", b.rawBytes, rawBytes)
+	}
+	return nil
+}
+
+// checkCostTree returns an error when the tree under node is not ordered, has wrong heights or is out of balance
+func checkCostTree(node *costNode) error {
+	_, err := checkCostSubTree(node, nil, nil)
+	return err
+}
+
+func checkCostSubTree(node *costNode, lower, upper *int) (int, error) {
+	if node == nil {
+		return 0, nil
+	}
+	if (lower != nil && node.cost <= *lower) || (upper != nil && node.cost >= *upper) {
+		return 0, fmt.Errorf("cost %v is out of order", node.cost)
+	}
+	left, err := checkCostSubTree(node.left, lower, &node.cost)
+	if err != nil {
+		return 0, err
+	}
+	right, err := checkCostSubTree(node.right, &node.cost, upper)
+	if err != nil {
+		return 0, err
+	}
+	if node.height != max(left, right)+1 {
+		return 0, fmt.Errorf("node %v has height %v, want %v", node.cost, node.height, max(left, right)+1)
+	}
+	if left-right > 1 || right-left > 1 {
+		return 0, fmt.Errorf("node %v is out of balance, heights %v and %v", node.cost, left, right)
+	}
+	return node.height, nil
+}
+
+// treeCosts appends the costs of the tree under node to costs in order
+func treeCosts(node *costNode, costs []int) []int {
+	if node == nil {
+		return costs
+	}
+	costs = treeCosts(node.left, costs)
+	costs = append(costs, node.cost)
+	return treeCosts(node.right, costs)
+}
+
+func BenchmarkAdd(b *testing.B) {
+	for _, costName := range []string{"constant", "balanced"} {
+		b.Run(costName, func(b *testing.B) {
+			costFun, _ := CostFunction(costName)
+			c := newTestCache(b, 100000, 0)
+			keys := benchmarkKeys(200000)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := rand.Intn(len(keys))
+				for pb.Next() {
+					c.Add(keys[i%len(keys)], keys[i%len(keys)], costFun)
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	for _, costName := range []string{"constant", "balanced"} {
+		b.Run(costName, func(b *testing.B) {
+			costFun, _ := CostFunction(costName)
+			c := newTestCache(b, 100000, 0)
+			keys := benchmarkKeys(100000)
+			for _, k := range keys {
+				c.Add(k, k, costFun)
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := rand.Intn(len(keys))
+				for pb.Next() {
+					c.Get(keys[i%len(keys)])
+					i++
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkGetHotKey has every goroutine read the same key, so they all contend for one bucket
+func BenchmarkGetHotKey(b *testing.B) {
+	c := newTestCache(b, 1000, 0)
+	c.Add([]byte("hot"), []byte("value"), &ConstantCost)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Get([]byte("hot"))
+		}
+	})
+}
+
+// BenchmarkGetOneBucket has every goroutine read the keys of a single bucket. Readers share the lock of the bucket,
+// so the time per read falls as -cpu grows: run it with -cpu 1,4,8 to see the reads scale.
+func BenchmarkGetOneBucket(b *testing.B) {
+	c := newTestCache(b, 1000, 1)
+	keys := benchmarkKeys(1000)
+	for _, k := range keys {
+		c.Add(k, k, &BalancedCost)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Intn(len(keys))
+		for pb.Next() {
+			c.Get(keys[i%len(keys)])
+			i++
+		}
+	})
+}
+
+func BenchmarkMixed(b *testing.B) {
+	c := newTestCache(b, 100000, 0)
+	keys := benchmarkKeys(200000)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			k := keys[r.Intn(len(keys))]
+			if r.Intn(10) == 0 {
+				c.Add(k, k, &BalancedCost)
+			} else if _, err := c.Get(k); err == ErrNotFound {
+				c.Add(k, k, &BalancedCost)
+			}
+		}
+	})
+}
+
+func benchmarkKeys(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = key(i)
+	}
+	return keys
+}
@@ -0,0 +1,155 @@
+package gocache
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Histograms are log-bucketed like HDR histograms: values are grouped by power of two and every power of two is
+// split in histogramSubBuckets linear slots, so a recorded value is known within 1/histogramSubBuckets of itself.
+const (
+	histogramSubBits    = 3
+	histogramSubBuckets = 1 << histogramSubBits
+	// histogramMaxExponent is the largest power of two recorded separately, about 17s in nanoseconds,
+	// longer durations are counted in the last slot
+	histogramMaxExponent = 34
+	histogramSlots       = (histogramMaxExponent - histogramSubBits + 2) * histogramSubBuckets
+)
+
+// Histogram records durations, it is safe for concurrent use and recording does not lock
+type Histogram struct {
+	counts [histogramSlots]uint64
+	count  uint64
+	sum    uint64
+	max    uint64
+}
+
+// Record adds a duration to the histogram, negative durations are recorded as 0
+func (h *Histogram) Record(d time.Duration) {
+	var value uint64
+	if d > 0 {
+		value = uint64(d)
+	}
+	atomic.AddUint64(&h.counts[histogramSlot(value)], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, value)
+	for {
+		current := atomic.LoadUint64(&h.max)
+		if value <= current || atomic.CompareAndSwapUint64(&h.max, current, value) {
+			break
+		}
+	}
+}
+
+// Snapshot returns the percentiles of the recorded durations
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	var merged Histogram
+	merged.Merge(h)
+	return merged.snapshot()
+}
+
+// Merge adds the durations recorded by other to h, for example to combine histograms recorded by different goroutines
+func (h *Histogram) Merge(other *Histogram) {
+	for i := range other.counts {
+		if count := atomic.LoadUint64(&other.counts[i]); count > 0 {
+			atomic.AddUint64(&h.counts[i], count)
+		}
+	}
+	atomic.AddUint64(&h.count, atomic.LoadUint64(&other.count))
+	atomic.AddUint64(&h.sum, atomic.LoadUint64(&other.sum))
+	otherMax := atomic.LoadUint64(&other.max)
+	for {
+		current := atomic.LoadUint64(&h.max)
+		if otherMax <= current || atomic.CompareAndSwapUint64(&h.max, current, otherMax) {
+			break
+		}
+	}
+}
+
+// snapshot computes the snapshot of h, h must not be used concurrently
+func (h *Histogram) snapshot() HistogramSnapshot {
+	snapshot := HistogramSnapshot{Count: h.count, Max: time.Duration(h.max)}
+	if h.count == 0 {
+		return snapshot
+	}
+	snapshot.counts = make([]uint64, histogramSlots)
+	copy(snapshot.counts, h.counts[:])
+	snapshot.Mean = time.Duration(h.sum / h.count)
+	snapshot.P50 = snapshot.Percentile(50)
+	snapshot.P90 = snapshot.Percentile(90)
+	snapshot.P99 = snapshot.Percentile(99)
+	snapshot.P999 = snapshot.Percentile(99.9)
+	return snapshot
+}
+
+// HistogramSnapshot holds the counts of a histogram at one point in time along with the common percentiles
+type HistogramSnapshot struct {
+	Count uint64        `json:"count"`
+	Mean  time.Duration `json:"mean"`
+	Max   time.Duration `json:"max"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+	P999  time.Duration `json:"p999"`
+	// counts are the counts of the slots, nil when nothing was recorded
+	counts []uint64
+}
+
+// Percentile returns the duration below which percentile percent of the recorded durations fall, percentile is
+// between 0 and 100. It uses the nearest rank: the smallest duration such that at least percentile percent of the
+// durations are lower or equal, so the p50 of 1, 2 and 3 is 2 and their p99 is 3. The result is the highest
+// duration of its slot, capped by the maximum recorded duration.
+func (s HistogramSnapshot) Percentile(percentile float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(percentile * float64(s.Count) / 100))
+	if rank == 0 {
+		rank = 1
+	}
+	if rank > s.Count {
+		rank = s.Count
+	}
+	var seen uint64
+	for slot, count := range s.counts {
+		seen += count
+		if seen >= rank {
+			// the last slot also counts the durations above its range
+			if slot == histogramSlots-1 {
+				return s.Max
+			}
+			value := time.Duration(histogramSlotMax(slot))
+			if value > s.Max {
+				return s.Max
+			}
+			return value
+		}
+	}
+	return s.Max
+}
+
+// histogramSlot returns the slot of value
+func histogramSlot(value uint64) int {
+	if value < histogramSubBuckets {
+		return int(value)
+	}
+	exponent := bits.Len64(value) - 1
+	if exponent > histogramMaxExponent {
+		return histogramSlots - 1
+	}
+	sub := int(value>>uint(exponent-histogramSubBits)) & (histogramSubBuckets - 1)
+	return (exponent-histogramSubBits+1)*histogramSubBuckets + sub
+}
+
+// histogramSlotMax returns the highest value counted in slot
+func histogramSlotMax(slot int) uint64 {
+	if slot < histogramSubBuckets {
+		return uint64(slot)
+	}
+	exponent := slot/histogramSubBuckets + histogramSubBits - 1
+	sub := uint64(slot % histogramSubBuckets)
+	width := uint64(1) << uint(exponent-histogramSubBits)
+	return uint64(1)<<uint(exponent) + sub*width + width - 1
+}
@@ -0,0 +1,123 @@
+package gocache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordAll returns the snapshot of a histogram holding durations
+func recordAll(durations ...time.Duration) HistogramSnapshot {
+	var h Histogram
+	for _, d := range durations {
+		h.Record(d)
+	}
+	return h.Snapshot()
+}
+
+// sequence returns the durations from 1ns to n ns
+func sequence(n int) []time.Duration {
+	durations := make([]time.Duration, n)
+	for i := range durations {
+		durations[i] = time.Duration(i + 1)
+	}
+	return durations
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	tests := []struct {
+		name       string
+		durations  []time.Duration
+		percentile float64
+		want       time.Duration
+	}{
+		{"empty", nil, 50, 0},
+		{"single", []time.Duration{5}, 50, 5},
+		{"p0 is the minimum", []time.Duration{3, 1, 2}, 0, 1},
+		{"p50 of three", []time.Duration{1, 2, 3}, 50, 2},
+		{"p99 of three", []time.Duration{1, 2, 3}, 99, 3},
+		{"p100 of three", []time.Duration{1, 2, 3}, 100, 3},
+		{"p50 of ten", sequence(10), 50, 5},
+		{"p90 of ten", sequence(10), 90, 9},
+		{"p99 of ten", sequence(10), 99, 10},
+		{"p99.9 of ten", sequence(10), 99.9, 10},
+		{"negative durations are 0", []time.Duration{-5, -1, 7}, 50, 0},
+		{"capped by the maximum", []time.Duration{1000, 1001}, 100, 1001},
+	}
+	for _, test := range tests {
+		if got := recordAll(test.durations...).Percentile(test.percentile); got != test.want {
+			t.Errorf("%s: Percentile(%v) = %v, want %v", test.name, test.percentile, got, test.want)
+		}
+	}
+}
+
+func TestHistogramPrecision(t *testing.T) {
+	// recorded values are known within 1/histogramSubBuckets above the exact nearest rank
+	durations := make([]time.Duration, 0, 10000)
+	for i := 1; i <= 10000; i++ {
+		durations = append(durations, time.Duration(i)*time.Microsecond)
+	}
+	snapshot := recordAll(durations...)
+	for _, percentile := range []float64{1, 25, 50, 90, 99, 99.9} {
+		exact := time.Duration(percentile*100) * time.Microsecond
+		got := snapshot.Percentile(percentile)
+		if got < exact || got > exact+exact/histogramSubBuckets {
+			t.Errorf("Percentile(%v) = %v, want within 1/%v above %v", percentile, got, histogramSubBuckets, exact)
+		}
+	}
+	if snapshot.P50 != snapshot.Percentile(50) || snapshot.P90 != snapshot.Percentile(90) ||
+		snapshot.P99 != snapshot.Percentile(99) || snapshot.P999 != snapshot.Percentile(99.9) {
+		t.Errorf("snapshot percentiles %+v do not match Percentile", snapshot)
+	}
+	if snapshot.Count != 10000 || snapshot.Max != 10*time.Millisecond || snapshot.Mean != 5000500*time.Nanosecond {
+		t.Errorf("Count, Max, Mean = %v, %v, %v, want 10000, 10ms, 5.0005ms", snapshot.Count, snapshot.Max, snapshot.Mean)
+	}
+}
+
+func TestHistogramLongDurations(t *testing.T) {
+	// durations above the last power of two share the last slot and are capped by the maximum
+	snapshot := recordAll(time.Minute, time.Hour)
+	if got := snapshot.Percentile(50); got != time.Hour {
+		t.Errorf("Percentile(50) = %v, want %v", got, time.Hour)
+	}
+	if snapshot.Max != time.Hour {
+		t.Errorf("Max = %v, want %v", snapshot.Max, time.Hour)
+	}
+}
+
+func TestHistogramSlots(t *testing.T) {
+	for value := uint64(0); value < 1<<16; value++ {
+		slot := histogramSlot(value)
+		if max := histogramSlotMax(slot); value > max {
+			t.Fatalf("value %v is above the maximum %v of its slot %v", value, max, slot)
+		}
+		if slot > 0 && value <= histogramSlotMax(slot-1) {
+			t.Fatalf("value %v is not above the maximum of the slot before its slot %v", value, slot)
+		}
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	var merged Histogram
+	histograms := make([]Histogram, 4)
+	var wg sync.WaitGroup
+	for i := range histograms {
+		wg.Add(1)
+		go func(h *Histogram, offset int) {
+			defer wg.Done()
+			for j := 1; j <= 25; j++ {
+				h.Record(time.Duration(offset + j))
+			}
+		}(&histograms[i], i*25)
+	}
+	wg.Wait()
+	for i := range histograms {
+		merged.Merge(&histograms[i])
+	}
+	snapshot := merged.Snapshot()
+	want := recordAll(sequence(100)...)
+	if snapshot.Count != 100 || snapshot.Max != 100 || snapshot.Mean != want.Mean || snapshot.P50 != want.P50 ||
+		snapshot.P99 != want.P99 {
+		t.Errorf("merged snapshot = %+v, want %+v", snapshot, want)
+	}
+}
@@ -0,0 +1,100 @@
+package httpcache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"gocache"
+)
+
+// maxBatchOps limits the number of operations in one POST /batch request
+const maxBatchOps = 10000
+
+// BatchOp is one operation of a POST /batch request. Op is one of "get", "put", "update" and "delete",
+// Value is only used by "put" and "update", Cost optionally names a preset cost function for "put" and Tags are
+// the tags of the entry added by "put".
+type BatchOp struct {
+	Op    string   `json:"op"`
+	Key   string   `json:"key"`
+	Value []byte   `json:"value,omitempty"`
+	Cost  string   `json:"cost,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+// BatchRequest is the body of POST /batch
+type BatchRequest struct {
+	Ops []BatchOp `json:"ops"`
+}
+
+// BatchResult is the outcome of one operation, results are returned in the order of the operations.
+// Found and Version are only set by "get", an operation succeeded when Error is empty.
+type BatchResult struct {
+	Key     string `json:"key"`
+	Found   bool   `json:"found,omitempty"`
+	Value   []byte `json:"value,omitempty"`
+	Reads   int    `json:"reads,omitempty"`
+	Updates int    `json:"updates,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BatchResponse is the body of the response to POST /batch
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+func (h *Handler) serveBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	var request BatchRequest
+	if err := json.NewDecoder(limitBody(w, r)).Decode(&request); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if len(request.Ops) > maxBatchOps {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("batch has more than %d operations", maxBatchOps))
+		return
+	}
+
+	response := BatchResponse{Results: make([]BatchResult, len(request.Ops))}
+	for i, op := range request.Ops {
+		response.Results[i] = h.runBatchOp(op)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// runBatchOp runs a single operation, the error of an operation is reported in its result and does not stop the batch
+func (h *Handler) runBatchOp(op BatchOp) BatchResult {
+	result := BatchResult{Key: op.Key}
+	var err error
+	switch op.Op {
+	case "get":
+		var data gocache.Data
+		if data, err = h.cache.Get([]byte(op.Key)); err == nil {
+			result.Value = data.GetValue()
+			result.Reads = data.GetReads()
+			result.Updates = data.GetUpdates()
+			result.Version = data.GetVersion()
+			result.Found = true
+		}
+	case "put":
+		var costFun *func(data gocache.Data) int
+		if costFun, err = h.costFunctionFor(op.Cost); err == nil {
+			err = h.cache.AddWithTags([]byte(op.Key), op.Value, costFun, op.Tags...)
+		}
+	case "update":
+		err = h.cache.Update([]byte(op.Key), op.Value)
+	case "delete":
+		err = h.cache.Evict([]byte(op.Key))
+	default:
+		err = errors.New("unknown operation " + op.Op)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
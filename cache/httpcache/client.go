@@ -0,0 +1,183 @@
+package httpcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gocache"
+)
+
+// Client is a gocache.Store backed by a cache served by Handler on another process
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+var _ gocache.Store = (*Client)(nil)
+
+// NewClient returns a client of the handler served at baseURL, for example "http://127.0.0.1:8080".
+// httpClient may be nil to use http.DefaultClient.
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{baseURL: strings.TrimRight(baseURL, "/"), httpClient: httpClient}
+}
+
+// BaseURL returns the URL the client sends its requests to
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// Add adds (k, v) to the remote cache. A cost function can not be sent over the network: when costFun is one of the
+// gocache presets the remote cache uses the same preset, otherwise it uses the default cost function of its handler.
+func (c *Client) Add(k, v []byte, costFun *func(data gocache.Data) int) error {
+	return c.AddWithTags(k, v, costFun)
+}
+
+// AddWithTags adds (k, v) to the remote cache with tags, the cost function is sent like in Add
+func (c *Client) AddWithTags(k, v []byte, costFun *func(data gocache.Data) int, tags ...string) error {
+	query := url.Values{}
+	if name, ok := gocache.CostFunctionName(costFun); ok {
+		query.Set("cost", name)
+	}
+	for _, tag := range tags {
+		query.Add("tag", tag)
+	}
+	response, err := c.do(http.MethodPut, c.keyURL(k, query), v)
+	if err != nil {
+		return err
+	}
+	return drain(response, gocache.ErrKeyNotExist)
+}
+
+// InvalidateTag evicts the entries of the remote cache tagged with tag and returns how many were evicted
+func (c *Client) InvalidateTag(tag string) (int, error) {
+	response, err := c.do(http.MethodDelete, c.baseURL+tagsPath+"?"+url.Values{"tag": {tag}}.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return 0, drain(response, nil)
+	}
+	defer response.Body.Close()
+	var result InvalidateResult
+	err = json.NewDecoder(response.Body).Decode(&result)
+	return result.Evicted, err
+}
+
+// Get returns the entry of k with the value, reads and updates of the remote entry
+func (c *Client) Get(k []byte) (gocache.Data, error) {
+	response, err := c.do(http.MethodGet, c.keyURL(k, nil), nil)
+	if err != nil {
+		return gocache.Data{}, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return gocache.Data{}, drain(response, gocache.ErrNotFound)
+	}
+	defer response.Body.Close()
+	value, err := io.ReadAll(response.Body)
+	if err != nil {
+		return gocache.Data{}, err
+	}
+	reads, _ := strconv.Atoi(response.Header.Get(ReadsHeader))
+	updates, _ := strconv.Atoi(response.Header.Get(UpdatesHeader))
+	return gocache.NewData(k, value, reads, updates), nil
+}
+
+// Update replaces the value of k in the remote cache
+func (c *Client) Update(k, v []byte) error {
+	response, err := c.do(http.MethodPatch, c.keyURL(k, nil), v)
+	if err != nil {
+		return err
+	}
+	return drain(response, gocache.ErrKeyNotExist)
+}
+
+// Evict removes k from the remote cache
+func (c *Client) Evict(k []byte) error {
+	response, err := c.do(http.MethodDelete, c.keyURL(k, nil), nil)
+	if err != nil {
+		return err
+	}
+	return drain(response, gocache.ErrKeyNotExist)
+}
+
+// Flush clears the remote cache
+func (c *Client) Flush() error {
+	response, err := c.do(http.MethodPost, c.baseURL+"/flush", nil)
+	if err != nil {
+		return err
+	}
+	return drain(response, nil)
+}
+
+// Stats returns the totals of the remote cache, without the breakdown per bucket
+func (c *Client) Stats() (gocache.Stats, error) {
+	var stats gocache.Stats
+	response, err := c.do(http.MethodGet, c.baseURL+"/stats", nil)
+	if err != nil {
+		return stats, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return stats, drain(response, nil)
+	}
+	defer response.Body.Close()
+	err = json.NewDecoder(response.Body).Decode(&stats)
+	return stats, err
+}
+
+// keyURL returns the URL of k with the parameters of query, which may be nil. The key is a query parameter rather
+// than a path element, which a mux in front of the handler could clean.
+func (c *Client) keyURL(k []byte, query url.Values) string {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("key", string(k))
+	return c.baseURL + keysPath + "?" + query.Encode()
+}
+
+func (c *Client) do(method, url string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/octet-stream")
+	}
+	return c.httpClient.Do(request)
+}
+
+// drain reads and closes the body of response and returns the error it carries, notFound for 404
+func drain(response *http.Response, notFound error) error {
+	defer response.Body.Close()
+	if response.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, response.Body)
+		return nil
+	}
+	if response.StatusCode == http.StatusNotFound && notFound != nil {
+		_, _ = io.Copy(io.Discard, response.Body)
+		return notFound
+	}
+	var body errorBody
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil || body.Error == "" {
+		return fmt.Errorf("gocache server responded %s", response.Status)
+	}
+	if response.StatusCode == http.StatusServiceUnavailable && body.Error == gocache.ErrNotInitialized.Error() {
+		return gocache.ErrNotInitialized
+	}
+	if response.StatusCode == http.StatusConflict && body.Error == gocache.ErrTxnConflict.Error() {
+		return gocache.ErrTxnConflict
+	}
+	return fmt.Errorf("gocache server responded %s: %s", response.Status, body.Error)
+}
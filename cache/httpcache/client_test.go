@@ -0,0 +1,94 @@
+package httpcache
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"gocache"
+)
+
+func TestClientRoundTrip(t *testing.T) {
+	c, server := newTestServer(t)
+	client := NewClient(server.URL+"/", nil)
+	if client.BaseURL() != server.URL {
+		t.Errorf("BaseURL = %q, want %q", client.BaseURL(), server.URL)
+	}
+
+	keys := [][]byte{[]byte("k"), []byte("a//b"), []byte("../x"), []byte(""), []byte("\x00\x01\xfe\xff"), []byte("?&=#%/")}
+	for i, k := range keys {
+		value := []byte{0, byte(i), 0xff}
+		if err := client.Add(k, value, &gocache.FrequencyCost); err != nil {
+			t.Fatalf("Add(%q): %v", k, err)
+		}
+		data, err := client.Get(k)
+		if err != nil || !bytes.Equal(data.GetValue(), value) || !bytes.Equal(data.GetKey(), k) {
+			t.Errorf("Get(%q) = %q, %q, %v, want %q", k, data.GetKey(), data.GetValue(), err, value)
+		}
+		if data.GetReads() != 1 {
+			t.Errorf("Get(%q) reads = %v, want 1", k, data.GetReads())
+		}
+	}
+	if entries := c.GetEntriesCount(); entries != uint64(len(keys)) {
+		t.Errorf("remote cache has %v entries, want %v", entries, len(keys))
+	}
+
+	k := keys[4]
+	if err := client.Update(k, []byte("updated")); err != nil {
+		t.Errorf("Update(%q): %v", k, err)
+	}
+	if data, _ := client.Get(k); string(data.GetValue()) != "updated" || data.GetUpdates() != 1 {
+		t.Errorf("Get after Update = %q with %v updates, want %q with 1", data.GetValue(), data.GetUpdates(), "updated")
+	}
+	if err := client.Evict(k); err != nil {
+		t.Errorf("Evict(%q): %v", k, err)
+	}
+	if _, err := client.Get(k); err != gocache.ErrNotFound {
+		t.Errorf("Get after Evict = %v, want %v", err, gocache.ErrNotFound)
+	}
+	if err := client.Update(k, []byte("v")); err != gocache.ErrKeyNotExist {
+		t.Errorf("Update of an evicted key = %v, want %v", err, gocache.ErrKeyNotExist)
+	}
+	if err := client.Evict(k); err != gocache.ErrKeyNotExist {
+		t.Errorf("Evict of an evicted key = %v, want %v", err, gocache.ErrKeyNotExist)
+	}
+
+	stats, err := client.Stats()
+	if err != nil || stats.Entries != uint64(len(keys)-1) {
+		t.Errorf("Stats = %v entries, %v, want %v", stats.Entries, err, len(keys)-1)
+	}
+	if err := client.Flush(); err != nil || c.GetEntriesCount() != 0 {
+		t.Errorf("Flush = %v, %v entries left", err, c.GetEntriesCount())
+	}
+}
+
+func TestClientTags(t *testing.T) {
+	_, server := newTestServer(t)
+	client := NewClient(server.URL, nil)
+	client.AddWithTags([]byte("k1"), []byte("v"), &gocache.SizeCost, "a//tag", "other")
+	client.AddWithTags([]byte("k2"), []byte("v"), nil, "a//tag")
+	client.AddWithTags([]byte("k3"), []byte("v"), nil, "a/tag")
+	if evicted, err := client.InvalidateTag("a//tag"); evicted != 2 || err != nil {
+		t.Errorf("InvalidateTag = %v, %v, want 2", evicted, err)
+	}
+	if _, err := client.Get([]byte("k3")); err != nil {
+		t.Errorf("Get of a key with another tag = %v", err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	server := httptest.NewServer(NewHandler(&gocache.Cache{}, &gocache.SizeCost))
+	defer server.Close()
+	client := NewClient(server.URL, nil)
+	if _, err := client.Get([]byte("k")); err != gocache.ErrNotInitialized {
+		t.Errorf("Get from an uninitialized cache = %v, want %v", err, gocache.ErrNotInitialized)
+	}
+	if err := client.Add([]byte("k"), []byte("v"), nil); err != gocache.ErrNotInitialized {
+		t.Errorf("Add to an uninitialized cache = %v, want %v", err, gocache.ErrNotInitialized)
+	}
+
+	server.Close()
+	if err := client.Add([]byte("k"), []byte("v"), nil); err == nil {
+		t.Errorf("Add to a closed server succeeded")
+	}
+}
@@ -0,0 +1,322 @@
+// Package httpcache exposes a gocache.Cache over HTTP.
+//
+// Routes, relative to where the handler is mounted:
+//
+//	GET    /keys/{key}   value of the key as the response body
+//	PUT    /keys/{key}   adds the request body as the value of the key (Cache.Add), with the tags given as
+//	                     repeated "tag" query parameters (Cache.AddWithTags)
+//	PATCH  /keys/{key}   replaces the value of an existing key (Cache.Update)
+//	DELETE /keys/{key}   evicts the key (Cache.Evict)
+//	DELETE /tags?tag=    evicts the keys tagged with tag (Cache.InvalidateTag), the count is returned as JSON
+//	POST   /batch        runs a list of get/put/update/delete operations given as JSON
+//	POST   /txn          runs a list of operations like /batch in a transaction (Cache.Transaction), after
+//	                     checking the versions of watched keys
+//	GET    /stats        totals of the cache as JSON (Cache.Stats)
+//	GET    /buckets      counters of every bucket as JSON (Cache.GetBucketsStats)
+//	GET    /scan         a page of the keys as JSON, with the query parameters cursor, count and prefix (Cache.Scan)
+//	POST   /flush        clears the cache (Cache.Clear)
+//	GET    /snapshot     binary snapshot of the cache (Cache.Snapshot)
+//	PUT    /snapshot     restores a binary snapshot into the cache (Cache.Restore)
+//	POST   /publish?channel=
+//	                     publishes the request body to the channel, the number of receivers is returned as JSON
+//	GET    /subscribe    streams the messages of the channels and patterns given as repeated "channel" and
+//	                     "pattern" query parameters as JSON lines, the id of the subscription is in the
+//	                     X-Gocache-Subscription header
+//	POST   /subscriptions/{id}
+//	                     changes the channels and patterns of a streamed subscription with the query parameters
+//	                     subscribe, psubscribe, unsubscribe and punsubscribe
+//
+// The key of /keys/{key} is path escaped, "/" may be sent as is or as %2F. The handler reads it from the escaped path
+// rather than routing it through its http.ServeMux, which would clean the key "a//b" into "a/b". A ServeMux the
+// handler is mounted on still cleans the paths it routes, so every /keys route is also served as /keys?key= with the
+// key as a query parameter, which carries any key and is what Client uses. Tags and channels are query parameters
+// for the same reason. Values are sent as raw bytes, request bodies larger than 64MB are rejected with 413.
+// The cost function of PUT can be chosen with the "cost" query parameter naming one of the gocache presets,
+// otherwise the handler's default is used.
+package httpcache
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gocache"
+	"gocache/pubsub"
+)
+
+const (
+	keysPath = "/keys"
+	tagsPath = "/tags"
+
+	// ReadsHeader carries the reads counter of the entry returned by GET /keys
+	ReadsHeader = "X-Gocache-Reads"
+	// UpdatesHeader carries the updates counter of the entry returned by GET /keys
+	UpdatesHeader = "X-Gocache-Updates"
+	// VersionHeader carries the version of the entry returned by GET /keys, to watch it in POST /txn
+	VersionHeader = "X-Gocache-Version"
+)
+
+// maxValueSize limits the size of request bodies
+const maxValueSize = 64 << 20
+
+// Handler is a http.Handler serving one cache. It can be mounted on any mux, use http.StripPrefix when it is
+// not mounted at the root.
+type Handler struct {
+	cache         *gocache.Cache
+	costFunction  *func(data gocache.Data) int
+	mux           *http.ServeMux
+	broker        *pubsub.Broker
+	subscriptions subscriptions
+}
+
+// NewHandler returns a handler for c, costFun is used for added entries when the request does not choose a preset
+func NewHandler(c *gocache.Cache, costFun *func(data gocache.Data) int) *Handler {
+	h := &Handler{cache: c, costFunction: costFun, mux: http.NewServeMux(), broker: pubsub.NewBroker()}
+	h.mux.HandleFunc(keysPath, h.serveKey)
+	h.mux.HandleFunc(tagsPath, h.serveTag)
+	h.mux.HandleFunc("/batch", h.serveBatch)
+	h.mux.HandleFunc("/txn", h.serveTxn)
+	h.mux.HandleFunc("/stats", h.serveStats)
+	h.mux.HandleFunc("/buckets", h.serveBuckets)
+	h.mux.HandleFunc("/scan", h.serveScan)
+	h.mux.HandleFunc("/flush", h.serveFlush)
+	h.mux.HandleFunc("/snapshot", h.serveSnapshot)
+	h.mux.HandleFunc(publishPath, h.servePublish)
+	h.mux.HandleFunc("/subscribe", h.serveSubscribe)
+	h.mux.HandleFunc(subscriptionsPath, h.serveSubscription)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// keys in the path are not routed by the mux, which would clean them
+	if strings.HasPrefix(r.URL.EscapedPath(), keysPath+"/") {
+		h.serveKey(w, r)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) serveKey(w http.ResponseWriter, r *http.Request) {
+	key, err := keyParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		data, err := h.cache.Get([]byte(key))
+		if err != nil {
+			writeCacheError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set(ReadsHeader, strconv.Itoa(data.GetReads()))
+		w.Header().Set(UpdatesHeader, strconv.Itoa(data.GetUpdates()))
+		w.Header().Set(VersionHeader, strconv.FormatUint(data.GetVersion(), 10))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data.GetValue())))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data.GetValue())
+		}
+	case http.MethodPut:
+		costFun, err := h.costFunctionFor(r.URL.Query().Get("cost"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		value, err := readBody(w, r)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if err := h.cache.AddWithTags([]byte(key), value, costFun, r.URL.Query()["tag"]...); err != nil {
+			writeCacheError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPatch:
+		value, err := readBody(w, r)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if err := h.cache.Update([]byte(key), value); err != nil {
+			writeCacheError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := h.cache.Evict([]byte(key)); err != nil {
+			writeCacheError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, "GET, HEAD, PUT, PATCH, DELETE")
+	}
+}
+
+func (h *Handler) serveStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	stats := h.cache.Stats()
+	// the breakdown per bucket is served by GET /buckets
+	stats.Buckets = nil
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (h *Handler) serveBuckets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.cache.GetBucketsStats())
+}
+
+func (h *Handler) serveFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	h.cache.Clear()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		// the status is already sent when writing fails, so the error can only end the response
+		_ = h.cache.Snapshot(w)
+	case http.MethodPut, http.MethodPost:
+		if err := h.cache.Restore(r.Body, h.costFunction); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, "GET, PUT, POST")
+	}
+}
+
+// costFunctionFor returns the preset named by name, or the default cost function of the handler when name is empty
+func (h *Handler) costFunctionFor(name string) (*func(data gocache.Data) int, error) {
+	if name == "" {
+		if h.costFunction == nil {
+			return nil, errors.New("no cost function given and the handler has no default")
+		}
+		return h.costFunction, nil
+	}
+	return gocache.CostFunction(name)
+}
+
+// InvalidateResult is the body of the response of DELETE /tags
+type InvalidateResult struct {
+	Evicted int `json:"evicted"`
+}
+
+func (h *Handler) serveTag(w http.ResponseWriter, r *http.Request) {
+	tag, err := queryParam(r, "tag")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, "DELETE")
+		return
+	}
+	writeJSON(w, http.StatusOK, InvalidateResult{Evicted: h.cache.InvalidateTag(tag)})
+}
+
+// queryParam returns the query parameter name of r, which may be empty but must be given
+func queryParam(r *http.Request, name string) (string, error) {
+	query, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		return "", err
+	}
+	values, found := query[name]
+	if !found {
+		return "", errors.New("missing " + name)
+	}
+	return values[0], nil
+}
+
+// keyParam returns the key of a /keys request, from the path of /keys/{key} or from the query of /keys?key=
+func keyParam(r *http.Request) (string, error) {
+	if strings.HasPrefix(r.URL.EscapedPath(), keysPath+"/") {
+		return unescapePath(r, keysPath+"/", "key")
+	}
+	return queryParam(r, "key")
+}
+
+// unescapePath returns the path escaped element following prefix in the path of r, what names it in errors. Paths
+// routed by http.ServeMux are cleaned, strings other than generated ids are passed with queryParam or, for keys,
+// read before the mux.
+func unescapePath(r *http.Request, prefix, what string) (string, error) {
+	escaped := strings.TrimPrefix(r.URL.EscapedPath(), prefix)
+	if escaped == "" {
+		return "", errors.New("missing " + what)
+	}
+	return url.PathUnescape(escaped)
+}
+
+// limitBody limits the body of r to maxValueSize bytes, reading more fails with a *http.MaxBytesError
+func limitBody(w http.ResponseWriter, r *http.Request) io.Reader {
+	return http.MaxBytesReader(w, r.Body, maxValueSize)
+}
+
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	return io.ReadAll(limitBody(w, r))
+}
+
+// writeBodyError writes the error of reading or decoding a request body, 413 when the body is too large
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	writeError(w, http.StatusBadRequest, err)
+}
+
+// statusFor maps the errors of the cache to HTTP status codes
+func statusFor(err error) int {
+	switch err {
+	case gocache.ErrNotFound, gocache.ErrKeyNotExist:
+		return http.StatusNotFound
+	case gocache.ErrNotInitialized:
+		return http.StatusServiceUnavailable
+	case gocache.ErrTxnConflict:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+func writeCacheError(w http.ResponseWriter, err error) {
+	writeError(w, statusFor(err), err)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorBody{err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+}
@@ -0,0 +1,252 @@
+package httpcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"gocache"
+)
+
+func newTestServer(t *testing.T) (*gocache.Cache, *httptest.Server) {
+	t.Helper()
+	c := &gocache.Cache{}
+	c.Init(1000, 4)
+	server := httptest.NewServer(NewHandler(c, &gocache.SizeCost))
+	t.Cleanup(server.Close)
+	return c, server
+}
+
+// request sends a request to server and returns the status and the body of the response
+func request(t *testing.T, method, target string, body []byte) (int, []byte) {
+	t.Helper()
+	r, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	response, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, target, err)
+	}
+	defer response.Body.Close()
+	b, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("reading the response of %s %s: %v", method, target, err)
+	}
+	return response.StatusCode, b
+}
+
+func keyTarget(server *httptest.Server, key string) string {
+	return server.URL + keysPath + "?" + url.Values{"key": {key}}.Encode()
+}
+
+func TestHandlerKeys(t *testing.T) {
+	c, server := newTestServer(t)
+	// keys which http.ServeMux would clean or redirect if they were path elements
+	keys := []string{"a/b", "a//b", "a/../b", "./x", "/", "", "with space?&#%", "\x00\xff\n"}
+	for i, key := range keys {
+		if status, body := request(t, http.MethodPut, keyTarget(server, key), []byte{byte(i)}); status != http.StatusNoContent {
+			t.Fatalf("PUT %q = %v %s, want %v", key, status, body, http.StatusNoContent)
+		}
+	}
+	for i, key := range keys {
+		status, body := request(t, http.MethodGet, keyTarget(server, key), nil)
+		if status != http.StatusOK || !bytes.Equal(body, []byte{byte(i)}) {
+			t.Errorf("GET %q = %v %q, want %v %q", key, status, body, http.StatusOK, []byte{byte(i)})
+		}
+		if data, err := c.Get([]byte(key)); err != nil || !bytes.Equal(data.GetValue(), []byte{byte(i)}) {
+			t.Errorf("cache Get(%q) = %q, %v", key, data.GetValue(), err)
+		}
+	}
+
+	if status, _ := request(t, http.MethodPatch, keyTarget(server, "a//b"), []byte("updated")); status != http.StatusNoContent {
+		t.Errorf("PATCH = %v, want %v", status, http.StatusNoContent)
+	}
+	if data, _ := c.Get([]byte("a//b")); string(data.GetValue()) != "updated" {
+		t.Errorf("value after PATCH = %q, want %q", data.GetValue(), "updated")
+	}
+	if status, _ := request(t, http.MethodDelete, keyTarget(server, "a/../b"), nil); status != http.StatusNoContent {
+		t.Errorf("DELETE = %v, want %v", status, http.StatusNoContent)
+	}
+	if status, _ := request(t, http.MethodGet, keyTarget(server, "a/../b"), nil); status != http.StatusNotFound {
+		t.Errorf("GET after DELETE = %v, want %v", status, http.StatusNotFound)
+	}
+	if status, _ := request(t, http.MethodPatch, keyTarget(server, "missing"), []byte("v")); status != http.StatusNotFound {
+		t.Errorf("PATCH of a missing key = %v, want %v", status, http.StatusNotFound)
+	}
+	if status, _ := request(t, http.MethodGet, server.URL+keysPath, nil); status != http.StatusBadRequest {
+		t.Errorf("GET without a key = %v, want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestHandlerKeysInPath(t *testing.T) {
+	c, server := newTestServer(t)
+	// the handler reads the escaped path, the keys are not cleaned
+	keys := map[string]string{"a/b": "a/b", "a%2F%2Fb": "a//b", "with%20space%3F": "with space?", "%00%FF": "\x00\xff"}
+	for escaped, key := range keys {
+		if status, body := request(t, http.MethodPut, server.URL+keysPath+"/"+escaped, []byte(key)); status != http.StatusNoContent {
+			t.Fatalf("PUT %q = %v %s, want %v", escaped, status, body, http.StatusNoContent)
+		}
+		if data, err := c.Get([]byte(key)); err != nil || string(data.GetValue()) != key {
+			t.Errorf("cache Get(%q) = %q, %v", key, data.GetValue(), err)
+		}
+		status, body := request(t, http.MethodGet, server.URL+keysPath+"/"+escaped, nil)
+		if status != http.StatusOK || string(body) != key {
+			t.Errorf("GET %q = %v %q, want %v %q", escaped, status, body, http.StatusOK, key)
+		}
+		// both forms name the same entry
+		if status, body := request(t, http.MethodGet, keyTarget(server, key), nil); status != http.StatusOK || string(body) != key {
+			t.Errorf("GET ?key=%q = %v %q, want %v %q", key, status, body, http.StatusOK, key)
+		}
+	}
+
+	if status, _ := request(t, http.MethodPatch, server.URL+keysPath+"/a%2F%2Fb", []byte("updated")); status != http.StatusNoContent {
+		t.Errorf("PATCH = %v, want %v", status, http.StatusNoContent)
+	}
+	if data, _ := c.Get([]byte("a//b")); string(data.GetValue()) != "updated" {
+		t.Errorf("value after PATCH = %q, want %q", data.GetValue(), "updated")
+	}
+	if status, _ := request(t, http.MethodDelete, server.URL+keysPath+"/a/b", nil); status != http.StatusNoContent {
+		t.Errorf("DELETE = %v, want %v", status, http.StatusNoContent)
+	}
+	if _, err := c.Get([]byte("a/b")); err != gocache.ErrNotFound {
+		t.Errorf("Get after DELETE = %v, want %v", err, gocache.ErrNotFound)
+	}
+	if status, _ := request(t, http.MethodGet, server.URL+keysPath+"/", nil); status != http.StatusBadRequest {
+		t.Errorf("GET without a key = %v, want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestHandlerMountedOnServeMux(t *testing.T) {
+	c := &gocache.Cache{}
+	c.Init(1000, 4)
+	mux := http.NewServeMux()
+	mux.Handle("/cache/", http.StripPrefix("/cache", NewHandler(c, &gocache.SizeCost)))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	target := server.URL + "/cache" + keysPath + "?" + url.Values{"key": {"a//b"}}.Encode()
+	if status, body := request(t, http.MethodPut, target, []byte("value")); status != http.StatusNoContent {
+		t.Fatalf("PUT = %v %s, want %v", status, body, http.StatusNoContent)
+	}
+	if _, err := c.Get([]byte("a//b")); err != nil {
+		t.Errorf("Get(a//b) = %v", err)
+	}
+	if _, err := c.Get([]byte("a/b")); err != gocache.ErrNotFound {
+		t.Errorf("Get(a/b) = %v, want %v", err, gocache.ErrNotFound)
+	}
+	// the mux in front cleans the paths it routes, keys in the path work when they are left as they are
+	if status, body := request(t, http.MethodPut, server.URL+"/cache"+keysPath+"/a/b%20c", []byte("value")); status != http.StatusNoContent {
+		t.Fatalf("PUT of the key in the path = %v %s, want %v", status, body, http.StatusNoContent)
+	}
+	if _, err := c.Get([]byte("a/b c")); err != nil {
+		t.Errorf("Get(a/b c) = %v", err)
+	}
+}
+
+func TestHandlerBodyTooLarge(t *testing.T) {
+	c, server := newTestServer(t)
+	status, _ := request(t, http.MethodPut, keyTarget(server, "k"), make([]byte, maxValueSize+1))
+	if status != http.StatusRequestEntityTooLarge {
+		t.Errorf("PUT of %v bytes = %v, want %v", maxValueSize+1, status, http.StatusRequestEntityTooLarge)
+	}
+	if _, err := c.Get([]byte("k")); err != gocache.ErrNotFound {
+		t.Errorf("Get after a rejected PUT = %v, want %v", err, gocache.ErrNotFound)
+	}
+}
+
+func TestHandlerTags(t *testing.T) {
+	c, server := newTestServer(t)
+	for _, key := range []string{"k1", "k2"} {
+		target := server.URL + keysPath + "?" + url.Values{"key": {key}, "tag": {"a//tag"}, "cost": {"constant"}}.Encode()
+		if status, body := request(t, http.MethodPut, target, []byte("v")); status != http.StatusNoContent {
+			t.Fatalf("PUT %q = %v %s", key, status, body)
+		}
+	}
+	c.Add([]byte("other"), []byte("v"), &gocache.SizeCost)
+
+	status, body := request(t, http.MethodDelete, server.URL+tagsPath+"?tag="+url.QueryEscape("a//tag"), nil)
+	var result InvalidateResult
+	if err := json.Unmarshal(body, &result); status != http.StatusOK || err != nil || result.Evicted != 2 {
+		t.Errorf("DELETE /tags = %v %s, want 2 evicted", status, body)
+	}
+	if entries := c.GetEntriesCount(); entries != 1 {
+		t.Errorf("entries after invalidating the tag = %v, want 1", entries)
+	}
+}
+
+func TestHandlerBatch(t *testing.T) {
+	c, server := newTestServer(t)
+	c.Add([]byte("existing"), []byte("v"), &gocache.SizeCost)
+	body, _ := json.Marshal(BatchRequest{Ops: []BatchOp{
+		{Op: "put", Key: "k", Value: []byte("value")},
+		{Op: "get", Key: "k"},
+		{Op: "update", Key: "missing", Value: []byte("v")},
+		{Op: "delete", Key: "existing"},
+		{Op: "unknown", Key: "k"},
+	}})
+	status, body := request(t, http.MethodPost, server.URL+"/batch", body)
+	var response BatchResponse
+	if err := json.Unmarshal(body, &response); status != http.StatusOK || err != nil || len(response.Results) != 5 {
+		t.Fatalf("POST /batch = %v %s", status, body)
+	}
+	results := response.Results
+	if results[0].Error != "" || !results[1].Found || string(results[1].Value) != "value" {
+		t.Errorf("put and get results = %+v, %+v", results[0], results[1])
+	}
+	if results[2].Error != gocache.ErrKeyNotExist.Error() || results[3].Error != "" || results[4].Error == "" {
+		t.Errorf("update, delete and unknown results = %+v, %+v, %+v", results[2], results[3], results[4])
+	}
+
+	if status, _ := request(t, http.MethodPost, server.URL+"/batch", []byte("{")); status != http.StatusBadRequest {
+		t.Errorf("POST /batch with invalid JSON = %v, want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestHandlerSnapshot(t *testing.T) {
+	c, server := newTestServer(t)
+	c.Add([]byte("k"), []byte("v"), &gocache.SizeCost)
+	status, snapshot := request(t, http.MethodGet, server.URL+"/snapshot", nil)
+	if status != http.StatusOK {
+		t.Fatalf("GET /snapshot = %v", status)
+	}
+
+	restored, restoredServer := newTestServer(t)
+	if status, body := request(t, http.MethodPut, restoredServer.URL+"/snapshot", snapshot); status != http.StatusNoContent {
+		t.Fatalf("PUT /snapshot = %v %s", status, body)
+	}
+	if data, err := restored.Get([]byte("k")); err != nil || string(data.GetValue()) != "v" {
+		t.Errorf("Get after PUT /snapshot = %q, %v", data.GetValue(), err)
+	}
+
+	// an entry announcing a key of 2^62 bytes must fail without allocating it
+	corrupted := append([]byte("GOCACHE\x02\x01"), 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x40)
+	status, body := request(t, http.MethodPut, restoredServer.URL+"/snapshot", corrupted)
+	if status != http.StatusBadRequest || !strings.Contains(string(body), "corrupted snapshot") {
+		t.Errorf("PUT /snapshot of a corrupted snapshot = %v %s, want %v", status, body, http.StatusBadRequest)
+	}
+}
+
+func TestHandlerStatsAndFlush(t *testing.T) {
+	c, server := newTestServer(t)
+	c.Add([]byte("k"), []byte("v"), &gocache.SizeCost)
+	status, body := request(t, http.MethodGet, server.URL+"/stats", nil)
+	var stats gocache.Stats
+	if err := json.Unmarshal(body, &stats); status != http.StatusOK || err != nil || stats.Entries != 1 || stats.Buckets != nil {
+		t.Errorf("GET /stats = %v %s, want 1 entry without buckets", status, body)
+	}
+	if status, _ := request(t, http.MethodPost, server.URL+"/flush", nil); status != http.StatusNoContent {
+		t.Errorf("POST /flush = %v, want %v", status, http.StatusNoContent)
+	}
+	if entries := c.GetEntriesCount(); entries != 0 {
+		t.Errorf("entries after POST /flush = %v, want 0", entries)
+	}
+	if status, _ := request(t, http.MethodGet, server.URL+"/flush", nil); status != http.StatusMethodNotAllowed {
+		t.Errorf("GET /flush = %v, want %v", status, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,300 @@
+package httpcache
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"gocache/pubsub"
+)
+
+const (
+	publishPath       = "/publish"
+	subscriptionsPath = "/subscriptions/"
+
+	// SubscriptionHeader carries the id of the subscription streamed by GET /subscribe
+	SubscriptionHeader = "X-Gocache-Subscription"
+)
+
+// PublishResult is the body of the response of POST /publish
+type PublishResult struct {
+	Receivers int `json:"receivers"`
+}
+
+// subscriptions are the subscriptions streamed by the handler, keyed by id
+type subscriptions struct {
+	mutex sync.Mutex
+	byID  map[string]*pubsub.Subscription
+}
+
+// Broker returns the broker of the handler, to publish from the process serving it or to publish the keyspace
+// events of the cache with PublishKeyspaceEvents
+func (h *Handler) Broker() *pubsub.Broker {
+	return h.broker
+}
+
+func (h *Handler) servePublish(w http.ResponseWriter, r *http.Request) {
+	channel, err := queryParam(r, "channel")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	data, err := readBody(w, r)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	receivers, err := h.broker.Publish(channel, data)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, PublishResult{Receivers: receivers})
+}
+
+// serveSubscribe serves GET /subscribe?channel=&pattern=, it streams the messages of the subscription as JSON
+// lines until the client goes away or the subscription has no channel and no pattern left
+func (h *Handler) serveSubscribe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	query := r.URL.Query()
+	if len(query["channel"]) == 0 && len(query["pattern"]) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("missing channel or pattern"))
+		return
+	}
+	size := pubsub.DefaultBuffer
+	if value := query.Get("buffer"); value != "" {
+		var err error
+		if size, err = strconv.Atoi(value); err != nil || size < 1 {
+			writeError(w, http.StatusBadRequest, errors.New("invalid buffer"))
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	s := h.broker.Subscribe(size)
+	id := newSubscriptionID()
+	h.subscriptions.mutex.Lock()
+	if h.subscriptions.byID == nil {
+		h.subscriptions.byID = map[string]*pubsub.Subscription{}
+	}
+	h.subscriptions.byID[id] = s
+	h.subscriptions.mutex.Unlock()
+	defer func() {
+		h.subscriptions.mutex.Lock()
+		delete(h.subscriptions.byID, id)
+		h.subscriptions.mutex.Unlock()
+		s.Close()
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set(SubscriptionHeader, id)
+	w.WriteHeader(http.StatusOK)
+	s.Subscribe(query["channel"]...)
+	s.PSubscribe(query["pattern"]...)
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case message := <-s.Messages:
+			if err := encoder.Encode(message); err != nil {
+				return
+			}
+			flusher.Flush()
+			unsubscribed := message.Kind == pubsub.KindUnsubscribe || message.Kind == pubsub.KindPUnsubscribe
+			if unsubscribed && message.Count == 0 && s.Count() == 0 {
+				return
+			}
+		}
+	}
+}
+
+// serveSubscription serves POST /subscriptions/{id}, which changes the channels and patterns of a subscription
+// streamed by GET /subscribe with the query parameters subscribe, psubscribe, unsubscribe and punsubscribe. An
+// empty unsubscribe or punsubscribe removes all the channels or all the patterns.
+func (h *Handler) serveSubscription(w http.ResponseWriter, r *http.Request) {
+	id, err := unescapePath(r, subscriptionsPath, "subscription")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	h.subscriptions.mutex.Lock()
+	s := h.subscriptions.byID[id]
+	h.subscriptions.mutex.Unlock()
+	if s == nil {
+		writeError(w, http.StatusNotFound, errors.New("subscription not found"))
+		return
+	}
+	query := r.URL.Query()
+	if channels := query["subscribe"]; len(channels) > 0 {
+		s.Subscribe(channels...)
+	}
+	if patterns := query["psubscribe"]; len(patterns) > 0 {
+		s.PSubscribe(patterns...)
+	}
+	if channels, found := query["unsubscribe"]; found {
+		s.Unsubscribe(nonEmpty(channels)...)
+	}
+	if patterns, found := query["punsubscribe"]; found {
+		s.PUnsubscribe(nonEmpty(patterns)...)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// nonEmpty returns values without the empty strings
+func nonEmpty(values []string) []string {
+	result := values[:0:0]
+	for _, value := range values {
+		if value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+func newSubscriptionID() string {
+	var id [8]byte
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// Publish sends data to the subscribers of channel on the remote handler and returns how many received it
+func (c *Client) Publish(channel string, data []byte) (int, error) {
+	response, err := c.do(http.MethodPost, c.baseURL+publishPath+"?"+url.Values{"channel": {channel}}.Encode(), data)
+	if err != nil {
+		return 0, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return 0, drain(response, nil)
+	}
+	defer response.Body.Close()
+	var result PublishResult
+	err = json.NewDecoder(response.Body).Decode(&result)
+	return result.Receivers, err
+}
+
+// Subscription is a subscription streamed from a remote handler. The messages and confirmations are received on
+// Messages, which is closed when the stream ends: after Close, when no channel and no pattern is left, or when
+// the connection fails, which Err then returns.
+type Subscription struct {
+	Messages <-chan pubsub.Message
+	client   *Client
+	id       string
+	response *http.Response
+	err      error
+	done     chan struct{}
+	closed   int32
+}
+
+// Subscribe opens a subscription to channels and patterns on the remote handler, at least one must be given. The
+// http.Client of the client must not have a timeout shorter than the life of the subscription.
+func (c *Client) Subscribe(channels, patterns []string) (*Subscription, error) {
+	query := url.Values{"channel": channels, "pattern": patterns}
+	response, err := c.do(http.MethodGet, c.baseURL+"/subscribe?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, drain(response, nil)
+	}
+	messages := make(chan pubsub.Message)
+	s := &Subscription{Messages: messages, client: c, id: response.Header.Get(SubscriptionHeader), response: response, done: make(chan struct{})}
+	go s.read(messages)
+	return s, nil
+}
+
+// read decodes the stream into messages until it ends
+func (s *Subscription) read(messages chan<- pubsub.Message) {
+	defer close(messages)
+	defer close(s.done)
+	decoder := json.NewDecoder(bufio.NewReader(s.response.Body))
+	for {
+		var message pubsub.Message
+		if err := decoder.Decode(&message); err != nil {
+			s.response.Body.Close()
+			if err != io.EOF && atomic.LoadInt32(&s.closed) == 0 {
+				s.err = err
+			}
+			return
+		}
+		messages <- message
+	}
+}
+
+// Subscribe adds channels to the subscription
+func (s *Subscription) Subscribe(channels ...string) error {
+	return s.change("subscribe", channels)
+}
+
+// PSubscribe adds patterns to the subscription
+func (s *Subscription) PSubscribe(patterns ...string) error {
+	return s.change("psubscribe", patterns)
+}
+
+// Unsubscribe removes channels from the subscription, or all its channels when none is given
+func (s *Subscription) Unsubscribe(channels ...string) error {
+	return s.change("unsubscribe", emptyIfNone(channels))
+}
+
+// PUnsubscribe removes patterns from the subscription, or all its patterns when none is given
+func (s *Subscription) PUnsubscribe(patterns ...string) error {
+	return s.change("punsubscribe", emptyIfNone(patterns))
+}
+
+func (s *Subscription) change(parameter string, names []string) error {
+	query := url.Values{parameter: names}
+	response, err := s.client.do(http.MethodPost, s.client.baseURL+subscriptionsPath+url.PathEscape(s.id)+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	return drain(response, nil)
+}
+
+// emptyIfNone returns names, or a single empty name meaning all when there is none
+func emptyIfNone(names []string) []string {
+	if len(names) == 0 {
+		return []string{""}
+	}
+	return names
+}
+
+// Err waits for the stream to end and returns the error which ended it, nil when it ended normally or by Close
+func (s *Subscription) Err() error {
+	<-s.done
+	return s.err
+}
+
+// Close ends the stream, the messages not yet received are dropped
+func (s *Subscription) Close() error {
+	atomic.StoreInt32(&s.closed, 1)
+	err := s.response.Body.Close()
+	go func() {
+		// unblock read when it is waiting for the messages to be received
+		for range s.Messages {
+		}
+	}()
+	return err
+}
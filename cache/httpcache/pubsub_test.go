@@ -0,0 +1,161 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gocache"
+	"gocache/pubsub"
+)
+
+func newTestPubSubServer(t *testing.T) (*gocache.Cache, *Handler, *Client) {
+	t.Helper()
+	c := &gocache.Cache{}
+	c.Init(1000, 4)
+	h := NewHandler(c, &gocache.SizeCost)
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	return c, h, NewClient(server.URL, nil)
+}
+
+// receive returns the next message of s, it fails the test when none arrives
+func receive(t *testing.T, s *Subscription) pubsub.Message {
+	t.Helper()
+	select {
+	case message, open := <-s.Messages:
+		if !open {
+			t.Fatalf("stream ended: %v", s.Err())
+		}
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no message received")
+		return pubsub.Message{}
+	}
+}
+
+func TestSubscribeStream(t *testing.T) {
+	_, h, client := newTestPubSubServer(t)
+	s, err := client.Subscribe([]string{"a//b"}, []string{"news.*"})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer s.Close()
+	if message := receive(t, s); message.Kind != pubsub.KindSubscribe || message.Channel != "a//b" || message.Count != 1 {
+		t.Errorf("channel confirmation = %+v", message)
+	}
+	if message := receive(t, s); message.Kind != pubsub.KindPSubscribe || message.Pattern != "news.*" || message.Count != 2 {
+		t.Errorf("pattern confirmation = %+v", message)
+	}
+
+	if receivers, err := client.Publish("a//b", []byte("\x00binary")); err != nil || receivers != 1 {
+		t.Errorf("Publish(a//b) = %v, %v, want 1 receiver", receivers, err)
+	}
+	if message := receive(t, s); message.Kind != pubsub.KindMessage || message.Channel != "a//b" || string(message.Data) != "\x00binary" {
+		t.Errorf("message = %+v", message)
+	}
+	if receivers, _ := h.Broker().Publish("news.tech", []byte("local")); receivers != 1 {
+		t.Errorf("Publish on the broker of the handler reached %v subscriptions, want 1", receivers)
+	}
+	if message := receive(t, s); message.Kind != pubsub.KindPMessage || message.Pattern != "news.*" || message.Channel != "news.tech" {
+		t.Errorf("pattern message = %+v", message)
+	}
+	if _, err := client.Publish(pubsub.KeyeventPrefix+"add", nil); err == nil {
+		t.Errorf("Publish to a reserved channel succeeded")
+	}
+
+	if err := s.Subscribe("other"); err != nil {
+		t.Errorf("Subscribe(other): %v", err)
+	}
+	if message := receive(t, s); message.Kind != pubsub.KindSubscribe || message.Channel != "other" || message.Count != 3 {
+		t.Errorf("confirmation of a channel added to the stream = %+v", message)
+	}
+	if err := s.Unsubscribe(); err != nil {
+		t.Errorf("Unsubscribe: %v", err)
+	}
+	receive(t, s)
+	receive(t, s)
+	if err := s.PUnsubscribe(); err != nil {
+		t.Errorf("PUnsubscribe: %v", err)
+	}
+	if message := receive(t, s); message.Kind != pubsub.KindPUnsubscribe || message.Count != 0 {
+		t.Errorf("last confirmation = %+v", message)
+	}
+	// the stream ends once no channel and no pattern is left
+	select {
+	case message, open := <-s.Messages:
+		if open {
+			t.Errorf("message after the last unsubscription = %+v", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the stream did not end")
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("Err = %v", err)
+	}
+}
+
+func TestSubscribeKeyspaceEvents(t *testing.T) {
+	c, h, client := newTestPubSubServer(t)
+	stop := h.Broker().PublishKeyspaceEvents(c, nil)
+	defer stop()
+	s, err := client.Subscribe(nil, []string{pubsub.KeyeventPrefix + "*"})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer s.Close()
+	receive(t, s)
+
+	c.Add([]byte("k"), []byte("v"), &gocache.SizeCost)
+	if message := receive(t, s); message.Channel != pubsub.KeyeventPrefix+"add" || string(message.Data) != "k" {
+		t.Errorf("keyspace event = %+v", message)
+	}
+}
+
+func TestSubscribeClose(t *testing.T) {
+	_, h, client := newTestPubSubServer(t)
+	s, err := client.Subscribe([]string{"c"}, nil)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	receive(t, s)
+	if err := s.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("Err after Close = %v", err)
+	}
+	// the handler drops the subscription once it notices the client went away
+	deadline := time.Now().Add(5 * time.Second)
+	for h.Broker().NumSub("c") != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the subscription of a closed stream is still subscribed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := s.Subscribe("other"); err == nil {
+		t.Errorf("Subscribe on a closed stream succeeded")
+	}
+}
+
+func TestSubscribeErrors(t *testing.T) {
+	_, server := newTestServer(t)
+	tests := []struct {
+		method, target string
+		want           int
+	}{
+		{http.MethodGet, "/subscribe", http.StatusBadRequest},
+		{http.MethodGet, "/subscribe?channel=c&buffer=0", http.StatusBadRequest},
+		{http.MethodPost, "/subscribe?channel=c", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/subscriptions/unknown?subscribe=c", http.StatusNotFound},
+		{http.MethodPost, "/publish", http.StatusBadRequest},
+		{http.MethodGet, "/publish?channel=c", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/publish?channel=" + pubsub.KeyspacePrefix + "k", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		if status, body := request(t, test.method, server.URL+test.target, nil); status != test.want {
+			t.Errorf("%s %s = %v %s, want %v", test.method, test.target, status, body, test.want)
+		}
+	}
+}
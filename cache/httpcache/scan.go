@@ -0,0 +1,76 @@
+package httpcache
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// defaultScanCount is the count of GET /scan when the request does not give one
+const defaultScanCount = 100
+
+// ScanResult is the body of the response of GET /scan, scanning is over when Cursor is 0
+type ScanResult struct {
+	Cursor uint64   `json:"cursor"`
+	Keys   []string `json:"keys"`
+}
+
+// serveScan serves GET /scan?cursor=&count=&prefix=, a page of Cache.Scan. Like the MATCH option of Redis, the
+// prefix filters the keys of the page, so a page can hold fewer keys than count while the scan is not over.
+func (h *Handler) serveScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	query := r.URL.Query()
+	var cursor uint64
+	if value := query.Get("cursor"); value != "" {
+		var err error
+		if cursor, err = strconv.ParseUint(value, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("invalid cursor"))
+			return
+		}
+	}
+	count := defaultScanCount
+	if value := query.Get("count"); value != "" {
+		var err error
+		if count, err = strconv.Atoi(value); err != nil || count < 1 {
+			writeError(w, http.StatusBadRequest, errors.New("invalid count"))
+			return
+		}
+	}
+	prefix := query.Get("prefix")
+	next, keys := h.cache.Scan(cursor, count)
+	result := ScanResult{Cursor: next, Keys: make([]string, 0, len(keys))}
+	for _, key := range keys {
+		if strings.HasPrefix(string(key), prefix) {
+			result.Keys = append(result.Keys, string(key))
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Scan returns a page of the keys of the remote cache starting with prefix, start with cursor 0 and call Scan
+// again with the returned cursor until it returns 0
+func (c *Client) Scan(cursor uint64, count int, prefix string) (uint64, []string, error) {
+	query := url.Values{}
+	query.Set("cursor", strconv.FormatUint(cursor, 10))
+	query.Set("count", strconv.Itoa(count))
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	response, err := c.do(http.MethodGet, c.baseURL+"/scan?"+query.Encode(), nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return 0, nil, drain(response, nil)
+	}
+	defer response.Body.Close()
+	var result ScanResult
+	err = json.NewDecoder(response.Body).Decode(&result)
+	return result.Cursor, result.Keys, err
+}
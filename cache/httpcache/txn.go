@@ -0,0 +1,131 @@
+package httpcache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"gocache"
+)
+
+// errTxnAborted rolls back the transaction of POST /txn when one of its operations failed
+var errTxnAborted = errors.New("transaction aborted")
+
+// TxnWatch is a key whose entry must still have Version when the transaction of POST /txn commits, like the WATCH
+// command of Redis. Version 0 stands for an absent key, versions are returned by GET /keys/{key} and by "get".
+type TxnWatch struct {
+	Key     string `json:"key"`
+	Version uint64 `json:"version"`
+}
+
+// TxnRequest is the body of POST /txn
+type TxnRequest struct {
+	Watch []TxnWatch `json:"watch,omitempty"`
+	Ops   []BatchOp  `json:"ops"`
+}
+
+// TxnResponse is the body of the response to POST /txn. When an operation fails, nothing is written, Committed is
+// false and the results end with the failed operation. A "get" of an absent key is not a failure.
+type TxnResponse struct {
+	Committed bool          `json:"committed"`
+	Results   []BatchResult `json:"results"`
+}
+
+// serveTxn serves POST /txn, which runs the operations of the request in a transaction after checking the versions
+// of the watched keys. The response is 409 Conflict when a watched key or a key of an operation changed.
+func (h *Handler) serveTxn(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	var request TxnRequest
+	if err := json.NewDecoder(limitBody(w, r)).Decode(&request); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if len(request.Ops)+len(request.Watch) > maxBatchOps {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("transaction has more than %d operations", maxBatchOps))
+		return
+	}
+
+	response := TxnResponse{Results: make([]BatchResult, 0, len(request.Ops))}
+	err := h.cache.Transaction(func(tx *gocache.Txn) error {
+		for _, watch := range request.Watch {
+			if err := tx.Watch([]byte(watch.Key), watch.Version); err != nil {
+				return err
+			}
+		}
+		for _, op := range request.Ops {
+			result := h.runTxnOp(tx, op)
+			response.Results = append(response.Results, result)
+			if result.Error != "" {
+				return errTxnAborted
+			}
+		}
+		return nil
+	})
+	switch err {
+	case nil:
+		response.Committed = true
+	case errTxnAborted:
+	default:
+		writeCacheError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// runTxnOp runs a single operation of POST /txn in tx
+func (h *Handler) runTxnOp(tx *gocache.Txn, op BatchOp) BatchResult {
+	result := BatchResult{Key: op.Key}
+	var err error
+	switch op.Op {
+	case "get":
+		var data gocache.Data
+		if data, err = tx.Get([]byte(op.Key)); err == nil {
+			result.Value = data.GetValue()
+			result.Reads = data.GetReads()
+			result.Updates = data.GetUpdates()
+			result.Version = data.GetVersion()
+			result.Found = true
+		} else if err == gocache.ErrNotFound {
+			err = nil
+		}
+	case "put":
+		var costFun *func(data gocache.Data) int
+		if costFun, err = h.costFunctionFor(op.Cost); err == nil {
+			err = tx.AddWithTags([]byte(op.Key), op.Value, costFun, op.Tags...)
+		}
+	case "update":
+		err = tx.Update([]byte(op.Key), op.Value)
+	case "delete":
+		err = tx.Evict([]byte(op.Key))
+	default:
+		err = errors.New("unknown operation " + op.Op)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Txn runs request as a transaction on the remote cache, it returns gocache.ErrTxnConflict when a watched key or a
+// key of an operation changed, the transaction can then be sent again
+func (c *Client) Txn(request TxnRequest) (TxnResponse, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return TxnResponse{}, err
+	}
+	response, err := c.do(http.MethodPost, c.baseURL+"/txn", body)
+	if err != nil {
+		return TxnResponse{}, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return TxnResponse{}, drain(response, nil)
+	}
+	defer response.Body.Close()
+	var result TxnResponse
+	err = json.NewDecoder(response.Body).Decode(&result)
+	return result, err
+}
@@ -0,0 +1,163 @@
+package gocache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Op is an operation on the cache
+type Op int
+
+const (
+	OpAdd Op = iota
+	OpGet
+	OpUpdate
+	OpEvict
+	OpCommit
+	OpGetFunc
+	OpInvalidateTag
+	numOps
+)
+
+var opNames = [numOps]string{"add", "get", "update", "evict", "commit", "get_func", "invalidate_tag"}
+
+func (op Op) String() string {
+	if op < 0 || op >= numOps {
+		return "unknown"
+	}
+	return opNames[op]
+}
+
+// bucketInstrumentation holds the histograms of one bucket, per operation
+type bucketInstrumentation struct {
+	wait    [numOps]Histogram // time spent waiting for the bucket lock
+	latency [numOps]Histogram // time from calling lock to unlocking the bucket, wait included
+}
+
+// OperationStats holds the histograms of one operation
+type OperationStats struct {
+	Op      string            `json:"op"`
+	Wait    HistogramSnapshot `json:"wait"`
+	Latency HistogramSnapshot `json:"latency"`
+}
+
+// EnableInstrumentation starts recording the lock wait time and the latency of every operation, Add, Get, Update,
+// Evict, the commits of transactions, GetFunc and InvalidateTag, in histograms per bucket, they are returned by Stats
+// and GetBucketsStats. Histograms take about 30KB per bucket.
+// Calling it again resets the histograms. While instrumentation is disabled, which is the default,
+// operations only check a nil pointer.
+func (c *Cache) EnableInstrumentation() {
+	for i := 0; i < len(c.buckets); i++ {
+		c.buckets[i].instrumentation.Store(&bucketInstrumentation{})
+	}
+}
+
+// DisableInstrumentation stops recording histograms and drops the recorded ones
+func (c *Cache) DisableInstrumentation() {
+	for i := 0; i < len(c.buckets); i++ {
+		c.buckets[i].instrumentation.Store((*bucketInstrumentation)(nil))
+	}
+}
+
+func (b *bucket) loadInstrumentation() *bucketInstrumentation {
+	instrumentation, _ := b.instrumentation.Load().(*bucketInstrumentation)
+	return instrumentation
+}
+
+// opTimer is returned by lock and passed to unlock, it is empty when instrumentation is disabled
+type opTimer struct {
+	instrumentation *bucketInstrumentation
+	op              Op
+	start           time.Time
+}
+
+// lock locks the bucket for op and counts the contention when the lock is already held.
+// With instrumentation enabled, it records the time spent waiting for the lock. The reads recorded under the shared
+// lock are applied once the lock is taken, so the holder sees the costs of the entries up to date.
+func (b *bucket) lock(op Op) opTimer {
+	instrumentation := b.loadInstrumentation()
+	if instrumentation == nil {
+		if !b.mutex.TryLock() {
+			atomic.AddUint64(&b.contentions, 1)
+			b.mutex.Lock()
+		}
+		b.applyReads()
+		return opTimer{}
+	}
+
+	start := time.Now()
+	if !b.mutex.TryLock() {
+		atomic.AddUint64(&b.contentions, 1)
+		b.mutex.Lock()
+		instrumentation.wait[op].Record(time.Since(start))
+	} else {
+		instrumentation.wait[op].Record(0)
+	}
+	b.applyReads()
+	return opTimer{instrumentation, op, start}
+}
+
+// lockUntimed locks the bucket like lock for work which is part of another operation, such as the updates of the
+// dependency graph, without recording it in the histograms. The bucket is unlocked with mutex.Unlock.
+func (b *bucket) lockUntimed() {
+	if !b.mutex.TryLock() {
+		atomic.AddUint64(&b.contentions, 1)
+		b.mutex.Lock()
+	}
+	b.applyReads()
+}
+
+// unlock unlocks the bucket locked by lock, recording the latency of the operation
+func (b *bucket) unlock(timer opTimer) {
+	b.mutex.Unlock()
+	if timer.instrumentation != nil {
+		timer.instrumentation.latency[timer.op].Record(time.Since(timer.start))
+	}
+}
+
+// rlock takes the shared lock of the bucket for op like lock takes the exclusive one
+func (b *bucket) rlock(op Op) opTimer {
+	instrumentation := b.loadInstrumentation()
+	if instrumentation == nil {
+		if !b.mutex.TryRLock() {
+			atomic.AddUint64(&b.contentions, 1)
+			b.mutex.RLock()
+		}
+		return opTimer{}
+	}
+
+	start := time.Now()
+	if !b.mutex.TryRLock() {
+		atomic.AddUint64(&b.contentions, 1)
+		b.mutex.RLock()
+		instrumentation.wait[op].Record(time.Since(start))
+	} else {
+		instrumentation.wait[op].Record(0)
+	}
+	return opTimer{instrumentation, op, start}
+}
+
+// runlock unlocks the bucket locked by rlock, recording the latency of the operation
+func (b *bucket) runlock(timer opTimer) {
+	b.mutex.RUnlock()
+	if timer.instrumentation != nil {
+		timer.instrumentation.latency[timer.op].Record(time.Since(timer.start))
+	}
+}
+
+// operationStats returns the histograms of every operation merged over instrumentations, nil when there are none
+func operationStats(instrumentations []*bucketInstrumentation) []OperationStats {
+	if len(instrumentations) == 0 {
+		return nil
+	}
+	stats := make([]OperationStats, numOps)
+	for op := Op(0); op < numOps; op++ {
+		var wait, latency Histogram
+		for _, instrumentation := range instrumentations {
+			wait.Merge(&instrumentation.wait[op])
+			latency.Merge(&instrumentation.latency[op])
+		}
+		stats[op] = OperationStats{op.String(), wait.snapshot(), latency.snapshot()}
+	}
+	return stats
+}
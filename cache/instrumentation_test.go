@@ -0,0 +1,96 @@
+package gocache
+
+import (
+	"testing"
+	"time"
+)
+
+// operation returns the stats of op in operations, it fails the test when they are missing
+func operation(t *testing.T, operations []OperationStats, op Op) OperationStats {
+	t.Helper()
+	if len(operations) != int(numOps) {
+		t.Fatalf("%v operation stats, want %v", len(operations), numOps)
+	}
+	if operations[op].Op != op.String() {
+		t.Fatalf("operation stats %v are for %q, want %q", op, operations[op].Op, op.String())
+	}
+	return operations[op]
+}
+
+func TestInstrumentationCountsOperations(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	c.Add(key(0), []byte("value"), &ConstantCost)
+	if stats := c.Stats(); stats.Operations != nil {
+		t.Errorf("Operations before EnableInstrumentation = %+v, want nil", stats.Operations)
+	}
+
+	c.EnableInstrumentation()
+	for i := 0; i < 10; i++ {
+		c.Add(key(i), []byte("value"), &ConstantCost)
+	}
+	for i := 0; i < 6; i++ {
+		c.Get(key(i))
+	}
+	c.Update(key(1), []byte("other"))
+	c.Update(key(2), []byte("other"))
+	c.Evict(key(3))
+
+	stats := c.Stats()
+	want := map[Op]uint64{OpAdd: 10, OpGet: 6, OpUpdate: 2, OpEvict: 1, OpCommit: 0}
+	for op, count := range want {
+		operation := operation(t, stats.Operations, op)
+		if operation.Wait.Count != count || operation.Latency.Count != count {
+			t.Errorf("%v wait and latency counts = %v, %v, want %v", op, operation.Wait.Count, operation.Latency.Count, count)
+		}
+		if operation.Latency.Max < operation.Wait.Max {
+			t.Errorf("%v latency max %v is below the wait max %v", op, operation.Latency.Max, operation.Wait.Max)
+		}
+	}
+
+	// the buckets add up to the cache
+	var adds uint64
+	for _, b := range c.GetBucketsStats() {
+		adds += operation(t, b.Operations, OpAdd).Latency.Count
+	}
+	if adds != 10 {
+		t.Errorf("buckets recorded %v adds, want 10", adds)
+	}
+
+	c.EnableInstrumentation()
+	if count := operation(t, c.Stats().Operations, OpAdd).Latency.Count; count != 0 {
+		t.Errorf("adds after enabling instrumentation again = %v, want 0", count)
+	}
+	c.DisableInstrumentation()
+	c.Add(key(20), []byte("value"), &ConstantCost)
+	if stats := c.Stats(); stats.Operations != nil || stats.Buckets[0].Operations != nil {
+		t.Errorf("Operations after DisableInstrumentation = %+v, want nil", stats.Operations)
+	}
+}
+
+func TestInstrumentationRecordsWait(t *testing.T) {
+	c := newTestCache(t, 100, 1)
+	c.EnableInstrumentation()
+	const held = 20 * time.Millisecond
+
+	c.buckets[0].mutex.Lock()
+	done := make(chan struct{})
+	go func() {
+		c.Add(key(0), []byte("value"), &ConstantCost)
+		close(done)
+	}()
+	// wait for the add to find the lock held
+	for c.GetContentionsCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(held)
+	c.buckets[0].mutex.Unlock()
+	<-done
+
+	add := operation(t, c.Stats().Operations, OpAdd)
+	if add.Wait.Count != 1 || add.Wait.Max < held || add.Wait.P50 < held {
+		t.Errorf("add wait = %+v, want one wait of at least %v", add.Wait, held)
+	}
+	if add.Latency.Max < add.Wait.Max {
+		t.Errorf("add latency %v is below its wait %v", add.Latency.Max, add.Wait.Max)
+	}
+}
@@ -0,0 +1,281 @@
+package invalidation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gocache"
+)
+
+// keyLocks is the number of locks serializing the writes of the bus and the incoming invalidations per key
+const keyLocks = 64
+
+// QueueSize is the number of invalidations a bus holds while its transport sends the previous ones, invalidations
+// written while the queue is full are dropped
+const QueueSize = 4096
+
+// BusStats holds the counters of a bus
+type BusStats struct {
+	Published     uint64 `json:"published"`     // invalidations sent
+	PublishErrors uint64 `json:"publishErrors"` // invalidations the transport failed to send
+	Dropped       uint64 `json:"dropped"`       // invalidations dropped because the queue was full
+	Received      uint64 `json:"received"`      // invalidations received from other instances
+	Applied       uint64 `json:"applied"`       // received invalidations which evicted the key
+	Stale         uint64 `json:"stale"`         // received invalidations older than the local value, ignored
+	// LastError is the last error of the transport, writes do not return the errors of the transport
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Bus is a gocache.Store writing to a local cache and telling the other instances to evict the keys it writes.
+//
+// Every write is stamped with a version from a hybrid logical clock: the wall clock in nanoseconds, moved forward
+// past every version seen from other instances. An invalidation only evicts the local value when it is newer, so an
+// invalidation delayed on the network does not wipe a value written after it.
+//
+// Invalidations are published in the order of the writes by a goroutine of the bus, so a slow or unreachable peer
+// does not slow the writes down. They are dropped when QueueSize of them are waiting, the errors of the transport are
+// counted and reported by Stats.
+type Bus struct {
+	cache     *gocache.Cache
+	transport Transport
+	origin    string
+	clock     uint64 // last version given out or seen
+
+	locks         [keyLocks]sync.Mutex
+	versionsMutex sync.Mutex
+	versions      map[string]uint64 // version of the local value of every key written through the bus
+	writes        map[string]uint64 // version of the writes of the bus in progress
+	cancel        func()
+	stats         BusStats
+
+	queueMutex sync.RWMutex
+	queue      chan Message
+	closed     bool
+	published  chan struct{} // closed once the queue is closed and drained
+	errorMutex sync.Mutex
+	lastError  string
+}
+
+var _ gocache.Store = (*Bus)(nil)
+
+// NewBus returns a bus writing to c and sending invalidations through transport
+func NewBus(c *gocache.Cache, transport Transport) *Bus {
+	b := &Bus{
+		cache:     c,
+		transport: transport,
+		origin:    newOrigin(),
+		versions:  map[string]uint64{},
+		writes:    map[string]uint64{},
+		queue:     make(chan Message, QueueSize),
+		published: make(chan struct{}),
+	}
+	// versions are kept by the listener, under the lock of the bucket, so a key evicted right after a write of the
+	// bus can not keep the version of the write. Versions of keys which leave the cache are dropped, any invalidation
+	// is then harmless for them.
+	b.cancel = c.OnMutation(func(mutation gocache.Mutation) {
+		switch mutation.Kind {
+		case gocache.MutationAdd, gocache.MutationUpdate:
+			b.versionsMutex.Lock()
+			if version, found := b.writes[string(mutation.Key)]; found {
+				b.versions[string(mutation.Key)] = version
+			}
+			b.versionsMutex.Unlock()
+		case gocache.MutationEvict:
+			b.versionsMutex.Lock()
+			delete(b.versions, string(mutation.Key))
+			b.versionsMutex.Unlock()
+		case gocache.MutationClear:
+			b.versionsMutex.Lock()
+			b.versions = map[string]uint64{}
+			b.versionsMutex.Unlock()
+		}
+	})
+	transport.Receive(b.receive)
+	go b.publish()
+	return b
+}
+
+// Origin returns the id of this instance on the bus
+func (b *Bus) Origin() string {
+	return b.origin
+}
+
+// Stats returns the counters of the bus
+func (b *Bus) Stats() BusStats {
+	return BusStats{
+		Published:     atomic.LoadUint64(&b.stats.Published),
+		PublishErrors: atomic.LoadUint64(&b.stats.PublishErrors),
+		Received:      atomic.LoadUint64(&b.stats.Received),
+		Applied:       atomic.LoadUint64(&b.stats.Applied),
+		Stale:         atomic.LoadUint64(&b.stats.Stale),
+		Dropped:       atomic.LoadUint64(&b.stats.Dropped),
+		LastError:     b.loadLastError(),
+	}
+}
+
+func (b *Bus) loadLastError() string {
+	b.errorMutex.Lock()
+	defer b.errorMutex.Unlock()
+	return b.lastError
+}
+
+// Get reads k from the local cache
+func (b *Bus) Get(k []byte) (gocache.Data, error) {
+	return b.cache.Get(k)
+}
+
+// Add adds (k, v) to the local cache and invalidates k on the other instances
+func (b *Bus) Add(k, v []byte, costFun *func(data gocache.Data) int) error {
+	return b.write(k, true, func() error {
+		return b.cache.Add(k, v, costFun)
+	})
+}
+
+// Update updates k in the local cache and invalidates k on the other instances, they are invalidated even when the
+// local cache does not hold k
+func (b *Bus) Update(k, v []byte) error {
+	return b.write(k, true, func() error {
+		return b.cache.Update(k, v)
+	})
+}
+
+// Evict evicts k from the local cache and from the other instances
+func (b *Bus) Evict(k []byte) error {
+	return b.write(k, false, func() error {
+		return b.cache.Evict(k)
+	})
+}
+
+// Close stops listening to the mutations of the cache and closes the transport. Invalidations still in the queue
+// are given to the closed transport, which drops them.
+func (b *Bus) Close() error {
+	b.cancel()
+	b.queueMutex.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.queueMutex.Unlock()
+	err := b.transport.Close()
+	<-b.published
+	return err
+}
+
+// write runs a local write of k with a new version and queues the invalidation of k. When keepVersion is set, the
+// listener of the mutations keeps the version once apply adds or updates k, to ignore the older invalidations of k.
+func (b *Bus) write(k []byte, keepVersion bool, apply func() error) error {
+	lock := b.lockFor(k)
+	lock.Lock()
+	version := b.nextVersion()
+	if keepVersion {
+		b.versionsMutex.Lock()
+		b.writes[string(k)] = version
+		b.versionsMutex.Unlock()
+	}
+	err := apply()
+	if keepVersion {
+		b.versionsMutex.Lock()
+		delete(b.writes, string(k))
+		b.versionsMutex.Unlock()
+	}
+	lock.Unlock()
+
+	// the key is copied as the caller may reuse it before the invalidation is published
+	b.enqueue(Message{Origin: b.origin, Version: version, Key: append([]byte{}, k...)})
+	return err
+}
+
+// enqueue queues msg for publish, it drops msg when the queue is full or the bus is closed
+func (b *Bus) enqueue(msg Message) {
+	b.queueMutex.RLock()
+	defer b.queueMutex.RUnlock()
+	if b.closed {
+		atomic.AddUint64(&b.stats.Dropped, 1)
+		return
+	}
+	select {
+	case b.queue <- msg:
+	default:
+		atomic.AddUint64(&b.stats.Dropped, 1)
+	}
+}
+
+// publish sends the queued invalidations until Close
+func (b *Bus) publish() {
+	defer close(b.published)
+	for msg := range b.queue {
+		if err := b.transport.Publish(msg); err != nil {
+			atomic.AddUint64(&b.stats.PublishErrors, 1)
+			b.errorMutex.Lock()
+			b.lastError = err.Error()
+			b.errorMutex.Unlock()
+			continue
+		}
+		atomic.AddUint64(&b.stats.Published, 1)
+	}
+}
+
+// receive applies an invalidation of another instance
+func (b *Bus) receive(msg Message) {
+	if msg.Origin == b.origin {
+		return
+	}
+	atomic.AddUint64(&b.stats.Received, 1)
+	b.observeVersion(msg.Version)
+
+	lock := b.lockFor(msg.Key)
+	lock.Lock()
+	defer lock.Unlock()
+	b.versionsMutex.Lock()
+	local, found := b.versions[string(msg.Key)]
+	b.versionsMutex.Unlock()
+	if found && local >= msg.Version {
+		atomic.AddUint64(&b.stats.Stale, 1)
+		return
+	}
+	if b.cache.Evict(msg.Key) == nil {
+		atomic.AddUint64(&b.stats.Applied, 1)
+	}
+}
+
+func (b *Bus) lockFor(k []byte) *sync.Mutex {
+	hasher := fnv.New32a()
+	_, _ = hasher.Write(k)
+	return &b.locks[hasher.Sum32()%keyLocks]
+}
+
+// nextVersion returns a version above every version given out or seen, close to the wall clock
+func (b *Bus) nextVersion() uint64 {
+	for {
+		last := atomic.LoadUint64(&b.clock)
+		next := uint64(time.Now().UnixNano())
+		if next <= last {
+			next = last + 1
+		}
+		if atomic.CompareAndSwapUint64(&b.clock, last, next) {
+			return next
+		}
+	}
+}
+
+// observeVersion moves the clock past a version seen from another instance
+func (b *Bus) observeVersion(version uint64) {
+	for {
+		last := atomic.LoadUint64(&b.clock)
+		if version <= last || atomic.CompareAndSwapUint64(&b.clock, last, version) {
+			return
+		}
+	}
+}
+
+func newOrigin() string {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return time.Now().Format(time.RFC3339Nano)
+	}
+	return hex.EncodeToString(id[:])
+}
@@ -0,0 +1,214 @@
+package invalidation
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"gocache"
+)
+
+func newTestBus(t *testing.T, transport Transport) (*gocache.Cache, *Bus) {
+	t.Helper()
+	c := &gocache.Cache{}
+	c.Init(1000, 4)
+	b := NewBus(c, transport)
+	t.Cleanup(func() { b.Close() })
+	return c, b
+}
+
+// waitFor fails the test when condition is still false after a few seconds
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !condition(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func waitForPublished(t *testing.T, b *Bus, published uint64) {
+	t.Helper()
+	waitFor(t, "the invalidations to be published", func() bool { return b.Stats().Published >= published })
+}
+
+func TestBusInvalidatesOtherInstances(t *testing.T) {
+	hub := NewMemoryHub()
+	caches := make([]*gocache.Cache, 3)
+	buses := make([]*Bus, 3)
+	for i := range buses {
+		caches[i], buses[i] = newTestBus(t, hub.Transport())
+		caches[i].Add([]byte("k"), []byte("old"), &gocache.SizeCost)
+	}
+
+	if err := buses[0].Add([]byte("k"), []byte("new"), &gocache.SizeCost); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	waitForPublished(t, buses[0], 1)
+	if data, err := buses[0].Get([]byte("k")); err != nil || string(data.GetValue()) != "new" {
+		t.Errorf("Get on the writer = %q, %v, want %q", data.GetValue(), err, "new")
+	}
+	for i := 1; i < len(buses); i++ {
+		if _, err := caches[i].Get([]byte("k")); err != gocache.ErrNotFound {
+			t.Errorf("Get on instance %v after the invalidation = %v, want %v", i, err, gocache.ErrNotFound)
+		}
+		if stats := buses[i].Stats(); stats.Received != 1 || stats.Applied != 1 {
+			t.Errorf("stats of instance %v = %+v, want 1 received and applied", i, stats)
+		}
+	}
+}
+
+func TestBusIgnoresStaleInvalidations(t *testing.T) {
+	hub := NewMemoryHub()
+	c, b := newTestBus(t, hub.Transport())
+	other := hub.Transport()
+	b.Add([]byte("k"), []byte("v"), &gocache.SizeCost)
+	b.versionsMutex.Lock()
+	version := b.versions["k"]
+	b.versionsMutex.Unlock()
+
+	other.Publish(Message{Origin: "other", Version: version - 1, Key: []byte("k")})
+	if _, err := c.Get([]byte("k")); err != nil {
+		t.Errorf("Get after an older invalidation = %v, want the value kept", err)
+	}
+	if stats := b.Stats(); stats.Stale != 1 || stats.Applied != 0 {
+		t.Errorf("stats = %+v, want 1 stale invalidation", stats)
+	}
+
+	other.Publish(Message{Origin: "other", Version: version + 1, Key: []byte("k")})
+	if _, err := c.Get([]byte("k")); err != gocache.ErrNotFound {
+		t.Errorf("Get after a newer invalidation = %v, want %v", err, gocache.ErrNotFound)
+	}
+	// the clock moved past the version seen, the next write wins over it
+	b.Add([]byte("k"), []byte("v"), &gocache.SizeCost)
+	other.Publish(Message{Origin: "other", Version: version + 1, Key: []byte("k")})
+	if _, err := c.Get([]byte("k")); err != nil {
+		t.Errorf("Get of a value written after a seen version = %v, want the value kept", err)
+	}
+
+	// its own messages coming back are ignored
+	other.Publish(Message{Origin: b.Origin(), Version: version + 100, Key: []byte("k")})
+	if stats := b.Stats(); stats.Received != 3 {
+		t.Errorf("Received = %v, want 3", stats.Received)
+	}
+}
+
+func TestBusPublishesInOrder(t *testing.T) {
+	hub := NewMemoryHub()
+	_, b := newTestBus(t, hub.Transport())
+	var mutex sync.Mutex
+	var received []Message
+	hub.Transport().Receive(func(msg Message) {
+		mutex.Lock()
+		received = append(received, msg)
+		mutex.Unlock()
+	})
+
+	const writes = 1000
+	key := []byte("k")
+	for i := 0; i < writes; i++ {
+		b.Add(key, []byte("v"), &gocache.SizeCost)
+		// the bus keeps its own copy of the key
+		key[0] = 'k'
+	}
+	waitForPublished(t, b, writes)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(received) != writes {
+		t.Fatalf("received %v invalidations, want %v", len(received), writes)
+	}
+	for i := 1; i < len(received); i++ {
+		if received[i].Version <= received[i-1].Version {
+			t.Fatalf("invalidation %v has version %v after %v", i, received[i].Version, received[i-1].Version)
+		}
+	}
+}
+
+func TestBusEvictDropsVersion(t *testing.T) {
+	_, b := newTestBus(t, NewMemoryHub().Transport())
+	b.Add([]byte("k1"), []byte("v"), &gocache.SizeCost)
+	b.Add([]byte("k2"), []byte("v"), &gocache.SizeCost)
+	b.Evict([]byte("k1"))
+	b.Evict([]byte("missing"))
+	b.Update([]byte("missing"), []byte("v"))
+
+	b.versionsMutex.Lock()
+	_, found := b.versions["k2"]
+	versions := len(b.versions)
+	b.versionsMutex.Unlock()
+	if versions != 1 || !found {
+		t.Errorf("bus keeps %v versions, want only the version of k2", versions)
+	}
+}
+
+func TestBusEvictionAfterWriteDropsVersion(t *testing.T) {
+	c, b := newTestBus(t, NewMemoryHub().Transport())
+	// k is evicted by another write once it is added, before the write of the bus returns
+	err := b.write([]byte("k"), true, func() error {
+		if err := c.Add([]byte("k"), []byte("v"), &gocache.SizeCost); err != nil {
+			return err
+		}
+		return c.Evict([]byte("k"))
+	})
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	b.versionsMutex.Lock()
+	versions, writes := len(b.versions), len(b.writes)
+	b.versionsMutex.Unlock()
+	if versions != 0 || writes != 0 {
+		t.Errorf("bus keeps %v versions and %v writes of an evicted key, want none", versions, writes)
+	}
+}
+
+// blockingTransport blocks Publish until release is closed and fails with err
+type blockingTransport struct {
+	release chan struct{}
+	err     error
+}
+
+func (t *blockingTransport) Publish(msg Message) error {
+	<-t.release
+	return t.err
+}
+
+func (t *blockingTransport) Receive(handler func(msg Message)) {}
+
+func (t *blockingTransport) Close() error {
+	return nil
+}
+
+func TestBusWritesDoNotWaitForTheTransport(t *testing.T) {
+	transport := &blockingTransport{release: make(chan struct{}), err: errors.New("peer down")}
+	c, b := newTestBus(t, transport)
+
+	start := time.Now()
+	// the first invalidation is taken by the publishing goroutine, the next ones fill the queue
+	for i := 0; i < QueueSize+10; i++ {
+		if err := b.Add([]byte("k"), []byte("v"), &gocache.SizeCost); err != nil {
+			t.Fatalf("Add with a blocked transport = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("writes with a blocked transport took %v", elapsed)
+	}
+	if _, err := c.Get([]byte("k")); err != nil {
+		t.Errorf("Get after writing with a blocked transport = %v", err)
+	}
+	if dropped := b.Stats().Dropped; dropped < 9 || dropped > 10 {
+		t.Errorf("Dropped = %v, want 9 or 10", dropped)
+	}
+
+	close(transport.release)
+	waitFor(t, "the publish errors", func() bool {
+		stats := b.Stats()
+		return stats.PublishErrors+stats.Dropped == QueueSize+10
+	})
+	if stats := b.Stats(); stats.LastError != "peer down" || stats.Published != 0 {
+		t.Errorf("stats = %+v, want the error of the transport", stats)
+	}
+}
@@ -0,0 +1,62 @@
+package invalidation
+
+import "sync"
+
+// MemoryHub connects in-memory transports, it is meant for instances in one process and for tests
+type MemoryHub struct {
+	mutex      sync.RWMutex
+	transports map[*MemoryTransport]struct{}
+}
+
+// NewMemoryHub returns a hub without transports
+func NewMemoryHub() *MemoryHub {
+	return &MemoryHub{transports: map[*MemoryTransport]struct{}{}}
+}
+
+// Transport returns a new transport connected to the hub
+func (h *MemoryHub) Transport() *MemoryTransport {
+	t := &MemoryTransport{hub: h}
+	h.mutex.Lock()
+	h.transports[t] = struct{}{}
+	h.mutex.Unlock()
+	return t
+}
+
+// MemoryTransport delivers messages synchronously to the other transports of its hub
+type MemoryTransport struct {
+	hub     *MemoryHub
+	mutex   sync.RWMutex
+	handler func(msg Message)
+}
+
+func (t *MemoryTransport) Publish(msg Message) error {
+	t.hub.mutex.RLock()
+	defer t.hub.mutex.RUnlock()
+	for other := range t.hub.transports {
+		if other == t {
+			continue
+		}
+		other.mutex.RLock()
+		handler := other.handler
+		other.mutex.RUnlock()
+		if handler != nil {
+			// every receiver gets its own copy of the key, as it would from a network transport
+			handler(Message{Origin: msg.Origin, Version: msg.Version, Key: append([]byte{}, msg.Key...)})
+		}
+	}
+	return nil
+}
+
+func (t *MemoryTransport) Receive(handler func(msg Message)) {
+	t.mutex.Lock()
+	t.handler = handler
+	t.mutex.Unlock()
+}
+
+// Close disconnects the transport from its hub
+func (t *MemoryTransport) Close() error {
+	t.hub.mutex.Lock()
+	delete(t.hub.transports, t)
+	t.hub.mutex.Unlock()
+	return nil
+}
@@ -0,0 +1,63 @@
+// Package invalidation keeps near caches of many instances coherent. Every instance embeds a Bus in front of its
+// local cache, and whenever a key is written on one instance, the others evict it.
+package invalidation
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Message tells the other instances that Key changed on instance Origin at Version
+type Message struct {
+	Origin  string
+	Version uint64
+	Key     []byte
+}
+
+// Transport carries messages between the instances
+type Transport interface {
+	// Publish sends msg to the other instances, a transport may also deliver it back to its sender
+	Publish(msg Message) error
+	// Receive sets the function called for every incoming message, it is called once before any Publish
+	Receive(handler func(msg Message))
+	Close() error
+}
+
+// messageMagic starts every encoded message, the last byte is the format version
+var messageMagic = [4]byte{'G', 'C', 'I', 1}
+
+// maxMessageSize limits the size of an encoded message, which must fit in a UDP datagram
+const maxMessageSize = 65507
+
+var errMalformedMessage = errors.New("malformed invalidation message")
+
+func encodeMessage(msg Message) ([]byte, error) {
+	size := len(messageMagic) + 2 + len(msg.Origin) + 8 + len(msg.Key)
+	if size > maxMessageSize || len(msg.Origin) > 0xffff {
+		return nil, errors.New("invalidation message too large")
+	}
+	payload := make([]byte, 0, size)
+	payload = append(payload, messageMagic[:]...)
+	payload = append(payload, byte(len(msg.Origin)>>8), byte(len(msg.Origin)))
+	payload = append(payload, msg.Origin...)
+	var version [8]byte
+	binary.BigEndian.PutUint64(version[:], msg.Version)
+	payload = append(payload, version[:]...)
+	return append(payload, msg.Key...), nil
+}
+
+func decodeMessage(payload []byte) (Message, error) {
+	if len(payload) < len(messageMagic)+2 || string(payload[:len(messageMagic)]) != string(messageMagic[:]) {
+		return Message{}, errMalformedMessage
+	}
+	payload = payload[len(messageMagic):]
+	originLength := int(binary.BigEndian.Uint16(payload))
+	payload = payload[2:]
+	if len(payload) < originLength+8 {
+		return Message{}, errMalformedMessage
+	}
+	msg := Message{Origin: string(payload[:originLength])}
+	msg.Version = binary.BigEndian.Uint64(payload[originLength:])
+	msg.Key = append([]byte{}, payload[originLength+8:]...)
+	return msg, nil
+}
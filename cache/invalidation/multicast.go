@@ -0,0 +1,76 @@
+package invalidation
+
+import (
+	"net"
+	"sync"
+)
+
+// MulticastTransport sends every message as one UDP datagram to a multicast group. Delivery is not guaranteed,
+// a lost invalidation leaves a stale value in the near cache of the instance which missed it.
+type MulticastTransport struct {
+	listener *net.UDPConn
+	sender   *net.UDPConn
+	mutex    sync.RWMutex
+	handler  func(msg Message)
+}
+
+// NewMulticastTransport joins the multicast group at groupAddr, for example "239.0.0.1:9999", on iface, which may
+// be nil to let the system choose the interface
+func NewMulticastTransport(groupAddr string, iface *net.Interface) (*MulticastTransport, error) {
+	addr, err := net.ResolveUDPAddr("udp", groupAddr)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.ListenMulticastUDP("udp", iface, addr)
+	if err != nil {
+		return nil, err
+	}
+	sender, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	t := &MulticastTransport{listener: listener, sender: sender}
+	go t.read()
+	return t, nil
+}
+
+func (t *MulticastTransport) Publish(msg Message) error {
+	payload, err := encodeMessage(msg)
+	if err != nil {
+		return err
+	}
+	_, err = t.sender.Write(payload)
+	return err
+}
+
+func (t *MulticastTransport) Receive(handler func(msg Message)) {
+	t.mutex.Lock()
+	t.handler = handler
+	t.mutex.Unlock()
+}
+
+func (t *MulticastTransport) Close() error {
+	t.sender.Close()
+	return t.listener.Close()
+}
+
+func (t *MulticastTransport) read() {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, _, err := t.listener.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		msg, err := decodeMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		t.mutex.RLock()
+		handler := t.handler
+		t.mutex.RUnlock()
+		if handler != nil {
+			handler(msg)
+		}
+	}
+}
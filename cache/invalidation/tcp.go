@@ -0,0 +1,217 @@
+package invalidation
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Timeouts of the connections to the peers of a TCP transport
+const (
+	dialTimeout  = 2 * time.Second
+	writeTimeout = 2 * time.Second
+	// redialInterval is the time during which messages to a peer which could not be dialed fail without dialing
+	// again, so a dead peer does not hold every message for dialTimeout
+	redialInterval = time.Second
+)
+
+// TCPTransport sends every message to every peer over a TCP connection, and receives the messages of the peers
+// on its own listener. Connections are opened on the first message and opened again after a failure, a message
+// which could not be written to a peer is lost for that peer, as are the messages sent within redialInterval after
+// failing to connect to it.
+type TCPTransport struct {
+	listener net.Listener
+	mutex    sync.RWMutex
+	handler  func(msg Message)
+	peers    map[string]*tcpPeer
+	inbound  map[net.Conn]struct{}
+	closed   bool
+}
+
+type tcpPeer struct {
+	addr    string
+	mutex   sync.Mutex
+	conn    net.Conn
+	writer  *bufio.Writer
+	dialErr error     // error of the last failed dial
+	redial  time.Time // time from which the peer is dialed again after dialErr
+}
+
+// NewTCPTransport listens on listenAddr for the messages of the peers and sends its messages to peers
+func NewTCPTransport(listenAddr string, peers ...string) (*TCPTransport, error) {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	t := &TCPTransport{listener: listener, peers: map[string]*tcpPeer{}, inbound: map[net.Conn]struct{}{}}
+	for _, peer := range peers {
+		t.AddPeer(peer)
+	}
+	go t.accept()
+	return t, nil
+}
+
+// Addr returns the address the transport listens on
+func (t *TCPTransport) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+// AddPeer adds a peer to send messages to
+func (t *TCPTransport) AddPeer(addr string) {
+	t.mutex.Lock()
+	if _, found := t.peers[addr]; !found {
+		t.peers[addr] = &tcpPeer{addr: addr}
+	}
+	t.mutex.Unlock()
+}
+
+// RemovePeer stops sending messages to the peer at addr
+func (t *TCPTransport) RemovePeer(addr string) {
+	t.mutex.Lock()
+	peer := t.peers[addr]
+	delete(t.peers, addr)
+	t.mutex.Unlock()
+	if peer != nil {
+		peer.close()
+	}
+}
+
+// Publish writes msg to every peer, it returns the last error but tries all the peers
+func (t *TCPTransport) Publish(msg Message) error {
+	payload, err := encodeMessage(msg)
+	if err != nil {
+		return err
+	}
+	t.mutex.RLock()
+	peers := make([]*tcpPeer, 0, len(t.peers))
+	for _, peer := range t.peers {
+		peers = append(peers, peer)
+	}
+	t.mutex.RUnlock()
+
+	var lastErr error
+	for _, peer := range peers {
+		if err := peer.send(payload); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (t *TCPTransport) Receive(handler func(msg Message)) {
+	t.mutex.Lock()
+	t.handler = handler
+	t.mutex.Unlock()
+}
+
+// Close stops listening and closes the connections to and from the peers
+func (t *TCPTransport) Close() error {
+	t.mutex.Lock()
+	t.closed = true
+	for _, peer := range t.peers {
+		peer.close()
+	}
+	// messages published after Close are not sent
+	t.peers = map[string]*tcpPeer{}
+	for conn := range t.inbound {
+		conn.Close()
+	}
+	t.mutex.Unlock()
+	return t.listener.Close()
+}
+
+func (t *TCPTransport) accept() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		t.mutex.Lock()
+		if t.closed {
+			t.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		t.inbound[conn] = struct{}{}
+		t.mutex.Unlock()
+		go t.read(conn)
+	}
+}
+
+// read reads the messages of one peer, each one is its length and its encoding
+func (t *TCPTransport) read(conn net.Conn) {
+	defer func() {
+		t.mutex.Lock()
+		delete(t.inbound, conn)
+		t.mutex.Unlock()
+		conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	var header [4]byte
+	for {
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			return
+		}
+		length := binary.BigEndian.Uint32(header[:])
+		if length > maxMessageSize {
+			return
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return
+		}
+		msg, err := decodeMessage(payload)
+		if err != nil {
+			return
+		}
+		t.mutex.RLock()
+		handler := t.handler
+		t.mutex.RUnlock()
+		if handler != nil {
+			handler(msg)
+		}
+	}
+}
+
+func (p *tcpPeer) send(payload []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.conn == nil {
+		if p.dialErr != nil && time.Now().Before(p.redial) {
+			return p.dialErr
+		}
+		conn, err := net.DialTimeout("tcp", p.addr, dialTimeout)
+		if err != nil {
+			p.dialErr, p.redial = err, time.Now().Add(redialInterval)
+			return err
+		}
+		p.conn, p.writer, p.dialErr = conn, bufio.NewWriter(conn), nil
+	}
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+	_ = p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := p.writer.Write(header[:])
+	if err == nil {
+		_, err = p.writer.Write(payload)
+	}
+	if err == nil {
+		err = p.writer.Flush()
+	}
+	if err != nil {
+		p.conn.Close()
+		p.conn, p.writer = nil, nil
+	}
+	return err
+}
+
+func (p *tcpPeer) close() {
+	p.mutex.Lock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn, p.writer = nil, nil
+	}
+	p.mutex.Unlock()
+}
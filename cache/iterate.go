@@ -0,0 +1,104 @@
+package gocache
+
+import "bytes"
+
+// Range calls fn for every entry of the cache until fn returns false. Buckets are visited one at a time: the entries
+// of a bucket are copied under its read lock and fn is called after the lock is released, so fn may call the cache.
+// Iteration is weakly consistent, an entry added or removed during Range may or may not be visited, but an entry
+// present for the whole iteration is visited exactly once. Reading entries through Range does not count as a read.
+func (c *Cache) Range(fn func(data Data) bool) {
+	if c == nil {
+		return
+	}
+	for i := 0; i < len(c.buckets); i++ {
+		for _, data := range c.buckets[i].copyEntries(nil) {
+			data, err := c.output(data)
+			if err != nil {
+				// only a broken codec fails to decompress what it compressed
+				continue
+			}
+			if !fn(data) {
+				return
+			}
+		}
+	}
+}
+
+// Keys returns the keys of all the entries of the cache, with the same consistency as Range. Only the keys are
+// copied, the values are neither copied nor decompressed.
+func (c *Cache) Keys() [][]byte {
+	if c == nil {
+		return nil
+	}
+	var keys [][]byte
+	for i := 0; i < len(c.buckets); i++ {
+		keys = c.buckets[i].appendKeys(keys)
+	}
+	return keys
+}
+
+// ScanPrefix returns the entries whose key starts with prefix, with the same consistency as Range
+func (c *Cache) ScanPrefix(prefix []byte) []Data {
+	if c == nil {
+		return nil
+	}
+	var entries []Data
+	for i := 0; i < len(c.buckets); i++ {
+		for _, data := range c.buckets[i].copyEntries(prefix) {
+			if data, err := c.output(data); err == nil {
+				entries = append(entries, data)
+			}
+		}
+	}
+	return entries
+}
+
+// Scan returns a part of the keys of the cache, like the SCAN command of Redis. Start with cursor 0 and call Scan
+// again with the returned cursor until it returns 0. Every call returns the keys of whole buckets until at least
+// count keys are collected, so it may return more than count keys, or none for a call over empty buckets.
+// Keys present for the whole scan are returned exactly once, keys added or removed during the scan may or may
+// not be returned. The cursor is the index of the next bucket, it stays valid across changes of the cache.
+func (c *Cache) Scan(cursor uint64, count int) (next uint64, keys [][]byte) {
+	if c == nil {
+		return 0, nil
+	}
+	if count < 1 {
+		count = 1
+	}
+	for next = cursor; next < uint64(len(c.buckets)) && len(keys) < count; next++ {
+		keys = c.buckets[next].appendKeys(keys)
+	}
+	if next >= uint64(len(c.buckets)) {
+		next = 0
+	}
+	return next, keys
+}
+
+// copyEntries returns copies of the entries of the bucket whose key starts with prefix, taken under the read lock.
+// A nil prefix matches every entry.
+func (b *bucket) copyEntries(prefix []byte) []Data {
+	b.mutex.RLock()
+	entries := make([]Data, 0, len(b.entries))
+	for _, value := range b.entries {
+		if bytes.HasPrefix(value.key, prefix) {
+			entries = append(entries, value.copyData())
+		}
+	}
+	b.mutex.RUnlock()
+	return entries
+}
+
+// appendKeys appends the keys of the bucket to keys, copied after the read lock is released unless the cache is in
+// zero-copy mode. Keys of entries never change, a new key is a new entry.
+func (b *bucket) appendKeys(keys [][]byte) [][]byte {
+	start := len(keys)
+	b.mutex.RLock()
+	for _, value := range b.entries {
+		keys = append(keys, value.key)
+	}
+	b.mutex.RUnlock()
+	for i := start; i < len(keys); i++ {
+		keys[i] = b.cache.copyBytes(keys[i])
+	}
+	return keys
+}
@@ -0,0 +1,106 @@
+package gocache
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+)
+
+func sortedKeys(keys [][]byte) []string {
+	sorted := make([]string, len(keys))
+	for i, k := range keys {
+		sorted[i] = string(k)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func TestRangeAndKeys(t *testing.T) {
+	c := newTestCache(t, 1000, 16)
+	want := make([]string, 0, 300)
+	for i := 0; i < 300; i++ {
+		c.Add(key(i), []byte("v"), &SizeCost)
+		want = append(want, string(key(i)))
+	}
+	sort.Strings(want)
+	if got := sortedKeys(c.Keys()); len(got) != len(want) || got[0] != want[0] || got[len(got)-1] != want[len(want)-1] {
+		t.Fatalf("Keys returned %v keys, want %v", len(got), len(want))
+	}
+
+	visited := 0
+	c.Range(func(data Data) bool {
+		visited++
+		// the bucket is not locked while fn runs
+		c.Evict(data.GetKey())
+		return visited < 100
+	})
+	if visited != 100 {
+		t.Errorf("Range visited %v entries after fn returned false at 100", visited)
+	}
+	if n := c.GetEntriesCount(); n != 200 {
+		t.Errorf("entries = %v, want 200", n)
+	}
+	// Range does not count as a read
+	c.Range(func(data Data) bool {
+		if data.GetReads() != 0 {
+			t.Errorf("entry %q has %v reads", data.GetKey(), data.GetReads())
+		}
+		return true
+	})
+	checkCache(t, c)
+}
+
+func TestScanPrefix(t *testing.T) {
+	c := newTestCache(t, 1000, 16)
+	for i := 0; i < 100; i++ {
+		c.Add(key(i), []byte("v"), &SizeCost)
+		c.Add(append([]byte("user:"), key(i)...), []byte("v"), &SizeCost)
+	}
+	entries := c.ScanPrefix([]byte("user:"))
+	if len(entries) != 100 {
+		t.Fatalf("ScanPrefix returned %v entries, want 100", len(entries))
+	}
+	for _, data := range entries {
+		if !bytes.HasPrefix(data.GetKey(), []byte("user:")) {
+			t.Errorf("ScanPrefix returned %q", data.GetKey())
+		}
+	}
+	if n := len(c.ScanPrefix([]byte("none:"))); n != 0 {
+		t.Errorf("ScanPrefix of a missing prefix returned %v entries", n)
+	}
+}
+
+func TestScan(t *testing.T) {
+	for _, count := range []int{0, 1, 10, 1000} {
+		c := newTestCache(t, 1000, 16)
+		for i := 0; i < 300; i++ {
+			c.Add(key(i), []byte("v"), &SizeCost)
+		}
+		seen := map[string]int{}
+		cursor, calls := uint64(0), 0
+		for {
+			next, keys := c.Scan(cursor, count)
+			for _, k := range keys {
+				seen[string(k)]++
+			}
+			// keys changed during the scan must not break it
+			c.Add([]byte("new"+string(key(calls))), []byte("v"), &SizeCost)
+			calls++
+			if next == 0 {
+				break
+			}
+			if next <= cursor {
+				t.Fatalf("count %v: cursor went from %v to %v", count, cursor, next)
+			}
+			cursor = next
+		}
+		for i := 0; i < 300; i++ {
+			if n := seen[string(key(i))]; n != 1 {
+				t.Fatalf("count %v: key %s returned %v times", count, key(i), n)
+			}
+		}
+		if count >= 1000 && calls != 1 {
+			t.Errorf("count %v: scan took %v calls, want 1", count, calls)
+		}
+	}
+}
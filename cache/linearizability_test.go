@@ -0,0 +1,342 @@
+package gocache
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// The checker below decides whether a concurrent history of operations on one key is linearizable against a
+// sequential model of a single register which may be absent, with the algorithm of Wing and Gong as improved by
+// Lowe and used by Porcupine: operations are linearized one at a time in an order allowed by their invocation and
+// response timestamps, backtracking when no pending operation fits the model, and memoizing the pairs of linearized
+// operations and model state already explored. Keys are independent in a map, so a history of the whole cache is
+// checked one key at a time.
+
+type historyOpKind int
+
+const (
+	historyAdd historyOpKind = iota
+	historyGet
+	historyUpdate
+	historyEvict
+)
+
+var historyOpNames = []string{"add", "get", "update", "evict"}
+
+// historyOp is one operation of a history. Value is the value written by add and update and the value read by get,
+// Ok is false when the operation failed, that is a get which did not find the key or an update or an evict of a
+// missing key. Call and Return are the timestamps of invocation and response.
+type historyOp struct {
+	Client int
+	Kind   historyOpKind
+	Key    string
+	Value  string
+	Ok     bool
+	Call   int64
+	Return int64
+}
+
+func (op historyOp) String() string {
+	return fmt.Sprintf("client %v %v(%q) = %q, %v [%v, %v]", op.Client, historyOpNames[op.Kind], op.Key, op.Value, op.Ok, op.Call, op.Return)
+}
+
+// registerState is the state of the sequential model of one key
+type registerState struct {
+	present bool
+	value   string
+}
+
+// step applies op to state, it returns false when the result of op is not possible in state
+func (state registerState) step(op historyOp) (bool, registerState) {
+	switch op.Kind {
+	case historyAdd:
+		return op.Ok, registerState{true, op.Value}
+	case historyGet:
+		if !state.present {
+			return !op.Ok, state
+		}
+		return op.Ok && op.Value == state.value, state
+	case historyUpdate:
+		if !state.present {
+			return !op.Ok, state
+		}
+		return op.Ok, registerState{true, op.Value}
+	case historyEvict:
+		return op.Ok == state.present, registerState{}
+	}
+	return false, state
+}
+
+// historyRecorder records the operations of several clients with timestamps from one logical clock, so the real
+// time order of operations which do not overlap is kept without depending on the resolution of the system clock
+type historyRecorder struct {
+	clock int64
+	mutex sync.Mutex
+	ops   []historyOp
+}
+
+func (r *historyRecorder) now() int64 {
+	return atomic.AddInt64(&r.clock, 1)
+}
+
+func (r *historyRecorder) record(op historyOp) {
+	r.mutex.Lock()
+	r.ops = append(r.ops, op)
+	r.mutex.Unlock()
+}
+
+// run calls the cache for op and records it with the timestamps taken just before and just after the call
+func (r *historyRecorder) run(c *Cache, op historyOp) {
+	k := []byte(op.Key)
+	op.Call = r.now()
+	switch op.Kind {
+	case historyAdd:
+		op.Ok = c.Add(k, []byte(op.Value), &BalancedCost) == nil
+	case historyGet:
+		data, err := c.Get(k)
+		op.Ok, op.Value = err == nil, string(data.GetValue())
+	case historyUpdate:
+		op.Ok = c.Update(k, []byte(op.Value)) == nil
+	case historyEvict:
+		op.Ok = c.Evict(k) == nil
+	}
+	op.Return = r.now()
+	r.record(op)
+}
+
+// checkHistory checks every key of history on its own, it returns nil when all of them are linearizable and
+// otherwise an error with the operations of the first key which is not
+func checkHistory(history []historyOp) error {
+	byKey := map[string][]historyOp{}
+	for _, op := range history {
+		byKey[op.Key] = append(byKey[op.Key], op)
+	}
+	keys := make([]string, 0, len(byKey))
+	for k := range byKey {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !linearizable(byKey[k]) {
+			ops := byKey[k]
+			sort.Slice(ops, func(i, j int) bool { return ops[i].Call < ops[j].Call })
+			message := fmt.Sprintf("history of key %q is not linearizable:", k)
+			for _, op := range ops {
+				message += "\n\t" + op.String()
+			}
+			return fmt.Errorf("%v", message)
+		}
+	}
+	return nil
+}
+
+// historyEntry is the invocation or the response of an operation in the doubly linked list of events
+type historyEntry struct {
+	id    int
+	call  bool
+	op    historyOp
+	match *historyEntry // the response of an invocation
+	time  int64
+	prev  *historyEntry
+	next  *historyEntry
+}
+
+// lift removes the invocation entry and its response from the list
+func (entry *historyEntry) lift() {
+	entry.prev.next = entry.next
+	entry.next.prev = entry.prev
+	match := entry.match
+	match.prev.next = match.next
+	if match.next != nil {
+		match.next.prev = match.prev
+	}
+}
+
+// unlift puts back an entry removed by lift
+func (entry *historyEntry) unlift() {
+	match := entry.match
+	match.prev.next = match
+	if match.next != nil {
+		match.next.prev = match
+	}
+	entry.prev.next = entry
+	entry.next.prev = entry
+}
+
+// linearizedSet is a bit set of the ids of the linearized operations
+type linearizedSet []uint64
+
+func (s linearizedSet) set(id int)   { s[id/64] |= 1 << uint(id%64) }
+func (s linearizedSet) clear(id int) { s[id/64] &^= 1 << uint(id%64) }
+
+func (s linearizedSet) key(state registerState) string {
+	buf := make([]byte, 0, len(s)*8+len(state.value)+1)
+	for _, word := range s {
+		for i := 0; i < 64; i += 8 {
+			buf = append(buf, byte(word>>uint(i)))
+		}
+	}
+	if state.present {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	return string(append(buf, state.value...))
+}
+
+// linearizable returns whether the operations of one key can be ordered so that every operation takes effect at
+// one instant between its invocation and its response and the results match the model, starting with the key absent
+func linearizable(ops []historyOp) bool {
+	events := make([]*historyEntry, 0, 2*len(ops))
+	for i, op := range ops {
+		call := &historyEntry{id: i, call: true, op: op, time: op.Call}
+		ret := &historyEntry{id: i, time: op.Return}
+		call.match = ret
+		events = append(events, call, ret)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].time < events[j].time })
+	head := &historyEntry{id: -1}
+	prev := head
+	for _, event := range events {
+		prev.next, event.prev = event, prev
+		prev = event
+	}
+
+	type frame struct {
+		entry *historyEntry
+		state registerState
+	}
+	var stack []frame
+	state := registerState{}
+	linearized := make(linearizedSet, (len(ops)+63)/64)
+	explored := map[string]bool{}
+	entry := head.next
+	for head.next != nil {
+		if entry.call {
+			ok, next := state.step(entry.op)
+			if ok {
+				linearized.set(entry.id)
+				if key := linearized.key(next); !explored[key] {
+					explored[key] = true
+					stack = append(stack, frame{entry, state})
+					state = next
+					entry.lift()
+					entry = head.next
+					continue
+				}
+				linearized.clear(entry.id)
+			}
+			entry = entry.next
+		} else {
+			// a response is reached before its operation was linearized, the last choice has to be undone
+			if len(stack) == 0 {
+				return false
+			}
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			state = top.state
+			linearized.clear(top.entry.id)
+			top.entry.unlift()
+			entry = top.entry.next
+		}
+	}
+	return true
+}
+
+func TestCheckHistory(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []historyOp
+		want    bool
+	}{
+		{"sequential", []historyOp{
+			{Kind: historyAdd, Key: "k", Value: "1", Ok: true, Call: 1, Return: 2},
+			{Kind: historyGet, Key: "k", Value: "1", Ok: true, Call: 3, Return: 4},
+			{Kind: historyEvict, Key: "k", Ok: true, Call: 5, Return: 6},
+			{Kind: historyGet, Key: "k", Call: 7, Return: 8},
+		}, true},
+		{"stale read", []historyOp{
+			{Kind: historyAdd, Key: "k", Value: "1", Ok: true, Call: 1, Return: 2},
+			{Kind: historyUpdate, Key: "k", Value: "2", Ok: true, Call: 3, Return: 4},
+			{Kind: historyGet, Key: "k", Value: "1", Ok: true, Call: 5, Return: 6},
+		}, false},
+		{"concurrent read of either value", []historyOp{
+			{Kind: historyAdd, Key: "k", Value: "1", Ok: true, Call: 1, Return: 2},
+			{Client: 1, Kind: historyUpdate, Key: "k", Value: "2", Ok: true, Call: 3, Return: 6},
+			{Client: 2, Kind: historyGet, Key: "k", Value: "1", Ok: true, Call: 4, Return: 5},
+			{Client: 3, Kind: historyGet, Key: "k", Value: "2", Ok: true, Call: 4, Return: 7},
+		}, true},
+		{"read before write", []historyOp{
+			{Kind: historyGet, Key: "k", Value: "1", Ok: true, Call: 1, Return: 2},
+			{Kind: historyAdd, Key: "k", Value: "1", Ok: true, Call: 3, Return: 4},
+		}, false},
+		{"reads going back", []historyOp{
+			{Kind: historyAdd, Key: "k", Value: "1", Ok: true, Call: 1, Return: 2},
+			{Client: 1, Kind: historyUpdate, Key: "k", Value: "2", Ok: true, Call: 3, Return: 10},
+			{Client: 2, Kind: historyGet, Key: "k", Value: "2", Ok: true, Call: 4, Return: 5},
+			{Client: 2, Kind: historyGet, Key: "k", Value: "1", Ok: true, Call: 6, Return: 7},
+		}, false},
+		{"two evicts succeed", []historyOp{
+			{Kind: historyAdd, Key: "k", Value: "1", Ok: true, Call: 1, Return: 2},
+			{Client: 1, Kind: historyEvict, Key: "k", Ok: true, Call: 3, Return: 6},
+			{Client: 2, Kind: historyEvict, Key: "k", Ok: true, Call: 4, Return: 5},
+		}, false},
+		{"keys are independent", []historyOp{
+			{Kind: historyAdd, Key: "a", Value: "1", Ok: true, Call: 1, Return: 2},
+			{Kind: historyGet, Key: "b", Call: 3, Return: 4},
+			{Kind: historyUpdate, Key: "b", Value: "1", Call: 5, Return: 6},
+		}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := checkHistory(test.history); (err == nil) != test.want {
+				t.Errorf("checkHistory = %v, want linearizable %v", err, test.want)
+			}
+		})
+	}
+}
+
+// TestLinearizable runs concurrent clients on a few keys and checks that the recorded history is linearizable.
+// The capacity is large enough for no entry to be evicted by the cache itself, which the model does not allow.
+func TestLinearizable(t *testing.T) {
+	clients, ops, keys := 6, 300, 4
+	if testing.Short() {
+		ops = 100
+	}
+	for round := 0; round < 5; round++ {
+		c := newTestCache(t, 100, 2)
+		recorder := &historyRecorder{}
+		var wg sync.WaitGroup
+		for client := 0; client < clients; client++ {
+			wg.Add(1)
+			go func(client int) {
+				defer wg.Done()
+				r := rand.New(rand.NewSource(int64(round*clients + client)))
+				for i := 0; i < ops; i++ {
+					op := historyOp{
+						Client: client,
+						Kind:   historyOpKind(r.Intn(4)),
+						Key:    fmt.Sprint("key", r.Intn(keys)),
+						// every written value is unique, so a read tells which write it saw
+						Value: fmt.Sprintf("%v-%v", client, i),
+					}
+					if op.Kind == historyGet {
+						op.Value = ""
+					}
+					recorder.run(c, op)
+				}
+			}(client)
+		}
+		wg.Wait()
+		if n := c.GetEvictionsCount(EvictedByCapacity) + c.GetEvictionsCount(EvictedByCollision); n != 0 {
+			t.Fatalf("round %v: %v entries were evicted by the cache", round, n)
+		}
+		if err := checkHistory(recorder.ops); err != nil {
+			t.Fatalf("round %v: %v", round, err)
+		}
+	}
+}
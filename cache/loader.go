@@ -0,0 +1,113 @@
+package gocache
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+// Loader returns the value of a key which is not in the cache, for example by reading it from a database
+type Loader func(k []byte) ([]byte, error)
+
+// ErrLoaderPanicked is returned to the callers of GetOrLoad waiting for a call of the loader which panicked
+var ErrLoaderPanicked = errors.New("loader panicked")
+
+// loadCall is a load in flight, callers asking for the same key wait for done and share the result
+type loadCall struct {
+	done chan struct{}
+	data Data
+	err  error
+}
+
+// GetOrLoad returns the entry of k like Get. When k is not in the cache, it calls loader and adds the loaded value
+// with costFun. Concurrent calls for the same missing key wait for a single call of loader and share its result, the
+// entry as it was stored. An error of loader is returned as is and nothing is added. When loader panics, the panic
+// goes on in the caller which called it and the waiting callers get ErrLoaderPanicked.
+func (c *Cache) GetOrLoad(k []byte, loader Loader, costFun *func(data Data) int) (Data, error) {
+	if c == nil || len(c.buckets) == 0 {
+		return Data{}, ErrNotInitialized
+	}
+	h := getHash64(k)
+	index := h % uint64(len(c.buckets))
+	span := c.startSpan(OpGet, h, index)
+	data, err := c.buckets[index].getFromBucket(k, h, OpGet)
+	if err != ErrNotFound {
+		if span != nil {
+			span.SetBool(AttributeHit, err == nil)
+			span.End(err)
+		}
+		if err != nil {
+			return data, err
+		}
+		return c.output(data)
+	}
+
+	start := time.Now()
+	data, err = c.load(k, h, index, loader, costFun)
+	c.applyDependencyWork()
+	if span != nil {
+		span.SetBool(AttributeHit, false)
+		span.SetInt(AttributeLoaderDuration, int64(time.Since(start)))
+		span.End(err)
+	}
+	if err != nil {
+		return data, err
+	}
+	return c.output(data)
+}
+
+// load calls loader once for all the concurrent callers missing k and adds the loaded value to the bucket
+func (c *Cache) load(k []byte, h uint64, index uint64, loader Loader, costFun *func(data Data) int) (Data, error) {
+	c.loadsMutex.Lock()
+	if call, found := c.loads[string(k)]; found {
+		c.loadsMutex.Unlock()
+		<-call.done
+		return call.data, call.err
+	}
+	// a load of k may have finished between the miss of the caller and now, it must not be loaded again
+	if data, found := c.buckets[index].getLoaded(k, h); found {
+		c.loadsMutex.Unlock()
+		return data, nil
+	}
+	if c.loads == nil {
+		c.loads = map[string]*loadCall{}
+	}
+	call := &loadCall{done: make(chan struct{}), err: ErrLoaderPanicked}
+	c.loads[string(k)] = call
+	c.loadsMutex.Unlock()
+	// the waiters are released even when loader panics, they get ErrLoaderPanicked
+	defer func() {
+		c.loadsMutex.Lock()
+		delete(c.loads, string(k))
+		c.loadsMutex.Unlock()
+		close(call.done)
+	}()
+
+	value, err := loader(k)
+	if err == nil {
+		_, err = c.buckets[index].addToBucket(k, value, h, costFun, nil)
+	}
+	if err == nil {
+		// the callers get the entry as stored, with its version, unless it was already evicted or replaced
+		if data := c.buckets[index].peek(k, h); data != nil {
+			call.data = *data
+		} else {
+			call.data = Data{key: k, value: value, costFunction: costFun}
+		}
+	}
+	call.err = err
+	return call.data, call.err
+}
+
+// getLoaded returns the entry of k and counts the read like Get when k is in the bucket, without counting a miss
+// otherwise as the caller already did
+func (b *bucket) getLoaded(k []byte, h uint64) (Data, bool) {
+	timer := b.rlock(OpGet)
+	node, exist := b.entries[h]
+	if !exist || !bytes.Equal(node.key, k) {
+		b.runlock(timer)
+		return Data{}, false
+	}
+	data, _ := b.getShared(node, timer)
+	return data, true
+}
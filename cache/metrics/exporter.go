@@ -0,0 +1,215 @@
+// Package metrics exports the counters of gocache caches in the Prometheus text exposition format.
+//
+// It does not depend on the Prometheus client library, an Exporter is a plain http.Handler which can be
+// mounted on the /metrics path of any mux and scraped by Prometheus. There is no expirations counter, entries of a
+// gocache cache do not expire, they are only removed for the eviction reasons counted by gocache_evictions_total.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"gocache"
+)
+
+// contentType is the content type of the Prometheus text exposition format
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Source is what an Exporter scrapes, a *gocache.Cache or a *gocache.Namespace
+type Source interface {
+	Stats() gocache.Stats
+}
+
+// Exporter collects the counters of the registered caches on every scrape
+type Exporter struct {
+	mutex  sync.RWMutex
+	caches map[string]Source
+}
+
+// NewExporter returns an exporter without any cache
+func NewExporter() *Exporter {
+	return &Exporter{caches: map[string]Source{}}
+}
+
+// Register adds c, a cache or a namespace, to the exporter, its series are labeled with cache="name". Registering
+// a name again replaces the cache. A namespace has its own counters, so it is registered under its own name.
+func (e *Exporter) Register(name string, c Source) {
+	e.mutex.Lock()
+	e.caches[name] = c
+	e.mutex.Unlock()
+}
+
+// Unregister removes the cache registered under name
+func (e *Exporter) Unregister(name string) {
+	e.mutex.Lock()
+	delete(e.caches, name)
+	e.mutex.Unlock()
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	_ = e.Write(w)
+}
+
+// metric is one metric family, values returns the samples of a cache
+type metric struct {
+	name   string
+	help   string
+	kind   string
+	values func(s *scrape) []sample
+}
+
+// scrape holds the stats of a cache, read once per cache and scrape, and the fill of its buckets
+type scrape struct {
+	stats gocache.Stats
+	fill  fill
+}
+
+type sample struct {
+	labels string
+	value  float64
+}
+
+func single(value float64) []sample {
+	return []sample{{"", value}}
+}
+
+var metricFamilies = []metric{
+	{"gocache_hits_total", "Get calls which found the key.", "counter", func(s *scrape) []sample {
+		return single(float64(s.stats.Hits))
+	}},
+	{"gocache_misses_total", "Get calls which did not find the key.", "counter", func(s *scrape) []sample {
+		return single(float64(s.stats.Misses))
+	}},
+	{"gocache_adds_total", "Entries added.", "counter", func(s *scrape) []sample {
+		return single(float64(s.stats.Adds))
+	}},
+	{"gocache_updates_total", "Entries updated.", "counter", func(s *scrape) []sample {
+		return single(float64(s.stats.Updates))
+	}},
+	{"gocache_evictions_total", "Entries removed, by reason.", "counter", func(s *scrape) []sample {
+		var samples []sample
+		for _, reason := range gocache.EvictionReasons() {
+			samples = append(samples, sample{`reason="` + reason.String() + `"`, float64(s.stats.Evictions[reason.String()])})
+		}
+		return samples
+	}},
+	// collisions are reset by Clear, unlike the counters, so they are exported as a gauge
+	{"gocache_collisions", "Operations which hit a different key with the same hash since the last Clear.", "gauge", func(s *scrape) []sample {
+		return single(float64(s.stats.Collisions))
+	}},
+	{"gocache_lock_contentions_total", "Operations which had to wait for the lock of their bucket.", "counter", func(s *scrape) []sample {
+		return single(float64(s.stats.Contentions))
+	}},
+	{"gocache_entries", "Entries in the cache.", "gauge", func(s *scrape) []sample {
+		return single(float64(s.stats.Entries))
+	}},
+	{"gocache_capacity", "Maximum number of entries in the cache.", "gauge", func(s *scrape) []sample {
+		return single(float64(s.stats.MaxEntries))
+	}},
+	{"gocache_bytes", "Sum of the key and value lengths of the entries, compressed values counting their compressed length.", "gauge", func(s *scrape) []sample {
+		return single(float64(s.stats.Bytes))
+	}},
+	{"gocache_raw_bytes", "Sum of the key and value lengths of the entries before compression.", "gauge", func(s *scrape) []sample {
+		return single(float64(s.stats.RawBytes))
+	}},
+	{"gocache_buckets", "Number of buckets.", "gauge", func(s *scrape) []sample {
+		return single(float64(len(s.stats.Buckets)))
+	}},
+	{"gocache_bucket_fill_ratio", "Entries over maximum entries of the least and the most filled bucket.", "gauge", func(s *scrape) []sample {
+		return []sample{{`bucket="min"`, s.fill.min}, {`bucket="max"`, s.fill.max}}
+	}},
+	{"gocache_bucket_fill_skew", "Entries of the most filled bucket over the mean entries per bucket, 1 when the hash spreads keys evenly.", "gauge", func(s *scrape) []sample {
+		return single(s.fill.skew)
+	}},
+}
+
+type fill struct {
+	min, max, skew float64
+}
+
+// bucketFill computes the fill ratios of the least and most filled buckets and the skew of the entries
+func bucketFill(buckets []gocache.BucketStats) fill {
+	if len(buckets) == 0 {
+		return fill{}
+	}
+	result := fill{min: math.Inf(1), max: math.Inf(-1)}
+	var total, most uint64
+	for _, b := range buckets {
+		ratio := 0.0
+		if b.MaxEntries > 0 {
+			ratio = float64(b.Entries) / float64(b.MaxEntries)
+		}
+		result.min = math.Min(result.min, ratio)
+		result.max = math.Max(result.max, ratio)
+		total += b.Entries
+		if b.Entries > most {
+			most = b.Entries
+		}
+	}
+	if total > 0 {
+		result.skew = float64(most) / (float64(total) / float64(len(buckets)))
+	}
+	return result
+}
+
+// Write writes the metrics of all the registered caches to w
+func (e *Exporter) Write(w io.Writer) error {
+	e.mutex.RLock()
+	names := make([]string, 0, len(e.caches))
+	for name := range e.caches {
+		names = append(names, name)
+	}
+	caches := make([]Source, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		caches[i] = e.caches[name]
+	}
+	e.mutex.RUnlock()
+
+	scrapes := make([]scrape, len(caches))
+	for i, c := range caches {
+		stats := c.Stats()
+		scrapes[i] = scrape{stats: stats, fill: bucketFill(stats.Buckets)}
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, family := range metricFamilies {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", family.name, family.help, family.name, family.kind)
+		for i := range scrapes {
+			cacheLabel := `cache="` + escapeLabel(names[i]) + `"`
+			for _, s := range family.values(&scrapes[i]) {
+				labels := cacheLabel
+				if s.labels != "" {
+					labels += "," + s.labels
+				}
+				fmt.Fprintf(bw, "%s{%s} %s\n", family.name, labels, formatValue(s.value))
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,230 @@
+package metrics
+
+import (
+	"bufio"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gocache"
+)
+
+// family is a metric family as parsed from the exposition format
+type family struct {
+	help, kind string
+	samples    map[string]float64 // values by labels
+}
+
+// parse parses the exposition format, it fails the test on a malformed line or a sample without HELP and TYPE
+func parse(t *testing.T, body string) map[string]*family {
+	t.Helper()
+	families := map[string]*family{}
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.SplitN(line, " ", 4)
+			if len(fields) != 4 {
+				t.Fatalf("malformed comment %q", line)
+			}
+			f := families[fields[2]]
+			if f == nil {
+				f = &family{samples: map[string]float64{}}
+				families[fields[2]] = f
+			}
+			if fields[1] == "HELP" {
+				f.help = fields[3]
+			} else {
+				f.kind = fields[3]
+			}
+			continue
+		}
+		open, end := strings.IndexByte(line, '{'), strings.LastIndex(line, "} ")
+		if open < 0 || end < open {
+			t.Fatalf("malformed sample %q", line)
+		}
+		f := families[line[:open]]
+		if f == nil || f.help == "" || f.kind == "" {
+			t.Fatalf("sample %q without HELP and TYPE", line)
+		}
+		value, err := strconv.ParseFloat(line[end+2:], 64)
+		if err != nil {
+			t.Fatalf("sample %q: %v", line, err)
+		}
+		labels := line[open+1 : end]
+		if _, found := f.samples[labels]; found {
+			t.Fatalf("duplicate sample %q", line)
+		}
+		f.samples[labels] = value
+	}
+	return families
+}
+
+// scrapeHandler scrapes e over HTTP and parses the response
+func scrapeHandler(t *testing.T, e *Exporter) map[string]*family {
+	t.Helper()
+	server := httptest.NewServer(e)
+	defer server.Close()
+	response, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("scrape: %v", err)
+	}
+	defer response.Body.Close()
+	if got := response.Header.Get("Content-Type"); got != contentType {
+		t.Errorf("Content-Type = %q, want %q", got, contentType)
+	}
+	var body strings.Builder
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		body.WriteString(scanner.Text() + "\n")
+	}
+	return parse(t, body.String())
+}
+
+func TestExporter(t *testing.T) {
+	c := &gocache.Cache{}
+	c.Init(8, 2)
+	for i := 0; i < 10; i++ {
+		c.Add([]byte{'k', byte('0' + i)}, []byte("value"), &gocache.ConstantCost)
+	}
+	c.Get([]byte("k9"))
+	c.Get([]byte("missing"))
+	c.Update([]byte("k9"), []byte("other"))
+	c.Evict([]byte("k9"))
+
+	e := NewExporter()
+	e.Register("main", c)
+	families := scrapeHandler(t, e)
+	if len(families) != len(metricFamilies) {
+		t.Errorf("scraped %v families, want %v", len(families), len(metricFamilies))
+	}
+	evictions := c.GetEvictionsCount(gocache.EvictedByCapacity)
+	want := map[string]map[string]float64{
+		"gocache_hits_total":    {`cache="main"`: 1},
+		"gocache_misses_total":  {`cache="main"`: 1},
+		"gocache_adds_total":    {`cache="main"`: 10},
+		"gocache_updates_total": {`cache="main"`: 1},
+		"gocache_evictions_total": {
+			`cache="main",reason="capacity"`:   float64(evictions),
+			`cache="main",reason="collision"`:  0,
+			`cache="main",reason="explicit"`:   1,
+			`cache="main",reason="tag"`:        0,
+			`cache="main",reason="dependency"`: 0,
+		},
+		"gocache_entries":  {`cache="main"`: float64(c.GetEntriesCount())},
+		"gocache_capacity": {`cache="main"`: 8},
+		"gocache_buckets":  {`cache="main"`: 2},
+	}
+	for name, samples := range want {
+		f := families[name]
+		if f == nil {
+			t.Errorf("family %v is missing", name)
+			continue
+		}
+		for labels, value := range samples {
+			if got, found := f.samples[labels]; !found || got != value {
+				t.Errorf("%v{%v} = %v, want %v", name, labels, got, value)
+			}
+		}
+	}
+	if evictions == 0 || float64(c.GetEntriesCount()) != 8-1 {
+		t.Errorf("%v capacity evictions and %v entries, want the cache full before the eviction of k9", evictions, c.GetEntriesCount())
+	}
+	for name, f := range families {
+		wantKind := "gauge"
+		if strings.HasSuffix(name, "_total") {
+			wantKind = "counter"
+		}
+		if f.kind != wantKind {
+			t.Errorf("%v is a %v, want a %v", name, f.kind, wantKind)
+		}
+	}
+	fill := families["gocache_bucket_fill_ratio"].samples
+	if fill[`cache="main",bucket="min"`] > fill[`cache="main",bucket="max"`] || fill[`cache="main",bucket="max"`] > 1 {
+		t.Errorf("bucket fill ratios = %v", fill)
+	}
+	if skew := families["gocache_bucket_fill_skew"].samples[`cache="main"`]; skew < 1 {
+		t.Errorf("bucket fill skew = %v, want at least 1", skew)
+	}
+}
+
+func TestExporterCollisionsAreAGauge(t *testing.T) {
+	c := &gocache.Cache{}
+	c.Init(100, 1)
+	e := NewExporter()
+	e.Register("c", c)
+	if f := scrapeHandler(t, e)["gocache_collisions"]; f == nil || f.kind != "gauge" {
+		t.Errorf("gocache_collisions = %+v, want a gauge since Clear resets it", f)
+	}
+}
+
+func TestExporterRegister(t *testing.T) {
+	a, b := &gocache.Cache{}, &gocache.Cache{}
+	a.Init(100, 1)
+	b.Init(200, 1)
+	e := NewExporter()
+	e.Register("b", b)
+	e.Register("a \"quoted\"\n", a)
+	var body strings.Builder
+	if err := e.Write(&body); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	capacity := parse(t, body.String())["gocache_capacity"].samples
+	if capacity[`cache="b"`] != 200 || capacity[`cache="a \"quoted\"\n"`] != 100 {
+		t.Errorf("capacities = %v", capacity)
+	}
+	// the caches are written in the order of their names
+	if strings.Index(body.String(), `cache="a`) > strings.Index(body.String(), `cache="b"`) {
+		t.Errorf("cache b written before cache a")
+	}
+
+	e.Register("b", a)
+	e.Unregister("a \"quoted\"\n")
+	body.Reset()
+	e.Write(&body)
+	if capacity := parse(t, body.String())["gocache_capacity"].samples; len(capacity) != 1 || capacity[`cache="b"`] != 100 {
+		t.Errorf("capacities after replacing b and unregistering a = %v", capacity)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{0, "0"},
+		{1.5, "1.5"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, test := range tests {
+		if got := formatValue(test.value); got != test.want {
+			t.Errorf("formatValue(%v) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestExporterNamespace(t *testing.T) {
+	c := &gocache.Cache{}
+	c.Init(100, 2)
+	ns, err := c.NewNamespace("tenant", 10)
+	if err != nil {
+		t.Fatalf("NewNamespace: %v", err)
+	}
+	ns.Add([]byte("k"), []byte("v"), &gocache.SizeCost)
+	e := NewExporter()
+	e.Register("main", c)
+	e.Register("main/tenant", ns)
+	families := scrapeHandler(t, e)
+	if capacity := families["gocache_capacity"].samples; capacity[`cache="main"`] != 100 || capacity[`cache="main/tenant"`] != 10 {
+		t.Errorf("capacities = %v", capacity)
+	}
+	if adds := families["gocache_adds_total"].samples; adds[`cache="main"`] != 0 || adds[`cache="main/tenant"`] != 1 {
+		t.Errorf("adds = %v, want the add counted for the namespace only", adds)
+	}
+}
@@ -0,0 +1,106 @@
+package gocache
+
+// MutationKind is the kind of change a Mutation describes
+type MutationKind int
+
+const (
+	MutationAdd MutationKind = iota
+	MutationUpdate
+	MutationEvict
+	MutationClear
+)
+
+var mutationKindNames = []string{"add", "update", "evict", "clear"}
+
+func (kind MutationKind) String() string {
+	if kind < 0 || int(kind) >= len(mutationKindNames) {
+		return "unknown"
+	}
+	return mutationKindNames[kind]
+}
+
+// Mutation is a change of the cache. Key, Value and CostFunction are those of the entry, they are not set for
+// MutationClear. Tags is only set for MutationAdd and Reason only for MutationEvict. Key, Value and Tags are shared
+// with the cache and must not be modified.
+//
+// Value is nil when the entry is compressed: decompressing it under the lock of the bucket would slow down every
+// mutation for the listeners which do not need the value, GetValue decompresses it.
+type Mutation struct {
+	Kind         MutationKind
+	Key          []byte
+	Value        []byte
+	CostFunction *func(data Data) int
+	Tags         []string
+	Reason       EvictionReason
+	compressed   []byte // value of a compressed entry, Value is then nil
+	codec        Codec  // codec compressed is compressed with
+}
+
+// GetValue returns the value of the entry, decompressed when the entry is compressed. Listeners are called with the
+// lock of the bucket held, so a listener which needs the values of compressed entries should keep the mutation and
+// call GetValue later. A codec which fails to decompress what it compressed is broken, GetValue then returns nil.
+func (mutation Mutation) GetValue() []byte {
+	return decompressed(mutation.Value, mutation.compressed, mutation.codec)
+}
+
+// withValue returns mutation with value, the value of an entry stored with codec
+func (mutation Mutation) withValue(value []byte, codec Codec) Mutation {
+	if codec == nil {
+		mutation.Value = value
+	} else {
+		mutation.compressed, mutation.codec = value, codec
+	}
+	return mutation
+}
+
+// decompressed returns value, or compressed decompressed with codec when codec is not nil
+func decompressed(value, compressed []byte, codec Codec) []byte {
+	if codec == nil {
+		return value
+	}
+	value, err := codec.Decompress(nil, compressed)
+	if err != nil {
+		return nil
+	}
+	return value
+}
+
+type mutationListener struct {
+	fn func(mutation Mutation)
+}
+
+// OnMutation calls fn for every change of the cache until the returned function is called. Add overwriting an
+// entry with the same key is reported as MutationAdd only, every other removal of an entry is reported as
+// MutationEvict with its reason. fn is called with the lock of the bucket held, so the mutations of a key are
+// seen in the order they happened, fn must be fast and must not call the cache.
+func (c *Cache) OnMutation(fn func(mutation Mutation)) (cancel func()) {
+	listener := &mutationListener{fn}
+	c.listenersMutex.Lock()
+	listeners, _ := c.listeners.Load().([]*mutationListener)
+	c.listeners.Store(append(append([]*mutationListener{}, listeners...), listener))
+	c.listenersMutex.Unlock()
+
+	return func() {
+		c.listenersMutex.Lock()
+		listeners, _ := c.listeners.Load().([]*mutationListener)
+		remaining := make([]*mutationListener, 0, len(listeners))
+		for _, l := range listeners {
+			if l != listener {
+				remaining = append(remaining, l)
+			}
+		}
+		c.listeners.Store(remaining)
+		c.listenersMutex.Unlock()
+	}
+}
+
+// notify calls the mutation listeners with mutation
+func (c *Cache) notify(mutation Mutation) {
+	listeners, _ := c.listeners.Load().([]*mutationListener)
+	if len(listeners) == 0 {
+		return
+	}
+	for _, listener := range listeners {
+		listener.fn(mutation)
+	}
+}
@@ -0,0 +1,82 @@
+package gocache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestOnMutation(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	var mutations []string
+	cancel := c.OnMutation(func(mutation Mutation) {
+		mutations = append(mutations, fmt.Sprintf("%v %s=%s", mutation.Kind, mutation.Key, mutation.Value))
+	})
+	c.Add([]byte("k"), []byte("v1"), &SizeCost)
+	c.Add([]byte("k"), []byte("v2"), &SizeCost)
+	c.Update([]byte("k"), []byte("v3"))
+	c.Evict([]byte("k"))
+	c.Clear()
+	cancel()
+	c.Add([]byte("k"), []byte("v4"), &SizeCost)
+
+	want := []string{"add k=v1", "add k=v2", "update k=v3", "evict k=v3", "clear ="}
+	if fmt.Sprint(mutations) != fmt.Sprint(want) {
+		t.Errorf("mutations = %q, want %q", mutations, want)
+	}
+}
+
+// TestClearOrderedWithMutations replays the mutations reported during concurrent adds and clears, the replayed
+// entries must be those left in the cache
+func TestClearOrderedWithMutations(t *testing.T) {
+	c := newTestCache(t, 10000, 8)
+	var mutex sync.Mutex
+	model := map[string]string{}
+	c.OnMutation(func(mutation Mutation) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch mutation.Kind {
+		case MutationAdd, MutationUpdate:
+			model[string(mutation.Key)] = string(mutation.Value)
+		case MutationEvict:
+			delete(model, string(mutation.Key))
+		case MutationClear:
+			model = map[string]string{}
+		}
+	})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				c.Add(key(g*2000+i), []byte("v"), &ConstantCost)
+			}
+		}(g)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			c.Clear()
+		}
+	}()
+	wg.Wait()
+
+	entries := map[string]string{}
+	c.Range(func(data Data) bool {
+		entries[string(data.GetKey())] = string(data.GetValue())
+		return true
+	})
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(entries) != len(model) {
+		t.Fatalf("cache has %v entries, the replayed mutations %v", len(entries), len(model))
+	}
+	for k, v := range entries {
+		if model[k] != v {
+			t.Fatalf("entry %q = %q in the cache, %q in the replayed mutations", k, v, model[k])
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package gocache
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrNamespaceExists is returned by NewNamespace when a namespace with the same name already exists
+var ErrNamespaceExists = errors.New("namespace already exists")
+
+// Namespace is a logical database within a cache. It has the same number of buckets as the cache it belongs to but
+// its own entries, capacity quota, counters and locks, so a namespace filling up evicts only its own entries and
+// never slows down or evicts the entries of the cache or of other namespaces. The same key can be stored in several
+// namespaces. Mutations, tracing and instrumentation of a namespace are separate from those of the cache: a
+// namespace has its own OnMutation, SetTracer and EnableInstrumentation, and metrics.Exporter registers it under its
+// own name.
+//
+// Namespaces share the bucket infrastructure of the cache, its code, bucket layout, copy mode and compression, but
+// not the buckets themselves. Entries of several namespaces in one bucket would compete for the capacity and the
+// lock of the bucket, so the eviction pressure of a noisy tenant would reach the others. The price is the memory of
+// the empty buckets of every namespace. Cache.Clear and Cache.Stats only cover the entries added to the cache
+// itself, each namespace has its own Clear and Stats.
+type Namespace struct {
+	name  string
+	cache Cache
+}
+
+var _ Store = (*Namespace)(nil)
+
+// Namespace returns the namespace with the given name, creating it on first use with a quota equal to the
+// capacity of the cache. A namespace created before the cache is initialized gets its quota from Init, and its
+// methods return ErrNotInitialized until then.
+func (c *Cache) Namespace(name string) *Namespace {
+	c.namespacesMutex.Lock()
+	defer c.namespacesMutex.Unlock()
+	if ns, found := c.namespaces[name]; found {
+		return ns
+	}
+	ns := &Namespace{name: name}
+	if len(c.buckets) > 0 {
+		c.initNamespace(ns, int(c.GetCapacity()))
+	}
+	c.addNamespace(ns)
+	return ns
+}
+
+// NewNamespace creates a namespace whose quota is capacity entries, it returns ErrNamespaceExists when the
+// name is already used. Like the capacity of Init, the quota can not be more than the number of buckets of the
+// cache times 2000, and it is rounded up to a multiple of the number of buckets.
+func (c *Cache) NewNamespace(name string, capacity int) (*Namespace, error) {
+	if c == nil || len(c.buckets) == 0 {
+		return nil, ErrNotInitialized
+	}
+	if capacity < 0 || capacity > len(c.buckets)*maxEntriesPerBucket {
+		return nil, fmt.Errorf("namespace capacity must be between 0 and %v", len(c.buckets)*maxEntriesPerBucket)
+	}
+	c.namespacesMutex.Lock()
+	defer c.namespacesMutex.Unlock()
+	if _, found := c.namespaces[name]; found {
+		return nil, ErrNamespaceExists
+	}
+	ns := &Namespace{name: name}
+	c.initNamespace(ns, capacity)
+	c.addNamespace(ns)
+	return ns, nil
+}
+
+// initNamespace initializes the entries of ns with the bucket layout and the settings of the cache
+func (c *Cache) initNamespace(ns *Namespace, capacity int) {
+	ns.cache.Init(capacity, len(c.buckets))
+	ns.cache.SetCopyMode(c.GetCopyMode())
+	if settings, _ := c.compression.Load().(*compression); settings != nil {
+		ns.cache.compression.Store(settings)
+	}
+}
+
+// initNamespaces initializes the namespaces obtained from Namespace before the cache was initialized
+func (c *Cache) initNamespaces() {
+	c.namespacesMutex.Lock()
+	defer c.namespacesMutex.Unlock()
+	for _, ns := range c.namespaces {
+		if len(ns.cache.buckets) == 0 {
+			c.initNamespace(ns, int(c.GetCapacity()))
+		}
+	}
+}
+
+// addNamespace registers ns, namespacesMutex must be locked by the caller
+func (c *Cache) addNamespace(ns *Namespace) {
+	if c.namespaces == nil {
+		c.namespaces = map[string]*Namespace{}
+	}
+	c.namespaces[ns.name] = ns
+}
+
+// Namespaces returns the names of the namespaces of the cache in sorted order
+func (c *Cache) Namespaces() []string {
+	c.namespacesMutex.Lock()
+	names := make([]string, 0, len(c.namespaces))
+	for name := range c.namespaces {
+		names = append(names, name)
+	}
+	c.namespacesMutex.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// DropNamespace removes the namespace with the given name and clears its entries, it returns false when there
+// is no such namespace. Handles of the namespace obtained before keep working but are no longer part of the cache.
+func (c *Cache) DropNamespace(name string) bool {
+	c.namespacesMutex.Lock()
+	ns, found := c.namespaces[name]
+	delete(c.namespaces, name)
+	c.namespacesMutex.Unlock()
+	if found {
+		ns.Clear()
+	}
+	return found
+}
+
+// Name returns the name of the namespace
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// Add adds (k, v) to the namespace, see Cache.Add
+func (ns *Namespace) Add(k, v []byte, costFun *func(data Data) int) error {
+	return ns.cache.Add(k, v, costFun)
+}
+
+// Get returns the entry of k in the namespace, see Cache.Get
+func (ns *Namespace) Get(k []byte) (Data, error) {
+	return ns.cache.Get(k)
+}
+
+// GetFunc calls fn with the value of k in the namespace without copying it, see Cache.GetFunc
+func (ns *Namespace) GetFunc(k []byte, fn func(value []byte)) error {
+	return ns.cache.GetFunc(k, fn)
+}
+
+// SetCopyMode sets the copy mode of the namespace, which starts with the copy mode of the cache, see CopyMode
+func (ns *Namespace) SetCopyMode(mode CopyMode) {
+	ns.cache.SetCopyMode(mode)
+}
+
+// SetCompression sets the compression of the values of the namespace, which starts with the compression of the
+// cache, see Cache.SetCompression
+func (ns *Namespace) SetCompression(codec Codec, threshold int) {
+	ns.cache.SetCompression(codec, threshold)
+}
+
+// Update replaces the value of k in the namespace, see Cache.Update
+func (ns *Namespace) Update(k, v []byte) error {
+	return ns.cache.Update(k, v)
+}
+
+// Evict removes k from the namespace, see Cache.Evict
+func (ns *Namespace) Evict(k []byte) error {
+	return ns.cache.Evict(k)
+}
+
+// GetOrLoad returns the entry of k in the namespace, loading it when missing, see Cache.GetOrLoad
+func (ns *Namespace) GetOrLoad(k []byte, loader Loader, costFun *func(data Data) int) (Data, error) {
+	return ns.cache.GetOrLoad(k, loader, costFun)
+}
+
+// Clear removes all the entries of the namespace, the cache and the other namespaces are not changed
+func (ns *Namespace) Clear() {
+	ns.cache.Clear()
+}
+
+// Stats returns the counters of the namespace, see Cache.Stats
+func (ns *Namespace) Stats() Stats {
+	return ns.cache.Stats()
+}
+
+// GetEntriesCount returns the number of entries in the namespace
+func (ns *Namespace) GetEntriesCount() uint64 {
+	return ns.cache.GetEntriesCount()
+}
+
+// GetCapacity returns the quota of the namespace in entries
+func (ns *Namespace) GetCapacity() uint64 {
+	return ns.cache.GetCapacity()
+}
+
+// Range calls fn for every entry of the namespace until fn returns false, see Cache.Range
+func (ns *Namespace) Range(fn func(data Data) bool) {
+	ns.cache.Range(fn)
+}
+
+// Keys returns the keys of the namespace, see Cache.Keys
+func (ns *Namespace) Keys() [][]byte {
+	return ns.cache.Keys()
+}
+
+// ScanPrefix returns the entries of the namespace whose key starts with prefix, see Cache.ScanPrefix
+func (ns *Namespace) ScanPrefix(prefix []byte) []Data {
+	return ns.cache.ScanPrefix(prefix)
+}
+
+// Scan returns a page of the keys of the namespace, see Cache.Scan
+func (ns *Namespace) Scan(cursor uint64, count int) (uint64, [][]byte) {
+	return ns.cache.Scan(cursor, count)
+}
+
+// AddWithTags adds (k, v) to the namespace with tags, see Cache.AddWithTags
+func (ns *Namespace) AddWithTags(k, v []byte, costFun *func(data Data) int, tags ...string) error {
+	return ns.cache.AddWithTags(k, v, costFun, tags...)
+}
+
+// InvalidateTag evicts the entries of the namespace tagged with tag, see Cache.InvalidateTag
+func (ns *Namespace) InvalidateTag(tag string) int {
+	return ns.cache.InvalidateTag(tag)
+}
+
+// DependsOn declares that child is derived from parents in the namespace, see Cache.DependsOn
+func (ns *Namespace) DependsOn(child []byte, parents ...[]byte) error {
+	return ns.cache.DependsOn(child, parents...)
+}
+
+// Watch returns a watcher of the changes of the keys of the namespace starting with prefix, see Cache.Watch
+func (ns *Namespace) Watch(prefix []byte) *Watcher {
+	return ns.cache.Watch(prefix)
+}
+
+// WatchBuffer returns a watcher like Watch buffering size events, see Cache.WatchBuffer
+func (ns *Namespace) WatchBuffer(prefix []byte, size int) *Watcher {
+	return ns.cache.WatchBuffer(prefix, size)
+}
+
+// Transaction runs fn in a transaction on the keys of the namespace, see Cache.Transaction
+func (ns *Namespace) Transaction(fn func(tx *Txn) error) error {
+	return ns.cache.Transaction(fn)
+}
+
+// OnMutation calls fn for every change of the namespace until the returned function is called, the listeners of the
+// cache are not called for the changes of the namespace, see Cache.OnMutation
+func (ns *Namespace) OnMutation(fn func(mutation Mutation)) (cancel func()) {
+	return ns.cache.OnMutation(fn)
+}
+
+// SetTracer makes the namespace emit a span for every operation to tracer, see Cache.SetTracer
+func (ns *Namespace) SetTracer(tracer Tracer) {
+	ns.cache.SetTracer(tracer)
+}
+
+// EnableInstrumentation starts recording the histograms of the operations of the namespace, returned by its Stats,
+// see Cache.EnableInstrumentation. Like for the cache, it has no effect before the namespace is initialized.
+func (ns *Namespace) EnableInstrumentation() {
+	ns.cache.EnableInstrumentation()
+}
+
+// DisableInstrumentation stops recording the histograms of the namespace, see Cache.DisableInstrumentation
+func (ns *Namespace) DisableInstrumentation() {
+	ns.cache.DisableInstrumentation()
+}
@@ -0,0 +1,176 @@
+package gocache
+
+import "testing"
+
+func TestNamespaceIsolation(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	a, err := c.NewNamespace("a", 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := c.Namespace("b")
+	if c.Namespace("a") != a {
+		t.Errorf("Namespace did not return the existing namespace")
+	}
+	if _, err := c.NewNamespace("a", 8); err != ErrNamespaceExists {
+		t.Errorf("NewNamespace of an existing name error = %v, want %v", err, ErrNamespaceExists)
+	}
+
+	c.Add([]byte("k"), []byte("cache"), &SizeCost)
+	a.Add([]byte("k"), []byte("a"), &SizeCost)
+	b.Add([]byte("k"), []byte("b"), &SizeCost)
+	for _, store := range []struct {
+		name  string
+		store Store
+	}{{"cache", c}, {"a", a}, {"b", b}} {
+		if data, err := store.store.Get([]byte("k")); err != nil || string(data.GetValue()) != store.name {
+			t.Errorf("%v: Get = %q, %v, want %q", store.name, data.GetValue(), err, store.name)
+		}
+	}
+
+	// filling a over its quota evicts only entries of a
+	for i := 0; i < 100; i++ {
+		a.Add(key(i), []byte("v"), &SizeCost)
+	}
+	if n := a.GetEntriesCount(); n > a.GetCapacity() || a.GetCapacity() != 8 {
+		t.Errorf("a has %v entries for a quota of %v, want at most 8", n, a.GetCapacity())
+	}
+	if n := a.Stats().Evictions[EvictedByCapacity.String()]; n == 0 {
+		t.Errorf("a has no capacity evictions")
+	}
+	if _, err := c.Get([]byte("k")); err != nil {
+		t.Errorf("cache lost its entry: %v", err)
+	}
+	if _, err := b.Get([]byte("k")); err != nil {
+		t.Errorf("b lost its entry: %v", err)
+	}
+	if n := c.GetEvictionsCount(EvictedByCapacity); n != 0 {
+		t.Errorf("cache has %v capacity evictions", n)
+	}
+
+	b.Clear()
+	if b.GetEntriesCount() != 0 || c.GetEntriesCount() != 1 || a.GetEntriesCount() == 0 {
+		t.Errorf("Clear of b changed other namespaces")
+	}
+	if got := c.Namespaces(); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("Namespaces = %v, want [a b]", got)
+	}
+	if !c.DropNamespace("a") || c.DropNamespace("a") {
+		t.Errorf("DropNamespace did not drop a exactly once")
+	}
+	if c.Namespace("a") == a {
+		t.Errorf("Namespace returned a dropped namespace")
+	}
+}
+
+func TestNamespaceNotInitialized(t *testing.T) {
+	c := &Cache{}
+	ns := c.Namespace("a")
+	if err := ns.Add([]byte("k"), []byte("v"), &SizeCost); err != ErrNotInitialized {
+		t.Errorf("Add error = %v, want %v", err, ErrNotInitialized)
+	}
+	if _, err := c.NewNamespace("b", 10); err != ErrNotInitialized {
+		t.Errorf("NewNamespace error = %v, want %v", err, ErrNotInitialized)
+	}
+	if got := c.Namespaces(); len(got) != 1 || got[0] != "a" {
+		t.Errorf("Namespaces before Init = %v, want [a]", got)
+	}
+
+	// the namespace obtained before Init works once the cache is initialized
+	c.Init(100, 4)
+	if c.Namespace("a") != ns {
+		t.Errorf("Namespace after Init did not return the namespace obtained before")
+	}
+	if err := ns.Add([]byte("k"), []byte("v"), &SizeCost); err != nil {
+		t.Errorf("Add after Init error = %v", err)
+	}
+	if ns.GetCapacity() != c.GetCapacity() {
+		t.Errorf("namespace capacity = %v, want the capacity of the cache %v", ns.GetCapacity(), c.GetCapacity())
+	}
+	checkCache(t, &ns.cache)
+}
+
+func TestNamespaceClearAndStats(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	a := c.Namespace("a")
+	b := c.Namespace("b")
+	for i := 0; i < 10; i++ {
+		c.Add(key(i), []byte("cache"), &SizeCost)
+	}
+	for i := 0; i < 5; i++ {
+		a.Add(key(i), []byte("a"), &SizeCost)
+	}
+	b.Add(key(0), []byte("b"), &SizeCost)
+	a.Get(key(0))
+	a.Get([]byte("missing"))
+
+	stats, aStats, bStats := c.Stats(), a.Stats(), b.Stats()
+	if stats.Entries != 10 || stats.Adds != 10 || stats.Hits != 0 || stats.Misses != 0 {
+		t.Errorf("cache Stats = %v entries, %v adds, %v hits, %v misses, want only the entries of the cache",
+			stats.Entries, stats.Adds, stats.Hits, stats.Misses)
+	}
+	if aStats.Entries != 5 || aStats.Adds != 5 || aStats.Hits != 1 || aStats.Misses != 1 {
+		t.Errorf("a Stats = %v entries, %v adds, %v hits, %v misses, want 5, 5, 1, 1",
+			aStats.Entries, aStats.Adds, aStats.Hits, aStats.Misses)
+	}
+	if bStats.Entries != 1 || bStats.Adds != 1 {
+		t.Errorf("b Stats = %v entries, %v adds, want 1, 1", bStats.Entries, bStats.Adds)
+	}
+
+	c.Clear()
+	if c.GetEntriesCount() != 0 || a.GetEntriesCount() != 5 || b.GetEntriesCount() != 1 {
+		t.Errorf("entries after Clear of the cache = %v, %v, %v, want 0, 5, 1",
+			c.GetEntriesCount(), a.GetEntriesCount(), b.GetEntriesCount())
+	}
+	if data, err := a.Get(key(0)); err != nil || string(data.GetValue()) != "a" {
+		t.Errorf("a Get after Clear of the cache = %q, %v", data.GetValue(), err)
+	}
+	c.Add(key(0), []byte("cache"), &SizeCost)
+	a.Clear()
+	if c.GetEntriesCount() != 1 || a.GetEntriesCount() != 0 || b.GetEntriesCount() != 1 {
+		t.Errorf("entries after Clear of a = %v, %v, %v, want 1, 0, 1",
+			c.GetEntriesCount(), a.GetEntriesCount(), b.GetEntriesCount())
+	}
+	for _, ns := range []*Namespace{a, b} {
+		checkCache(t, &ns.cache)
+	}
+}
+
+func TestNamespaceHooks(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	ns := c.Namespace("ns")
+	var cacheMutations, nsMutations []Mutation
+	c.OnMutation(func(mutation Mutation) { cacheMutations = append(cacheMutations, mutation) })
+	cancel := ns.OnMutation(func(mutation Mutation) { nsMutations = append(nsMutations, mutation) })
+	cacheTracer, nsTracer := NewRecordingTracer(), NewRecordingTracer()
+	c.SetTracer(cacheTracer)
+	ns.SetTracer(nsTracer)
+	ns.EnableInstrumentation()
+
+	ns.Add([]byte("k"), []byte("v"), &SizeCost)
+	ns.Get([]byte("k"))
+	if len(nsMutations) != 1 || nsMutations[0].Kind != MutationAdd || len(cacheMutations) != 0 {
+		t.Errorf("mutations of the namespace %+v and of the cache %+v, want one add of the namespace",
+			nsMutations, cacheMutations)
+	}
+	if spans := nsTracer.Spans(); len(spans) != 2 || spans[0].Op != OpAdd || spans[1].Op != OpGet {
+		t.Errorf("spans of the namespace = %+v, want an add and a get", spans)
+	}
+	if spans := cacheTracer.Spans(); len(spans) != 0 {
+		t.Errorf("spans of the cache = %+v, want none", spans)
+	}
+	if operations := ns.Stats().Operations; operations == nil || operations[OpAdd].Latency.Count != 1 {
+		t.Errorf("operations of the namespace = %+v, want one add", operations)
+	}
+	if operations := c.Stats().Operations; operations != nil {
+		t.Errorf("operations of the cache = %+v, want none", operations)
+	}
+
+	cancel()
+	ns.SetTracer(nil)
+	ns.DisableInstrumentation()
+	ns.Update([]byte("k"), []byte("w"))
+	if len(nsMutations) != 1 || len(nsTracer.Spans()) != 2 || ns.Stats().Operations != nil {
+		t.Errorf("the namespace kept reporting after the hooks were removed")
+	}
+}
@@ -0,0 +1,317 @@
+// Package pubsub is a lightweight publish/subscribe broker with the semantics of the SUBSCRIBE, PSUBSCRIBE,
+// UNSUBSCRIBE, PUNSUBSCRIBE and PUBLISH commands of Redis, and a publisher of the changes of a gocache cache on
+// reserved keyspace channels.
+package pubsub
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuffer is the number of messages a subscription buffers before dropping messages
+const DefaultBuffer = 1024
+
+// ReservedPrefix starts the names of the channels only the broker publishes to, see PublishKeyspaceEvents
+const ReservedPrefix = "__key"
+
+// ErrReservedChannel is returned when publishing to a channel starting with ReservedPrefix
+var ErrReservedChannel = errors.New("pubsub: the channel is reserved for keyspace events")
+
+// Kinds of Message, as in the replies of Redis
+const (
+	KindSubscribe    = "subscribe"
+	KindPSubscribe   = "psubscribe"
+	KindUnsubscribe  = "unsubscribe"
+	KindPUnsubscribe = "punsubscribe"
+	KindMessage      = "message"
+	KindPMessage     = "pmessage"
+)
+
+// Message is a published message or the confirmation of a change of the subscriptions. Channel is the channel
+// of a message and of a confirmation of a channel, Pattern the pattern which matched a pmessage and the pattern of
+// a confirmation of a pattern. Count is the number of channels and patterns subscribed after a confirmation.
+// Data is shared by all the receivers and must not be modified.
+type Message struct {
+	Kind    string `json:"kind"`
+	Pattern string `json:"pattern,omitempty"`
+	Channel string `json:"channel,omitempty"`
+	Data    []byte `json:"data,omitempty"`
+	Count   int    `json:"count,omitempty"`
+}
+
+// Broker delivers the messages published to a channel to the subscriptions of the channel and of the patterns
+// matching it. The zero value is not usable, use NewBroker.
+type Broker struct {
+	mutex    sync.RWMutex
+	channels map[string]map[*Subscription]struct{}
+	patterns map[string]map[*Subscription]struct{}
+}
+
+// NewBroker returns a broker without subscriptions
+func NewBroker() *Broker {
+	return &Broker{channels: map[string]map[*Subscription]struct{}{}, patterns: map[string]map[*Subscription]struct{}{}}
+}
+
+// Publish sends data to the subscribers of channel and returns the number of subscriptions which received it, a
+// subscription matching with several patterns receives it once per pattern like in Redis
+func (b *Broker) Publish(channel string, data []byte) (int, error) {
+	if strings.HasPrefix(channel, ReservedPrefix) {
+		return 0, ErrReservedChannel
+	}
+	return b.publish(channel, data), nil
+}
+
+// publish sends data to the subscribers of channel, reserved or not
+func (b *Broker) publish(channel string, data []byte) int {
+	receivers := 0
+	b.mutex.RLock()
+	for s := range b.channels[channel] {
+		if s.send(Message{Kind: KindMessage, Channel: channel, Data: data}) {
+			receivers++
+		}
+	}
+	for pattern, subscriptions := range b.patterns {
+		if !Match(pattern, channel) {
+			continue
+		}
+		for s := range subscriptions {
+			if s.send(Message{Kind: KindPMessage, Pattern: pattern, Channel: channel, Data: data}) {
+				receivers++
+			}
+		}
+	}
+	b.mutex.RUnlock()
+	return receivers
+}
+
+// NumSub returns the number of subscriptions of channel
+func (b *Broker) NumSub(channel string) int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return len(b.channels[channel])
+}
+
+// NumPat returns the number of distinct patterns subscribed to
+func (b *Broker) NumPat() int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return len(b.patterns)
+}
+
+// Subscription is a set of channels and patterns whose messages are received on Messages, along with the
+// confirmations of the changes of the set. Messages are sent without blocking the publishers: the messages arriving
+// while the buffer is full are dropped and counted by Dropped. The methods are safe for concurrent use.
+type Subscription struct {
+	Messages <-chan Message
+	messages chan Message
+	broker   *Broker
+	channels map[string]struct{} // guarded by the mutex of the broker
+	patterns map[string]struct{}
+	dropped  uint64
+	mutex    sync.RWMutex // held for reading while sending, so Close never closes messages under a send
+	closed   bool
+}
+
+// Subscribe returns a subscription to channels buffering size messages, no channel can be given to subscribe later
+func (b *Broker) Subscribe(size int, channels ...string) *Subscription {
+	messages := make(chan Message, size)
+	s := &Subscription{Messages: messages, messages: messages, broker: b, channels: map[string]struct{}{}, patterns: map[string]struct{}{}}
+	s.Subscribe(channels...)
+	return s
+}
+
+// Subscribe adds channels to the subscription, a confirmation is received for every channel
+func (s *Subscription) Subscribe(channels ...string) {
+	s.change(channels, s.channels, s.broker.channels, KindSubscribe, true)
+}
+
+// PSubscribe adds patterns to the subscription, a confirmation is received for every pattern. Patterns are globs
+// matched with Match.
+func (s *Subscription) PSubscribe(patterns ...string) {
+	s.change(patterns, s.patterns, s.broker.patterns, KindPSubscribe, true)
+}
+
+// Unsubscribe removes channels from the subscription, or all its channels when none is given. A confirmation is
+// received for every channel, and one with an empty channel when there was none to remove.
+func (s *Subscription) Unsubscribe(channels ...string) {
+	s.change(channels, s.channels, s.broker.channels, KindUnsubscribe, false)
+}
+
+// PUnsubscribe removes patterns from the subscription like Unsubscribe removes channels
+func (s *Subscription) PUnsubscribe(patterns ...string) {
+	s.change(patterns, s.patterns, s.broker.patterns, KindPUnsubscribe, false)
+}
+
+// change adds names to or removes names from own, the channels or the patterns of the subscription, and from
+// index, the same set of the broker
+func (s *Subscription) change(names []string, own map[string]struct{}, index map[string]map[*Subscription]struct{}, kind string, add bool) {
+	b := s.broker
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !add && len(names) == 0 {
+		for name := range own {
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			s.send(Message{Kind: kind, Count: len(s.channels) + len(s.patterns)})
+			return
+		}
+	}
+	for _, name := range names {
+		if add {
+			own[name] = struct{}{}
+			if index[name] == nil {
+				index[name] = map[*Subscription]struct{}{}
+			}
+			index[name][s] = struct{}{}
+		} else {
+			delete(own, name)
+			delete(index[name], s)
+			if len(index[name]) == 0 {
+				delete(index, name)
+			}
+		}
+		message := Message{Kind: kind, Channel: name, Count: len(s.channels) + len(s.patterns)}
+		if kind == KindPSubscribe || kind == KindPUnsubscribe {
+			message.Channel, message.Pattern = "", name
+		}
+		s.send(message)
+	}
+}
+
+// Count returns the number of channels and patterns of the subscription
+func (s *Subscription) Count() int {
+	s.broker.mutex.RLock()
+	defer s.broker.mutex.RUnlock()
+	return len(s.channels) + len(s.patterns)
+}
+
+// send delivers message to the subscription, or counts it as dropped when the buffer is full
+func (s *Subscription) send(message Message) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return false
+	}
+	select {
+	case s.messages <- message:
+		return true
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		return false
+	}
+}
+
+// Dropped returns the number of messages dropped because the buffer of the subscription was full
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close removes all the channels and patterns of the subscription without confirmations and closes Messages once
+// the buffered messages are read, it can be called more than once
+func (s *Subscription) Close() {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.messages)
+	s.mutex.Unlock()
+	// sending is over, so removing the channels only drops confirmations nobody can receive
+	s.Unsubscribe()
+	s.PUnsubscribe()
+}
+
+// Match returns whether channel matches the glob pattern, where * matches any sequence of bytes, ? any byte,
+// [abc] and [a-z] a byte of a set, [^abc] a byte out of a set, and \ escapes the next byte. Patterns come from
+// clients, so the matching never backtracks further than the last *: a mismatch after it only makes that * match
+// one more byte, the earlier ones keep what they matched. It takes at most len(pattern)*len(channel) steps.
+func Match(pattern, channel string) bool {
+	p, c := 0, 0
+	// star is the position in pattern after the last * seen, -1 before the first one, and starEnd the end in
+	// channel of the bytes matched by it
+	star, starEnd := -1, 0
+	for c < len(channel) {
+		if p < len(pattern) && pattern[p] == '*' {
+			for p < len(pattern) && pattern[p] == '*' {
+				p++
+			}
+			if p == len(pattern) {
+				return true
+			}
+			star, starEnd = p, c
+			continue
+		}
+		if p < len(pattern) {
+			if n := matchByte(pattern[p:], channel[c]); n > 0 {
+				p, c = p+n, c+1
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		starEnd++
+		p, c = star, starEnd
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchByte returns the length of the element starting pattern, a byte, ?, an escaped byte or a set, when it
+// matches c, and 0 when it does not
+func matchByte(pattern string, c byte) int {
+	switch pattern[0] {
+	case '?':
+		return 1
+	case '[':
+		end := strings.IndexByte(pattern[1:], ']')
+		if end < 0 {
+			// an unterminated set matches itself
+			if c == '[' {
+				return 1
+			}
+			return 0
+		}
+		if matchSet(pattern[1:end+1], c) {
+			return end + 2
+		}
+		return 0
+	case '\\':
+		if len(pattern) > 1 {
+			if pattern[1] == c {
+				return 2
+			}
+			return 0
+		}
+	}
+	if pattern[0] == c {
+		return 1
+	}
+	return 0
+}
+
+// matchSet returns whether c is in the set of a [...] pattern, given without the brackets
+func matchSet(set string, c byte) bool {
+	negated := len(set) > 0 && set[0] == '^'
+	if negated {
+		set = set[1:]
+	}
+	found := false
+	for i := 0; i < len(set); i++ {
+		if i+2 < len(set) && set[i+1] == '-' {
+			if set[i] <= c && c <= set[i+2] {
+				found = true
+			}
+			i += 2
+		} else if set[i] == c {
+			found = true
+		}
+	}
+	return found != negated
+}
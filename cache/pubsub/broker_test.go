@@ -0,0 +1,210 @@
+package pubsub
+
+import (
+	"math/rand"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, channel string
+		want             bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"news", "news", true},
+		{"news", "new", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"news.*", "news.tech", true},
+		{"news.*", "news", false},
+		{"*.tech", "news.tech", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"a*b", "abab", true},
+		{"a*ab", "aab", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"h[]llo", "hallo", false},
+		{"h[llo", "h[llo", true},
+		{"h[llo", "hallo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{`a\`, `a\`, true},
+		{"__keyevent__:evict:*", "__keyevent__:evict:capacity", true},
+		{"__keyevent__:evict:*", "__keyevent__:add", false},
+	}
+	for _, test := range tests {
+		if got := Match(test.pattern, test.channel); got != test.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", test.pattern, test.channel, got, test.want)
+		}
+	}
+}
+
+// globRegexp translates a pattern without sets to an equivalent regular expression
+func globRegexp(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*':
+			expr.WriteString("(?s:.*)")
+		case '?':
+			expr.WriteString("(?s:.)")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			fallthrough
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
+
+func TestMatchRandom(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	generate := func(alphabet string, maxLength int) string {
+		b := make([]byte, random.Intn(maxLength+1))
+		for i := range b {
+			b[i] = alphabet[random.Intn(len(alphabet))]
+		}
+		return string(b)
+	}
+	for i := 0; i < 20000; i++ {
+		pattern, channel := generate(`ab*?\`, 8), generate("ab*?", 10)
+		if got, want := Match(pattern, channel), globRegexp(pattern).MatchString(channel); got != want {
+			t.Fatalf("Match(%q, %q) = %v, want %v", pattern, channel, got, want)
+		}
+	}
+}
+
+func TestMatchAdversarialPattern(t *testing.T) {
+	// a backtracking matcher takes exponential time on these patterns
+	pattern := strings.Repeat("*a", 30) + "*b"
+	channel := strings.Repeat("a", 10000)
+	start := time.Now()
+	if Match(pattern, channel) {
+		t.Errorf("Match(%q, a...) = true", pattern)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Match of an adversarial pattern took %v", elapsed)
+	}
+}
+
+// receive returns the next message of s, it fails the test when none arrives
+func receive(t *testing.T, s *Subscription) Message {
+	t.Helper()
+	select {
+	case message := <-s.Messages:
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no message received")
+		return Message{}
+	}
+}
+
+func TestSubscribeAndPublish(t *testing.T) {
+	b := NewBroker()
+	s := b.Subscribe(16, "news", "sport")
+	for i, channel := range []string{"news", "sport"} {
+		if message := receive(t, s); !reflect.DeepEqual(message, Message{Kind: KindSubscribe, Channel: channel, Count: i + 1}) {
+			t.Errorf("confirmation = %+v", message)
+		}
+	}
+	s.PSubscribe("news.*")
+	if message := receive(t, s); message.Kind != KindPSubscribe || message.Pattern != "news.*" || message.Count != 3 {
+		t.Errorf("pattern confirmation = %+v", message)
+	}
+	other := b.Subscribe(16, "news")
+	receive(t, other)
+	if b.NumSub("news") != 2 || b.NumPat() != 1 || s.Count() != 3 {
+		t.Errorf("NumSub, NumPat, Count = %v, %v, %v, want 2, 1, 3", b.NumSub("news"), b.NumPat(), s.Count())
+	}
+
+	if receivers, err := b.Publish("news", []byte("hello")); err != nil || receivers != 2 {
+		t.Errorf("Publish(news) = %v, %v, want 2 receivers", receivers, err)
+	}
+	if message := receive(t, s); message.Kind != KindMessage || message.Channel != "news" || string(message.Data) != "hello" {
+		t.Errorf("message = %+v", message)
+	}
+	receive(t, other)
+	if receivers, _ := b.Publish("news.tech", []byte("pattern")); receivers != 1 {
+		t.Errorf("Publish(news.tech) reached %v subscriptions, want 1", receivers)
+	}
+	if message := receive(t, s); message.Kind != KindPMessage || message.Pattern != "news.*" || message.Channel != "news.tech" {
+		t.Errorf("pattern message = %+v", message)
+	}
+	if receivers, _ := b.Publish("nobody", []byte("lost")); receivers != 0 {
+		t.Errorf("Publish(nobody) reached %v subscriptions", receivers)
+	}
+	if _, err := b.Publish(KeyspacePrefix+"k", nil); err != ErrReservedChannel {
+		t.Errorf("Publish to a reserved channel error = %v, want %v", err, ErrReservedChannel)
+	}
+
+	s.Unsubscribe("news")
+	if message := receive(t, s); !reflect.DeepEqual(message, Message{Kind: KindUnsubscribe, Channel: "news", Count: 2}) {
+		t.Errorf("unsubscribe confirmation = %+v", message)
+	}
+	s.Unsubscribe()
+	if message := receive(t, s); !reflect.DeepEqual(message, Message{Kind: KindUnsubscribe, Channel: "sport", Count: 1}) {
+		t.Errorf("unsubscribe all confirmation = %+v", message)
+	}
+	s.Unsubscribe()
+	if message := receive(t, s); !reflect.DeepEqual(message, Message{Kind: KindUnsubscribe, Count: 1}) {
+		t.Errorf("confirmation of unsubscribing without channels = %+v", message)
+	}
+	s.PUnsubscribe()
+	if message := receive(t, s); message.Kind != KindPUnsubscribe || message.Pattern != "news.*" || message.Count != 0 {
+		t.Errorf("punsubscribe confirmation = %+v", message)
+	}
+	if b.NumSub("news") != 1 || b.NumSub("sport") != 0 || b.NumPat() != 0 {
+		t.Errorf("NumSub, NumPat after unsubscribing = %v, %v, %v", b.NumSub("news"), b.NumSub("sport"), b.NumPat())
+	}
+
+	other.Close()
+	other.Close()
+	if _, open := <-other.Messages; open {
+		t.Errorf("Messages is open after Close")
+	}
+	if b.NumSub("news") != 0 {
+		t.Errorf("NumSub after Close = %v", b.NumSub("news"))
+	}
+}
+
+func TestDroppedMessages(t *testing.T) {
+	b := NewBroker()
+	s := b.Subscribe(2, "c")
+	receive(t, s)
+	for i := 0; i < 5; i++ {
+		b.Publish("c", []byte{byte(i)})
+	}
+	if dropped := s.Dropped(); dropped != 3 {
+		t.Errorf("Dropped = %v, want 3", dropped)
+	}
+	for i := 0; i < 2; i++ {
+		if message := receive(t, s); message.Data[0] != byte(i) {
+			t.Errorf("message %v = %+v", i, message)
+		}
+	}
+	// a full subscription does not count as a receiver
+	fast := b.Subscribe(16, "c")
+	receive(t, fast)
+	b.Publish("c", nil)
+	b.Publish("c", nil)
+	if receivers, _ := b.Publish("c", nil); receivers != 1 {
+		t.Errorf("Publish with one full subscription reached %v, want 1", receivers)
+	}
+}
@@ -0,0 +1,43 @@
+package pubsub
+
+import "gocache"
+
+const (
+	// KeyspacePrefix starts the channel of a key, the message is the event, for example "__keyspace__:user:1"
+	KeyspacePrefix = "__keyspace__:"
+	// KeyeventPrefix starts the channel of an event, the message is the key, for example "__keyevent__:evict:capacity"
+	KeyeventPrefix = "__keyevent__:"
+)
+
+// EventName returns the name of the keyspace event of mutation: "add", "update", "clear", or "evict:" followed by
+// the eviction reason, so "__keyevent__:evict:*" matches every eviction
+func EventName(kind gocache.MutationKind, reason gocache.EvictionReason) string {
+	if kind == gocache.MutationEvict {
+		return kind.String() + ":" + reason.String()
+	}
+	return kind.String()
+}
+
+// PublishKeyspaceEvents publishes the changes of the keys of c starting with prefix until stop is called, like the
+// keyspace notifications of Redis: every change is published to the channel of its key, with the event as message,
+// and to the channel of its event, with the key as message. Clear is only published to "__keyevent__:clear".
+// The changes are read from a watcher of c, so a burst of changes larger than its buffer is dropped rather than
+// slowing down the cache.
+func (b *Broker) PublishKeyspaceEvents(c *gocache.Cache, prefix []byte) (stop func()) {
+	w := c.Watch(prefix)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range w.Events {
+			name := EventName(event.Kind, event.Reason)
+			if event.Kind != gocache.MutationClear {
+				b.publish(KeyspacePrefix+string(event.Key), []byte(name))
+			}
+			b.publish(KeyeventPrefix+name, event.Key)
+		}
+	}()
+	return func() {
+		w.Close()
+		<-done
+	}
+}
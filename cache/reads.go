@@ -0,0 +1,106 @@
+package gocache
+
+import "sync/atomic"
+
+// Reads are buffered the way Ristretto and Caffeine buffer them, and applied to the cost structures in batches, but
+// the buffers are not per-P ring buffers: Go gives no access to the current P, and a ring buffer which drops reads
+// when full would make the reads counts, and the costs computed from them, approximate. A reader holding the shared
+// lock adds its read to an atomic counter of the entry, and the entry to a slice of the bucket guarded by readsMutex
+// on its first pending read, so the costs stay exact and a hot key costs one atomic add per read.
+const (
+	// maxPendingReads is the number of entries with pending reads after which a reader tries to apply the reads
+	maxPendingReads = 64
+	// maxEntryPendingReads is the number of pending reads of one entry after which a reader tries to apply the reads
+	maxEntryPendingReads = 1 << 10
+	// forcePendingReads is the factor of the limits above after which a reader waits for the exclusive lock to
+	// apply the reads, so they can not pile up under a steady stream of readers
+	forcePendingReads = 16
+)
+
+// recordRead counts a read of node while the caller holds the shared lock of the bucket. The
+// read is added to the pending reads of the entry, and the entry to the pending reads of the bucket on its first
+// pending read, so a hot entry is only appended once per batch. It returns the number of pending reads of the entry
+// and whether the reads should be applied.
+func (b *bucket) recordRead(node *Data) (uint32, bool) {
+	pending := atomic.AddUint32(&node.pendingReads, 1)
+	if pending == 1 {
+		b.readsMutex.Lock()
+		b.pendingReads = append(b.pendingReads, node)
+		full := len(b.pendingReads) >= maxPendingReads
+		b.readsMutex.Unlock()
+		return pending, full
+	}
+	return pending, pending >= maxEntryPendingReads
+}
+
+// getShared returns a copy of node, found by a reader holding the shared lock taken with timer, and records the
+// read. It releases the lock.
+func (b *bucket) getShared(node *Data, timer opTimer) (Data, error) {
+	pending, full := b.recordRead(node)
+	data := node.copyData()
+	data.reads = node.reads + int(pending)
+	b.runlock(timer)
+	atomic.AddUint64(&b.hits, 1)
+	if full {
+		b.flushReads(pending)
+	}
+	return data, nil
+}
+
+// flushReads applies the pending reads after a reader found them full, without waiting for the exclusive lock
+// unless they grew far past the limits, pending is the number of pending reads of the entry the reader read
+func (b *bucket) flushReads(pending uint32) {
+	if b.mutex.TryLock() {
+		b.applyReads()
+		b.mutex.Unlock()
+		return
+	}
+	b.readsMutex.Lock()
+	force := len(b.pendingReads) >= maxPendingReads*forcePendingReads
+	b.readsMutex.Unlock()
+	if force || pending >= maxEntryPendingReads*forcePendingReads {
+		// the lock is not timed: the reader already recorded its Get, the flush is not an operation of its own
+		b.mutex.Lock()
+		b.applyReads()
+		b.mutex.Unlock()
+	}
+}
+
+// applyReads adds the pending reads of the entries to their reads and moves them to the cost lists of their new
+// costs, as if every read had been applied by Get. Bucket must be locked by the caller, no reader can record a read,
+// readsMutex is only taken for the readers checking the number of pending reads in flushReads.
+func (b *bucket) applyReads() {
+	if len(b.pendingReads) == 0 {
+		return
+	}
+	b.readsMutex.Lock()
+	// the entries are still in the bucket: removing an entry takes the exclusive lock, which applies the reads
+	// first, and clearBucket drops the pending reads
+	for i, node := range b.pendingReads {
+		b.pendingReads[i] = nil
+		pending := node.pendingReads
+		node.pendingReads = 0
+		oldCost := (*node.costFunction)(*node)
+		node.reads += int(pending)
+		b.moveNode(node, oldCost, (*node.costFunction)(*node))
+	}
+	b.pendingReads = b.pendingReads[:0]
+	b.readsMutex.Unlock()
+}
+
+// copyData returns a copy of the entry without its links, with the pending reads counted. The caller must hold a lock
+// of the bucket of the entry.
+func (node *Data) copyData() Data {
+	return Data{
+		key:          node.key,
+		value:        node.value,
+		reads:        node.reads + int(atomic.LoadUint32(&node.pendingReads)),
+		updates:      node.updates,
+		version:      node.version,
+		dependents:   node.dependents,
+		costFunction: node.costFunction,
+		tags:         node.tags,
+		codec:        node.codec,
+		size:         node.size,
+	}
+}
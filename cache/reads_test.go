@@ -0,0 +1,125 @@
+package gocache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPendingReadsDecideEviction(t *testing.T) {
+	c := newTestCache(t, 2, 1)
+	c.Add([]byte("a"), []byte("1"), &FrequencyCost)
+	c.Add([]byte("b"), []byte("2"), &FrequencyCost)
+	for i := 0; i < 3; i++ {
+		c.Get([]byte("a"))
+	}
+	// the reads of a are still pending, they must be applied before choosing the entry to evict
+	c.Add([]byte("c"), []byte("3"), &FrequencyCost)
+	if _, err := c.Get([]byte("b")); err != ErrNotFound {
+		t.Errorf("b was not evicted")
+	}
+	data, err := c.Get([]byte("a"))
+	if err != nil {
+		t.Fatalf("the entry read 3 times was evicted")
+	}
+	if data.GetReads() != 4 {
+		t.Errorf("a has %v reads, want 4", data.GetReads())
+	}
+	checkCache(t, c)
+}
+
+func TestPendingReadsOfRemovedEntry(t *testing.T) {
+	c := newTestCache(t, 10, 1)
+	c.Add([]byte("a"), []byte("1"), &FrequencyCost)
+	c.Get([]byte("a"))
+	c.Clear()
+	c.Add([]byte("a"), []byte("1"), &FrequencyCost)
+	c.Get([]byte("a"))
+	c.Evict([]byte("a"))
+	c.Add([]byte("a"), []byte("1"), &FrequencyCost)
+	if data, _ := c.Get([]byte("a")); data.GetReads() != 1 {
+		t.Errorf("a has %v reads, want 1", data.GetReads())
+	}
+	checkCache(t, c)
+}
+
+// TestConcurrentReadsCounted has many goroutines read a few keys under the shared lock, no read may be lost
+func TestConcurrentReadsCounted(t *testing.T) {
+	goroutines, reads := 8, 5000
+	c := newTestCache(t, 100, 2)
+	for i := 0; i < 4; i++ {
+		c.Add(key(i), []byte("v"), &BalancedCost)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < reads; i++ {
+				if _, err := c.Get(key(i % 4)); err != nil {
+					t.Error(err)
+					return
+				}
+				if i%1000 == g {
+					// writers to the same buckets apply the pending reads
+					c.Add(key(10+g), []byte("v"), &BalancedCost)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	for i := 0; i < 4; i++ {
+		data, _ := c.Get(key(i))
+		if want := goroutines*reads/4 + 1; data.GetReads() != want {
+			t.Errorf("key %v has %v reads, want %v", i, data.GetReads(), want)
+		}
+	}
+	checkCache(t, c)
+	for i := range c.buckets {
+		b := &c.buckets[i]
+		timer := b.lock(OpGet)
+		for _, node := range b.entries {
+			if node.pendingReads != 0 {
+				t.Errorf("%q has %v pending reads after the bucket was locked", node.key, node.pendingReads)
+			}
+		}
+		b.unlock(timer)
+	}
+}
+
+// TestForcedFlushIsNotTimed has a reader force the flush of the pending reads, which must not be recorded as a Get
+func TestForcedFlushIsNotTimed(t *testing.T) {
+	c := newTestCache(t, 10, 1)
+	c.Add([]byte("a"), []byte("1"), &FrequencyCost)
+	c.EnableInstrumentation()
+	b := &c.buckets[0]
+	node := b.entries[getHash64([]byte("a"))]
+
+	// a shared lock held elsewhere makes the readers fail to take the exclusive lock until the reads pile up
+	b.mutex.RLock()
+	const reads = maxEntryPendingReads * forcePendingReads
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < reads; i++ {
+			c.Get([]byte("a"))
+		}
+		close(done)
+	}()
+	for atomic.LoadUint32(&node.pendingReads) < reads {
+		time.Sleep(time.Millisecond)
+	}
+	b.mutex.RUnlock()
+	<-done
+
+	b.mutex.RLock()
+	applied, pending := node.reads, atomic.LoadUint32(&node.pendingReads)
+	b.mutex.RUnlock()
+	if applied != reads || pending != 0 {
+		t.Errorf("%v reads applied and %v pending, want the %v reads applied by the last reader", applied, pending, reads)
+	}
+	get := c.Stats().Operations[OpGet]
+	if get.Wait.Count != reads || get.Latency.Count != reads {
+		t.Errorf("%v get waits and %v get latencies recorded, want one per Get: %v", get.Wait.Count, get.Latency.Count, reads)
+	}
+}
@@ -0,0 +1,312 @@
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"gocache"
+)
+
+// DefaultLogSize is the number of mutations a primary keeps for replicas to resume from
+const DefaultLogSize = 1 << 16
+
+// maxBatch is the number of mutations copied out of the log at once
+const maxBatch = 1024
+
+// ErrClosed is returned by Serve after Close
+var ErrClosed = errors.New("replication stopped")
+
+// ReplicaStatus describes a replica connected to a primary
+type ReplicaStatus struct {
+	Addr        string    `json:"addr"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	SentOffset  uint64    `json:"sentOffset"`  // offset of the next mutation to send
+	AckedOffset uint64    `json:"ackedOffset"` // offset of the next mutation the replica has not applied yet
+	LagOffsets  uint64    `json:"lagOffsets"`  // mutations recorded by the primary and not applied by the replica
+	FullSyncs   int       `json:"fullSyncs"`
+}
+
+// Primary records the mutations of a cache and streams them to the replicas connecting to it
+type Primary struct {
+	cache   *gocache.Cache
+	runID   uint64 // random id of this primary, offsets of another run mean nothing to it
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	log     []entry // ring buffer, the mutation of offset o is at o % len(log)
+	first   uint64  // oldest offset still in the log
+	next    uint64  // offset of the next mutation
+	closed  bool
+	cancel  func()
+	conns   map[*replicaConn]struct{}
+	servers map[net.Listener]struct{}
+}
+
+// replicaConn is the state of one connected replica, guarded by the mutex of the primary
+type replicaConn struct {
+	conn   net.Conn
+	status ReplicaStatus
+	done   bool
+}
+
+// NewPrimary starts recording the mutations of c, logSize is the number of mutations kept for replicas which
+// reconnect, 0 for DefaultLogSize
+func NewPrimary(c *gocache.Cache, logSize int) *Primary {
+	if logSize <= 0 {
+		logSize = DefaultLogSize
+	}
+	p := &Primary{
+		cache:   c,
+		runID:   newRunID(),
+		log:     make([]entry, logSize),
+		conns:   map[*replicaConn]struct{}{},
+		servers: map[net.Listener]struct{}{},
+	}
+	p.cond = sync.NewCond(&p.mutex)
+	p.cancel = c.OnMutation(p.record)
+	go p.heartbeat()
+	return p
+}
+
+// record appends a mutation to the log, it is called by the cache with the bucket locked
+func (p *Primary) record(mutation gocache.Mutation) {
+	p.mutex.Lock()
+	p.log[p.next%uint64(len(p.log))] = entry{p.next, time.Now().UnixNano(), mutation}
+	p.next++
+	if p.next-p.first > uint64(len(p.log)) {
+		p.first = p.next - uint64(len(p.log))
+	}
+	p.mutex.Unlock()
+	p.cond.Broadcast()
+}
+
+// heartbeat wakes the senders regularly so they can tell idle replicas the current offset
+func (p *Primary) heartbeat() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.mutex.Lock()
+		closed := p.closed
+		p.mutex.Unlock()
+		if closed {
+			return
+		}
+		p.cond.Broadcast()
+	}
+}
+
+// Offset returns the offset the next mutation will get
+func (p *Primary) Offset() uint64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.next
+}
+
+// Replicas returns the status of the connected replicas
+func (p *Primary) Replicas() []ReplicaStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	replicas := make([]ReplicaStatus, 0, len(p.conns))
+	for rc := range p.conns {
+		status := rc.status
+		status.LagOffsets = p.next - status.AckedOffset
+		replicas = append(replicas, status)
+	}
+	return replicas
+}
+
+// Serve accepts replicas on l until Close is called
+func (p *Primary) Serve(l net.Listener) error {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return ErrClosed
+	}
+	p.servers[l] = struct{}{}
+	p.mutex.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			p.mutex.Lock()
+			closed := p.closed
+			delete(p.servers, l)
+			p.mutex.Unlock()
+			if closed {
+				return ErrClosed
+			}
+			return err
+		}
+		go p.serveReplica(conn)
+	}
+}
+
+// Close stops recording mutations and disconnects the replicas
+func (p *Primary) Close() error {
+	p.cancel()
+	p.mutex.Lock()
+	p.closed = true
+	for l := range p.servers {
+		_ = l.Close()
+	}
+	for rc := range p.conns {
+		_ = rc.conn.Close()
+	}
+	p.mutex.Unlock()
+	p.cond.Broadcast()
+	return nil
+}
+
+func (p *Primary) serveReplica(conn net.Conn) {
+	defer conn.Close()
+	var handshake [4 + 8 + 8]byte
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(conn, handshake[:]); err != nil {
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	if !bytes.Equal(handshake[:4], handshakeMagic[:]) {
+		return
+	}
+	runID := binary.BigEndian.Uint64(handshake[4:])
+	offset := binary.BigEndian.Uint64(handshake[12:])
+
+	rc := &replicaConn{conn: conn, status: ReplicaStatus{Addr: conn.RemoteAddr().String(), ConnectedAt: time.Now()}}
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return
+	}
+	p.conns[rc] = struct{}{}
+	resume := runID == p.runID && offset >= p.first && offset <= p.next
+	p.mutex.Unlock()
+	defer func() {
+		p.mutex.Lock()
+		delete(p.conns, rc)
+		p.mutex.Unlock()
+	}()
+
+	go p.readAcks(rc)
+
+	w := bufio.NewWriter(conn)
+	if resume {
+		if writeFrame(w, frameResume, appendUint64(appendUint64(nil, p.runID), offset)) != nil || w.Flush() != nil {
+			return
+		}
+	} else {
+		var err error
+		if offset, err = p.fullSync(rc, w); err != nil || w.Flush() != nil {
+			return
+		}
+	}
+	p.stream(rc, w, offset)
+}
+
+// fullSync sends the offset of the first mutation the snapshot may not contain, then a snapshot of the cache in
+// snapshot frames, so a snapshot of any size is streamed without being held in memory
+func (p *Primary) fullSync(rc *replicaConn, w *bufio.Writer) (uint64, error) {
+	// mutations before offset were applied to the buckets before they are copied, mutations from offset on may or
+	// may not be in the snapshot and are streamed again, which is harmless as they are replayed in order
+	offset := p.Offset()
+	if err := writeFrame(w, frameFullSync, appendUint64(appendUint64(nil, p.runID), offset)); err != nil {
+		return offset, err
+	}
+	chunks := bufio.NewWriterSize(snapshotWriter{w}, snapshotChunkSize)
+	if err := p.cache.Snapshot(chunks); err != nil {
+		return offset, err
+	}
+	if err := chunks.Flush(); err != nil {
+		return offset, err
+	}
+	p.mutex.Lock()
+	rc.status.FullSyncs++
+	rc.status.SentOffset = offset
+	p.mutex.Unlock()
+	return offset, writeFrame(w, frameSnapshot, nil)
+}
+
+// stream sends the mutations from offset on until the replica disconnects or the primary is closed
+func (p *Primary) stream(rc *replicaConn, w *bufio.Writer, offset uint64) {
+	batch := make([]entry, 0, maxBatch)
+	lastWrite := time.Now()
+	for {
+		p.mutex.Lock()
+		for offset == p.next && !p.closed && !rc.done && time.Since(lastWrite) < heartbeatInterval {
+			p.cond.Wait()
+		}
+		if p.closed || rc.done {
+			p.mutex.Unlock()
+			return
+		}
+		fellBehind := offset < p.first
+		batch = batch[:0]
+		for ; !fellBehind && offset < p.next && len(batch) < maxBatch; offset++ {
+			batch = append(batch, p.log[offset%uint64(len(p.log))])
+		}
+		next := p.next
+		rc.status.SentOffset = offset
+		p.mutex.Unlock()
+
+		var err error
+		switch {
+		case fellBehind:
+			// the log wrapped around before the replica caught up, it needs a snapshot again
+			offset, err = p.fullSync(rc, w)
+		case len(batch) == 0:
+			err = writeFrame(w, frameHeartbeat, appendUint64(appendUint64(nil, next), uint64(time.Now().UnixNano())))
+		default:
+			for _, e := range batch {
+				payload := encodeMutation(e)
+				if len(payload) > maxFrameSize {
+					// the value is too large for a frame, the snapshot carries it in snapshot frames
+					offset, err = p.fullSync(rc, w)
+					break
+				}
+				if err = writeFrame(w, frameMutation, payload); err != nil {
+					break
+				}
+			}
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			return
+		}
+		lastWrite = time.Now()
+	}
+}
+
+// readAcks reads the offsets acknowledged by the replica until the connection fails
+func (p *Primary) readAcks(rc *replicaConn) {
+	r := bufio.NewReader(rc.conn)
+	var ack [8]byte
+	for {
+		if _, err := io.ReadFull(r, ack[:]); err != nil {
+			p.mutex.Lock()
+			rc.done = true
+			p.mutex.Unlock()
+			p.cond.Broadcast()
+			_ = rc.conn.Close()
+			return
+		}
+		p.mutex.Lock()
+		rc.status.AckedOffset = binary.BigEndian.Uint64(ack[:])
+		p.mutex.Unlock()
+	}
+}
+
+// newRunID returns a random id, so a replica reconnecting to a restarted primary does not resume at a stale offset
+func newRunID() uint64 {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return uint64(time.Now().UnixNano())
+	}
+	return binary.BigEndian.Uint64(id[:])
+}
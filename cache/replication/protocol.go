@@ -0,0 +1,193 @@
+// Package replication streams the mutations of a gocache cache from a primary to read-only replicas over TCP.
+//
+// The primary keeps the latest mutations in a log, every mutation gets the next offset. A replica connects with the
+// offset it wants next: when the log still holds it, the primary resumes streaming from there, otherwise it sends a
+// full snapshot of the cache first. A replica only serves reads until it is promoted.
+//
+// The log holds adds, updates, evictions and clears. It has no expiry records because entries of the cache have no
+// time to live, an entry only leaves the primary by an eviction, which is replicated with its reason.
+package replication
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"gocache"
+)
+
+// handshakeMagic starts the handshake of a replica, the last byte is the protocol version. Version 2 added the
+// tags to mutation frames and the tags to the snapshots of full syncs, version 3 split the snapshot of a full sync
+// in snapshot frames.
+var handshakeMagic = [4]byte{'G', 'C', 'R', 3}
+
+// Frames sent by the primary, every frame is its type, the length of its payload and the payload
+const (
+	frameFullSync  byte = 1 // run id, offset, followed by the snapshot in snapshot frames
+	frameResume    byte = 2 // run id, offset
+	frameMutation  byte = 3 // offset, timestamp, kind, reason, cost function name, key, value, tags
+	frameHeartbeat byte = 4 // offset of the next mutation, timestamp
+	frameSnapshot  byte = 5 // next bytes of the snapshot of a full sync, empty at the end of the snapshot
+)
+
+// maxFrameSize limits the payload of a frame, so a corrupted or hostile length can not make readFrame allocate up to
+// 4GB. Snapshot frames are much smaller, and a mutation too large for a frame is sent as a full sync instead.
+const maxFrameSize = 64 << 20
+
+// snapshotChunkSize is the size of the snapshot frames of a full sync
+const snapshotChunkSize = 64 << 10
+
+// heartbeatInterval is the time the primary waits before telling an idle replica its offset again
+const heartbeatInterval = time.Second
+
+var errFrameTooLarge = errors.New("replication frame too large")
+
+// entry is a mutation of the log
+type entry struct {
+	offset    uint64
+	timestamp int64 // unix nanoseconds at which the primary recorded the mutation
+	mutation  gocache.Mutation
+}
+
+// writeFrame writes a frame, it fails with errFrameTooLarge when the payload is larger than maxFrameSize
+func writeFrame(w *bufio.Writer, frameType byte, payload []byte) error {
+	if uint64(len(payload)) > maxFrameSize {
+		return errFrameTooLarge
+	}
+	var header [5]byte
+	header[0] = frameType
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readFrame reads a frame, it fails with errFrameTooLarge before allocating the payload when its length is larger
+// than maxFrameSize
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > maxFrameSize {
+		return 0, nil, errFrameTooLarge
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// snapshotWriter writes the bytes of a snapshot as snapshot frames
+type snapshotWriter struct {
+	w *bufio.Writer
+}
+
+func (sw snapshotWriter) Write(p []byte) (int, error) {
+	for n := 0; n < len(p); {
+		chunk := p[n:]
+		if len(chunk) > snapshotChunkSize {
+			chunk = chunk[:snapshotChunkSize]
+		}
+		if err := writeFrame(sw.w, frameSnapshot, chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+	}
+	return len(p), nil
+}
+
+func encodeMutation(e entry) []byte {
+	name, _ := gocache.CostFunctionName(e.mutation.CostFunction)
+	// the value of a compressed entry is decompressed here, by the sender, rather than under the lock of the bucket
+	value := e.mutation.GetValue()
+	payload := make([]byte, 0, 8+8+2+2+len(name)+4+len(e.mutation.Key)+4+len(value))
+	payload = appendUint64(payload, e.offset)
+	payload = appendUint64(payload, uint64(e.timestamp))
+	payload = append(payload, byte(e.mutation.Kind), byte(e.mutation.Reason))
+	payload = appendBytes(payload, []byte(name))
+	payload = appendBytes(payload, e.mutation.Key)
+	payload = appendBytes(payload, value)
+	var count [4]byte
+	binary.BigEndian.PutUint32(count[:], uint32(len(e.mutation.Tags)))
+	payload = append(payload, count[:]...)
+	for _, tag := range e.mutation.Tags {
+		payload = appendBytes(payload, []byte(tag))
+	}
+	return payload
+}
+
+func decodeMutation(payload []byte) (entry, string, error) {
+	var e entry
+	if len(payload) < 18 {
+		return e, "", errors.New("short mutation frame")
+	}
+	e.offset = binary.BigEndian.Uint64(payload)
+	e.timestamp = int64(binary.BigEndian.Uint64(payload[8:]))
+	e.mutation.Kind = gocache.MutationKind(payload[16])
+	e.mutation.Reason = gocache.EvictionReason(payload[17])
+	rest := payload[18:]
+	var fields [3][]byte
+	for i := range fields {
+		if len(rest) < 4 {
+			return e, "", errors.New("short mutation frame")
+		}
+		length := binary.BigEndian.Uint32(rest)
+		rest = rest[4:]
+		if uint64(len(rest)) < uint64(length) {
+			return e, "", errors.New("short mutation frame")
+		}
+		fields[i], rest = rest[:length], rest[length:]
+	}
+	e.mutation.Key = fields[1]
+	e.mutation.Value = fields[2]
+	if len(rest) < 4 {
+		return e, "", errors.New("short mutation frame")
+	}
+	count := binary.BigEndian.Uint32(rest)
+	rest = rest[4:]
+	for i := uint32(0); i < count; i++ {
+		if len(rest) < 4 {
+			return e, "", errors.New("short mutation frame")
+		}
+		length := binary.BigEndian.Uint32(rest)
+		rest = rest[4:]
+		if uint64(len(rest)) < uint64(length) {
+			return e, "", errors.New("short mutation frame")
+		}
+		e.mutation.Tags = append(e.mutation.Tags, string(rest[:length]))
+		rest = rest[length:]
+	}
+	return e, string(fields[0]), nil
+}
+
+func appendUint64(b []byte, x uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], x)
+	return append(b, buf[:]...)
+}
+
+func appendBytes(b []byte, field []byte) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], uint32(len(field)))
+	return append(append(b, buf[:]...), field...)
+}
+
+// readUint64s decodes the first n big endian uint64 of payload
+func readUint64s(payload []byte, n int) ([]uint64, error) {
+	if len(payload) < 8*n {
+		return nil, fmt.Errorf("short frame, %d bytes", len(payload))
+	}
+	values := make([]uint64, n)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint64(payload[8*i:])
+	}
+	return values, nil
+}
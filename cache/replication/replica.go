@@ -0,0 +1,302 @@
+package replication
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"gocache"
+)
+
+// Reconnection backoff of a replica
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 5 * time.Second
+)
+
+// ReplicaStats describes the replication state of a replica
+type ReplicaStats struct {
+	Connected     bool   `json:"connected"`
+	Offset        uint64 `json:"offset"`        // offset of the next mutation to apply
+	PrimaryOffset uint64 `json:"primaryOffset"` // offset of the next mutation of the primary, as last heard
+	LagOffsets    uint64 `json:"lagOffsets"`    // mutations of the primary not applied yet
+	// Delay is the time between the primary recording the last applied mutation and the replica applying it,
+	// it includes the difference between the clocks of the two hosts
+	Delay      time.Duration `json:"delay"`
+	FullSyncs  int           `json:"fullSyncs"`
+	Reconnects int           `json:"reconnects"`
+	LastError  string        `json:"lastError,omitempty"`
+}
+
+// Replica keeps a copy of the cache of a primary. It only serves reads until it is promoted.
+type Replica struct {
+	primaryAddr  string
+	cache        *gocache.Cache
+	costFunction *func(data gocache.Data) int
+	mutex        sync.Mutex
+	runID        uint64
+	stats        ReplicaStats
+	conn         net.Conn
+	stopped      bool
+	stop         chan struct{}
+	restoring    *restoring // full sync being received, only used by the goroutine of Run
+}
+
+// restoring is a full sync being received, the bytes of its snapshot frames are piped into Cache.Restore
+type restoring struct {
+	runID, offset uint64
+	snapshot      *io.PipeWriter
+	done          chan error
+}
+
+// NewReplica returns a replica of the primary at primaryAddr, keeping its copy in a cache initialized with capacity
+// and buckets. The capacity should be at least that of the primary, or the replica evicts entries the primary keeps.
+// Entries whose cost function is not one of the presets get costFun. Call Run to start replicating.
+func NewReplica(primaryAddr string, capacity, buckets int, costFun *func(data gocache.Data) int) *Replica {
+	r := &Replica{primaryAddr: primaryAddr, cache: &gocache.Cache{}, costFunction: costFun, stop: make(chan struct{})}
+	r.cache.Init(capacity, buckets)
+	return r
+}
+
+// Get reads k from the copy of the cache
+func (r *Replica) Get(k []byte) (gocache.Data, error) {
+	return r.cache.Get(k)
+}
+
+// GetEntriesCount returns the number of entries of the copy of the cache
+func (r *Replica) GetEntriesCount() uint64 {
+	return r.cache.GetEntriesCount()
+}
+
+// Stats returns the replication state of the replica
+func (r *Replica) Stats() ReplicaStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	stats := r.stats
+	if stats.PrimaryOffset > stats.Offset {
+		stats.LagOffsets = stats.PrimaryOffset - stats.Offset
+	}
+	return stats
+}
+
+// Run replicates the primary until Close or Promote is called, reconnecting with backoff when the connection fails.
+// After a reconnection it resumes from the offset it stopped at when the primary still has it in its log.
+func (r *Replica) Run() error {
+	backoff := minBackoff
+	for {
+		err := r.replicate()
+		r.mutex.Lock()
+		r.stats.Connected = false
+		if err != nil {
+			r.stats.LastError = err.Error()
+		}
+		stopped := r.stopped
+		if !stopped {
+			r.stats.Reconnects++
+		}
+		r.mutex.Unlock()
+		if stopped {
+			return nil
+		}
+		select {
+		case <-r.stop:
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// Promote stops replicating and returns the cache, which is from then on owned and written by the caller
+func (r *Replica) Promote() *gocache.Cache {
+	_ = r.Close()
+	return r.cache
+}
+
+// Close stops replicating, the replica keeps serving reads from its copy
+func (r *Replica) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.stopped {
+		r.stopped = true
+		close(r.stop)
+		if r.conn != nil {
+			_ = r.conn.Close()
+		}
+	}
+	return nil
+}
+
+// replicate runs one connection to the primary
+func (r *Replica) replicate() error {
+	conn, err := net.DialTimeout("tcp", r.primaryAddr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	r.mutex.Lock()
+	if r.stopped {
+		r.mutex.Unlock()
+		return nil
+	}
+	r.conn = conn
+	handshake := appendUint64(appendUint64(append([]byte{}, handshakeMagic[:]...), r.runID), r.stats.Offset)
+	r.mutex.Unlock()
+
+	if _, err := conn.Write(handshake); err != nil {
+		return err
+	}
+	defer r.abortRestore()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		frameType, payload, err := readFrame(reader)
+		if err != nil {
+			return err
+		}
+		if err := r.apply(frameType, payload); err != nil {
+			return err
+		}
+		// acknowledge once the frames received so far are applied
+		if reader.Buffered() == 0 {
+			r.mutex.Lock()
+			offset := r.stats.Offset
+			r.mutex.Unlock()
+			if _, err := writer.Write(appendUint64(nil, offset)); err != nil {
+				return err
+			}
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// apply applies one frame of the primary to the copy of the cache
+func (r *Replica) apply(frameType byte, payload []byte) error {
+	switch frameType {
+	case frameFullSync:
+		header, err := readUint64s(payload, 2)
+		if err != nil {
+			return err
+		}
+		r.abortRestore()
+		// the copy is incomplete until the snapshot is restored, a reconnection must not resume from it
+		r.mutex.Lock()
+		r.runID = 0
+		r.mutex.Unlock()
+		r.cache.Clear()
+		reader, writer := io.Pipe()
+		r.restoring = &restoring{runID: header[0], offset: header[1], snapshot: writer, done: make(chan error, 1)}
+		go func(done chan<- error) {
+			err := r.cache.Restore(reader, r.costFunction)
+			// the snapshot frames left after a failure fail instead of blocking
+			reader.CloseWithError(fmt.Errorf("restoring the snapshot of a full sync: %v", err))
+			done <- err
+		}(r.restoring.done)
+	case frameSnapshot:
+		if r.restoring == nil {
+			return errors.New("snapshot frame outside of a full sync")
+		}
+		if len(payload) > 0 {
+			_, err := r.restoring.snapshot.Write(payload)
+			return err
+		}
+		restored := r.restoring
+		r.restoring = nil
+		_ = restored.snapshot.Close()
+		if err := <-restored.done; err != nil {
+			return err
+		}
+		r.synced(restored.runID, restored.offset, true)
+	case frameResume:
+		header, err := readUint64s(payload, 2)
+		if err != nil {
+			return err
+		}
+		r.synced(header[0], header[1], false)
+	case frameHeartbeat:
+		header, err := readUint64s(payload, 2)
+		if err != nil {
+			return err
+		}
+		r.mutex.Lock()
+		r.stats.PrimaryOffset = header[0]
+		r.mutex.Unlock()
+	case frameMutation:
+		e, costName, err := decodeMutation(payload)
+		if err != nil {
+			return err
+		}
+		if err := r.applyMutation(e.mutation, costName); err != nil {
+			return err
+		}
+		r.mutex.Lock()
+		r.stats.Offset = e.offset + 1
+		if r.stats.Offset > r.stats.PrimaryOffset {
+			r.stats.PrimaryOffset = r.stats.Offset
+		}
+		r.stats.Delay = time.Since(time.Unix(0, e.timestamp))
+		r.mutex.Unlock()
+	default:
+		return fmt.Errorf("unknown replication frame %d", frameType)
+	}
+	return nil
+}
+
+// synced records the run id and the offset of the primary after a full sync or a resume
+func (r *Replica) synced(runID, offset uint64, fullSync bool) {
+	r.mutex.Lock()
+	r.runID, r.stats.Offset = runID, offset
+	if fullSync {
+		r.stats.FullSyncs++
+	}
+	r.stats.PrimaryOffset = offset
+	r.stats.Connected = true
+	r.stats.LastError = ""
+	r.mutex.Unlock()
+}
+
+// abortRestore stops the full sync being received, when the connection fails in the middle of it
+func (r *Replica) abortRestore() {
+	if r.restoring == nil {
+		return
+	}
+	r.restoring.snapshot.CloseWithError(io.ErrUnexpectedEOF)
+	<-r.restoring.done
+	r.restoring = nil
+}
+
+func (r *Replica) applyMutation(mutation gocache.Mutation, costName string) error {
+	switch mutation.Kind {
+	case gocache.MutationAdd:
+		costFun := r.costFunction
+		if costName != "" {
+			var err error
+			if costFun, err = gocache.CostFunction(costName); err != nil {
+				return err
+			}
+		}
+		if costFun == nil {
+			return fmt.Errorf("no cost function for key %q", mutation.Key)
+		}
+		return r.cache.AddWithTags(mutation.Key, mutation.Value, costFun, mutation.Tags...)
+	case gocache.MutationUpdate:
+		// the key may already be gone when the replica evicted it for capacity
+		_ = r.cache.Update(mutation.Key, mutation.Value)
+	case gocache.MutationEvict:
+		_ = r.cache.Evict(mutation.Key)
+	case gocache.MutationClear:
+		r.cache.Clear()
+	default:
+		return fmt.Errorf("unknown mutation kind %d", mutation.Kind)
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"gocache"
+)
+
+func newTestPrimary(t *testing.T, logSize int) (*gocache.Cache, *Primary, string) {
+	t.Helper()
+	c := &gocache.Cache{}
+	c.Init(10000, 8)
+	p := NewPrimary(c, logSize)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go p.Serve(l)
+	t.Cleanup(func() { p.Close() })
+	return c, p, l.Addr().String()
+}
+
+func startReplica(t *testing.T, addr string) *Replica {
+	t.Helper()
+	r := NewReplica(addr, 10000, 8, &gocache.SizeCost)
+	go r.Run()
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// waitFor fails the test when condition is still false after a few seconds
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !condition(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitForSync waits until r applied every mutation of p
+func waitForSync(t *testing.T, p *Primary, r *Replica) {
+	t.Helper()
+	waitFor(t, "the replica to catch up", func() bool {
+		stats := r.Stats()
+		return stats.Connected && stats.Offset == p.Offset()
+	})
+}
+
+// checkSameEntries fails the test when the entries of the replica differ from those of the primary
+func checkSameEntries(t *testing.T, primary *gocache.Cache, r *Replica) {
+	t.Helper()
+	if primary.GetEntriesCount() != r.GetEntriesCount() {
+		t.Fatalf("replica has %v entries, primary %v", r.GetEntriesCount(), primary.GetEntriesCount())
+	}
+	primary.Range(func(data gocache.Data) bool {
+		replicated, err := r.Get(data.GetKey())
+		if err != nil || !bytes.Equal(replicated.GetValue(), data.GetValue()) {
+			t.Fatalf("replica Get(%q) = %.20q, %v, want %.20q", data.GetKey(), replicated.GetValue(), err, data.GetValue())
+		}
+		return true
+	})
+}
+
+func TestFullSyncAndStreaming(t *testing.T) {
+	c, p, addr := newTestPrimary(t, 0)
+	for i := 0; i < 100; i++ {
+		c.AddWithTags([]byte(fmt.Sprintf("key%v", i)), []byte(fmt.Sprintf("value%v", i)), &gocache.FrequencyCost, "tag")
+	}
+	// a value spanning several snapshot frames
+	c.Add([]byte("large"), bytes.Repeat([]byte("0123456789"), 3*snapshotChunkSize/10), &gocache.SizeCost)
+
+	r := startReplica(t, addr)
+	waitForSync(t, p, r)
+	checkSameEntries(t, c, r)
+	if stats := r.Stats(); stats.FullSyncs != 1 {
+		t.Errorf("FullSyncs = %v, want 1", stats.FullSyncs)
+	}
+
+	c.Add([]byte("added"), []byte("v"), &gocache.SizeCost)
+	c.Update([]byte("key1"), []byte("updated"))
+	c.Evict([]byte("key2"))
+	c.InvalidateTag("tag")
+	c.Add([]byte("after"), []byte("v"), &gocache.SizeCost)
+	waitForSync(t, p, r)
+	checkSameEntries(t, c, r)
+
+	c.Clear()
+	c.Add([]byte("after clear"), []byte("v"), &gocache.SizeCost)
+	waitForSync(t, p, r)
+	checkSameEntries(t, c, r)
+}
+
+// proxy forwards connections to a primary, so tests can cut them and hold the acknowledgements of the replicas
+type proxy struct {
+	target   string
+	listener net.Listener
+	mutex    sync.Mutex
+	conns    []net.Conn
+	acks     sync.Mutex // held to hold the acknowledgements
+}
+
+func newProxy(t *testing.T, target string) *proxy {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	p := &proxy{target: target, listener: l}
+	t.Cleanup(func() {
+		l.Close()
+		p.cut()
+	})
+	go p.serve()
+	return p
+}
+
+func (p *proxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		upstream, err := net.Dial("tcp", p.target)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		p.mutex.Lock()
+		p.conns = append(p.conns, conn, upstream)
+		p.mutex.Unlock()
+		go func() {
+			io.Copy(conn, upstream)
+			conn.Close()
+		}()
+		go func() {
+			io.Copy(upstream, heldReader{conn, &p.acks})
+			upstream.Close()
+		}()
+	}
+}
+
+// cut closes the connections forwarded so far
+func (p *proxy) cut() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+	p.conns = nil
+}
+
+// heldReader does not return what it read while held is locked
+type heldReader struct {
+	r    io.Reader
+	held *sync.Mutex
+}
+
+func (h heldReader) Read(b []byte) (int, error) {
+	n, err := h.r.Read(b)
+	h.held.Lock()
+	h.held.Unlock()
+	return n, err
+}
+
+func TestResumeAfterDisconnect(t *testing.T) {
+	c, p, addr := newTestPrimary(t, 0)
+	proxy := newProxy(t, addr)
+	for i := 0; i < 10; i++ {
+		c.Add([]byte(fmt.Sprintf("key%v", i)), []byte("v"), &gocache.SizeCost)
+	}
+	r := startReplica(t, proxy.listener.Addr().String())
+	waitForSync(t, p, r)
+
+	proxy.cut()
+	waitFor(t, "the replica to disconnect", func() bool { return r.Stats().Reconnects > 0 })
+	for i := 10; i < 20; i++ {
+		c.Add([]byte(fmt.Sprintf("key%v", i)), []byte("v"), &gocache.SizeCost)
+	}
+	c.Evict([]byte("key0"))
+
+	waitForSync(t, p, r)
+	checkSameEntries(t, c, r)
+	if stats := r.Stats(); stats.FullSyncs != 1 {
+		t.Errorf("FullSyncs after resuming = %v, want 1", stats.FullSyncs)
+	}
+}
+
+func TestFullSyncWhenTheLogWrapped(t *testing.T) {
+	c, p, addr := newTestPrimary(t, 4)
+	proxy := newProxy(t, addr)
+	c.Add([]byte("first"), []byte("v"), &gocache.SizeCost)
+	r := startReplica(t, proxy.listener.Addr().String())
+	waitForSync(t, p, r)
+
+	proxy.cut()
+	waitFor(t, "the replica to disconnect", func() bool { return r.Stats().Reconnects > 0 })
+	for i := 0; i < 10; i++ {
+		c.Add([]byte(fmt.Sprintf("key%v", i)), []byte("v"), &gocache.SizeCost)
+	}
+
+	waitForSync(t, p, r)
+	checkSameEntries(t, c, r)
+	if stats := r.Stats(); stats.FullSyncs != 2 {
+		t.Errorf("FullSyncs after the log wrapped = %v, want 2", stats.FullSyncs)
+	}
+}
+
+func TestLag(t *testing.T) {
+	c, p, addr := newTestPrimary(t, 0)
+	proxy := newProxy(t, addr)
+	r := startReplica(t, proxy.listener.Addr().String())
+	waitForSync(t, p, r)
+
+	// the replica applies the mutations but the primary does not hear about it
+	proxy.acks.Lock()
+	for i := 0; i < 10; i++ {
+		c.Add([]byte(fmt.Sprintf("key%v", i)), []byte("v"), &gocache.SizeCost)
+	}
+	waitForSync(t, p, r)
+	replicas := p.Replicas()
+	if len(replicas) != 1 || replicas[0].LagOffsets == 0 || replicas[0].SentOffset != p.Offset() {
+		t.Errorf("Replicas with held acknowledgements = %+v, want a lag", replicas)
+	}
+
+	proxy.acks.Unlock()
+	waitFor(t, "the acknowledgements", func() bool {
+		replicas := p.Replicas()
+		return len(replicas) == 1 && replicas[0].LagOffsets == 0 && replicas[0].AckedOffset == p.Offset()
+	})
+	if stats := r.Stats(); stats.LagOffsets != 0 || stats.PrimaryOffset != p.Offset() {
+		t.Errorf("replica stats = %+v, want no lag at offset %v", stats, p.Offset())
+	}
+}
+
+func TestPromote(t *testing.T) {
+	c, p, addr := newTestPrimary(t, 0)
+	c.Add([]byte("k"), []byte("v"), &gocache.SizeCost)
+	r := NewReplica(addr, 10000, 8, &gocache.SizeCost)
+	ran := make(chan error)
+	go func() { ran <- r.Run() }()
+	waitForSync(t, p, r)
+
+	promoted := r.Promote()
+	if err := <-ran; err != nil {
+		t.Errorf("Run after Promote = %v", err)
+	}
+	c.Add([]byte("after"), []byte("v"), &gocache.SizeCost)
+	if err := promoted.Add([]byte("own"), []byte("v"), &gocache.SizeCost); err != nil {
+		t.Errorf("Add to the promoted cache: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := promoted.Get([]byte("k")); err != nil {
+		t.Errorf("Get of a replicated key after Promote = %v", err)
+	}
+	if _, err := promoted.Get([]byte("after")); err != gocache.ErrNotFound {
+		t.Errorf("Get of a key added to the primary after Promote = %v, want %v", err, gocache.ErrNotFound)
+	}
+	waitFor(t, "the primary to drop the replica", func() bool { return len(p.Replicas()) == 0 })
+}
+
+func TestCompressedValues(t *testing.T) {
+	c, p, addr := newTestPrimary(t, 0)
+	codec, err := gocache.NewCodec("flate")
+	if err != nil {
+		t.Fatalf("NewCodec: %v", err)
+	}
+	c.SetCompression(codec, 0)
+	value := bytes.Repeat([]byte(`{"name":"value"}`), 100)
+	c.Add([]byte("before"), value, &gocache.SizeCost)
+	r := startReplica(t, addr)
+	waitForSync(t, p, r)
+
+	// streamed mutations carry the values decompressed by the sender
+	c.Add([]byte("added"), value, &gocache.SizeCost)
+	c.Update([]byte("before"), append(value, '!'))
+	waitForSync(t, p, r)
+	checkSameEntries(t, c, r)
+}
+
+func TestFrameSizeLimit(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	if err := writeFrame(w, frameMutation, make([]byte, maxFrameSize+1)); err != errFrameTooLarge {
+		t.Errorf("writeFrame of a payload larger than maxFrameSize = %v, want %v", err, errFrameTooLarge)
+	}
+	if err := writeFrame(w, frameHeartbeat, []byte("payload")); err != nil {
+		t.Fatalf("writeFrame: %v", err)
+	}
+	w.Flush()
+	if frameType, payload, err := readFrame(bufio.NewReader(&buf)); err != nil || frameType != frameHeartbeat || string(payload) != "payload" {
+		t.Errorf("readFrame = %v, %q, %v, want the frame written", frameType, payload, err)
+	}
+
+	// the length is checked before the payload is allocated and read
+	header := []byte{frameMutation, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[1:], 1<<32-1)
+	if _, _, err := readFrame(bufio.NewReader(bytes.NewReader(header))); err != errFrameTooLarge {
+		t.Errorf("readFrame of a 4GB frame = %v, want %v", err, errFrameTooLarge)
+	}
+}
+
+func TestMutationLargerThanAFrame(t *testing.T) {
+	c, p, addr := newTestPrimary(t, 0)
+	c.Add([]byte("first"), []byte("v"), &gocache.SizeCost)
+	r := startReplica(t, addr)
+	waitForSync(t, p, r)
+
+	// the mutation does not fit in a frame, the replica gets it in a full sync
+	c.Add([]byte("huge"), bytes.Repeat([]byte("x"), maxFrameSize+1), &gocache.SizeCost)
+	c.Add([]byte("after"), []byte("v"), &gocache.SizeCost)
+	waitForSync(t, p, r)
+	checkSameEntries(t, c, r)
+	if stats := r.Stats(); stats.FullSyncs != 2 || stats.Reconnects != 0 {
+		t.Errorf("%v full syncs and %v reconnects, want 2 full syncs without reconnecting", stats.FullSyncs, stats.Reconnects)
+	}
+}
@@ -0,0 +1,209 @@
+package gocache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// snapshotMagic is written at the start of every snapshot, the last byte is the format version. Version 2 added
+// the tags of the entries, snapshots of version 1 are still restored.
+var snapshotMagic = []byte("GOCACHE\x02")
+
+const snapshotVersionWithoutTags = 1
+
+// maxSnapshotFieldSize is the maximum length of a key, value, cost function name or tag read by Restore
+const maxSnapshotFieldSize = 1 << 30
+
+const (
+	snapshotEnd   byte = 0
+	snapshotEntry byte = 1
+)
+
+// Snapshot writes all the entries of the cache to w. Buckets are copied one at a time under their read lock,
+// so the snapshot is consistent per bucket but not across buckets when the cache is modified concurrently.
+// Each entry keeps its reads, updates and the name of its cost function when it is one of the presets. Values are
+// written decompressed, so a snapshot can be restored whatever the compression of the cache restoring it.
+func (c *Cache) Snapshot(w io.Writer) error {
+	if c == nil || len(c.buckets) == 0 {
+		return ErrNotInitialized
+	}
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(snapshotMagic); err != nil {
+		return err
+	}
+	for i := 0; i < len(c.buckets); i++ {
+		for _, data := range c.buckets[i].copyEntries(nil) {
+			data, err := c.output(data)
+			if err != nil {
+				return err
+			}
+			if err := writeSnapshotEntry(bw, data); err != nil {
+				return err
+			}
+		}
+	}
+	if err := bw.WriteByte(snapshotEnd); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Restore reads a snapshot written by Snapshot and adds its entries to the cache. Entries which were stored with
+// a preset cost function get that preset back, all other entries get costFun. Values are compressed as set by
+// SetCompression.
+func (c *Cache) Restore(r io.Reader, costFun *func(data Data) int) error {
+	if c == nil || len(c.buckets) == 0 {
+		return ErrNotInitialized
+	}
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return err
+	}
+	version := magic[len(magic)-1]
+	if string(magic[:len(magic)-1]) != string(snapshotMagic[:len(snapshotMagic)-1]) ||
+		version < snapshotVersionWithoutTags || version > snapshotMagic[len(snapshotMagic)-1] {
+		return errors.New("not a gocache snapshot")
+	}
+	for {
+		marker, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if marker == snapshotEnd {
+			return nil
+		}
+		if marker != snapshotEntry {
+			return fmt.Errorf("corrupted snapshot, unexpected marker %d", marker)
+		}
+		node, err := readSnapshotEntry(br, costFun, version > snapshotVersionWithoutTags)
+		if err != nil {
+			return err
+		}
+		node.size = len(node.value)
+		if node.value, node.codec, err = c.compress(node.value); err != nil {
+			return err
+		}
+		h := getHash64(node.key)
+		if _, err := c.buckets[h%uint64(len(c.buckets))].addNodeToBucket(node, h); err != nil {
+			return err
+		}
+		c.applyDependencyWork()
+	}
+}
+
+func writeSnapshotEntry(w *bufio.Writer, data Data) error {
+	name, _ := CostFunctionName(data.costFunction)
+	if err := w.WriteByte(snapshotEntry); err != nil {
+		return err
+	}
+	for _, field := range [][]byte{data.key, data.value, []byte(name)} {
+		if err := writeUvarint(w, uint64(len(field))); err != nil {
+			return err
+		}
+		if _, err := w.Write(field); err != nil {
+			return err
+		}
+	}
+	if err := writeUvarint(w, uint64(data.reads)); err != nil {
+		return err
+	}
+	if err := writeUvarint(w, uint64(data.updates)); err != nil {
+		return err
+	}
+	if err := writeUvarint(w, uint64(len(data.tags))); err != nil {
+		return err
+	}
+	for _, tag := range data.tags {
+		if err := writeUvarint(w, uint64(len(tag))); err != nil {
+			return err
+		}
+		if _, err := w.WriteString(tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readSnapshotEntry(r *bufio.Reader, costFun *func(data Data) int, withTags bool) (*Data, error) {
+	var fields [3][]byte
+	for i := range fields {
+		field, err := readSnapshotBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = field
+	}
+	reads, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	updates, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	if withTags {
+		count, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < count; i++ {
+			tag, err := readSnapshotBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			tags = append(tags, string(tag))
+		}
+	}
+
+	entryCostFun := costFun
+	if len(fields[2]) > 0 {
+		if entryCostFun, err = CostFunction(string(fields[2])); err != nil {
+			return nil, err
+		}
+	}
+	if entryCostFun == nil {
+		return nil, fmt.Errorf("no cost function for key %q", fields[0])
+	}
+	return &Data{key: fields[0], value: fields[1], reads: int(reads), updates: int(updates), costFunction: entryCostFun, tags: tags}, nil
+}
+
+// readSnapshotBytes reads a field written after its length. Snapshots can come from untrusted clients, so the length
+// is bounded by maxSnapshotFieldSize and a long field is read in a buffer growing with the bytes actually read, a
+// corrupted length failing at the end of the input instead of allocating the length up front.
+func readSnapshotBytes(r *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > maxSnapshotFieldSize {
+		return nil, fmt.Errorf("corrupted snapshot, field of %d bytes is longer than %d", length, maxSnapshotFieldSize)
+	}
+	if length <= uint64(r.Size()) {
+		field := make([]byte, length)
+		if _, err := io.ReadFull(r, field); err != nil {
+			return nil, err
+		}
+		return field, nil
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeUvarint(w *bufio.Writer, x uint64) error {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	_, err := w.Write(buf[:n])
+	return err
+}
@@ -0,0 +1,59 @@
+package gocache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotRestoreLongValue(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	long := bytes.Repeat([]byte("0123456789"), 10000)
+	c.Add([]byte("long"), long, &SizeCost)
+	c.Add([]byte("short"), []byte("value"), &SizeCost)
+	var snapshot bytes.Buffer
+	if err := c.Snapshot(&snapshot); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	restored := newTestCache(t, 100, 4)
+	if err := restored.Restore(&snapshot, nil); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if data, err := restored.Get([]byte("long")); err != nil || !bytes.Equal(data.GetValue(), long) {
+		t.Errorf("Get(long) after Restore = %v bytes, %v, want %v bytes", len(data.GetValue()), err, len(long))
+	}
+	checkCache(t, restored)
+}
+
+func TestRestoreCorruptedLength(t *testing.T) {
+	entry := func(length uint64, data string) []byte {
+		b := append([]byte{}, snapshotMagic...)
+		b = append(b, snapshotEntry)
+		b = binary.AppendUvarint(b, length)
+		return append(b, data...)
+	}
+	tests := []struct {
+		name     string
+		snapshot []byte
+		wantErr  string
+	}{
+		{"too long", entry(1<<62, "key"), "longer than"},
+		{"truncated short", entry(10, "key"), io.ErrUnexpectedEOF.Error()},
+		{"truncated long", entry(1<<20, "key"), io.ErrUnexpectedEOF.Error()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestCache(t, 100, 4)
+			err := c.Restore(bytes.NewReader(test.snapshot), &SizeCost)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Restore = %v, want an error containing %q", err, test.wantErr)
+			}
+			if entries := c.GetEntriesCount(); entries != 0 {
+				t.Errorf("Restore of a corrupted snapshot added %v entries", entries)
+			}
+		})
+	}
+}
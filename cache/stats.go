@@ -0,0 +1,251 @@
+package gocache
+
+import "sync/atomic"
+
+// EvictionReason tells why an entry was removed from the cache
+type EvictionReason int
+
+const (
+	// EvictedByCapacity is the reason for the minimum cost entry removed to make room in a full bucket
+	EvictedByCapacity EvictionReason = iota
+	// EvictedByCollision is the reason for an entry replaced by a different key with the same hash
+	EvictedByCollision
+	// EvictedExplicitly is the reason for an entry removed by Evict
+	EvictedExplicitly
+	// EvictedByTag is the reason for an entry removed by InvalidateTag
+	EvictedByTag
+	// EvictedByDependency is the reason for an entry removed because an entry it depends on was removed or updated
+	EvictedByDependency
+	numEvictionReasons
+)
+
+// replaced is used internally when Add overwrites an entry with the same key, it is not counted as an eviction
+const replaced = numEvictionReasons
+
+var evictionReasonNames = [numEvictionReasons]string{"capacity", "collision", "explicit", "tag", "dependency"}
+
+func (reason EvictionReason) String() string {
+	if reason < 0 || reason >= numEvictionReasons {
+		return "unknown"
+	}
+	return evictionReasonNames[reason]
+}
+
+// EvictionReasons returns all the reasons an entry can be evicted for
+func EvictionReasons() []EvictionReason {
+	reasons := make([]EvictionReason, numEvictionReasons)
+	for i := range reasons {
+		reasons[i] = EvictionReason(i)
+	}
+	return reasons
+}
+
+// BucketStats holds the counters and the shape of the cost structures of a single bucket of the cache
+type BucketStats struct {
+	Index             int    `json:"index"`
+	Entries           uint64 `json:"entries"`
+	MaxEntries        uint64 `json:"maxEntries"`
+	Collisions        uint64 `json:"collisions"`
+	Bytes             uint64 `json:"bytes"`
+	RawBytes          uint64 `json:"rawBytes"` // bytes before compression
+	Hits              uint64 `json:"hits"`
+	Misses            uint64 `json:"misses"`
+	CapacityEvictions uint64 `json:"capacityEvictions"` // minimum cost entries evicted because this bucket was full
+	Contentions       uint64 `json:"contentions"`       // operations which had to wait for the bucket lock
+	TreeHeight        int    `json:"treeHeight"`        // height of the AVL tree of costs
+	CostLevels        int    `json:"costLevels"`        // number of distinct costs, which is the number of cost lists
+	MinCost           int    `json:"minCost"`           // cost of the entry which will be evicted next, 0 when the bucket is empty
+	MaxCost           int    `json:"maxCost"`
+	// Operations holds the lock wait and latency histograms of every operation, nil unless instrumentation is enabled
+	Operations []OperationStats `json:"operations,omitempty"`
+}
+
+// Stats is a snapshot of the counters of the cache, with totals over all the buckets and the breakdown per bucket
+type Stats struct {
+	Entries     uint64            `json:"entries"`
+	MaxEntries  uint64            `json:"maxEntries"`
+	Collisions  uint64            `json:"collisions"`
+	Bytes       uint64            `json:"bytes"`
+	RawBytes    uint64            `json:"rawBytes"` // bytes before compression, see SetCompression
+	Hits        uint64            `json:"hits"`
+	Misses      uint64            `json:"misses"`
+	Adds        uint64            `json:"adds"`
+	Updates     uint64            `json:"updates"`
+	Evictions   map[string]uint64 `json:"evictions"` // keyed by EvictionReason.String()
+	Contentions uint64            `json:"contentions"`
+	// MaxTreeHeight is the height of the tallest cost tree over all the buckets
+	MaxTreeHeight int `json:"maxTreeHeight"`
+	// Skew is entries of the most filled bucket over the mean entries per bucket, it is 1 when the hash spreads
+	// the keys evenly. Buckets have equal capacity, so a high skew means early capacity evictions in some buckets
+	// while the cache as a whole is not full.
+	Skew float64 `json:"skew"`
+	// CompressionRatio is RawBytes over Bytes, it is 1 when nothing is compressed or the cache is empty
+	CompressionRatio float64 `json:"compressionRatio"`
+	// Operations holds the histograms of every operation over all the buckets, nil unless instrumentation is enabled
+	Operations []OperationStats `json:"operations,omitempty"`
+	Buckets    []BucketStats    `json:"buckets,omitempty"`
+}
+
+// HitRatio returns hits over hits and misses, 0 when there was no Get
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// GetBucketsCount returns number of buckets in the cache
+func (c *Cache) GetBucketsCount() int {
+	return len(c.buckets)
+}
+
+// GetCapacity returns sum of the maximum number of entries over all the buckets in cache
+func (c *Cache) GetCapacity() uint64 {
+	if c.arena != nil {
+		return c.arena.GetCapacity()
+	}
+	var sum uint64 = 0
+	for i := 0; i < len(c.buckets); i++ {
+		sum += atomic.LoadUint64(&c.buckets[i].maxEntries)
+	}
+	return sum
+}
+
+// GetBucketsStats returns the counters of every bucket in the cache, indexed by bucket number.
+// Every bucket is read under its read lock, one bucket at a time.
+func (c *Cache) GetBucketsStats() []BucketStats {
+	stats := make([]BucketStats, len(c.buckets))
+	for i := 0; i < len(c.buckets); i++ {
+		stats[i] = c.buckets[i].stats()
+		stats[i].Index = i
+	}
+	return stats
+}
+
+// Stats returns the totals of the counters of the cache along with the stats of every bucket
+func (c *Cache) Stats() Stats {
+	stats := Stats{Buckets: c.GetBucketsStats(), Evictions: map[string]uint64{}}
+	var mostEntries uint64
+	for _, b := range stats.Buckets {
+		stats.Entries += b.Entries
+		stats.MaxEntries += b.MaxEntries
+		stats.Collisions += b.Collisions
+		stats.Bytes += b.Bytes
+		stats.RawBytes += b.RawBytes
+		stats.Hits += b.Hits
+		stats.Misses += b.Misses
+		stats.Contentions += b.Contentions
+		stats.MaxTreeHeight = max(stats.MaxTreeHeight, b.TreeHeight)
+		if b.Entries > mostEntries {
+			mostEntries = b.Entries
+		}
+	}
+	if c.arena != nil {
+		// an arena keeps only these counters
+		stats.Entries = c.arena.GetEntriesCount()
+		stats.MaxEntries = c.arena.GetCapacity()
+		stats.Collisions = c.arena.GetCollisionsCount()
+	}
+	stats.Adds = c.GetAddsCount()
+	stats.Updates = c.GetUpdatesCount()
+	for _, reason := range EvictionReasons() {
+		stats.Evictions[reason.String()] = c.GetEvictionsCount(reason)
+	}
+	var instrumentations []*bucketInstrumentation
+	for i := 0; i < len(c.buckets); i++ {
+		if instrumentation := c.buckets[i].loadInstrumentation(); instrumentation != nil {
+			instrumentations = append(instrumentations, instrumentation)
+		}
+	}
+	stats.Operations = operationStats(instrumentations)
+	if stats.Entries > 0 && len(stats.Buckets) > 0 {
+		stats.Skew = float64(mostEntries) / (float64(stats.Entries) / float64(len(stats.Buckets)))
+	}
+	stats.CompressionRatio = 1
+	if stats.Bytes > 0 {
+		stats.CompressionRatio = float64(stats.RawBytes) / float64(stats.Bytes)
+	}
+	return stats
+}
+
+func (b *bucket) stats() BucketStats {
+	b.mutex.RLock()
+	stats := BucketStats{
+		Entries:           atomic.LoadUint64(&b.entriesCount),
+		MaxEntries:        atomic.LoadUint64(&b.maxEntries),
+		Collisions:        atomic.LoadUint64(&b.collisions),
+		Bytes:             atomic.LoadUint64(&b.bytes),
+		RawBytes:          atomic.LoadUint64(&b.rawBytes),
+		Hits:              atomic.LoadUint64(&b.hits),
+		Misses:            atomic.LoadUint64(&b.misses),
+		CapacityEvictions: atomic.LoadUint64(&b.evictions[EvictedByCapacity]),
+		Contentions:       atomic.LoadUint64(&b.contentions),
+		TreeHeight:        height(b.costTree),
+		CostLevels:        len(b.costListsMap),
+	}
+	if minCostNode := findMinimum(b.costTree); minCostNode != nil {
+		stats.MinCost = minCostNode.cost
+		stats.MaxCost = findMaximum(b.costTree).cost
+	}
+	b.mutex.RUnlock()
+	if instrumentation := b.loadInstrumentation(); instrumentation != nil {
+		stats.Operations = operationStats([]*bucketInstrumentation{instrumentation})
+	}
+	return stats
+}
+
+// GetContentionsCount returns count of operations which had to wait for the lock of their bucket
+func (c *Cache) GetContentionsCount() uint64 {
+	return c.sumCounter(func(b *bucket) *uint64 { return &b.contentions })
+}
+
+// sumCounter returns the sum of the counter selected by field over all the buckets, it does not lock the buckets
+func (c *Cache) sumCounter(field func(b *bucket) *uint64) uint64 {
+	var sum uint64 = 0
+	for i := 0; i < len(c.buckets); i++ {
+		sum += atomic.LoadUint64(field(&c.buckets[i]))
+	}
+	return sum
+}
+
+// GetHitsCount returns count of Get calls which found the key
+func (c *Cache) GetHitsCount() uint64 {
+	return c.sumCounter(func(b *bucket) *uint64 { return &b.hits })
+}
+
+// GetMissesCount returns count of Get calls which did not find the key
+func (c *Cache) GetMissesCount() uint64 {
+	return c.sumCounter(func(b *bucket) *uint64 { return &b.misses })
+}
+
+// GetAddsCount returns count of entries added to the cache
+func (c *Cache) GetAddsCount() uint64 {
+	return c.sumCounter(func(b *bucket) *uint64 { return &b.adds })
+}
+
+// GetUpdatesCount returns count of entries updated in the cache
+func (c *Cache) GetUpdatesCount() uint64 {
+	return c.sumCounter(func(b *bucket) *uint64 { return &b.updates })
+}
+
+// GetEvictionsCount returns count of entries removed from the cache for reason
+func (c *Cache) GetEvictionsCount(reason EvictionReason) uint64 {
+	if reason < 0 || reason >= numEvictionReasons {
+		return 0
+	}
+	if c.arena != nil && reason == EvictedByCapacity {
+		return c.arena.GetEvictionsCount()
+	}
+	return c.sumCounter(func(b *bucket) *uint64 { return &b.evictions[reason] })
+}
+
+// GetBytesCount returns sum of the key and value lengths of all the entries in the cache, with the lengths of the
+// compressed values after compression
+func (c *Cache) GetBytesCount() uint64 {
+	return c.sumCounter(func(b *bucket) *uint64 { return &b.bytes })
+}
+
+// GetRawBytesCount returns sum of the key and value lengths of all the entries in the cache before compression
+func (c *Cache) GetRawBytesCount() uint64 {
+	return c.sumCounter(func(b *bucket) *uint64 { return &b.rawBytes })
+}
@@ -0,0 +1,95 @@
+package gocache
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	for i := 0; i < 10; i++ {
+		c.Add(key(i), []byte("value"), &ConstantCost)
+	}
+	c.Get(key(0))
+	c.Get([]byte("missing"))
+	c.Update(key(1), []byte("other"))
+	c.Evict(key(2))
+
+	stats := c.Stats()
+	if stats.Entries != 9 || stats.MaxEntries != c.GetCapacity() {
+		t.Errorf("Stats entries = %v of %v, want 9 of %v", stats.Entries, stats.MaxEntries, c.GetCapacity())
+	}
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Adds != 10 || stats.Updates != 1 {
+		t.Errorf("Stats hits, misses, adds, updates = %v, %v, %v, %v, want 1, 1, 10, 1",
+			stats.Hits, stats.Misses, stats.Adds, stats.Updates)
+	}
+	if evictions := stats.Evictions[EvictedExplicitly.String()]; evictions != 1 {
+		t.Errorf("Stats explicit evictions = %v, want 1", evictions)
+	}
+	if stats.Bytes != c.GetBytesCount() || stats.HitRatio() != 0.5 {
+		t.Errorf("Stats bytes = %v, hit ratio = %v, want %v and 0.5", stats.Bytes, stats.HitRatio(), c.GetBytesCount())
+	}
+	if len(stats.Buckets) != 4 {
+		t.Fatalf("Stats has %v buckets, want 4", len(stats.Buckets))
+	}
+	var entries uint64
+	for i, b := range stats.Buckets {
+		if b.Index != i {
+			t.Errorf("bucket %v has index %v", i, b.Index)
+		}
+		entries += b.Entries
+	}
+	if entries != stats.Entries {
+		t.Errorf("entries of the buckets sum to %v, want %v", entries, stats.Entries)
+	}
+}
+
+func TestBucketStatsCostStructures(t *testing.T) {
+	c := newTestCache(t, 100, 1)
+	if stats := c.GetBucketsStats()[0]; stats.TreeHeight != 0 || stats.CostLevels != 0 || stats.MinCost != 0 {
+		t.Errorf("empty bucket stats = %+v, want no tree", stats)
+	}
+
+	valueLength := func(data Data) int { return len(data.GetValue()) }
+	// seven distinct costs make a balanced tree of height 3, the two entries of cost 7 share a level
+	for i := 1; i <= 7; i++ {
+		c.Add(key(i), make([]byte, i), &valueLength)
+	}
+	c.Add(key(8), make([]byte, 7), &valueLength)
+
+	stats := c.GetBucketsStats()[0]
+	if stats.CostLevels != 7 || stats.TreeHeight != 3 {
+		t.Errorf("CostLevels, TreeHeight = %v, %v, want 7, 3", stats.CostLevels, stats.TreeHeight)
+	}
+	if stats.MinCost != 1 || stats.MaxCost != 7 {
+		t.Errorf("MinCost, MaxCost = %v, %v, want 1, 7", stats.MinCost, stats.MaxCost)
+	}
+	if height := c.Stats().MaxTreeHeight; height != 3 {
+		t.Errorf("MaxTreeHeight = %v, want 3", height)
+	}
+}
+
+func TestStatsContentions(t *testing.T) {
+	c := newTestCache(t, 100, 1)
+	b := &c.buckets[0]
+	b.mutex.Lock()
+	done := make(chan struct{})
+	go func() {
+		c.Add([]byte("k"), []byte("v"), &ConstantCost)
+		close(done)
+	}()
+	// the contention is counted before Add waits for the lock
+	for atomic.LoadUint64(&b.contentions) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	b.mutex.Unlock()
+	<-done
+
+	if contentions := c.Stats().Contentions; contentions != 1 {
+		t.Errorf("Stats contentions = %v, want 1", contentions)
+	}
+	if contentions := c.GetBucketsStats()[0].Contentions; contentions != 1 {
+		t.Errorf("bucket contentions = %v, want 1", contentions)
+	}
+}
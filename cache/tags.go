@@ -0,0 +1,106 @@
+package gocache
+
+// AddWithTags adds (k, v) to the cache like Add and tags the entry with tags, so InvalidateTag of any of them
+// evicts it. Tags are kept until the entry is removed: Update keeps them and Add of the same key replaces them.
+// Entries have no time to live, so the tag index has no cleanup on expiry, only on the removals of an entry.
+func (c *Cache) AddWithTags(k, v []byte, costFun *func(data Data) int, tags ...string) error {
+	if c == nil || len(c.buckets) == 0 {
+		return ErrNotInitialized
+	}
+	h := getHash64(k)
+	index := h % uint64(len(c.buckets))
+	span := c.startSpan(OpAdd, h, index)
+	evictions, err := c.buckets[index].addToBucket(k, v, h, costFun, uniqueTags(tags))
+	c.applyDependencyWork()
+	if span != nil {
+		span.SetInt(AttributeEvictions, int64(evictions))
+		span.End(err)
+	}
+	return err
+}
+
+// InvalidateTag evicts every entry tagged with tag and returns the number of evicted entries. Buckets are visited
+// one at a time, so an entry tagged concurrently may or may not be evicted. The evictions are counted and reported
+// to the mutation listeners with the reason EvictedByTag.
+func (c *Cache) InvalidateTag(tag string) int {
+	if c == nil {
+		return 0
+	}
+	span := c.startOpSpan(OpInvalidateTag)
+	evicted := 0
+	for i := 0; i < len(c.buckets); i++ {
+		evicted += c.buckets[i].invalidateTag(tag)
+	}
+	c.applyDependencyWork()
+	if span != nil {
+		span.SetInt(AttributeEvictions, int64(evicted))
+		span.End(nil)
+	}
+	return evicted
+}
+
+// GetTagsCount returns the number of distinct tags carried by the entries of the cache
+func (c *Cache) GetTagsCount() uint64 {
+	tags := map[string]struct{}{}
+	for i := 0; i < len(c.buckets); i++ {
+		b := &c.buckets[i]
+		b.mutex.RLock()
+		for tag := range b.tags {
+			tags[tag] = struct{}{}
+		}
+		b.mutex.RUnlock()
+	}
+	return uint64(len(tags))
+}
+
+func (b *bucket) invalidateTag(tag string) int {
+	timer := b.lock(OpInvalidateTag)
+	hashes := b.tags[tag]
+	evicted := len(hashes)
+	// removeEntry deletes from the set being ranged over, which is allowed for maps
+	for h := range hashes {
+		b.removeEntry(h, b.entries[h], EvictedByTag)
+	}
+	b.unlock(timer)
+	return evicted
+}
+
+// tagNode adds the entry node, stored under hash h, to the sets of its tags. Bucket must be locked by the caller.
+func (b *bucket) tagNode(h uint64, node *Data) {
+	for _, tag := range node.tags {
+		hashes, found := b.tags[tag]
+		if !found {
+			hashes = map[uint64]struct{}{}
+			b.tags[tag] = hashes
+		}
+		hashes[h] = struct{}{}
+	}
+}
+
+// untagNode removes the entry node, stored under hash h, from the sets of its tags, dropping the sets which
+// become empty. Bucket must be locked by the caller.
+func (b *bucket) untagNode(h uint64, node *Data) {
+	for _, tag := range node.tags {
+		hashes := b.tags[tag]
+		delete(hashes, h)
+		if len(hashes) == 0 {
+			delete(b.tags, tag)
+		}
+	}
+}
+
+// uniqueTags returns a copy of tags without duplicates, so the caller can reuse its slice
+func uniqueTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	unique := make([]string, 0, len(tags))
+	seen := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		if _, found := seen[tag]; !found {
+			seen[tag] = struct{}{}
+			unique = append(unique, tag)
+		}
+	}
+	return unique
+}
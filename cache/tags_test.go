@@ -0,0 +1,107 @@
+package gocache
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestInvalidateTag(t *testing.T) {
+	c := newTestCache(t, 1000, 8)
+	for i := 0; i < 100; i++ {
+		tags := []string{"all"}
+		if i%2 == 0 {
+			tags = append(tags, "even", "even")
+		}
+		if err := c.AddWithTags(key(i), []byte("v"), &SizeCost, tags...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Add([]byte("untagged"), []byte("v"), &SizeCost)
+	if n := c.GetTagsCount(); n != 2 {
+		t.Errorf("tags = %v, want 2", n)
+	}
+	if n := c.InvalidateTag("even"); n != 50 {
+		t.Errorf("InvalidateTag(even) = %v, want 50", n)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := c.Get(key(i)); (err == nil) != (i%2 == 1) {
+			t.Fatalf("Get(%s) after invalidation error = %v", key(i), err)
+		}
+	}
+	if n := c.GetEvictionsCount(EvictedByTag); n != 50 {
+		t.Errorf("tag evictions = %v, want 50", n)
+	}
+	if n := c.InvalidateTag("even"); n != 0 {
+		t.Errorf("second InvalidateTag(even) = %v, want 0", n)
+	}
+	checkCache(t, c)
+	if n := c.InvalidateTag("all"); n != 50 {
+		t.Errorf("InvalidateTag(all) = %v, want 50", n)
+	}
+	if c.GetEntriesCount() != 1 || c.GetTagsCount() != 0 {
+		t.Errorf("after invalidating every tag got %v entries and %v tags, want 1 and 0", c.GetEntriesCount(), c.GetTagsCount())
+	}
+	checkCache(t, c)
+}
+
+func TestTagsIndexCleanup(t *testing.T) {
+	tests := []struct {
+		name   string
+		remove func(c *Cache)
+	}{
+		{"evict", func(c *Cache) { c.Evict([]byte("k")) }},
+		{"overwrite", func(c *Cache) { c.Add([]byte("k"), []byte("v"), &SizeCost) }},
+		{"overwrite with other tags", func(c *Cache) { c.AddWithTags([]byte("k"), []byte("v"), &SizeCost, "other") }},
+		{"capacity eviction", func(c *Cache) {
+			c.Add([]byte("a"), []byte("long value"), &SizeCost)
+			c.Add([]byte("b"), []byte("long value"), &SizeCost)
+		}},
+		{"clear", func(c *Cache) { c.Clear() }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestCache(t, 2, 1)
+			c.AddWithTags([]byte("k"), []byte("v"), &SizeCost, "tag")
+			test.remove(c)
+			checkCache(t, c)
+			if n := c.InvalidateTag("tag"); n != 0 {
+				t.Errorf("InvalidateTag after the entry was removed evicted %v entries", n)
+			}
+		})
+	}
+}
+
+func TestTagsKeptByUpdateAndSnapshot(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	c.AddWithTags([]byte("k"), []byte("v"), &SizeCost, "a", "b")
+	c.Update([]byte("k"), []byte("new"))
+	if data, _ := c.Get([]byte("k")); len(data.GetTags()) != 2 {
+		t.Fatalf("tags after Update = %v, want [a b]", data.GetTags())
+	}
+
+	var snapshot bytes.Buffer
+	if err := c.Snapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	restored := newTestCache(t, 100, 4)
+	if err := restored.Restore(&snapshot, &SizeCost); err != nil {
+		t.Fatal(err)
+	}
+	checkCache(t, restored)
+	if n := restored.InvalidateTag("b"); n != 1 {
+		t.Errorf("InvalidateTag on the restored cache = %v, want 1", n)
+	}
+}
+
+func TestRestoreVersion1(t *testing.T) {
+	// a snapshot of the format without tags holding key "k", value "v", cost "size", 2 reads and 1 update
+	snapshot := append([]byte("GOCACHE\x01\x01\x01k\x01v\x04size\x02\x01"), 0)
+	c := newTestCache(t, 100, 4)
+	if err := c.Restore(bytes.NewReader(snapshot), nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.Get([]byte("k"))
+	if err != nil || string(data.GetValue()) != "v" || data.GetReads() != 3 || data.GetUpdates() != 1 {
+		t.Errorf("Get = %q reads %v updates %v, %v", data.GetValue(), data.GetReads(), data.GetUpdates(), err)
+	}
+}
@@ -0,0 +1,140 @@
+package gocache
+
+import (
+	"sync"
+	"time"
+)
+
+// Attributes set on the spans of the cache operations
+const (
+	AttributeKeyHash        = "gocache.key_hash"           // 64-bit FNV-1a hash of the key, every span of a single key
+	AttributeBucket         = "gocache.bucket"             // index of the bucket of the key, every span of a single key
+	AttributeHit            = "gocache.hit"                // whether Get and GetFunc found the key
+	AttributeEvictions      = "gocache.evictions"          // number of other entries Add evicted to make room, or InvalidateTag evicted
+	AttributeLoaderDuration = "gocache.loader_duration_ns" // time GetOrLoad spent loading a missing key in nanoseconds
+	AttributeKeys           = "gocache.keys"               // number of keys a transaction read or wrote
+)
+
+// Tracer starts a span for every operation of the cache. The cache does not depend on any tracing library,
+// an adapter implementing Tracer can forward the spans to OpenTelemetry or any other tracing system.
+type Tracer interface {
+	StartSpan(op Op) Span
+}
+
+// Span is one traced operation. The cache sets the attributes of the span while the operation runs and calls
+// End exactly once with the error returned by the operation.
+type Span interface {
+	SetInt(key string, value int64)
+	SetBool(key string, value bool)
+	End(err error)
+}
+
+// tracerHolder wraps the tracer so tracers of different types can be stored in the same atomic.Value
+type tracerHolder struct {
+	tracer Tracer
+}
+
+// SetTracer makes the cache emit a span for every operation to tracer: Add and AddWithTags, Get and GetOrLoad, Update,
+// Evict, GetFunc, InvalidateTag, and the commit of a Transaction, which starts once fn returned without error. A nil
+// tracer or NoopTracer disables tracing, which is the default, and then operations only check a nil pointer.
+func (c *Cache) SetTracer(tracer Tracer) {
+	if _, noop := tracer.(NoopTracer); noop || tracer == nil {
+		c.tracer.Store((*tracerHolder)(nil))
+		return
+	}
+	c.tracer.Store(&tracerHolder{tracer})
+}
+
+// startSpan starts the span of op on the key with hash h, it returns nil when tracing is disabled
+func (c *Cache) startSpan(op Op, h uint64, bucketIndex uint64) Span {
+	span := c.startOpSpan(op)
+	if span != nil {
+		span.SetInt(AttributeKeyHash, int64(h))
+		span.SetInt(AttributeBucket, int64(bucketIndex))
+	}
+	return span
+}
+
+// startOpSpan starts the span of op, an operation on several keys: InvalidateTag, or the commit of a transaction.
+// It returns nil when tracing is disabled.
+func (c *Cache) startOpSpan(op Op) Span {
+	holder, _ := c.tracer.Load().(*tracerHolder)
+	if holder == nil {
+		return nil
+	}
+	return holder.tracer.StartSpan(op)
+}
+
+// NoopTracer is a tracer which does not record anything
+type NoopTracer struct{}
+
+func (NoopTracer) StartSpan(op Op) Span {
+	return noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetInt(key string, value int64) {}
+func (noopSpan) SetBool(key string, value bool) {}
+func (noopSpan) End(err error)                  {}
+
+// RecordedSpan is a span kept in memory by RecordingTracer
+type RecordedSpan struct {
+	Op         Op
+	Start      time.Time
+	Duration   time.Duration
+	Attributes map[string]interface{}
+	Err        error
+}
+
+// RecordingTracer keeps every ended span in memory, it is meant for tests
+type RecordingTracer struct {
+	mutex sync.Mutex
+	spans []RecordedSpan
+}
+
+// NewRecordingTracer returns an empty recording tracer
+func NewRecordingTracer() *RecordingTracer {
+	return &RecordingTracer{}
+}
+
+func (t *RecordingTracer) StartSpan(op Op) Span {
+	return &recordingSpan{tracer: t, span: RecordedSpan{Op: op, Start: time.Now(), Attributes: map[string]interface{}{}}}
+}
+
+// Spans returns the ended spans in the order they ended
+func (t *RecordingTracer) Spans() []RecordedSpan {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	spans := make([]RecordedSpan, len(t.spans))
+	copy(spans, t.spans)
+	return spans
+}
+
+// Reset drops the recorded spans
+func (t *RecordingTracer) Reset() {
+	t.mutex.Lock()
+	t.spans = nil
+	t.mutex.Unlock()
+}
+
+type recordingSpan struct {
+	tracer *RecordingTracer
+	span   RecordedSpan
+}
+
+func (s *recordingSpan) SetInt(key string, value int64) {
+	s.span.Attributes[key] = value
+}
+
+func (s *recordingSpan) SetBool(key string, value bool) {
+	s.span.Attributes[key] = value
+}
+
+func (s *recordingSpan) End(err error) {
+	s.span.Duration = time.Since(s.span.Start)
+	s.span.Err = err
+	s.tracer.mutex.Lock()
+	s.tracer.spans = append(s.tracer.spans, s.span)
+	s.tracer.mutex.Unlock()
+}
@@ -0,0 +1,153 @@
+package gocache
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func newTracedCache(t *testing.T) (*Cache, *RecordingTracer) {
+	t.Helper()
+	c := newTestCache(t, 100, 4)
+	tracer := NewRecordingTracer()
+	c.SetTracer(tracer)
+	return c, tracer
+}
+
+// keyAttributes returns the attributes set on every span of an operation on k
+func keyAttributes(c *Cache, k []byte) map[string]interface{} {
+	h := getHash64(k)
+	return map[string]interface{}{
+		AttributeKeyHash: int64(h),
+		AttributeBucket:  int64(h % uint64(len(c.buckets))),
+	}
+}
+
+// with returns attributes with the given attributes added
+func with(attributes map[string]interface{}, more ...interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	for k, v := range attributes {
+		result[k] = v
+	}
+	for i := 0; i < len(more); i += 2 {
+		result[more[i].(string)] = more[i+1]
+	}
+	return result
+}
+
+func TestTracing(t *testing.T) {
+	c, tracer := newTracedCache(t)
+	k, missing := []byte("k"), []byte("missing")
+	loaderErr := errors.New("no value")
+
+	c.Add(k, []byte("v"), &ConstantCost)
+	c.Get(k)
+	c.Get(missing)
+	c.GetFunc(k, func([]byte) {})
+	c.GetOrLoad(missing, func(k []byte) ([]byte, error) { return nil, loaderErr }, &ConstantCost)
+	c.Update(k, []byte("other"))
+	c.Evict(missing)
+	c.AddWithTags([]byte("t1"), []byte("v"), &ConstantCost, "tag")
+	c.AddWithTags([]byte("t2"), []byte("v"), &ConstantCost, "tag")
+	c.InvalidateTag("tag")
+	c.Transaction(func(tx *Txn) error {
+		tx.Get(k)
+		return tx.Add([]byte("t3"), []byte("v"), &ConstantCost)
+	})
+
+	want := []struct {
+		op         Op
+		attributes map[string]interface{}
+		err        error
+	}{
+		{OpAdd, with(keyAttributes(c, k), AttributeEvictions, int64(0)), nil},
+		{OpGet, with(keyAttributes(c, k), AttributeHit, true), nil},
+		{OpGet, with(keyAttributes(c, missing), AttributeHit, false), ErrNotFound},
+		{OpGetFunc, with(keyAttributes(c, k), AttributeHit, true), nil},
+		{OpGet, with(keyAttributes(c, missing), AttributeHit, false), loaderErr},
+		{OpUpdate, keyAttributes(c, k), nil},
+		{OpEvict, keyAttributes(c, missing), ErrKeyNotExist},
+		{OpAdd, with(keyAttributes(c, []byte("t1")), AttributeEvictions, int64(0)), nil},
+		{OpAdd, with(keyAttributes(c, []byte("t2")), AttributeEvictions, int64(0)), nil},
+		{OpInvalidateTag, map[string]interface{}{AttributeEvictions: int64(2)}, nil},
+		{OpCommit, map[string]interface{}{AttributeKeys: int64(2)}, nil},
+	}
+	spans := tracer.Spans()
+	if len(spans) != len(want) {
+		t.Fatalf("recorded %v spans, want %v: %+v", len(spans), len(want), spans)
+	}
+	for i, span := range spans {
+		// the loader duration is the only attribute whose value is not known in advance
+		if duration, found := span.Attributes[AttributeLoaderDuration]; found {
+			if duration.(int64) < 0 {
+				t.Errorf("span %v has a negative loader duration %v", i, duration)
+			}
+			delete(span.Attributes, AttributeLoaderDuration)
+		} else if span.Op == OpGet && span.Err == loaderErr {
+			t.Errorf("span %v of GetOrLoad has no loader duration", i)
+		}
+		if span.Op != want[i].op || !reflect.DeepEqual(span.Attributes, want[i].attributes) || span.Err != want[i].err {
+			t.Errorf("span %v = %v %v %v, want %v %v %v", i, span.Op, span.Attributes, span.Err,
+				want[i].op, want[i].attributes, want[i].err)
+		}
+		if span.Start.IsZero() || span.Duration < 0 {
+			t.Errorf("span %v started at %v and lasted %v", i, span.Start, span.Duration)
+		}
+	}
+}
+
+func TestTracingSpanNames(t *testing.T) {
+	want := map[Op]string{OpAdd: "add", OpGet: "get", OpUpdate: "update", OpEvict: "evict", OpCommit: "commit",
+		OpGetFunc: "get_func", OpInvalidateTag: "invalidate_tag", numOps: "unknown"}
+	for op, name := range want {
+		if op.String() != name {
+			t.Errorf("Op(%d).String() = %q, want %q", op, op.String(), name)
+		}
+	}
+}
+
+func TestTracingTransactionErrors(t *testing.T) {
+	c, tracer := newTracedCache(t)
+	fnErr := errors.New("rolled back")
+	c.Transaction(func(tx *Txn) error {
+		tx.Add([]byte("k"), []byte("v"), &ConstantCost)
+		return fnErr
+	})
+	if spans := tracer.Spans(); len(spans) != 0 {
+		t.Errorf("a transaction which was not committed recorded %+v", spans)
+	}
+
+	c.Transaction(func(tx *Txn) error {
+		tx.Get([]byte("k"))
+		// a concurrent add of a key read by the transaction makes it conflict
+		c.Add([]byte("k"), []byte("v"), &ConstantCost)
+		return tx.Add([]byte("other"), []byte("v"), &ConstantCost)
+	})
+	spans := tracer.Spans()
+	if len(spans) != 2 || spans[1].Op != OpCommit || spans[1].Err != ErrTxnConflict {
+		t.Errorf("spans of a conflicting transaction = %+v, want an add then a commit failing with %v", spans, ErrTxnConflict)
+	}
+}
+
+func TestSetTracer(t *testing.T) {
+	c, tracer := newTracedCache(t)
+	c.Add([]byte("k"), []byte("v"), &ConstantCost)
+	tracer.Reset()
+	if spans := tracer.Spans(); len(spans) != 0 {
+		t.Errorf("spans after Reset = %+v", spans)
+	}
+	for _, disabled := range []Tracer{nil, NoopTracer{}} {
+		c.SetTracer(tracer)
+		c.SetTracer(disabled)
+		c.Get([]byte("k"))
+		c.InvalidateTag("tag")
+		if spans := tracer.Spans(); len(spans) != 0 {
+			t.Errorf("SetTracer(%T) kept recording %+v", disabled, spans)
+		}
+	}
+	// NoopTracer can be called without effect
+	span := NoopTracer{}.StartSpan(OpGet)
+	span.SetInt(AttributeBucket, 1)
+	span.SetBool(AttributeHit, true)
+	span.End(nil)
+}
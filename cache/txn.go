@@ -0,0 +1,295 @@
+package gocache
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+)
+
+// ErrTxnConflict is returned by Transaction when a key read, written or watched by the transaction changed before
+// the transaction committed
+var ErrTxnConflict = errors.New("transaction conflict, a key changed since the transaction read it")
+
+// ErrTxnTooLarge is returned by Transaction when the entries the transaction adds to a bucket can not fit without
+// evicting entries the transaction uses
+var ErrTxnTooLarge = errors.New("transaction adds more entries than its buckets can hold")
+
+// txnKey is a key used by a transaction: the version it had when the transaction first used it and the entry the
+// transaction sees, with the writes of the transaction applied
+type txnKey struct {
+	k       []byte
+	h       uint64
+	index   uint64
+	version uint64 // version of the entry when first used, 0 when the key was not in the cache
+	read    bool   // read by Get, the read is counted when committing
+	added   bool   // added by the transaction, so committing replaces the entry
+	changed bool   // written by the transaction
+	data    *Data  // the entry as seen by the transaction, nil when absent
+}
+
+// Txn is a transaction of Transaction. Get, Add, Update and Evict work on a private view of the cache in which the
+// writes of the transaction are visible, nothing is written to the cache before the transaction commits.
+// A Txn must only be used by the goroutine running the function given to Transaction.
+type Txn struct {
+	cache *Cache
+	keys  map[string]*txnKey
+	order []*txnKey // keys in the order the transaction first used them
+}
+
+// Transaction runs fn in a transaction and commits its writes all at once, or none of them when fn returns an
+// error, which is then returned as is.
+//
+// Keys are read optimistically: the first use of a key in the transaction records the version of its entry. To
+// commit, the buckets of all the keys used are locked in the order of their index, so concurrent transactions can
+// not deadlock, and the writes are applied only when none of the keys changed meanwhile, otherwise nothing is
+// written and ErrTxnConflict is returned, the transaction can then be run again. While the buckets are locked, no
+// other operation sees a part of the writes. Several writes of a key are committed as their final result.
+//
+// Entries added by the transaction evict other entries to make room like Add does, but never an entry the
+// transaction uses: when a bucket can not make room without evicting one, nothing is written and ErrTxnTooLarge is
+// returned. Evicting or updating an entry others depend on evicts them after the commit.
+func (c *Cache) Transaction(fn func(tx *Txn) error) error {
+	if c == nil || len(c.buckets) == 0 {
+		return ErrNotInitialized
+	}
+	tx := &Txn{cache: c, keys: map[string]*txnKey{}}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	span := c.startOpSpan(OpCommit)
+	err := tx.commit()
+	c.applyDependencyWork()
+	if span != nil {
+		span.SetInt(AttributeKeys, int64(len(tx.order)))
+		span.End(err)
+	}
+	return err
+}
+
+// key returns the state of k in the transaction, reading the entry of k from the cache on first use
+func (tx *Txn) key(k []byte) *txnKey {
+	if key, found := tx.keys[string(k)]; found {
+		return key
+	}
+	h := getHash64(k)
+	// the caller may reuse k once the call returns, the transaction keeps it until it commits
+	key := &txnKey{k: tx.cache.copyBytes(k), h: h, index: h % uint64(len(tx.cache.buckets))}
+	if data := tx.cache.buckets[key.index].peek(k, h); data != nil {
+		key.version, key.data = data.version, data
+	}
+	tx.keys[string(k)] = key
+	tx.order = append(tx.order, key)
+	return key
+}
+
+// Get returns the entry of k as seen by the transaction, ErrNotFound when it is absent
+func (tx *Txn) Get(k []byte) (Data, error) {
+	key := tx.key(k)
+	if key.data == nil {
+		return Data{}, ErrNotFound
+	}
+	if !key.changed {
+		key.read = true
+	}
+	return tx.cache.output(*key.data)
+}
+
+// Add adds (k, v) to the transaction, replacing the entry of k like Cache.Add
+func (tx *Txn) Add(k, v []byte, costFun *func(data Data) int) error {
+	return tx.AddWithTags(k, v, costFun)
+}
+
+// AddWithTags adds (k, v) with tags to the transaction like Cache.AddWithTags
+func (tx *Txn) AddWithTags(k, v []byte, costFun *func(data Data) int, tags ...string) error {
+	key := tx.key(k)
+	k, v, codec, size, err := tx.cache.prepare(k, v)
+	if err != nil {
+		return err
+	}
+	key.data = &Data{key: k, value: v, costFunction: costFun, tags: uniqueTags(tags), codec: codec, size: size}
+	key.added, key.changed = true, true
+	return nil
+}
+
+// Update replaces the value of k in the transaction, it returns ErrKeyNotExist when k is absent
+func (tx *Txn) Update(k, v []byte) error {
+	key := tx.key(k)
+	if key.data == nil {
+		return ErrKeyNotExist
+	}
+	value, codec, size, err := tx.cache.prepareValue(v)
+	if err != nil {
+		return err
+	}
+	data := *key.data
+	data.value, data.codec, data.size = value, codec, size
+	data.updates++
+	key.data, key.changed = &data, true
+	return nil
+}
+
+// Evict removes k from the transaction, it returns ErrKeyNotExist when k is absent
+func (tx *Txn) Evict(k []byte) error {
+	key := tx.key(k)
+	if key.data == nil {
+		return ErrKeyNotExist
+	}
+	key.data, key.changed = nil, true
+	return nil
+}
+
+// Watch makes the transaction conflict unless the entry of k has version when it commits, 0 standing for an absent
+// key, like the WATCH command of Redis for a version read earlier, for example by a client of a server. It returns
+// ErrTxnConflict right away when the version of k is already another one.
+func (tx *Txn) Watch(k []byte, version uint64) error {
+	if tx.key(k).version != version {
+		return ErrTxnConflict
+	}
+	return nil
+}
+
+// commit locks the buckets of the keys in the order of their index, checks that no key changed and that the
+// buckets can make room for the added entries without evicting a key of the transaction, and applies the writes:
+// evictions first, then updates and finally adds, which only evict entries the transaction does not use
+func (tx *Txn) commit() error {
+	if len(tx.order) == 0 {
+		return nil
+	}
+	indexes := make([]int, 0, len(tx.order))
+	locked := map[uint64]bool{}
+	for _, key := range tx.order {
+		if !locked[key.index] {
+			locked[key.index] = true
+			indexes = append(indexes, int(key.index))
+		}
+	}
+	sort.Ints(indexes)
+	timers := make([]opTimer, len(indexes))
+	for i, index := range indexes {
+		timers[i] = tx.cache.buckets[index].lock(OpCommit)
+	}
+	defer func() {
+		for i := len(indexes) - 1; i >= 0; i-- {
+			tx.cache.buckets[indexes[i]].unlock(timers[i])
+		}
+	}()
+
+	for _, key := range tx.order {
+		if tx.cache.buckets[key.index].version(key.k, key.h) != key.version {
+			return ErrTxnConflict
+		}
+	}
+	keep, err := tx.checkCapacity()
+	if err != nil {
+		return err
+	}
+	for _, key := range tx.order {
+		if key.read {
+			tx.cache.buckets[key.index].countRead(key.h)
+		}
+	}
+	for _, key := range tx.order {
+		if key.changed && key.data == nil && key.version != 0 {
+			tx.cache.buckets[key.index].deleteEntry(key.k, key.h, EvictedExplicitly)
+		}
+	}
+	for _, key := range tx.order {
+		if key.changed && key.data != nil && !key.added {
+			tx.cache.buckets[key.index].updateEntry(key.k, key.data, key.h)
+		}
+	}
+	for _, key := range tx.order {
+		if key.changed && key.data != nil && key.added {
+			tx.cache.buckets[key.index].addNodeKeeping(key.data, key.h, keep[key.index])
+		}
+	}
+	return nil
+}
+
+// checkCapacity returns the hashes of the keys of the transaction by bucket, which adds must not evict, or
+// ErrTxnTooLarge when a bucket has not enough other entries to evict for the entries the transaction adds. The
+// versions of the keys have been checked, so a key with a version is in the cache. Buckets must be locked by the
+// caller.
+func (tx *Txn) checkCapacity() (map[uint64]map[uint64]bool, error) {
+	keep := map[uint64]map[uint64]bool{}
+	// entries of the bucket after the writes of the transaction, entries it evicts and entries it keeps
+	entries, evicted, used := map[uint64]int{}, map[uint64]int{}, map[uint64]int{}
+	for _, key := range tx.order {
+		if keep[key.index] == nil {
+			keep[key.index] = map[uint64]bool{}
+			entries[key.index] = int(tx.cache.buckets[key.index].entriesCount)
+		}
+		keep[key.index][key.h] = true
+		switch {
+		case key.changed && key.data == nil && key.version != 0:
+			entries[key.index]--
+			evicted[key.index]++
+		case key.version != 0:
+			used[key.index]++
+		case key.changed && key.data != nil:
+			entries[key.index]++
+		}
+	}
+	for index, count := range entries {
+		b := &tx.cache.buckets[index]
+		evictions := count - int(b.maxEntries)
+		if evictions > 0 && evictions > int(b.entriesCount)-evicted[index]-used[index] {
+			return nil, ErrTxnTooLarge
+		}
+	}
+	return keep, nil
+}
+
+// peek returns a copy of the entry of k without counting a read, nil when k is not in the bucket
+func (b *bucket) peek(k []byte, h uint64) *Data {
+	if b.entries == nil {
+		return nil
+	}
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	value, exist := b.entries[h]
+	if !exist || string(value.key) != string(k) {
+		return nil
+	}
+	data := value.copyData()
+	return &data
+}
+
+// version returns the version of the entry of k, 0 when k is not in the bucket. Bucket must be locked by the caller.
+func (b *bucket) version(k []byte, h uint64) uint64 {
+	value, exist := b.entries[h]
+	if !exist || string(value.key) != string(k) {
+		return 0
+	}
+	return value.version
+}
+
+// countRead counts a read of the entry stored under hash h like Get does. Bucket must be locked by the caller.
+func (b *bucket) countRead(h uint64) {
+	value := b.entries[h]
+	oldCost := (*value.costFunction)(*value)
+	value.reads++
+	b.moveNode(value, oldCost, (*value.costFunction)(*value))
+	atomic.AddUint64(&b.hits, 1)
+}
+
+// minimumExcept returns the entry with the minimum cost, the oldest among entries of the same cost, whose hash is
+// not in keep, nil when every entry is kept. Bucket must be locked by the caller.
+func (b *bucket) minimumExcept(keep map[uint64]bool) *Data {
+	var visit func(node *costNode) *Data
+	visit = func(node *costNode) *Data {
+		if node == nil {
+			return nil
+		}
+		if found := visit(node.left); found != nil {
+			return found
+		}
+		for entry := b.costListsMap[node.cost].head; entry != nil; entry = entry.next {
+			if !keep[getHash64(entry.key)] {
+				return entry
+			}
+		}
+		return visit(node.right)
+	}
+	return visit(b.costTree)
+}
@@ -0,0 +1,265 @@
+package gocache
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestTransaction(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	c.Add([]byte("a"), []byte("1"), &SizeCost)
+	c.Add([]byte("b"), []byte("2"), &SizeCost)
+	err := c.Transaction(func(tx *Txn) error {
+		if err := tx.Update([]byte("a"), []byte("10")); err != nil {
+			return err
+		}
+		if err := tx.Evict([]byte("b")); err != nil {
+			return err
+		}
+		tx.Add([]byte("c"), []byte("3"), &SizeCost)
+		// the writes of the transaction are visible to it only
+		if data, err := tx.Get([]byte("a")); err != nil || string(data.GetValue()) != "10" {
+			t.Errorf("tx.Get(a) = %q, %v, want the written value", data.GetValue(), err)
+		}
+		if _, err := tx.Get([]byte("b")); err != ErrNotFound {
+			t.Errorf("tx.Get(b) error = %v, want %v", err, ErrNotFound)
+		}
+		if _, err := c.Get([]byte("c")); err != ErrNotFound {
+			t.Errorf("c is in the cache before the commit")
+		}
+		if err := tx.Update([]byte("missing"), []byte("v")); err != ErrKeyNotExist {
+			t.Errorf("tx.Update(missing) error = %v, want %v", err, ErrKeyNotExist)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a": "10", "c": "3"}
+	for k, v := range want {
+		if data, err := c.Get([]byte(k)); err != nil || string(data.GetValue()) != v {
+			t.Errorf("Get(%v) = %q, %v, want %q", k, data.GetValue(), err, v)
+		}
+	}
+	if _, err := c.Get([]byte("b")); err != ErrNotFound {
+		t.Errorf("b was not evicted")
+	}
+	checkCache(t, c)
+}
+
+func TestTransactionDoesNotEvictItsKeys(t *testing.T) {
+	valueLength := func(data Data) int { return len(data.GetValue()) }
+	c := newTestCache(t, 2, 1)
+	c.Add([]byte("a"), []byte("1"), &valueLength)
+	c.Add([]byte("b"), []byte("1234567890"), &valueLength)
+
+	// a has the lowest cost, the entry added by the transaction must evict b instead
+	err := c.Transaction(func(tx *Txn) error {
+		if err := tx.Update([]byte("a"), []byte("22")); err != nil {
+			return err
+		}
+		return tx.Add([]byte("n"), make([]byte, 21), &valueLength)
+	})
+	if err != nil {
+		t.Fatalf("Transaction = %v", err)
+	}
+	if data, err := c.Get([]byte("a")); err != nil || string(data.GetValue()) != "22" {
+		t.Errorf("Get(a) = %q, %v, want the updated value", data.GetValue(), err)
+	}
+	if _, err := c.Get([]byte("n")); err != nil {
+		t.Errorf("Get(n) = %v", err)
+	}
+	if _, err := c.Get([]byte("b")); err != ErrNotFound {
+		t.Errorf("Get(b) = %v, want %v", err, ErrNotFound)
+	}
+
+	// no entry is left to evict, so nothing is written
+	err = c.Transaction(func(tx *Txn) error {
+		tx.Update([]byte("a"), []byte("333"))
+		tx.Update([]byte("n"), []byte("4444"))
+		return tx.Add([]byte("other"), []byte("v"), &valueLength)
+	})
+	if err != ErrTxnTooLarge {
+		t.Errorf("Transaction adding to a bucket full of its keys = %v, want %v", err, ErrTxnTooLarge)
+	}
+	if data, _ := c.Get([]byte("a")); string(data.GetValue()) != "22" {
+		t.Errorf("Get(a) after a failed transaction = %q, want %q", data.GetValue(), "22")
+	}
+	if _, err := c.Get([]byte("other")); err != ErrNotFound {
+		t.Errorf("Get(other) after a failed transaction = %v, want %v", err, ErrNotFound)
+	}
+
+	// evicting a key of the transaction makes room
+	err = c.Transaction(func(tx *Txn) error {
+		tx.Evict([]byte("a"))
+		tx.Update([]byte("n"), []byte("4444"))
+		return tx.Add([]byte("other"), []byte("v"), &valueLength)
+	})
+	if err != nil || c.GetEntriesCount() != 2 {
+		t.Errorf("Transaction = %v with %v entries, want 2 entries", err, c.GetEntriesCount())
+	}
+	checkCache(t, c)
+}
+
+func TestTransactionCopiesKeys(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	c.Add([]byte("a"), []byte("1"), &SizeCost)
+	c.Add([]byte("b"), []byte("2"), &SizeCost)
+	err := c.Transaction(func(tx *Txn) error {
+		k := []byte("a")
+		err := tx.Evict(k)
+		// the caller reuses its buffer before the commit
+		k[0] = 'b'
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Transaction = %v", err)
+	}
+	if _, err := c.Get([]byte("a")); err != ErrNotFound {
+		t.Errorf("Get(a) = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := c.Get([]byte("b")); err != nil {
+		t.Errorf("Get(b) = %v, want the entry kept", err)
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	c.Add([]byte("a"), []byte("1"), &SizeCost)
+	failure := errors.New("failure")
+	err := c.Transaction(func(tx *Txn) error {
+		tx.Update([]byte("a"), []byte("2"))
+		tx.Add([]byte("b"), []byte("2"), &SizeCost)
+		return failure
+	})
+	if err != failure {
+		t.Errorf("Transaction error = %v, want %v", err, failure)
+	}
+	if data, _ := c.Get([]byte("a")); string(data.GetValue()) != "1" || data.GetUpdates() != 0 {
+		t.Errorf("a = %q with %v updates after the rollback", data.GetValue(), data.GetUpdates())
+	}
+	if _, err := c.Get([]byte("b")); err != ErrNotFound {
+		t.Errorf("b was added by the rolled back transaction")
+	}
+	checkCache(t, c)
+}
+
+func TestTransactionConflict(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Cache)
+	}{
+		{"update", func(c *Cache) { c.Update([]byte("a"), []byte("2")) }},
+		{"evict", func(c *Cache) { c.Evict([]byte("a")) }},
+		{"add", func(c *Cache) { c.Add([]byte("a"), []byte("1"), &SizeCost) }},
+		{"add of an absent key", func(c *Cache) { c.Add([]byte("b"), []byte("1"), &SizeCost) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestCache(t, 100, 4)
+			c.Add([]byte("a"), []byte("1"), &SizeCost)
+			err := c.Transaction(func(tx *Txn) error {
+				tx.Get([]byte("a"))
+				tx.Get([]byte("b"))
+				test.change(c)
+				return tx.Add([]byte("c"), []byte("3"), &SizeCost)
+			})
+			if err != ErrTxnConflict {
+				t.Errorf("Transaction error = %v, want %v", err, ErrTxnConflict)
+			}
+			if _, err := c.Get([]byte("c")); err != ErrNotFound {
+				t.Errorf("the conflicting transaction added c")
+			}
+		})
+	}
+}
+
+func TestTransactionWatch(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	c.Add([]byte("a"), []byte("1"), &SizeCost)
+	data, _ := c.Get([]byte("a"))
+	version := data.GetVersion()
+	if version == 0 {
+		t.Fatalf("entry has version 0")
+	}
+	if c.Transaction(func(tx *Txn) error { return tx.Watch([]byte("missing"), 0) }) != nil {
+		t.Errorf("watching an absent key with version 0 conflicts")
+	}
+	c.Update([]byte("a"), []byte("2"))
+	if data, _ := c.Get([]byte("a")); data.GetVersion() == version {
+		t.Errorf("Update did not change the version")
+	}
+	err := c.Transaction(func(tx *Txn) error {
+		if err := tx.Watch([]byte("a"), version); err != nil {
+			return err
+		}
+		return tx.Update([]byte("a"), []byte("3"))
+	})
+	if err != ErrTxnConflict {
+		t.Errorf("Transaction watching an old version error = %v, want %v", err, ErrTxnConflict)
+	}
+}
+
+// TestTransactionConcurrent moves amounts between accounts in concurrent transactions, retried on conflict, while
+// other transactions check that the total never changes
+func TestTransactionConcurrent(t *testing.T) {
+	accounts, total := 20, 20*100
+	c := newTestCache(t, 100, 8)
+	for i := 0; i < accounts; i++ {
+		c.Add(key(i), []byte("100"), &SizeCost)
+	}
+	balance := func(tx *Txn, k []byte) int {
+		data, err := tx.Get(k)
+		if err != nil {
+			t.Errorf("Get(%s): %v", k, err)
+		}
+		n, _ := strconv.Atoi(string(data.GetValue()))
+		return n
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < 500; i++ {
+				from, to, amount := key(r.Intn(accounts)), key(r.Intn(accounts)), r.Intn(10)
+				check := r.Intn(10) == 0
+				for {
+					sum := 0
+					err := c.Transaction(func(tx *Txn) error {
+						if check {
+							// the reads are only consistent when the transaction commits
+							for a := 0; a < accounts; a++ {
+								sum += balance(tx, key(a))
+							}
+							return nil
+						}
+						tx.Update(from, []byte(strconv.Itoa(balance(tx, from)-amount)))
+						return tx.Update(to, []byte(strconv.Itoa(balance(tx, to)+amount)))
+					})
+					if err != ErrTxnConflict {
+						if check && sum != total {
+							t.Errorf("accounts hold %v, want %v", sum, total)
+						}
+						break
+					}
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+	sum := 0
+	for i := 0; i < accounts; i++ {
+		data, _ := c.Get(key(i))
+		n, _ := strconv.Atoi(string(data.GetValue()))
+		sum += n
+	}
+	if sum != total {
+		t.Errorf("accounts hold %v, want %v", sum, total)
+	}
+	checkCache(t, c)
+}
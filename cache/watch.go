@@ -0,0 +1,89 @@
+package gocache
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultWatchBuffer is the number of events a watcher created by Watch buffers before dropping events
+const DefaultWatchBuffer = 1024
+
+// Event is a change of an entry delivered to a watcher. Value is set for MutationAdd and MutationUpdate, and for
+// MutationEvict with the value the entry had, Reason only for MutationEvict. A MutationClear event has no key and
+// is delivered to every watcher whatever its prefix. Key and Value are shared with the cache and must not be modified.
+// Like the Value of a Mutation, Value is nil when the entry is compressed, GetValue decompresses it.
+type Event struct {
+	Kind       MutationKind
+	Key        []byte
+	Value      []byte
+	Reason     EvictionReason
+	compressed []byte
+	codec      Codec
+}
+
+// GetValue returns the value of the entry, decompressed when the entry is compressed, see Mutation.GetValue
+func (event Event) GetValue() []byte {
+	return decompressed(event.Value, event.compressed, event.codec)
+}
+
+// Watcher receives the events of the keys starting with a prefix on Events until Close is called
+type Watcher struct {
+	Events  <-chan Event
+	events  chan Event
+	prefix  []byte
+	dropped uint64
+	mutex   sync.RWMutex // held for reading while sending, so Close never closes events under a send
+	closed  bool
+	cancel  func()
+}
+
+// Watch returns a watcher of the changes of the keys starting with prefix, an empty prefix watches every key.
+// Events are sent without blocking from the mutation listener of the cache: when the DefaultWatchBuffer events of
+// the watcher are not read in time, the next events are dropped and counted by Dropped, so a slow watcher never
+// slows down the cache. The events of a key are received in the order the changes happened. There are no expire
+// events: entries of the cache have no time to live, they only leave it by an eviction, reported with its reason.
+func (c *Cache) Watch(prefix []byte) *Watcher {
+	return c.WatchBuffer(prefix, DefaultWatchBuffer)
+}
+
+// WatchBuffer returns a watcher like Watch buffering size events
+func (c *Cache) WatchBuffer(prefix []byte, size int) *Watcher {
+	events := make(chan Event, max(size, 0))
+	w := &Watcher{Events: events, events: events, prefix: append([]byte{}, prefix...)}
+	w.cancel = c.OnMutation(w.send)
+	return w
+}
+
+// send delivers mutation to the watcher when it matches the prefix, or counts it as dropped when the buffer is full
+func (w *Watcher) send(mutation Mutation) {
+	if mutation.Kind != MutationClear && !bytes.HasPrefix(mutation.Key, w.prefix) {
+		return
+	}
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.events <- Event{Kind: mutation.Kind, Key: mutation.Key, Value: mutation.Value, Reason: mutation.Reason, compressed: mutation.compressed, codec: mutation.codec}:
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+	}
+}
+
+// Dropped returns the number of events dropped because the buffer of the watcher was full
+func (w *Watcher) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// Close stops the watcher and closes Events once the buffered events are read, it can be called more than once
+func (w *Watcher) Close() {
+	w.cancel()
+	w.mutex.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.events)
+	}
+	w.mutex.Unlock()
+}
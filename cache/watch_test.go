@@ -0,0 +1,106 @@
+package gocache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// receive reads the buffered events of w without waiting
+func receive(w *Watcher) []Event {
+	var events []Event
+	for {
+		select {
+		case event := <-w.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	c := newTestCache(t, 10, 1)
+	w := c.Watch([]byte("user:"))
+	defer w.Close()
+	c.Add([]byte("user:1"), []byte("a"), &ConstantCost)
+	c.Add([]byte("order:1"), []byte("b"), &ConstantCost)
+	c.Update([]byte("user:1"), []byte("c"))
+	c.Add([]byte("user:2"), []byte("d"), &ConstantCost)
+	c.Add([]byte("user:3"), []byte("e"), &ConstantCost)
+	c.Evict([]byte("user:3"))
+	c.Clear()
+
+	events := receive(w)
+	want := []string{
+		"add user:1 a", "update user:1 c", "add user:2 d", "add user:3 e", "evict user:3 e explicit", "clear  ",
+	}
+	if len(events) != len(want) {
+		t.Fatalf("received %v events, want %v: %v", len(events), len(want), events)
+	}
+	for i, event := range events {
+		got := fmt.Sprintf("%v %s %s", event.Kind, event.Key, event.Value)
+		if event.Kind == MutationEvict {
+			got += " " + event.Reason.String()
+		}
+		if got != want[i] {
+			t.Errorf("event %v = %q, want %q", i, got, want[i])
+		}
+	}
+}
+
+func TestWatchEvictionReason(t *testing.T) {
+	c := newTestCache(t, 1, 1)
+	w := c.Watch(nil)
+	defer w.Close()
+	c.Add([]byte("a"), []byte("1"), &ConstantCost)
+	c.Add([]byte("b"), []byte("2"), &ConstantCost)
+	events := receive(w)
+	if len(events) != 3 || events[1].Kind != MutationEvict || string(events[1].Key) != "a" || events[1].Reason != EvictedByCapacity {
+		t.Errorf("events = %v, want a evicted by capacity between the adds", events)
+	}
+}
+
+func TestWatchDrops(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	w := c.WatchBuffer(nil, 3)
+	for i := 0; i < 10; i++ {
+		c.Add(key(i), []byte("v"), &ConstantCost)
+	}
+	if n := len(receive(w)); n != 3 {
+		t.Errorf("received %v events, want 3", n)
+	}
+	if w.Dropped() != 7 {
+		t.Errorf("Dropped = %v, want 7", w.Dropped())
+	}
+	w.Close()
+	w.Close()
+	c.Add(key(0), []byte("v"), &ConstantCost)
+	if _, open := <-w.Events; open {
+		t.Errorf("Events is open after Close")
+	}
+}
+
+func TestWatchCloseConcurrent(t *testing.T) {
+	c := newTestCache(t, 100, 4)
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				c.Add(key(i%200), []byte("v"), &ConstantCost)
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		w := c.WatchBuffer(nil, 1)
+		w.Close()
+	}
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gocache"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// benchConfig holds the flags of the bench command
+type benchConfig struct {
+	capacity    int
+	buckets     int
+	keys        int
+	dist        string
+	zipfS       float64
+	hotFraction float64
+	hotOps      float64
+	readRatio   float64
+	valueDist   string
+	valueSize   int
+	valueMax    int
+	goroutines  int
+	duration    time.Duration
+	cost        string
+	fillOnMiss  bool
+	prefill     bool
+	seed        int64
+	json        bool
+}
+
+// benchReport is the result of a bench run, it is printed as text or as JSON with -json
+type benchReport struct {
+	Config struct {
+		Capacity     int     `json:"capacity"`
+		Buckets      int     `json:"buckets"`
+		Keys         int     `json:"keys"`
+		Distribution string  `json:"distribution"`
+		ReadRatio    float64 `json:"readRatio"`
+		ValueDist    string  `json:"valueDistribution"`
+		Goroutines   int     `json:"goroutines"`
+		Cost         string  `json:"cost"`
+	} `json:"config"`
+	Duration   time.Duration                        `json:"duration"`
+	Operations uint64                               `json:"operations"`
+	Throughput float64                              `json:"throughput"` // operations per second
+	Errors     uint64                               `json:"errors"`
+	HitRatio   float64                              `json:"hitRatio"`
+	Latency    map[string]gocache.HistogramSnapshot `json:"latency"` // keyed by read and write, a read includes the fill on a miss
+	Cache      gocache.Stats                        `json:"cache"`
+}
+
+// benchWorker holds the per goroutine state of a run, nothing in it is shared so the workers never contend
+// outside of the cache
+type benchWorker struct {
+	keys    keyGenerator
+	sizer   valueSizer
+	rand    *rand.Rand
+	latency [2]gocache.Histogram // indexed by opRead and opWrite
+	ops     uint64
+	errors  uint64
+	hits    uint64
+	misses  uint64
+}
+
+const (
+	opRead = iota
+	opWrite
+)
+
+func runBench(args []string) error {
+	var cfg benchConfig
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	flags.IntVar(&cfg.capacity, "capacity", 100000, "capacity of the cache in entries")
+	flags.IntVar(&cfg.buckets, "buckets", 0, "number of buckets, 0 for the default of 512")
+	flags.IntVar(&cfg.keys, "keys", 200000, "number of distinct keys in the workload")
+	flags.StringVar(&cfg.dist, "dist", "zipfian", "key distribution: uniform, zipfian, hotspot or sequential")
+	flags.Float64Var(&cfg.zipfS, "zipf-s", 1.1, "exponent of the zipfian distribution, greater than 1")
+	flags.Float64Var(&cfg.hotFraction, "hot-fraction", 0.2, "fraction of the keys that are hot in the hotspot distribution")
+	flags.Float64Var(&cfg.hotOps, "hot-ops", 0.8, "fraction of the operations that go to the hot keys in the hotspot distribution")
+	flags.Float64Var(&cfg.readRatio, "read-ratio", 0.9, "fraction of the operations that are reads, the others are writes")
+	flags.StringVar(&cfg.valueDist, "value-dist", "fixed", "value size distribution: fixed, uniform or exponential")
+	flags.IntVar(&cfg.valueSize, "value-size", 128, "value size in bytes, the minimum for uniform and the mean for exponential")
+	flags.IntVar(&cfg.valueMax, "value-max", 1024, "maximum value size in bytes for uniform and exponential")
+	flags.IntVar(&cfg.goroutines, "goroutines", 8, "number of concurrent goroutines")
+	flags.DurationVar(&cfg.duration, "duration", 10*time.Second, "duration of the run")
+	flags.StringVar(&cfg.cost, "cost", "balanced", "cost function preset: size, frequency, balanced or constant")
+	flags.BoolVar(&cfg.fillOnMiss, "fill-on-miss", true, "add the key after a read miss, like a read-through cache")
+	flags.BoolVar(&cfg.prefill, "prefill", true, "add every key before the run, up to the capacity")
+	flags.Int64Var(&cfg.seed, "seed", 1, "seed of the random generators")
+	flags.BoolVar(&cfg.json, "json", false, "print the report as JSON")
+	flags.Parse(args)
+
+	report, err := bench(cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	printBenchReport(report)
+	return nil
+}
+
+func bench(cfg benchConfig) (benchReport, error) {
+	var report benchReport
+	if cfg.keys < 1 || cfg.goroutines < 1 || cfg.duration <= 0 {
+		return report, fmt.Errorf("keys, goroutines and duration must be positive")
+	}
+	if cfg.readRatio < 0 || cfg.readRatio > 1 {
+		return report, fmt.Errorf("read ratio must be between 0 and 1, got %v", cfg.readRatio)
+	}
+	buckets := cfg.buckets
+	if buckets == 0 {
+		buckets = 512
+	}
+	if cfg.capacity < 1 || buckets < 1 || buckets > 1024 || cfg.capacity > buckets*2000 {
+		return report, fmt.Errorf("capacity must be between 1 and buckets times 2000, with at most 1024 buckets")
+	}
+	costFun, err := gocache.CostFunction(cfg.cost)
+	if err != nil {
+		return report, err
+	}
+
+	// keys and values are built before the run so the timings hold the cache operations only
+	keys := make([][]byte, cfg.keys)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key%v", i))
+	}
+	values := make([]byte, cfg.valueMax+1)
+	rand.New(rand.NewSource(cfg.seed)).Read(values)
+
+	workers := make([]*benchWorker, cfg.goroutines)
+	for i := range workers {
+		r := rand.New(rand.NewSource(cfg.seed + int64(i) + 1))
+		keyGen, err := newKeyGenerator(cfg.dist, r, cfg.keys, i, cfg.goroutines, cfg.zipfS, cfg.hotFraction, cfg.hotOps)
+		if err != nil {
+			return report, err
+		}
+		sizer, err := newValueSizer(cfg.valueDist, r, cfg.valueSize, cfg.valueMax)
+		if err != nil {
+			return report, err
+		}
+		workers[i] = &benchWorker{keys: keyGen, sizer: sizer, rand: r}
+	}
+
+	c := &gocache.Cache{}
+	c.Init(cfg.capacity, cfg.buckets)
+	if cfg.prefill {
+		sizer, _ := newValueSizer(cfg.valueDist, rand.New(rand.NewSource(cfg.seed)), cfg.valueSize, cfg.valueMax)
+		for i := 0; i < cfg.keys && i < cfg.capacity; i++ {
+			c.Add(keys[i], values[:sizer()], costFun)
+		}
+	}
+	prefilled := c.Stats()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	start := time.Now()
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *benchWorker) {
+			defer wg.Done()
+			w.run(c, keys, values, costFun, cfg, stop)
+		}(w)
+	}
+	time.Sleep(cfg.duration)
+	close(stop)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	var latency [2]gocache.Histogram
+	var hits, misses uint64
+	for _, w := range workers {
+		report.Operations += w.ops
+		report.Errors += w.errors
+		hits += w.hits
+		misses += w.misses
+		latency[opRead].Merge(&w.latency[opRead])
+		latency[opWrite].Merge(&w.latency[opWrite])
+	}
+	report.Config.Capacity = cfg.capacity
+	report.Config.Buckets = buckets
+	report.Config.Keys = cfg.keys
+	report.Config.Distribution = cfg.dist
+	report.Config.ReadRatio = cfg.readRatio
+	report.Config.ValueDist = cfg.valueDist
+	report.Config.Goroutines = cfg.goroutines
+	report.Config.Cost = cfg.cost
+	report.Duration = elapsed
+	report.Throughput = float64(report.Operations) / elapsed.Seconds()
+	if hits+misses > 0 {
+		report.HitRatio = float64(hits) / float64(hits+misses)
+	}
+	report.Latency = map[string]gocache.HistogramSnapshot{
+		"read":  latency[opRead].Snapshot(),
+		"write": latency[opWrite].Snapshot(),
+	}
+	report.Cache = c.Stats()
+	// the prefill is not part of the run
+	report.Cache.Adds -= prefilled.Adds
+	for reason, count := range prefilled.Evictions {
+		report.Cache.Evictions[reason] -= count
+	}
+	return report, nil
+}
+
+// run does operations until stop is closed, a read is a Get followed by an Add on a miss when fillOnMiss is set
+// and a write is an Update of the key, or an Add when the key is not cached
+func (w *benchWorker) run(c *gocache.Cache, keys [][]byte, values []byte, costFun *func(gocache.Data) int, cfg benchConfig, stop chan struct{}) {
+	for {
+		// checking stop on every operation would add a channel receive to every timing
+		for i := 0; i < 64; i++ {
+			key := keys[w.keys.next()]
+			var err error
+			if w.rand.Float64() < cfg.readRatio {
+				start := time.Now()
+				_, err = c.Get(key)
+				if err == gocache.ErrNotFound {
+					w.misses++
+					err = nil
+					if cfg.fillOnMiss {
+						err = c.Add(key, values[:w.sizer()], costFun)
+					}
+				} else if err == nil {
+					w.hits++
+				}
+				w.latency[opRead].Record(time.Since(start))
+			} else {
+				value := values[:w.sizer()]
+				start := time.Now()
+				err = c.Update(key, value)
+				if err == gocache.ErrKeyNotExist {
+					err = c.Add(key, value, costFun)
+				}
+				w.latency[opWrite].Record(time.Since(start))
+			}
+			if err != nil {
+				w.errors++
+			}
+			w.ops++
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+func printBenchReport(r benchReport) {
+	fmt.Printf("%v goroutines, %v keys (%v), %.0f%% reads, capacity %v in %v buckets, %v cost\n",
+		r.Config.Goroutines, r.Config.Keys, r.Config.Distribution, r.Config.ReadRatio*100, r.Config.Capacity,
+		r.Config.Buckets, r.Config.Cost)
+	fmt.Printf("operations : %v in %v, %.0f ops/s, %v errors\n", r.Operations, r.Duration.Round(time.Millisecond),
+		r.Throughput, r.Errors)
+	fmt.Printf("hit ratio  : %.4f\n", r.HitRatio)
+	for _, name := range []string{"read", "write"} {
+		s := r.Latency[name]
+		fmt.Printf("%-5s      : count %v mean %v p50 %v p90 %v p99 %v p99.9 %v max %v\n", name, s.Count, s.Mean,
+			s.P50, s.P90, s.P99, s.P999, s.Max)
+	}
+	fmt.Printf("cache      : %v entries of %v, %v bytes, %v collisions, skew %.2f, %v adds, %v updates\n",
+		r.Cache.Entries, r.Cache.MaxEntries, r.Cache.Bytes, r.Cache.Collisions, r.Cache.Skew, r.Cache.Adds,
+		r.Cache.Updates)
+	fmt.Printf("evictions  :")
+	for _, reason := range gocache.EvictionReasons() {
+		fmt.Printf(" %v %v", reason, r.Cache.Evictions[reason.String()])
+	}
+	fmt.Println()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testBenchConfig returns a configuration of a short run on a small cache
+func testBenchConfig() benchConfig {
+	return benchConfig{
+		capacity:    500,
+		buckets:     4,
+		keys:        1000,
+		dist:        "uniform",
+		zipfS:       1.1,
+		hotFraction: 0.2,
+		hotOps:      0.8,
+		readRatio:   0.5,
+		valueDist:   "uniform",
+		valueSize:   16,
+		valueMax:    64,
+		goroutines:  2,
+		duration:    100 * time.Millisecond,
+		cost:        "balanced",
+		fillOnMiss:  true,
+		prefill:     true,
+		seed:        1,
+	}
+}
+
+func TestBench(t *testing.T) {
+	report, err := bench(testBenchConfig())
+	if err != nil {
+		t.Fatalf("bench: %v", err)
+	}
+	if report.Operations == 0 || report.Errors != 0 {
+		t.Fatalf("%v operations and %v errors, want operations without errors", report.Operations, report.Errors)
+	}
+	read, write := report.Latency["read"], report.Latency["write"]
+	if read.Count+write.Count != report.Operations || read.Count == 0 || write.Count == 0 {
+		t.Errorf("%v reads and %v writes timed, want the %v operations", read.Count, write.Count, report.Operations)
+	}
+	// half of the keys are cached, so writes update cached keys and add the others, like read misses do
+	if report.Cache.Updates == 0 || report.Cache.Adds == 0 {
+		t.Errorf("%v updates and %v adds during the run, want both", report.Cache.Updates, report.Cache.Adds)
+	}
+	if report.Cache.Updates > write.Count {
+		t.Errorf("%v updates for %v writes", report.Cache.Updates, write.Count)
+	}
+	if report.HitRatio <= 0 || report.HitRatio >= 1 {
+		t.Errorf("hit ratio = %v, want hits and misses over 1000 keys in a cache of 500", report.HitRatio)
+	}
+	if report.Cache.Entries == 0 || report.Cache.Entries > 500 || report.Cache.Evictions["capacity"] == 0 {
+		t.Errorf("cache of %v entries with %v capacity evictions", report.Cache.Entries, report.Cache.Evictions["capacity"])
+	}
+	if report.Config.Buckets != 4 || report.Config.Goroutines != 2 || report.Duration < 100*time.Millisecond {
+		t.Errorf("report of %+v over %v", report.Config, report.Duration)
+	}
+}
+
+func TestBenchWriteOnly(t *testing.T) {
+	cfg := testBenchConfig()
+	cfg.readRatio = 0
+	cfg.prefill = false
+	cfg.dist = "sequential"
+	report, err := bench(cfg)
+	if err != nil {
+		t.Fatalf("bench: %v", err)
+	}
+	if report.Latency["read"].Count != 0 || report.HitRatio != 0 {
+		t.Errorf("write only run has %v reads and a hit ratio of %v", report.Latency["read"].Count, report.HitRatio)
+	}
+	if report.Cache.Adds == 0 {
+		t.Errorf("write only run on an empty cache added nothing")
+	}
+}
+
+func TestBenchErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(cfg *benchConfig)
+	}{
+		{"no keys", func(cfg *benchConfig) { cfg.keys = 0 }},
+		{"no goroutines", func(cfg *benchConfig) { cfg.goroutines = 0 }},
+		{"no duration", func(cfg *benchConfig) { cfg.duration = 0 }},
+		{"read ratio above 1", func(cfg *benchConfig) { cfg.readRatio = 1.5 }},
+		{"too many buckets", func(cfg *benchConfig) { cfg.buckets = 2048 }},
+		{"capacity too large", func(cfg *benchConfig) { cfg.capacity = 4*2000 + 1 }},
+		{"unknown cost", func(cfg *benchConfig) { cfg.cost = "unknown" }},
+		{"unknown distribution", func(cfg *benchConfig) { cfg.dist = "unknown" }},
+		{"unknown value distribution", func(cfg *benchConfig) { cfg.valueDist = "unknown" }},
+	}
+	for _, test := range tests {
+		cfg := testBenchConfig()
+		test.change(&cfg)
+		if _, err := bench(cfg); err == nil {
+			t.Errorf("%s: bench succeeded", test.name)
+		}
+	}
+}
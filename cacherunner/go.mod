@@ -1,6 +1,6 @@
 module cacherunner
 
-go 1.15
+go 1.19
 
 replace gocache => ./../cache
 
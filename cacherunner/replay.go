@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gocache"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// replayResult is the hit ratio of one policy at one capacity
+type replayResult struct {
+	Policy   string `json:"policy"`
+	Capacity int    `json:"capacity"`
+	// EffectiveCapacity is the capacity of the cache after rounding it up to a multiple of the buckets
+	EffectiveCapacity uint64  `json:"effectiveCapacity"`
+	Buckets           int     `json:"buckets"`
+	Requests          uint64  `json:"requests"`
+	Hits              uint64  `json:"hits"`
+	HitRatio          float64 `json:"hitRatio"`
+	Evictions         uint64  `json:"evictions"`
+	Collisions        uint64  `json:"collisions"`
+}
+
+func runReplay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	format := flags.String("format", "lirs", "trace format: lirs, arc, csv or binary")
+	capacities := flags.String("capacities", "1000,10000,100000", "comma separated capacities in entries")
+	policies := flags.String("policies", "constant,size,frequency,balanced", "comma separated cost function presets")
+	buckets := flags.Int("buckets", 0, "number of buckets, 0 to pick it from the capacity")
+	valueSize := flags.Int("value-size", 64, "value size in bytes for the formats without sizes")
+	warmup := flags.Float64("warmup", 0, "fraction of the requests replayed before the hits are counted")
+	parallel := flags.Int("parallel", 4, "number of runs replayed at the same time")
+	output := flags.String("output", "csv", "output format: csv or json")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: cacherunner replay [flags] <trace file, - for stdin>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *output != "csv" && *output != "json" {
+		return fmt.Errorf("unknown output format %q, use csv or json", *output)
+	}
+	if *warmup < 0 || *warmup >= 1 {
+		return fmt.Errorf("warmup must be between 0 and 1, got %v", *warmup)
+	}
+	if *parallel < 1 || *valueSize < 0 {
+		return fmt.Errorf("parallel must be positive and value size can not be negative")
+	}
+
+	var caps []int
+	for _, field := range strings.Split(*capacities, ",") {
+		capacity, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || capacity < 1 {
+			return fmt.Errorf("invalid capacity %q", field)
+		}
+		caps = append(caps, capacity)
+	}
+	var costFuns []*func(gocache.Data) int
+	var names []string
+	for _, field := range strings.Split(*policies, ",") {
+		name := strings.TrimSpace(field)
+		costFun, err := gocache.CostFunction(name)
+		if err != nil {
+			return err
+		}
+		costFuns = append(costFuns, costFun)
+		names = append(names, name)
+	}
+
+	var in io.Reader = os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+	t, err := readTrace(in, *format)
+	if err != nil {
+		return err
+	}
+
+	results := make([]replayResult, len(names)*len(caps))
+	var runErr error
+	var errOnce sync.Once
+	var wg sync.WaitGroup
+	runs := make(chan int)
+	for i := 0; i < *parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for run := range runs {
+				policy, capacity := run/len(caps), caps[run%len(caps)]
+				result, err := replay(t, capacity, *buckets, costFuns[policy], *valueSize, *warmup)
+				if err != nil {
+					errOnce.Do(func() { runErr = err })
+					continue
+				}
+				result.Policy = names[policy]
+				results[run] = result
+			}
+		}()
+	}
+	for run := range results {
+		runs <- run
+	}
+	close(runs)
+	wg.Wait()
+	if runErr != nil {
+		return runErr
+	}
+
+	if *output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	}
+	writer := csv.NewWriter(os.Stdout)
+	writer.Write([]string{"policy", "capacity", "effective_capacity", "buckets", "requests", "hits", "hit_ratio", "evictions", "collisions"})
+	for _, r := range results {
+		writer.Write([]string{r.Policy, strconv.Itoa(r.Capacity), strconv.FormatUint(r.EffectiveCapacity, 10),
+			strconv.Itoa(r.Buckets), strconv.FormatUint(r.Requests, 10), strconv.FormatUint(r.Hits, 10),
+			strconv.FormatFloat(r.HitRatio, 'f', 6, 64), strconv.FormatUint(r.Evictions, 10),
+			strconv.FormatUint(r.Collisions, 10)})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// replayBuckets picks the number of buckets for a capacity, about a hundred entries per bucket and no more than
+// the maximum of 1024 buckets of 2000 entries
+func replayBuckets(capacity int) int {
+	buckets := capacity / 100
+	if buckets > 1024 {
+		buckets = 1024
+	}
+	if least := (capacity + 1999) / 2000; buckets < least {
+		buckets = least
+	}
+	if buckets < 1 {
+		buckets = 1
+	}
+	return buckets
+}
+
+// replay runs the trace against a new cache, every request is a Get followed by an Add on a miss
+func replay(t *trace, capacity, buckets int, costFun *func(gocache.Data) int, valueSize int, warmup float64) (replayResult, error) {
+	if buckets == 0 {
+		buckets = replayBuckets(capacity)
+	}
+	if buckets > 1024 || capacity > buckets*2000 {
+		return replayResult{}, fmt.Errorf("capacity %v does not fit in %v buckets of 2000 entries", capacity, buckets)
+	}
+	maxSize := valueSize
+	for _, size := range t.sizes {
+		if int(size) > maxSize {
+			maxSize = int(size)
+		}
+	}
+	// values are never read back, every Add takes a prefix of the same buffer
+	values := make([]byte, maxSize)
+
+	c := &gocache.Cache{}
+	c.Init(capacity, buckets)
+	result := replayResult{Capacity: capacity, Buckets: buckets}
+	counted := int(float64(len(t.requests)) * warmup)
+	for i, key := range t.requests {
+		_, err := c.Get(t.keys[key])
+		if i >= counted {
+			result.Requests++
+			if err == nil {
+				result.Hits++
+			}
+		}
+		if err == gocache.ErrNotFound {
+			size := valueSize
+			if t.sizes != nil {
+				size = int(t.sizes[i])
+			}
+			if err := c.Add(t.keys[key], values[:size], costFun); err != nil {
+				return result, err
+			}
+		}
+	}
+	stats := c.Stats()
+	result.EffectiveCapacity = stats.MaxEntries
+	result.Collisions = stats.Collisions
+	for _, count := range stats.Evictions {
+		result.Evictions += count
+	}
+	if result.Requests > 0 {
+		result.HitRatio = float64(result.Hits) / float64(result.Requests)
+	}
+	return result, nil
+}
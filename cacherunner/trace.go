@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// trace is an access trace loaded in memory, every distinct key is stored once and the requests refer to it by index
+type trace struct {
+	keys     [][]byte
+	requests []int32
+	// sizes holds the size of the value of every request, nil when the format has no sizes
+	sizes []int32
+	index map[string]int32
+}
+
+func (t *trace) add(key string, size int) {
+	i, ok := t.index[key]
+	if !ok {
+		i = int32(len(t.keys))
+		t.index[key] = i
+		t.keys = append(t.keys, []byte(key))
+	}
+	t.requests = append(t.requests, i)
+	if size >= 0 {
+		t.sizes = append(t.sizes, int32(size))
+	}
+}
+
+// readTrace reads a trace in the named format, the formats are
+//
+//	lirs   one key per line, as in the LIRS traces
+//	arc    "start count ignored request" per line, as in the ARC traces, a line requests the keys start to start+count-1
+//	csv    "timestamp,key,size" per line, the size is the size of the value in bytes
+//	binary a sequence of keys as 8 byte little endian integers
+//
+// Empty lines and lines starting with # are skipped in the text formats.
+func readTrace(r io.Reader, format string) (*trace, error) {
+	t := &trace{index: map[string]int32{}}
+	switch format {
+	case "lirs", "arc":
+		scanner := bufio.NewScanner(r)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || text[0] == '#' {
+				continue
+			}
+			if format == "lirs" {
+				t.add(text, -1)
+				continue
+			}
+			fields := strings.Fields(text)
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %v: expected start and count", line)
+			}
+			start, err := strconv.ParseUint(fields[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", line, err)
+			}
+			count, err := strconv.ParseUint(fields[1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", line, err)
+			}
+			for i := uint64(0); i < count; i++ {
+				t.add(strconv.FormatUint(start+i, 10), -1)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	case "csv":
+		reader := csv.NewReader(bufio.NewReader(r))
+		reader.FieldsPerRecord = 3
+		reader.Comment = '#'
+		reader.ReuseRecord = true
+		for first := true; ; first = false {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			size, err := strconv.Atoi(strings.TrimSpace(record[2]))
+			if err != nil || size < 0 {
+				// the first record may be a header
+				if first {
+					continue
+				}
+				// the line in the file, comments and quoted line breaks included
+				line, _ := reader.FieldPos(2)
+				return nil, fmt.Errorf("line %v: invalid size %q", line, record[2])
+			}
+			t.add(strings.TrimSpace(record[1]), size)
+		}
+	case "binary":
+		reader := bufio.NewReader(r)
+		var buf [8]byte
+		for {
+			_, err := io.ReadFull(reader, buf[:])
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("truncated binary trace: %v", err)
+			}
+			t.add(strconv.FormatUint(binary.LittleEndian.Uint64(buf[:]), 10), -1)
+		}
+	default:
+		return nil, fmt.Errorf("unknown trace format %q, use lirs, arc, csv or binary", format)
+	}
+	if len(t.requests) == 0 {
+		return nil, fmt.Errorf("trace has no requests")
+	}
+	return t, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// requests returns the keys requested by t in order
+func requests(t *trace) []string {
+	keys := make([]string, len(t.requests))
+	for i, request := range t.requests {
+		keys[i] = string(t.keys[request])
+	}
+	return keys
+}
+
+// binaryTrace encodes keys in the binary format
+func binaryTrace(keys ...uint64) string {
+	buf := make([]byte, 8*len(keys))
+	for i, key := range keys {
+		binary.LittleEndian.PutUint64(buf[8*i:], key)
+	}
+	return string(buf)
+}
+
+func TestReadTrace(t *testing.T) {
+	tests := []struct {
+		name, format, input string
+		keys                []string
+		sizes               []int32
+	}{
+		{"lirs", "lirs", "1\n2\n1\n", []string{"1", "2", "1"}, nil},
+		{"lirs comments and blank lines", "lirs", "# header\n\n  a  \r\nb\n", []string{"a", "b"}, nil},
+		{"arc", "arc", "10 3 0 0\n# comment\n5 1 0 0\n", []string{"10", "11", "12", "5"}, nil},
+		{"arc without the ignored fields", "arc", "7 2\n", []string{"7", "8"}, nil},
+		{"csv", "csv", "1,a,10\n2,b,20\n3,a,30\n", []string{"a", "b", "a"}, []int32{10, 20, 30}},
+		{"csv header", "csv", "timestamp,key,size\n1, a ,10\n", []string{"a"}, []int32{10}},
+		{"csv comments", "csv", "# trace\n1,a,10\n", []string{"a"}, []int32{10}},
+		{"binary", "binary", binaryTrace(1, 1<<40, 1), []string{"1", "1099511627776", "1"}, nil},
+	}
+	for _, test := range tests {
+		tr, err := readTrace(strings.NewReader(test.input), test.format)
+		if err != nil {
+			t.Errorf("%s: readTrace: %v", test.name, err)
+			continue
+		}
+		if got := requests(tr); !reflect.DeepEqual(got, test.keys) {
+			t.Errorf("%s: requests = %q, want %q", test.name, got, test.keys)
+		}
+		if !reflect.DeepEqual(tr.sizes, test.sizes) {
+			t.Errorf("%s: sizes = %v, want %v", test.name, tr.sizes, test.sizes)
+		}
+	}
+}
+
+func TestReadTraceKeysStoredOnce(t *testing.T) {
+	tr, err := readTrace(strings.NewReader("a\nb\na\na\n"), "lirs")
+	if err != nil {
+		t.Fatalf("readTrace: %v", err)
+	}
+	if len(tr.keys) != 2 || !reflect.DeepEqual(tr.requests, []int32{0, 1, 0, 0}) {
+		t.Errorf("keys %q and requests %v, want 2 keys requested as 0 1 0 0", tr.keys, tr.requests)
+	}
+}
+
+func TestReadTraceErrors(t *testing.T) {
+	tests := []struct {
+		name, format, input, want string
+	}{
+		{"unknown format", "xml", "a\n", `unknown trace format "xml"`},
+		{"empty lirs", "lirs", "# nothing\n\n", "trace has no requests"},
+		{"empty binary", "binary", "", "trace has no requests"},
+		{"arc without count", "arc", "1 1\n\n2\n", "line 3: expected start and count"},
+		{"arc invalid start", "arc", "x 1\n", "line 1: "},
+		{"arc invalid count", "arc", "# comment\n1 -1\n", "line 2: "},
+		{"csv invalid size", "csv", "1,a,10\n2,b,x\n", `line 2: invalid size "x"`},
+		{"csv negative size", "csv", "1,a,10\n1,a,-1\n", `line 2: invalid size "-1"`},
+		{"csv line after comments", "csv", "# trace\n\n1,a,10\n# more\n2,b,x\n", `line 5: invalid size "x"`},
+		{"csv missing field", "csv", "1,a,10\n2,b\n", "record on line 2: wrong number of fields"},
+		{"csv only a header", "csv", "timestamp,key,size\n", "trace has no requests"},
+		{"truncated binary", "binary", binaryTrace(1) + "\x01\x02", "truncated binary trace"},
+	}
+	for _, test := range tests {
+		_, err := readTrace(strings.NewReader(test.input), test.format)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: readTrace error = %v, want %q", test.name, err, test.want)
+		}
+	}
+}
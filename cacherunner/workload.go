@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// keyGenerator picks the index of the next key of a worker, every worker has its own generator
+type keyGenerator interface {
+	next() int
+}
+
+type uniformKeys struct {
+	rand *rand.Rand
+	keys int
+}
+
+func (g *uniformKeys) next() int {
+	return g.rand.Intn(g.keys)
+}
+
+// zipfianKeys picks key i with a probability proportional to 1/(i+1)^s, so a few keys get most of the accesses
+type zipfianKeys struct {
+	zipf *rand.Zipf
+}
+
+func (g *zipfianKeys) next() int {
+	return int(g.zipf.Uint64())
+}
+
+// hotspotKeys sends hotOps of the accesses to the first hotKeys keys and the others to the remaining keys
+type hotspotKeys struct {
+	rand    *rand.Rand
+	keys    int
+	hotKeys int
+	hotOps  float64
+}
+
+func (g *hotspotKeys) next() int {
+	if g.rand.Float64() < g.hotOps || g.hotKeys == g.keys {
+		return g.rand.Intn(g.hotKeys)
+	}
+	return g.hotKeys + g.rand.Intn(g.keys-g.hotKeys)
+}
+
+// sequentialKeys scans the keys in order, starting at a different offset for every worker
+type sequentialKeys struct {
+	position int
+	keys     int
+}
+
+func (g *sequentialKeys) next() int {
+	key := g.position
+	g.position = (g.position + 1) % g.keys
+	return key
+}
+
+// newKeyGenerator returns the generator of worker for the distribution named dist over keys keys
+func newKeyGenerator(dist string, r *rand.Rand, keys int, worker, workers int, zipfS, hotFraction, hotOps float64) (keyGenerator, error) {
+	switch dist {
+	case "uniform":
+		return &uniformKeys{r, keys}, nil
+	case "zipfian":
+		if zipfS <= 1 {
+			return nil, fmt.Errorf("zipfian exponent must be greater than 1, got %v", zipfS)
+		}
+		return &zipfianKeys{rand.NewZipf(r, zipfS, 1, uint64(keys-1))}, nil
+	case "hotspot":
+		if hotFraction <= 0 || hotFraction > 1 || hotOps < 0 || hotOps > 1 {
+			return nil, fmt.Errorf("hotspot fractions must be between 0 and 1")
+		}
+		hotKeys := int(float64(keys) * hotFraction)
+		if hotKeys < 1 {
+			hotKeys = 1
+		}
+		return &hotspotKeys{r, keys, hotKeys, hotOps}, nil
+	case "sequential":
+		return &sequentialKeys{position: worker * keys / workers, keys: keys}, nil
+	}
+	return nil, fmt.Errorf("unknown key distribution %q, use uniform, zipfian, hotspot or sequential", dist)
+}
+
+// valueSizer picks the size of the next value written by a worker
+type valueSizer func() int
+
+// newValueSizer returns the sizer for the distribution named dist, size is the fixed or mean size and maxSize caps it
+func newValueSizer(dist string, r *rand.Rand, size, maxSize int) (valueSizer, error) {
+	if size < 0 || maxSize < size {
+		return nil, fmt.Errorf("value sizes must satisfy 0 <= size <= max size")
+	}
+	switch dist {
+	case "fixed":
+		return func() int { return size }, nil
+	case "uniform":
+		return func() int { return size + r.Intn(maxSize-size+1) }, nil
+	case "exponential":
+		return func() int {
+			if s := int(r.ExpFloat64() * float64(size)); s < maxSize {
+				return s
+			}
+			return maxSize
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown value size distribution %q, use fixed, uniform or exponential", dist)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// counts returns how many times g picked every key in n picks, it fails the test on a key out of range
+func counts(t *testing.T, g keyGenerator, keys, n int) []int {
+	t.Helper()
+	picked := make([]int, keys)
+	for i := 0; i < n; i++ {
+		key := g.next()
+		if key < 0 || key >= keys {
+			t.Fatalf("picked key %v out of %v keys", key, keys)
+		}
+		picked[key]++
+	}
+	return picked
+}
+
+func newTestKeyGenerator(t *testing.T, dist string, keys, worker, workers int) keyGenerator {
+	t.Helper()
+	g, err := newKeyGenerator(dist, rand.New(rand.NewSource(1)), keys, worker, workers, 1.1, 0.2, 0.8)
+	if err != nil {
+		t.Fatalf("newKeyGenerator(%v): %v", dist, err)
+	}
+	return g
+}
+
+func TestUniformKeys(t *testing.T) {
+	picked := counts(t, newTestKeyGenerator(t, "uniform", 10, 0, 1), 10, 100000)
+	for key, count := range picked {
+		if count < 9000 || count > 11000 {
+			t.Errorf("key %v picked %v times of 100000, want about 10000", key, count)
+		}
+	}
+}
+
+func TestZipfianKeys(t *testing.T) {
+	picked := counts(t, newTestKeyGenerator(t, "zipfian", 1000, 0, 1), 1000, 100000)
+	// with an exponent of 1.1 key 0 is picked 2^1.1 times as often as key 1, and the first 1% of the keys get about
+	// half of the picks
+	if ratio := float64(picked[0]) / float64(picked[1]); ratio < 1.9 || ratio > 2.4 {
+		t.Errorf("key 0 picked %v times as often as key 1, want about 2.14", ratio)
+	}
+	first := 0
+	for _, count := range picked[:10] {
+		first += count
+	}
+	if first < 40000 {
+		t.Errorf("the first 10 keys got %v of 100000 picks, want about half of them", first)
+	}
+}
+
+func TestHotspotKeys(t *testing.T) {
+	picked := counts(t, newTestKeyGenerator(t, "hotspot", 100, 0, 1), 100, 100000)
+	hot := 0
+	for _, count := range picked[:20] {
+		hot += count
+	}
+	if hot < 78000 || hot > 82000 {
+		t.Errorf("the 20 hot keys got %v of 100000 picks, want about 80000", hot)
+	}
+	for key, count := range picked {
+		if count == 0 {
+			t.Errorf("key %v never picked", key)
+		}
+	}
+
+	// every key is hot when the hot fraction is 1
+	g, err := newKeyGenerator("hotspot", rand.New(rand.NewSource(1)), 10, 0, 1, 1.1, 1, 0.5)
+	if err != nil {
+		t.Fatalf("newKeyGenerator: %v", err)
+	}
+	counts(t, g, 10, 1000)
+}
+
+func TestSequentialKeys(t *testing.T) {
+	// workers start at different offsets and wrap around
+	for worker, first := range []int{0, 3, 6} {
+		g := newTestKeyGenerator(t, "sequential", 10, worker, 3)
+		for i := 0; i < 12; i++ {
+			if key, want := g.next(), (first+i)%10; key != want {
+				t.Fatalf("worker %v pick %v = %v, want %v", worker, i, key, want)
+			}
+		}
+	}
+}
+
+func TestKeyGeneratorErrors(t *testing.T) {
+	tests := []struct {
+		dist                       string
+		zipfS, hotFraction, hotOps float64
+	}{
+		{"unknown", 1.1, 0.2, 0.8},
+		{"zipfian", 1, 0.2, 0.8},
+		{"hotspot", 1.1, 0, 0.8},
+		{"hotspot", 1.1, 1.5, 0.8},
+		{"hotspot", 1.1, 0.2, -0.1},
+		{"hotspot", 1.1, 0.2, 1.1},
+	}
+	for _, test := range tests {
+		if _, err := newKeyGenerator(test.dist, rand.New(rand.NewSource(1)), 10, 0, 1, test.zipfS, test.hotFraction, test.hotOps); err == nil {
+			t.Errorf("newKeyGenerator(%+v) succeeded", test)
+		}
+	}
+}
+
+func TestValueSizer(t *testing.T) {
+	tests := []struct {
+		dist             string
+		size, maxSize    int
+		minWant, maxWant int
+		minMean, maxMean float64
+	}{
+		{"fixed", 100, 1000, 100, 100, 100, 100},
+		{"uniform", 100, 200, 100, 200, 145, 155},
+		{"exponential", 100, 1000, 0, 1000, 95, 105},
+		{"exponential", 100, 100, 0, 100, 60, 66},
+	}
+	for _, test := range tests {
+		sizer, err := newValueSizer(test.dist, rand.New(rand.NewSource(1)), test.size, test.maxSize)
+		if err != nil {
+			t.Fatalf("newValueSizer(%v): %v", test.dist, err)
+		}
+		total := 0
+		const n = 100000
+		for i := 0; i < n; i++ {
+			size := sizer()
+			if size < test.minWant || size > test.maxWant {
+				t.Fatalf("%v sizer returned %v, want between %v and %v", test.dist, size, test.minWant, test.maxWant)
+			}
+			total += size
+		}
+		if mean := float64(total) / n; mean < test.minMean || mean > test.maxMean {
+			t.Errorf("%v sizer of %v capped at %v has a mean of %v, want between %v and %v", test.dist, test.size,
+				test.maxSize, mean, test.minMean, test.maxMean)
+		}
+	}
+
+	for _, test := range []struct {
+		dist          string
+		size, maxSize int
+	}{{"unknown", 1, 1}, {"fixed", -1, 1}, {"uniform", 10, 5}} {
+		if _, err := newValueSizer(test.dist, rand.New(rand.NewSource(1)), test.size, test.maxSize); err == nil {
+			t.Errorf("newValueSizer(%+v) succeeded", test)
+		}
+	}
+}
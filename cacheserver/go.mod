@@ -0,0 +1,7 @@
+module cacheserver
+
+go 1.19
+
+replace gocache => ./../cache
+
+require gocache v0.0.0-00010101000000-000000000000
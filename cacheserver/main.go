@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"gocache"
+	"gocache/httpcache"
+	"gocache/metrics"
+	"gocache/replication"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	capacity := flag.Int("capacity", 100000, "maximum number of entries in the cache")
+	buckets := flag.Int("buckets", 0, "number of buckets, 0 for the default of 512")
+	cost := flag.String("cost", "balanced", "default cost function: size, frequency, balanced or constant")
+	prefix := flag.String("prefix", "/", "path prefix the cache API is served under")
+	snapshot := flag.String("snapshot", "", "snapshot file to restore at startup")
+	replicationAddr := flag.String("replication-addr", "", "address to stream the mutations to replicas from, empty to disable replication")
+	keyspaceEvents := flag.Bool("keyspace-events", false, "publish the changes of the keys to the __keyspace__ and __keyevent__ channels")
+	compression := flag.String("compression", "", "codec compressing the values: flate or gzip, empty to disable compression")
+	compressionThreshold := flag.Int("compression-threshold", gocache.DefaultCompressionThreshold, "length from which values are compressed")
+	flag.Parse()
+
+	costFun, err := gocache.CostFunction(*cost)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var c gocache.Cache
+	c.Init(*capacity, *buckets)
+	if *compression != "" {
+		codec, err := gocache.NewCodec(*compression)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.SetCompression(codec, *compressionThreshold)
+	}
+
+	if *snapshot != "" {
+		f, err := os.Open(*snapshot)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = c.Restore(f, costFun)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("restored", c.GetEntriesCount(), "entries from", *snapshot)
+	}
+
+	if *replicationAddr != "" {
+		l, err := net.Listen("tcp", *replicationAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		primary := replication.NewPrimary(&c, 0)
+		go func() {
+			log.Fatal(primary.Serve(l))
+		}()
+		fmt.Println("streaming mutations to replicas on", l.Addr())
+	}
+
+	exporter := metrics.NewExporter()
+	exporter.Register("default", &c)
+
+	handler := httpcache.NewHandler(&c, costFun)
+	if *keyspaceEvents {
+		handler.Broker().PublishKeyspaceEvents(&c, nil)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exporter)
+	mountPath := "/" + strings.Trim(*prefix, "/")
+	if mountPath == "/" {
+		mux.Handle("/", handler)
+	} else {
+		mux.Handle(mountPath+"/", http.StripPrefix(mountPath, handler))
+	}
+
+	fmt.Printf("serving cache with capacity %d in %d buckets on %s%s\n", c.GetCapacity(), c.GetBucketsCount(), *addr, mountPath)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}